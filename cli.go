@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tiredkangaroo/music/client"
+	"github.com/tiredkangaroo/music/env"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// command is a subcommand of the binary. run gets the arguments after the command's name.
+type command struct {
+	usage   string // the arguments
+	summary string
+	run     func(ctx context.Context, fs *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":    {"", "run the server (the default without a command)", nil},
+	"search":   {"[-server url] [-user name] [-json] <query>", "search for tracks", cmdSearch},
+	"download": {"[-server url] <spotify url or search query>", "download a track (only spotify track urls with -server)", cmdDownload},
+	"import":   {"[-server url] [-user name] <spotify playlist url>", "import a spotify playlist", cmdImport},
+	"export":   {"[-server url] [-user name] [-format json|m3u] [-o file] <playlist id>", "export a playlist with its tracks", cmdExport},
+	"failed":   {"[-retry] [thing...]", "list failed downloads, or retry them (all of them without arguments)", cmdFailed},
+	"scan":     {"", "fix which tracks are marked downloaded from the files in the data directory", cmdScan},
+	"migrate":  {"", "create or update the database schema", cmdMigrate},
+	"backup":   {"[-files] <file>", "back up the database (and the data directory with -files) to a .tar.gz (- for stdout)", cmdBackup},
+	"restore":  {"-yes <file>", "replace the database (and data directory) with a backup", cmdRestore},
+	"stats":    {"[-json]", "show the size of the library", cmdStats},
+	"config":   {"", "show the configuration in use (secrets redacted) and check it", cmdConfig},
+}
+
+// runCommand runs a subcommand and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok || cmd.run == nil {
+		if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+			printUsage()
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: music %s %s\n\n%s\n", name, cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := cmd.run(ctx, fs, args); err != nil {
+		fmt.Fprintf(os.Stderr, "music %s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: music [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(os.Stderr, "\ncommands work on the configured database (POSTGRES_URL, DATA_PATH) unless they're given -server")
+	fmt.Fprintln(os.Stderr, "(or MUSIC_SERVER) and an api token (-token or MUSIC_API_TOKEN). run music <command> -h for its flags.")
+}
+
+// target is what a command works on: the library with the configured database as a user, or a running
+// server through its api.
+type target struct {
+	lib    *library.Library
+	user   library.User
+	client *client.Client
+	close  func()
+}
+
+type targetFlags struct {
+	server, token, user *string
+}
+
+func addTargetFlags(fs *flag.FlagSet) *targetFlags {
+	return &targetFlags{
+		server: fs.String("server", env.DefaultEnv.RemoteServerURL, "url of a running server to use instead of the database (default $MUSIC_SERVER)"),
+		token:  fs.String("token", env.DefaultEnv.RemoteAPIToken, "api token for -server (default $MUSIC_API_TOKEN)"),
+		user:   fs.String("user", "", "user to act as without -server (default the first admin)"),
+	}
+}
+
+func (f *targetFlags) open(ctx context.Context) (*target, error) {
+	if *f.server != "" {
+		if *f.token == "" {
+			return nil, errors.New("an api token is needed with -server (-token or MUSIC_API_TOKEN)")
+		}
+		return &target{client: client.New(*f.server, *f.token), close: func() {}}, nil
+	}
+
+	lib, closeLib, err := openLibrary()
+	if err != nil {
+		return nil, err
+	}
+	t := &target{lib: lib, close: closeLib}
+	if *f.user != "" {
+		t.user, err = lib.UserByUsername(ctx, *f.user)
+	} else {
+		t.user, err = firstAdmin(ctx, lib)
+	}
+	if err != nil {
+		closeLib()
+		return nil, err
+	}
+	return t, nil
+}
+
+func firstAdmin(ctx context.Context, lib *library.Library) (library.User, error) {
+	users, err := lib.ListUsers(ctx)
+	if err != nil {
+		return library.User{}, err
+	}
+	for _, u := range users { // oldest first
+		if u.IsAdmin {
+			return u, nil
+		}
+	}
+	return library.User{}, errors.New("there are no users yet, finish the setup in the ui first")
+}
+
+// withLibrary opens the library for commands that only work on the database.
+func withLibrary(fn func(lib *library.Library) error) error {
+	lib, closeLib, err := openLibrary()
+	if err != nil {
+		return err
+	}
+	defer closeLib()
+	return fn(lib)
+}
+
+func cmdSearch(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	tf := addTargetFlags(fs)
+	asJSON := fs.Bool("json", false, "print the results as json")
+	fs.Parse(args)
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t, err := tf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	var tracks []client.Track
+	if t.client != nil {
+		tracks, err = t.client.Search(ctx, query)
+	} else {
+		if err := env.Init(); err != nil { // searching asks spotify
+			return err
+		}
+		var results any
+		results, err = t.lib.Search(ctx, t.user.ID, query)
+		if err == nil {
+			err = convert(results, &tracks)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(tracks)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRACK ID\tNAME\tARTIST\tALBUM\tDOWNLOADED")
+	for _, tr := range tracks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", tr.TrackID, tr.TrackName, tr.ArtistName, tr.AlbumName, yesNo(tr.Downloaded))
+	}
+	return tw.Flush()
+}
+
+func cmdDownload(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	tf := addTargetFlags(fs)
+	fs.Parse(args)
+	thing := strings.Join(fs.Args(), " ")
+	if thing == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t, err := tf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	if t.client != nil {
+		trackID, ok := spotifyID(thing, "track")
+		if !ok {
+			return errors.New("only spotify track urls can be downloaded through a server")
+		}
+		err = t.client.Download(ctx, trackID)
+	} else {
+		if err := env.Init(); err != nil {
+			return err
+		}
+		err = t.lib.Download(ctx, t.user.ID, thing)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("downloaded", thing)
+	return nil
+}
+
+func cmdImport(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	tf := addTargetFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	playlistURL := fs.Arg(0)
+	spotifyPlaylistID, ok := spotifyID(playlistURL, "playlist")
+	if !ok {
+		return errors.New("the url provided is not the url for a spotify playlist")
+	}
+	t, err := tf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	var playlist client.Playlist
+	if t.client != nil {
+		playlist, err = t.client.ImportPlaylist(ctx, playlistURL)
+	} else {
+		if err := env.Init(); err != nil {
+			return err
+		}
+		var imported any
+		imported, err = t.lib.Import(ctx, t.user.ID, spotifyPlaylistID)
+		if err == nil {
+			err = convert(imported, &playlist)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %q as playlist %s\n", playlist.Name, playlist.ID)
+	return nil
+}
+
+func cmdExport(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	tf := addTargetFlags(fs)
+	format := fs.String("format", "json", "json, or m3u with the paths of the downloaded tracks (not with -server)")
+	out := fs.String("o", "-", "file to write to (- for stdout)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "json" && *format != "m3u" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	t, err := tf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+	if *format == "m3u" && t.client != nil {
+		return errors.New("m3u exports point to the audio files, so they can't be made with -server")
+	}
+
+	var playlist client.PlaylistWithTracks
+	if t.client != nil {
+		playlist, err = t.client.Playlist(ctx, fs.Arg(0))
+	} else {
+		p, tracks, perr := t.lib.PlaylistTracks(ctx, t.user.ID, fs.Arg(0))
+		err = perr
+		if err == nil {
+			err = convert(p, &playlist.Playlist)
+		}
+		if err == nil {
+			err = convert(tracks, &playlist.Tracks)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(playlist)
+	}
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintf(w, "#PLAYLIST:%s\n", playlist.Name)
+	skipped := 0
+	for _, tr := range playlist.Tracks {
+		if !tr.Downloaded {
+			skipped++
+			continue
+		}
+		fmt.Fprintf(w, "#EXTINF:%d,%s - %s\n%s\n", tr.Duration, tr.ArtistName, tr.TrackName, t.lib.PathToTrackFile(tr.TrackID))
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "left out %d tracks that aren't downloaded\n", skipped)
+	}
+	return nil
+}
+
+func cmdFailed(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	retry := fs.Bool("retry", false, "download the failed downloads again")
+	fs.Parse(args)
+	return withLibrary(func(lib *library.Library) error {
+		failed, err := lib.FailedDownloads(ctx)
+		if err != nil {
+			return err
+		}
+		if !*retry {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "FAILED AT\tATTEMPTS\tTHING\tERROR")
+			for _, f := range failed {
+				msg, _, _ := strings.Cut(f.Error, "\n") // spotdl errors have its logs after the first line
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.FailedAt.Time.Format("2006-01-02 15:04"), f.Attempts, f.Thing, msg)
+			}
+			return tw.Flush()
+		}
+
+		if err := env.Init(); err != nil {
+			return err
+		}
+		// the retries are the first admin's, so their webhooks hear how they went
+		admin, err := firstAdmin(ctx, lib)
+		if err != nil {
+			return err
+		}
+		things := fs.Args()
+		if len(things) == 0 {
+			for _, f := range failed {
+				things = append(things, f.Thing)
+			}
+		}
+		failures := 0
+		for _, thing := range things {
+			if err := lib.RetryFailedDownload(ctx, admin.ID, thing); err != nil {
+				failures++
+				msg, _, _ := strings.Cut(err.Error(), "\n")
+				fmt.Printf("failed     %s: %s\n", thing, msg)
+				continue
+			}
+			fmt.Printf("downloaded %s\n", thing)
+		}
+		if failures > 0 {
+			return fmt.Errorf("%d of %d downloads failed again", failures, len(things))
+		}
+		return nil
+	})
+}
+
+func cmdScan(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	return withLibrary(func(lib *library.Library) error {
+		result, err := lib.Scan(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("checked %d tracks\n", result.Checked)
+		for _, id := range result.MarkedDownloaded {
+			fmt.Println("found the file of", id)
+		}
+		for _, id := range result.MarkedMissing {
+			fmt.Println("missing the file of", id)
+		}
+		for _, p := range result.Orphans {
+			fmt.Println("not a track in the library:", p)
+		}
+		fmt.Printf("%d marked downloaded, %d marked not downloaded, %d files that aren't tracks\n",
+			len(result.MarkedDownloaded), len(result.MarkedMissing), len(result.Orphans))
+		return nil
+	})
+}
+
+func cmdMigrate(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	return withLibrary(func(lib *library.Library) error {
+		if err := lib.Migrate(ctx); err != nil {
+			return err
+		}
+		fmt.Println("the database is up to date")
+		return nil
+	})
+}
+
+func cmdBackup(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	withFiles := fs.Bool("files", false, "include the data directory (the downloaded audio, lyrics and images)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return withLibrary(func(lib *library.Library) error {
+		if fs.Arg(0) == "-" {
+			return lib.Backup(ctx, os.Stdout, *withFiles)
+		}
+		f, err := os.Create(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if err := lib.Backup(ctx, f, *withFiles); err != nil {
+			f.Close()
+			os.Remove(fs.Arg(0))
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "backed up to", fs.Arg(0))
+		return nil
+	})
+}
+
+func cmdRestore(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	yes := fs.Bool("yes", false, "confirm that everything in the database is replaced")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !*yes {
+		return errors.New("restoring replaces everything in the database, run it with -yes if that's what you want")
+	}
+	return withLibrary(func(lib *library.Library) error {
+		r := io.Reader(os.Stdin)
+		if fs.Arg(0) != "-" {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := lib.Migrate(ctx); err != nil {
+			return err
+		}
+		if err := lib.Restore(ctx, r); err != nil {
+			return err
+		}
+		fmt.Println("restored", fs.Arg(0))
+		return nil
+	})
+}
+
+func cmdStats(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	asJSON := fs.Bool("json", false, "print the stats as json")
+	fs.Parse(args)
+	return withLibrary(func(lib *library.Library) error {
+		stats, err := lib.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(stats)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "tracks\t%d (%d downloaded)\n", stats.Tracks, stats.DownloadedTracks)
+		fmt.Fprintf(tw, "albums\t%d\n", stats.Albums)
+		fmt.Fprintf(tw, "artists\t%d\n", stats.Artists)
+		fmt.Fprintf(tw, "playlists\t%d\n", stats.Playlists)
+		fmt.Fprintf(tw, "users\t%d\n", stats.Users)
+		fmt.Fprintf(tw, "plays\t%d\n", stats.Plays)
+		fmt.Fprintf(tw, "failed downloads\t%d\n", stats.FailedDownloads)
+		fmt.Fprintf(tw, "storage\t%.1f MiB\n", float64(stats.StorageBytes)/(1<<20))
+		return tw.Flush()
+	})
+}
+
+// spotifyID returns the ID from an open.spotify.com url of the kind (track, playlist...).
+func spotifyID(s, kind string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host != "open.spotify.com" {
+		return "", false
+	}
+	id, ok := strings.CutPrefix(strings.TrimSuffix(u.Path, "/"), "/"+kind+"/")
+	return id, ok && id != "" && !strings.Contains(id, "/")
+}
+
+// convert copies what the library returned into the client's type for it (they have the same json), so
+// commands print the same thing whether they work on the database or a server.
+func convert(from, to any) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func cmdConfig(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	fmt.Print(env.DefaultEnv.Redacted())
+	if err := env.Init(); err != nil {
+		return err
+	}
+	fmt.Println("\n# the configuration is valid")
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package client is a Go client for the music server's api (see server/openapi.json) for scripts and tools
+// that search, manage playlists, download and import. it authenticates with an api token.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the api of a music server.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// New returns a client for the server at baseURL (the SERVER_URL, e.g. https://music.example.com) that
+// authenticates with the api token.
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		token:      token,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// WithHTTPClient makes the client send its requests with hc instead of http.DefaultClient.
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.httpClient = hc
+	return c
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("music api: %d: %s", e.StatusCode, e.Message)
+}
+
+// Track is a track as search results and playlists have it. fields that only some routes fill in are
+// left zero by the others.
+type Track struct {
+	TrackID          string     `json:"track_id"`
+	TrackName        string     `json:"track_name"`
+	Duration         int        `json:"duration"` // seconds
+	Popularity       int        `json:"popularity"`
+	AlbumID          string     `json:"album_id"`
+	AlbumName        string     `json:"album_name"`
+	ArtistID         string     `json:"artist_id"`
+	ArtistName       string     `json:"artist_name"`
+	Artists          []string   `json:"artists"`
+	CoverURL         string     `json:"cover_url"`
+	Downloaded       bool       `json:"downloaded"`
+	TrackReleaseDate string     `json:"track_release_date"`
+	PlayCount        int        `json:"play_count"`
+	Position         int        `json:"position"` // in the playlist (regular playlists only)
+	AddedAt          *time.Time `json:"added_at"` // to the playlist (regular playlists only)
+	LikedAt          *time.Time `json:"liked_at"`
+	Rating           *int       `json:"rating"`
+	Genres           []string   `json:"genres"` // search results only
+}
+
+// Playlist is a playlist without its tracks.
+type Playlist struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	ImageURL    string          `json:"image_url"`
+	CreatedAt   string          `json:"created_at"`
+	Rules       json.RawMessage `json:"rules"` // set for smart playlists
+	FolderID    *string         `json:"folder_id"`
+}
+
+// PlaylistWithTracks is a playlist with its tracks.
+type PlaylistWithTracks struct {
+	Playlist
+	Tracks []Track `json:"tracks"`
+}
+
+// PlaylistUpdate are the changes to a playlist. nil fields stay the same.
+type PlaylistUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	ImageURL    *string `json:"image_url,omitempty"`
+}
+
+// DownloadProgress is reported for every track of a playlist download as it finishes.
+type DownloadProgress struct {
+	Index     int    // of the track in the playlist
+	NumTracks int    // that are being downloaded
+	Error     string // why the track couldn't be downloaded, "" if it was
+}
+
+// Search searches for tracks.
+func (c *Client) Search(ctx context.Context, query string) ([]Track, error) {
+	var tracks []Track
+	err := c.do(ctx, http.MethodGet, "/search?q="+url.QueryEscape(query), nil, &tracks)
+	return tracks, err
+}
+
+// Playlists lists the playlists.
+func (c *Client) Playlists(ctx context.Context) ([]Playlist, error) {
+	var playlists []Playlist
+	err := c.do(ctx, http.MethodGet, "/playlists", nil, &playlists)
+	return playlists, err
+}
+
+// Playlist returns a playlist with its tracks in playlist order.
+func (c *Client) Playlist(ctx context.Context, playlistID string) (PlaylistWithTracks, error) {
+	var playlist PlaylistWithTracks
+	err := c.do(ctx, http.MethodGet, "/playlists/"+url.PathEscape(playlistID), nil, &playlist)
+	return playlist, err
+}
+
+// CreatePlaylist creates a playlist and returns its ID.
+func (c *Client) CreatePlaylist(ctx context.Context, name, description, imageURL string) (string, error) {
+	var resp struct {
+		PlaylistID string `json:"playlist_id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/playlists", map[string]string{
+		"name":        name,
+		"description": description,
+		"image_url":   imageURL,
+	}, &resp)
+	return resp.PlaylistID, err
+}
+
+// UpdatePlaylist changes the name, description and/or image of a playlist.
+func (c *Client) UpdatePlaylist(ctx context.Context, playlistID string, update PlaylistUpdate) error {
+	return c.do(ctx, http.MethodPatch, "/playlists/"+url.PathEscape(playlistID), update, nil)
+}
+
+// DeletePlaylist deletes a playlist.
+func (c *Client) DeletePlaylist(ctx context.Context, playlistID string) error {
+	return c.do(ctx, http.MethodDelete, "/playlists/"+url.PathEscape(playlistID), nil, nil)
+}
+
+// AddTrackToPlaylist adds a track to a playlist at position, or at the end if position is nil.
+func (c *Client) AddTrackToPlaylist(ctx context.Context, playlistID, trackID string, position *int) error {
+	return c.do(ctx, http.MethodPost, "/playlists/"+url.PathEscape(playlistID)+"/tracks", map[string]any{
+		"track_id": trackID,
+		"position": position,
+	}, nil)
+}
+
+// RemoveTrackFromPlaylist removes a track from a playlist.
+func (c *Client) RemoveTrackFromPlaylist(ctx context.Context, playlistID, trackID string) error {
+	return c.do(ctx, http.MethodDelete, "/playlists/"+url.PathEscape(playlistID)+"/tracks/"+url.PathEscape(trackID), nil, nil)
+}
+
+// ImportPlaylist imports a spotify playlist (an open.spotify.com/playlist/... url) as a new playlist.
+func (c *Client) ImportPlaylist(ctx context.Context, spotifyPlaylistURL string) (Playlist, error) {
+	var playlist Playlist
+	err := c.do(ctx, http.MethodPost, "/playlists/import", map[string]string{
+		"spotify_playlist_url": spotifyPlaylistURL,
+	}, &playlist)
+	return playlist, err
+}
+
+// Download downloads a track (if it isn't downloaded already).
+func (c *Client) Download(ctx context.Context, trackID string) error {
+	return c.do(ctx, http.MethodPost, "/download/"+url.PathEscape(trackID), nil, nil)
+}
+
+// DownloadPlaylist downloads every track of a playlist that isn't downloaded yet. progress (if it isn't nil)
+// is called as each track finishes. a track that fails to download doesn't stop the others, its error is
+// only reported to progress.
+func (c *Client) DownloadPlaylist(ctx context.Context, playlistID string, progress func(DownloadProgress)) error {
+	resp, err := c.send(ctx, http.MethodGet, "/download-playlist/"+url.PathEscape(playlistID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	numTracks := 0
+	first := true
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if first {
+			first = false
+			var start struct {
+				NumTracks int `json:"num_tracks"`
+			}
+			if err := json.Unmarshal([]byte(data), &start); err != nil {
+				return fmt.Errorf("decode download event: %w", err)
+			}
+			numTracks = start.NumTracks
+			continue
+		}
+		var event struct {
+			Index int    `json:"index"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("decode download event: %w", err)
+		}
+		if progress != nil {
+			progress(DownloadProgress{Index: event.Index, NumTracks: numTracks, Error: event.Error})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read download events: %w", err)
+	}
+	return nil
+}
+
+// do sends a request with body as json (if it isn't nil) and decodes the response into v (if it isn't nil).
+func (c *Client) do(ctx context.Context, method, path string, body, v any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// send sends a request and returns the response if it's a 200, or the server's error otherwise.
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, rd)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+		apiErr.Message = e.Error
+	}
+	return nil, apiErr
+}
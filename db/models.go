@@ -5,6 +5,8 @@
 package db
 
 import (
+	"encoding/json"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -16,9 +18,29 @@ type Album struct {
 	AlbumReleaseDate pgtype.Date `json:"album_release_date"`
 }
 
+type ApiToken struct {
+	ID         pgtype.UUID      `json:"id"`
+	UserID     pgtype.UUID      `json:"user_id"`
+	Name       string           `json:"name"`
+	TokenHash  string           `json:"token_hash"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	Scopes     []string         `json:"scopes"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamp `json:"revoked_at"`
+}
+
 type Artist struct {
-	ArtistID   string `json:"artist_id"`
-	ArtistName string `json:"artist_name"`
+	ArtistID   string   `json:"artist_id"`
+	ArtistName string   `json:"artist_name"`
+	Genres     []string `json:"genres"`
+}
+
+type FailedDownload struct {
+	Thing    string           `json:"thing"`
+	Error    string           `json:"error"`
+	Attempts int32            `json:"attempts"`
+	FailedAt pgtype.Timestamp `json:"failed_at"`
 }
 
 type Play struct {
@@ -26,6 +48,20 @@ type Play struct {
 	TrackID   string           `json:"track_id"`
 	PlayedAt  pgtype.Timestamp `json:"played_at"`
 	SkippedAt pgtype.Int4      `json:"skipped_at"`
+	UserID    pgtype.UUID      `json:"user_id"`
+}
+
+type PlaybackSession struct {
+	SessionID        string           `json:"session_id"`
+	CurrentTrackID   pgtype.Text      `json:"current_track_id"`
+	Position         float64          `json:"position"`
+	Queue            []string         `json:"queue"`
+	History          []string         `json:"history"`
+	Shuffle          bool             `json:"shuffle"`
+	Repeat           string           `json:"repeat"`
+	SourcePlaylistID pgtype.UUID      `json:"source_playlist_id"`
+	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
+	ShuffleSeed      pgtype.Int8      `json:"shuffle_seed"`
 }
 
 type Playlist struct {
@@ -34,23 +70,95 @@ type Playlist struct {
 	Description string           `json:"description"`
 	ImageUrl    string           `json:"image_url"`
 	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	Rules       json.RawMessage  `json:"rules"`
+	FolderID    pgtype.UUID      `json:"folder_id"`
+	UserID      pgtype.UUID      `json:"user_id"`
+}
+
+type PlaylistFolder struct {
+	ID        pgtype.UUID      `json:"id"`
+	Name      string           `json:"name"`
+	ParentID  pgtype.UUID      `json:"parent_id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	UserID    pgtype.UUID      `json:"user_id"`
 }
 
 type PlaylistTrack struct {
-	PlaylistID pgtype.UUID `json:"playlist_id"`
-	TrackID    string      `json:"track_id"`
+	PlaylistID pgtype.UUID      `json:"playlist_id"`
+	TrackID    string           `json:"track_id"`
+	Position   int32            `json:"position"`
+	AddedAt    pgtype.Timestamp `json:"added_at"`
+}
+
+type SubsonicPassword struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	Password  string           `json:"password"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type Tag struct {
+	Tag        string           `json:"tag"`
+	TargetType string           `json:"target_type"`
+	TargetID   string           `json:"target_id"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
 }
 
 type Track struct {
-	TrackID          string      `json:"track_id"`
-	TrackName        string      `json:"track_name"`
-	Duration         int32       `json:"duration"`
-	Popularity       int32       `json:"popularity"`
-	AlbumID          string      `json:"album_id"`
-	ArtistID         string      `json:"artist_id"`
-	Artists          []string    `json:"artists"`
-	TrackReleaseDate pgtype.Date `json:"track_release_date"`
-	Downloaded       bool        `json:"downloaded"`
-	YoutubeUrl       string      `json:"youtube_url"`
-	Lyrics           string      `json:"lyrics"`
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	YoutubeUrl       string           `json:"youtube_url"`
+	Lyrics           string           `json:"lyrics"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+}
+
+type TrackRating struct {
+	TrackID string           `json:"track_id"`
+	LikedAt pgtype.Timestamp `json:"liked_at"`
+	Rating  pgtype.Int4      `json:"rating"`
+	UserID  pgtype.UUID      `json:"user_id"`
+}
+
+type User struct {
+	ID           pgtype.UUID      `json:"id"`
+	Username     string           `json:"username"`
+	PasswordHash string           `json:"password_hash"`
+	IsAdmin      bool             `json:"is_admin"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+}
+
+type UserSession struct {
+	TokenHash string           `json:"token_hash"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+type Webhook struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	Url       string           `json:"url"`
+	Secret    string           `json:"secret"`
+	Events    []string         `json:"events"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             pgtype.UUID      `json:"id"`
+	WebhookID      pgtype.UUID      `json:"webhook_id"`
+	Event          string           `json:"event"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       int32            `json:"attempts"`
+	LastStatusCode pgtype.Int4      `json:"last_status_code"`
+	LastError      pgtype.Text      `json:"last_error"`
+	NextAttemptAt  pgtype.Timestamp `json:"next_attempt_at"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	DeliveredAt    pgtype.Timestamp `json:"delivered_at"`
 }
@@ -7,44 +7,319 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addTag = `-- name: AddTag :exec
+INSERT INTO tags (tag, target_type, target_id)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING
+`
+
+type AddTagParams struct {
+	Tag        string `json:"tag"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+func (q *Queries) AddTag(ctx context.Context, arg AddTagParams) error {
+	_, err := q.db.Exec(ctx, addTag, arg.Tag, arg.TargetType, arg.TargetID)
+	return err
+}
+
 const addTrackToPlaylist = `-- name: AddTrackToPlaylist :exec
-INSERT INTO playlist_tracks (playlist_id, track_id)
-VALUES ($1, $2)
+INSERT INTO playlist_tracks (playlist_id, track_id, position)
+VALUES ($1, $2, $3)
 `
 
 type AddTrackToPlaylistParams struct {
 	PlaylistID pgtype.UUID `json:"playlist_id"`
 	TrackID    string      `json:"track_id"`
+	Position   int32       `json:"position"`
 }
 
 func (q *Queries) AddTrackToPlaylist(ctx context.Context, arg AddTrackToPlaylistParams) error {
-	_, err := q.db.Exec(ctx, addTrackToPlaylist, arg.PlaylistID, arg.TrackID)
+	_, err := q.db.Exec(ctx, addTrackToPlaylist, arg.PlaylistID, arg.TrackID, arg.Position)
 	return err
 }
 
-const createPlaylist = `-- name: CreatePlaylist :one
-INSERT INTO playlists (name, description, image_url)
+const artistGenresFetched = `-- name: ArtistGenresFetched :one
+SELECT genres IS NOT NULL AS fetched FROM artists WHERE artist_id = $1
+`
+
+func (q *Queries) ArtistGenresFetched(ctx context.Context, artistID string) (bool, error) {
+	row := q.db.QueryRow(ctx, artistGenresFetched, artistID)
+	var fetched bool
+	err := row.Scan(&fetched)
+	return fetched, err
+}
+
+const claimUnownedData = `-- name: ClaimUnownedData :exec
+WITH claimed_playlists AS (
+    UPDATE playlists SET user_id = $1 WHERE playlists.user_id IS NULL
+), claimed_folders AS (
+    UPDATE playlist_folders SET user_id = $1 WHERE playlist_folders.user_id IS NULL
+), claimed_plays AS (
+    UPDATE plays SET user_id = $1 WHERE plays.user_id IS NULL
+), claimed_session AS (
+    UPDATE playback_sessions SET session_id = $1::text WHERE session_id = 'default'
+)
+UPDATE track_ratings SET user_id = $1 WHERE track_ratings.user_id IS NULL
+`
+
+// gives the playlists, folders, plays, likes & ratings and playback session from before there were users to
+// the user.
+func (q *Queries) ClaimUnownedData(ctx context.Context, userID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, claimUnownedData, userID)
+	return err
+}
+
+const countPlaylistTracks = `-- name: CountPlaylistTracks :one
+SELECT count(*) FROM playlist_tracks WHERE playlist_id = $1
+`
+
+func (q *Queries) CountPlaylistTracks(ctx context.Context, playlistID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countPlaylistTracks, playlistID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT count(*) FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAPIToken = `-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at
+`
+
+type CreateAPITokenParams struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	Name      string           `json:"name"`
+	TokenHash string           `json:"token_hash"`
+	Scopes    []string         `json:"scopes"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+type CreateAPITokenRow struct {
+	ID        pgtype.UUID      `json:"id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (CreateAPITokenRow, error) {
+	row := q.db.QueryRow(ctx, createAPIToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i CreateAPITokenRow
+	err := row.Scan(&i.ID, &i.CreatedAt)
+	return i, err
+}
+
+const createFolder = `-- name: CreateFolder :one
+INSERT INTO playlist_folders (name, parent_id, user_id)
 VALUES ($1, $2, $3)
 RETURNING id
 `
 
+type CreateFolderParams struct {
+	Name     string      `json:"name"`
+	ParentID pgtype.UUID `json:"parent_id"`
+	UserID   pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) CreateFolder(ctx context.Context, arg CreateFolderParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createFolder, arg.Name, arg.ParentID, arg.UserID)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createPlaylist = `-- name: CreatePlaylist :one
+INSERT INTO playlists (name, description, image_url, user_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id
+`
+
 type CreatePlaylistParams struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	ImageUrl    string `json:"image_url"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	ImageUrl    string      `json:"image_url"`
+	UserID      pgtype.UUID `json:"user_id"`
 }
 
 func (q *Queries) CreatePlaylist(ctx context.Context, arg CreatePlaylistParams) (pgtype.UUID, error) {
-	row := q.db.QueryRow(ctx, createPlaylist, arg.Name, arg.Description, arg.ImageUrl)
+	row := q.db.QueryRow(ctx, createPlaylist,
+		arg.Name,
+		arg.Description,
+		arg.ImageUrl,
+		arg.UserID,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createSmartPlaylist = `-- name: CreateSmartPlaylist :one
+INSERT INTO playlists (name, description, image_url, rules, user_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`
+
+type CreateSmartPlaylistParams struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	ImageUrl    string          `json:"image_url"`
+	Rules       json.RawMessage `json:"rules"`
+	UserID      pgtype.UUID     `json:"user_id"`
+}
+
+func (q *Queries) CreateSmartPlaylist(ctx context.Context, arg CreateSmartPlaylistParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createSmartPlaylist,
+		arg.Name,
+		arg.Description,
+		arg.ImageUrl,
+		arg.Rules,
+		arg.UserID,
+	)
 	var id pgtype.UUID
 	err := row.Scan(&id)
 	return id, err
 }
 
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (username, password_hash, is_admin)
+VALUES ($1, $2, $3)
+RETURNING id, username, password_hash, is_admin, created_at
+`
+
+type CreateUserParams struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	IsAdmin      bool   `json:"is_admin"`
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createUser, arg.Username, arg.PasswordHash, arg.IsAdmin)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createUserSession = `-- name: CreateUserSession :exec
+INSERT INTO user_sessions (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3)
+`
+
+type CreateUserSessionParams struct {
+	TokenHash string           `json:"token_hash"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateUserSession(ctx context.Context, arg CreateUserSessionParams) error {
+	_, err := q.db.Exec(ctx, createUserSession, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createWebhook = `-- name: CreateWebhook :one
+INSERT INTO webhooks (user_id, url, secret, events)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, url, secret, events, created_at
+`
+
+type CreateWebhookParams struct {
+	UserID pgtype.UUID `json:"user_id"`
+	Url    string      `json:"url"`
+	Secret string      `json:"secret"`
+	Events []string    `json:"events"`
+}
+
+func (q *Queries) CreateWebhook(ctx context.Context, arg CreateWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, createWebhook,
+		arg.UserID,
+		arg.Url,
+		arg.Secret,
+		arg.Events,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteExpiredUserSessions = `-- name: DeleteExpiredUserSessions :exec
+DELETE FROM user_sessions WHERE expires_at <= CURRENT_TIMESTAMP
+`
+
+func (q *Queries) DeleteExpiredUserSessions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredUserSessions)
+	return err
+}
+
+const deleteFailedDownload = `-- name: DeleteFailedDownload :exec
+DELETE FROM failed_downloads WHERE thing = $1
+`
+
+func (q *Queries) DeleteFailedDownload(ctx context.Context, thing string) error {
+	_, err := q.db.Exec(ctx, deleteFailedDownload, thing)
+	return err
+}
+
+const deleteFolder = `-- name: DeleteFolder :exec
+DELETE FROM playlist_folders WHERE id = $1
+`
+
+func (q *Queries) DeleteFolder(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteFolder, id)
+	return err
+}
+
+const deleteOldWebhookDeliveries = `-- name: DeleteOldWebhookDeliveries :exec
+DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < CURRENT_TIMESTAMP - interval '7 days'
+`
+
+// deliveries are kept for a week after they're created so they can be looked at, unless they're pending.
+func (q *Queries) DeleteOldWebhookDeliveries(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteOldWebhookDeliveries)
+	return err
+}
+
+const deletePlaybackSession = `-- name: DeletePlaybackSession :exec
+DELETE FROM playback_sessions WHERE session_id = $1
+`
+
+func (q *Queries) DeletePlaybackSession(ctx context.Context, sessionID string) error {
+	_, err := q.db.Exec(ctx, deletePlaybackSession, sessionID)
+	return err
+}
+
 const deletePlaylist = `-- name: DeletePlaylist :exec
 DELETE FROM playlists WHERE id = $1
 `
@@ -54,6 +329,189 @@ func (q *Queries) DeletePlaylist(ctx context.Context, id pgtype.UUID) error {
 	return err
 }
 
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserSession = `-- name: DeleteUserSession :exec
+DELETE FROM user_sessions WHERE token_hash = $1
+`
+
+func (q *Queries) DeleteUserSession(ctx context.Context, tokenHash string) error {
+	_, err := q.db.Exec(ctx, deleteUserSession, tokenHash)
+	return err
+}
+
+const deleteUserSessionsExcept = `-- name: DeleteUserSessionsExcept :exec
+DELETE FROM user_sessions WHERE user_id = $1 AND token_hash <> $2
+`
+
+type DeleteUserSessionsExceptParams struct {
+	UserID    pgtype.UUID `json:"user_id"`
+	TokenHash string      `json:"token_hash"`
+}
+
+// logs the user out everywhere but the session with the token hash (which can be empty to log out everywhere).
+func (q *Queries) DeleteUserSessionsExcept(ctx context.Context, arg DeleteUserSessionsExceptParams) error {
+	_, err := q.db.Exec(ctx, deleteUserSessionsExcept, arg.UserID, arg.TokenHash)
+	return err
+}
+
+const deleteWebhook = `-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE id = $1 AND user_id = $2
+`
+
+type DeleteWebhookParams struct {
+	ID     pgtype.UUID `json:"id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeleteWebhook(ctx context.Context, arg DeleteWebhookParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhook, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (webhook_id, event, payload)
+SELECT id, $1::text, $2::jsonb FROM webhooks
+WHERE $1::text = ANY(events)
+    AND user_id = $3::uuid
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	UserID  pgtype.UUID     `json:"user_id"`
+}
+
+// queues an event for the webhooks of the user it happened to that are subscribed to it.
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, enqueueWebhookDeliveries, arg.Event, arg.Payload, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const firstPlay = `-- name: FirstPlay :one
+SELECT
+    p.play_id,
+    p.played_at,
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.cover_url
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+ORDER BY p.played_at
+LIMIT 1
+`
+
+type FirstPlayParams struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type FirstPlayRow struct {
+	PlayID    pgtype.UUID      `json:"play_id"`
+	PlayedAt  pgtype.Timestamp `json:"played_at"`
+	TrackID   string           `json:"track_id"`
+	TrackName string           `json:"track_name"`
+	Artists   []string         `json:"artists"`
+	CoverUrl  string           `json:"cover_url"`
+}
+
+func (q *Queries) FirstPlay(ctx context.Context, arg FirstPlayParams) (FirstPlayRow, error) {
+	row := q.db.QueryRow(ctx, firstPlay, arg.UserID, arg.StartTime, arg.EndTime)
+	var i FirstPlayRow
+	err := row.Scan(
+		&i.PlayID,
+		&i.PlayedAt,
+		&i.TrackID,
+		&i.TrackName,
+		&i.Artists,
+		&i.CoverUrl,
+	)
+	return i, err
+}
+
+const folderIsWithin = `-- name: FolderIsWithin :one
+WITH RECURSIVE chain AS (
+    SELECT f.id, f.parent_id FROM playlist_folders f WHERE f.id = $1
+    UNION ALL
+    SELECT f.id, f.parent_id FROM playlist_folders f JOIN chain c ON f.id = c.parent_id
+)
+SELECT EXISTS (
+    SELECT 1 FROM chain WHERE chain.id = $2
+)
+`
+
+type FolderIsWithinParams struct {
+	FolderID   pgtype.UUID `json:"folder_id"`
+	AncestorID pgtype.UUID `json:"ancestor_id"`
+}
+
+// whether folder_id is ancestor_id or inside of it (at any depth).
+func (q *Queries) FolderIsWithin(ctx context.Context, arg FolderIsWithinParams) (bool, error) {
+	row := q.db.QueryRow(ctx, folderIsWithin, arg.FolderID, arg.AncestorID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const getFolder = `-- name: GetFolder :one
+SELECT id, name, parent_id, created_at, user_id FROM playlist_folders WHERE id = $1
+`
+
+func (q *Queries) GetFolder(ctx context.Context, id pgtype.UUID) (PlaylistFolder, error) {
+	row := q.db.QueryRow(ctx, getFolder, id)
+	var i PlaylistFolder
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.ParentID,
+		&i.CreatedAt,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const getPlaybackSession = `-- name: GetPlaybackSession :one
+SELECT session_id, current_track_id, position, queue, history, shuffle, repeat, source_playlist_id, updated_at, shuffle_seed FROM playback_sessions WHERE session_id = $1
+`
+
+func (q *Queries) GetPlaybackSession(ctx context.Context, sessionID string) (PlaybackSession, error) {
+	row := q.db.QueryRow(ctx, getPlaybackSession, sessionID)
+	var i PlaybackSession
+	err := row.Scan(
+		&i.SessionID,
+		&i.CurrentTrackID,
+		&i.Position,
+		&i.Queue,
+		&i.History,
+		&i.Shuffle,
+		&i.Repeat,
+		&i.SourcePlaylistID,
+		&i.UpdatedAt,
+		&i.ShuffleSeed,
+	)
+	return i, err
+}
+
 const getPlaylist = `-- name: GetPlaylist :one
 SELECT
     p.id,
@@ -61,6 +519,9 @@ SELECT
     p.description,
     p.image_url,
     p.created_at,
+    p.rules,
+    p.folder_id,
+    p.user_id,
     COALESCE(
         json_agg(
             json_build_object(
@@ -69,13 +530,34 @@ SELECT
                 'duration', t.duration,
                 'popularity', t.popularity,
                 'album_id', t.album_id,
+                'album_name', a.album_name,
                 'artist_id', t.artist_id,
+                'artist_name', ar.artist_name,
                 'artists', t.artists,
                 'cover_url', a.cover_url,
                 'downloaded', t.downloaded,
                 'track_release_date', t.track_release_date,
-                'lyrics', t.lyrics
+                'lyrics', t.lyrics,
+                'play_count', pc.play_count,
+                'position', pt.position,
+                'added_at', pt.added_at,
+                'liked_at', r.liked_at,
+                'rating', r.rating
             )
+            ORDER BY
+                CASE WHEN NOT $1::boolean THEN
+                    CASE $2::text WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+                END ASC,
+                CASE WHEN $1::boolean THEN
+                    CASE $2::text WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+                END DESC,
+                CASE WHEN NOT $1::boolean THEN
+                    CASE $2::text WHEN 'added_at' THEN extract(epoch FROM pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN pc.play_count END
+                END ASC,
+                CASE WHEN $1::boolean THEN
+                    CASE $2::text WHEN 'added_at' THEN extract(epoch FROM pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN pc.play_count END
+                END DESC,
+                pt.position
         ) FILTER (WHERE t.track_id IS NOT NULL),
         '[]'::json
     ) AS tracks
@@ -83,21 +565,37 @@ FROM playlists p
 LEFT JOIN playlist_tracks pt ON pt.playlist_id = p.id
 LEFT JOIN tracks t ON t.track_id = pt.track_id
 LEFT JOIN albums a ON t.album_id = a.album_id
-WHERE p.id = $1
+LEFT JOIN artists ar ON t.artist_id = ar.artist_id
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = p.user_id
+LEFT JOIN LATERAL (
+    SELECT count(*) AS play_count FROM plays WHERE plays.track_id = t.track_id AND plays.user_id = p.user_id
+) pc ON TRUE
+WHERE p.id = $3
 GROUP BY p.id
 `
 
+type GetPlaylistParams struct {
+	Descending bool        `json:"descending"`
+	Sort       string      `json:"sort"`
+	ID         pgtype.UUID `json:"id"`
+}
+
 type GetPlaylistRow struct {
 	ID          pgtype.UUID      `json:"id"`
 	Name        string           `json:"name"`
 	Description string           `json:"description"`
 	ImageUrl    string           `json:"image_url"`
 	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	Rules       json.RawMessage  `json:"rules"`
+	FolderID    pgtype.UUID      `json:"folder_id"`
+	UserID      pgtype.UUID      `json:"user_id"`
 	Tracks      interface{}      `json:"tracks"`
 }
 
-func (q *Queries) GetPlaylist(ctx context.Context, id pgtype.UUID) (GetPlaylistRow, error) {
-	row := q.db.QueryRow(ctx, getPlaylist, id)
+// the tracks are in playlist order, or sorted by sort (title, artist, album, added_at, duration or
+// play_count) if it isn't empty.
+func (q *Queries) GetPlaylist(ctx context.Context, arg GetPlaylistParams) (GetPlaylistRow, error) {
+	row := q.db.QueryRow(ctx, getPlaylist, arg.Descending, arg.Sort, arg.ID)
 	var i GetPlaylistRow
 	err := row.Scan(
 		&i.ID,
@@ -105,31 +603,57 @@ func (q *Queries) GetPlaylist(ctx context.Context, id pgtype.UUID) (GetPlaylistR
 		&i.Description,
 		&i.ImageUrl,
 		&i.CreatedAt,
+		&i.Rules,
+		&i.FolderID,
+		&i.UserID,
 		&i.Tracks,
 	)
 	return i, err
 }
 
+const getPlaylistByID = `-- name: GetPlaylistByID :one
+SELECT id, name, description, image_url, created_at, rules, folder_id, user_id FROM playlists WHERE id = $1
+`
+
+func (q *Queries) GetPlaylistByID(ctx context.Context, id pgtype.UUID) (Playlist, error) {
+	row := q.db.QueryRow(ctx, getPlaylistByID, id)
+	var i Playlist
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.ImageUrl,
+		&i.CreatedAt,
+		&i.Rules,
+		&i.FolderID,
+		&i.UserID,
+	)
+	return i, err
+}
+
 const getPlaylistTracksNotDownloaded = `-- name: GetPlaylistTracksNotDownloaded :many
-SELECT tracks.track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, playlist_id, playlist_tracks.track_id FROM tracks
+SELECT tracks.track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, tracks.added_at, playlist_id, playlist_tracks.track_id, position, playlist_tracks.added_at FROM tracks
 JOIN playlist_tracks ON tracks.track_id = playlist_tracks.track_id
 WHERE playlist_tracks.playlist_id = $1 AND tracks.downloaded = FALSE
 `
 
 type GetPlaylistTracksNotDownloadedRow struct {
-	TrackID          string      `json:"track_id"`
-	TrackName        string      `json:"track_name"`
-	Duration         int32       `json:"duration"`
-	Popularity       int32       `json:"popularity"`
-	AlbumID          string      `json:"album_id"`
-	ArtistID         string      `json:"artist_id"`
-	Artists          []string    `json:"artists"`
-	TrackReleaseDate pgtype.Date `json:"track_release_date"`
-	Downloaded       bool        `json:"downloaded"`
-	YoutubeUrl       string      `json:"youtube_url"`
-	Lyrics           string      `json:"lyrics"`
-	PlaylistID       pgtype.UUID `json:"playlist_id"`
-	TrackID_2        string      `json:"track_id_2"`
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	YoutubeUrl       string           `json:"youtube_url"`
+	Lyrics           string           `json:"lyrics"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	PlaylistID       pgtype.UUID      `json:"playlist_id"`
+	TrackID_2        string           `json:"track_id_2"`
+	Position         int32            `json:"position"`
+	AddedAt_2        pgtype.Timestamp `json:"added_at_2"`
 }
 
 func (q *Queries) GetPlaylistTracksNotDownloaded(ctx context.Context, playlistID pgtype.UUID) ([]GetPlaylistTracksNotDownloadedRow, error) {
@@ -153,8 +677,11 @@ func (q *Queries) GetPlaylistTracksNotDownloaded(ctx context.Context, playlistID
 			&i.Downloaded,
 			&i.YoutubeUrl,
 			&i.Lyrics,
+			&i.AddedAt,
 			&i.PlaylistID,
 			&i.TrackID_2,
+			&i.Position,
+			&i.AddedAt_2,
 		); err != nil {
 			return nil, err
 		}
@@ -166,8 +693,19 @@ func (q *Queries) GetPlaylistTracksNotDownloaded(ctx context.Context, playlistID
 	return items, nil
 }
 
+const getSubsonicPassword = `-- name: GetSubsonicPassword :one
+SELECT password FROM subsonic_passwords WHERE user_id = $1
+`
+
+func (q *Queries) GetSubsonicPassword(ctx context.Context, userID pgtype.UUID) (string, error) {
+	row := q.db.QueryRow(ctx, getSubsonicPassword, userID)
+	var password string
+	err := row.Scan(&password)
+	return password, err
+}
+
 const getTrackByID = `-- name: GetTrackByID :one
-SELECT track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics FROM tracks WHERE track_id = $1
+SELECT track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, added_at FROM tracks WHERE track_id = $1
 `
 
 func (q *Queries) GetTrackByID(ctx context.Context, trackID string) (Track, error) {
@@ -185,6 +723,7 @@ func (q *Queries) GetTrackByID(ctx context.Context, trackID string) (Track, erro
 		&i.Downloaded,
 		&i.YoutubeUrl,
 		&i.Lyrics,
+		&i.AddedAt,
 	)
 	return i, err
 }
@@ -200,38 +739,248 @@ func (q *Queries) GetTrackLyrics(ctx context.Context, trackID string) (string, e
 	return lyrics, err
 }
 
-const getYoutubeURLByTrackID = `-- name: GetYoutubeURLByTrackID :one
-SELECT youtube_url FROM tracks WHERE track_id = $1
+const getTrackRating = `-- name: GetTrackRating :one
+SELECT track_id, liked_at, rating, user_id FROM track_ratings WHERE user_id = $1 AND track_id = $2
 `
 
-func (q *Queries) GetYoutubeURLByTrackID(ctx context.Context, trackID string) (string, error) {
-	row := q.db.QueryRow(ctx, getYoutubeURLByTrackID, trackID)
-	var youtube_url string
-	err := row.Scan(&youtube_url)
-	return youtube_url, err
+type GetTrackRatingParams struct {
+	UserID  pgtype.UUID `json:"user_id"`
+	TrackID string      `json:"track_id"`
 }
 
-const insertTrack = `-- name: InsertTrack :exec
-WITH upsert_artist AS (
-    INSERT INTO artists (artist_id, artist_name)
-    VALUES ($1, $2)
-    ON CONFLICT (artist_id) DO NOTHING
-),
-upsert_album AS (
-    INSERT INTO albums (album_id, album_name, artist_id, cover_url, album_release_date)
-    VALUES ($3, $4, $1, $5, $6)
-    ON CONFLICT (album_id) DO NOTHING
-)
-INSERT INTO tracks (
-    track_id,
-    track_name,
-    duration,
-    popularity,
-    album_id,
-    artist_id,
-    artists,
-    track_release_date,
-    downloaded,
+func (q *Queries) GetTrackRating(ctx context.Context, arg GetTrackRatingParams) (TrackRating, error) {
+	row := q.db.QueryRow(ctx, getTrackRating, arg.UserID, arg.TrackID)
+	var i TrackRating
+	err := row.Scan(
+		&i.TrackID,
+		&i.LikedAt,
+		&i.Rating,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const getTracksByIDs = `-- name: GetTracksByIDs :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = $1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = $1
+WHERE t.track_id = ANY($2::text[])
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+`
+
+type GetTracksByIDsParams struct {
+	UserID   pgtype.UUID `json:"user_id"`
+	TrackIds []string    `json:"track_ids"`
+}
+
+type GetTracksByIDsRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// the tracks with the given IDs (in no particular order) with the same columns as ListLibraryTracks.
+func (q *Queries) GetTracksByIDs(ctx context.Context, arg GetTracksByIDsParams) ([]GetTracksByIDsRow, error) {
+	rows, err := q.db.Query(ctx, getTracksByIDs, arg.UserID, arg.TrackIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTracksByIDsRow
+	for rows.Next() {
+		var i GetTracksByIDsRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.AddedAt,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserByAPIToken = `-- name: GetUserByAPIToken :one
+SELECT users.id, users.username, users.password_hash, users.is_admin, users.created_at,
+    api_tokens.id AS token_id, api_tokens.scopes
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = $1 AND api_tokens.revoked_at IS NULL
+    AND (api_tokens.expires_at IS NULL OR api_tokens.expires_at > CURRENT_TIMESTAMP)
+`
+
+type GetUserByAPITokenRow struct {
+	ID           pgtype.UUID      `json:"id"`
+	Username     string           `json:"username"`
+	PasswordHash string           `json:"password_hash"`
+	IsAdmin      bool             `json:"is_admin"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+	TokenID      pgtype.UUID      `json:"token_id"`
+	Scopes       []string         `json:"scopes"`
+}
+
+// only tokens that are neither revoked nor expired.
+func (q *Queries) GetUserByAPIToken(ctx context.Context, tokenHash string) (GetUserByAPITokenRow, error) {
+	row := q.db.QueryRow(ctx, getUserByAPIToken, tokenHash)
+	var i GetUserByAPITokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+		&i.TokenID,
+		&i.Scopes,
+	)
+	return i, err
+}
+
+const getUserBySession = `-- name: GetUserBySession :one
+SELECT users.id, users.username, users.password_hash, users.is_admin, users.created_at FROM user_sessions
+JOIN users ON users.id = user_sessions.user_id
+WHERE user_sessions.token_hash = $1 AND user_sessions.expires_at > CURRENT_TIMESTAMP
+`
+
+func (q *Queries) GetUserBySession(ctx context.Context, tokenHash string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserBySession, tokenHash)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username, password_hash, is_admin, created_at FROM users WHERE username = $1
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getWebhook = `-- name: GetWebhook :one
+SELECT id, user_id, url, secret, events, created_at FROM webhooks WHERE id = $1 AND user_id = $2
+`
+
+type GetWebhookParams struct {
+	ID     pgtype.UUID `json:"id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) GetWebhook(ctx context.Context, arg GetWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, getWebhook, arg.ID, arg.UserID)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getYoutubeURLByTrackID = `-- name: GetYoutubeURLByTrackID :one
+SELECT youtube_url FROM tracks WHERE track_id = $1
+`
+
+func (q *Queries) GetYoutubeURLByTrackID(ctx context.Context, trackID string) (string, error) {
+	row := q.db.QueryRow(ctx, getYoutubeURLByTrackID, trackID)
+	var youtube_url string
+	err := row.Scan(&youtube_url)
+	return youtube_url, err
+}
+
+const insertTrack = `-- name: InsertTrack :exec
+WITH upsert_artist AS (
+    INSERT INTO artists (artist_id, artist_name)
+    VALUES ($1, $2)
+    ON CONFLICT (artist_id) DO NOTHING
+),
+upsert_album AS (
+    INSERT INTO albums (album_id, album_name, artist_id, cover_url, album_release_date)
+    VALUES ($3, $4, $1, $5, $6)
+    ON CONFLICT (album_id) DO NOTHING
+)
+INSERT INTO tracks (
+    track_id,
+    track_name,
+    duration,
+    popularity,
+    album_id,
+    artist_id,
+    artists,
+    track_release_date,
+    downloaded,
     lyrics,
     youtube_url
 )
@@ -304,26 +1053,35 @@ func (q *Queries) InsertTrack(ctx context.Context, arg InsertTrackParams) error
 	return err
 }
 
-const listPlaylists = `-- name: ListPlaylists :many
-SELECT id, name, description, image_url, created_at FROM playlists ORDER BY created_at DESC
+const lastPlayedBefore = `-- name: LastPlayedBefore :many
+SELECT track_id, max(played_at)::timestamp AS last_played_at
+FROM plays
+WHERE track_id = ANY($1::text[]) AND played_at < $2 AND user_id = $3
+GROUP BY track_id
 `
 
-func (q *Queries) ListPlaylists(ctx context.Context) ([]Playlist, error) {
-	rows, err := q.db.Query(ctx, listPlaylists)
+type LastPlayedBeforeParams struct {
+	TrackIds []string         `json:"track_ids"`
+	Before   pgtype.Timestamp `json:"before"`
+	UserID   pgtype.UUID      `json:"user_id"`
+}
+
+type LastPlayedBeforeRow struct {
+	TrackID      string           `json:"track_id"`
+	LastPlayedAt pgtype.Timestamp `json:"last_played_at"`
+}
+
+// when each of the tracks was last played before a point in time. tracks without plays are left out.
+func (q *Queries) LastPlayedBefore(ctx context.Context, arg LastPlayedBeforeParams) ([]LastPlayedBeforeRow, error) {
+	rows, err := q.db.Query(ctx, lastPlayedBefore, arg.TrackIds, arg.Before, arg.UserID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Playlist
+	var items []LastPlayedBeforeRow
 	for rows.Next() {
-		var i Playlist
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.ImageUrl,
-			&i.CreatedAt,
-		); err != nil {
+		var i LastPlayedBeforeRow
+		if err := rows.Scan(&i.TrackID, &i.LastPlayedAt); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -334,136 +1092,155 @@ func (q *Queries) ListPlaylists(ctx context.Context) ([]Playlist, error) {
 	return items, nil
 }
 
-const markTrackAsDownloaded = `-- name: MarkTrackAsDownloaded :exec
-UPDATE tracks
-SET downloaded = TRUE
-WHERE track_id = $1
-`
-
-func (q *Queries) MarkTrackAsDownloaded(ctx context.Context, trackID string) error {
-	_, err := q.db.Exec(ctx, markTrackAsDownloaded, trackID)
-	return err
-}
-
-const markTrackAsNotDownloaded = `-- name: MarkTrackAsNotDownloaded :exec
-UPDATE tracks
-SET downloaded = FALSE
-WHERE track_id = $1
-`
-
-func (q *Queries) MarkTrackAsNotDownloaded(ctx context.Context, trackID string) error {
-	_, err := q.db.Exec(ctx, markTrackAsNotDownloaded, trackID)
-	return err
-}
-
-const playlistWithNameExists = `-- name: PlaylistWithNameExists :one
-SELECT EXISTS (
-    SELECT 1 FROM playlists WHERE name = $1
-)
-`
-
-func (q *Queries) PlaylistWithNameExists(ctx context.Context, name string) (bool, error) {
-	row := q.db.QueryRow(ctx, playlistWithNameExists, name)
-	var exists bool
-	err := row.Scan(&exists)
-	return exists, err
-}
-
-const recordPlay = `-- name: RecordPlay :one
-INSERT INTO plays (track_id, played_at, skipped_at)
-VALUES ($1, $2, $3)
-ON CONFLICT (track_id, played_at) DO NOTHING
-RETURNING play_id
-`
-
-type RecordPlayParams struct {
-	TrackID   string           `json:"track_id"`
-	PlayedAt  pgtype.Timestamp `json:"played_at"`
-	SkippedAt pgtype.Int4      `json:"skipped_at"`
-}
-
-func (q *Queries) RecordPlay(ctx context.Context, arg RecordPlayParams) (pgtype.UUID, error) {
-	row := q.db.QueryRow(ctx, recordPlay, arg.TrackID, arg.PlayedAt, arg.SkippedAt)
-	var play_id pgtype.UUID
-	err := row.Scan(&play_id)
-	return play_id, err
-}
-
-const recordSkip = `-- name: RecordSkip :exec
-UPDATE plays
-SET skipped_at = $1
-WHERE play_id = $2
+const libraryCounts = `-- name: LibraryCounts :one
+SELECT
+    (SELECT count(*) FROM tracks) AS tracks,
+    (SELECT count(*) FROM tracks WHERE downloaded) AS downloaded_tracks,
+    (SELECT count(*) FROM albums) AS albums,
+    (SELECT count(*) FROM artists) AS artists,
+    (SELECT count(*) FROM playlists) AS playlists,
+    (SELECT count(*) FROM users) AS users,
+    (SELECT count(*) FROM plays) AS plays,
+    (SELECT count(*) FROM failed_downloads) AS failed_downloads
 `
 
-type RecordSkipParams struct {
-	SkippedAt pgtype.Int4 `json:"skipped_at"`
-	PlayID    pgtype.UUID `json:"play_id"`
+type LibraryCountsRow struct {
+	Tracks           int64 `json:"tracks"`
+	DownloadedTracks int64 `json:"downloaded_tracks"`
+	Albums           int64 `json:"albums"`
+	Artists          int64 `json:"artists"`
+	Playlists        int64 `json:"playlists"`
+	Users            int64 `json:"users"`
+	Plays            int64 `json:"plays"`
+	FailedDownloads  int64 `json:"failed_downloads"`
 }
 
-func (q *Queries) RecordSkip(ctx context.Context, arg RecordSkipParams) error {
-	_, err := q.db.Exec(ctx, recordSkip, arg.SkippedAt, arg.PlayID)
-	return err
+func (q *Queries) LibraryCounts(ctx context.Context) (LibraryCountsRow, error) {
+	row := q.db.QueryRow(ctx, libraryCounts)
+	var i LibraryCountsRow
+	err := row.Scan(
+		&i.Tracks,
+		&i.DownloadedTracks,
+		&i.Albums,
+		&i.Artists,
+		&i.Playlists,
+		&i.Users,
+		&i.Plays,
+		&i.FailedDownloads,
+	)
+	return i, err
 }
 
-const removeTrackFromPlaylist = `-- name: RemoveTrackFromPlaylist :exec
-DELETE FROM playlist_tracks
-WHERE playlist_id = $1 AND track_id = $2
+const listAPITokens = `-- name: ListAPITokens :many
+SELECT id, name, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_tokens
+WHERE user_id = $1 ORDER BY created_at DESC
 `
 
-type RemoveTrackFromPlaylistParams struct {
-	PlaylistID pgtype.UUID `json:"playlist_id"`
-	TrackID    string      `json:"track_id"`
+type ListAPITokensRow struct {
+	ID         pgtype.UUID      `json:"id"`
+	Name       string           `json:"name"`
+	Scopes     []string         `json:"scopes"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamp `json:"revoked_at"`
 }
 
-func (q *Queries) RemoveTrackFromPlaylist(ctx context.Context, arg RemoveTrackFromPlaylistParams) error {
-	_, err := q.db.Exec(ctx, removeTrackFromPlaylist, arg.PlaylistID, arg.TrackID)
-	return err
+func (q *Queries) ListAPITokens(ctx context.Context, userID pgtype.UUID) ([]ListAPITokensRow, error) {
+	rows, err := q.db.Query(ctx, listAPITokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAPITokensRow
+	for rows.Next() {
+		var i ListAPITokensRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const searchTrackByName = `-- name: SearchTrackByName :many
-SELECT track_id, track_name, duration, popularity, tracks.album_id, tracks.artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, albums.album_id, album_name, albums.artist_id, cover_url, album_release_date, artists.artist_id, artist_name FROM tracks
-JOIN albums ON tracks.album_id = albums.album_id
-JOIN artists ON tracks.artist_id = artists.artist_id
-WHERE track_name ILIKE '%' || $1 || '%'
-LIMIT $2 OFFSET $3
+const listAlbumTracks = `-- name: ListAlbumTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = $1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = $1
+WHERE t.album_id = $2
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY t.track_name
 `
 
-type SearchTrackByNameParams struct {
-	Column1 pgtype.Text `json:"column_1"`
-	Limit   int32       `json:"limit"`
-	Offset  int32       `json:"offset"`
+type ListAlbumTracksParams struct {
+	UserID  pgtype.UUID `json:"user_id"`
+	AlbumID string      `json:"album_id"`
 }
 
-type SearchTrackByNameRow struct {
-	TrackID          string      `json:"track_id"`
-	TrackName        string      `json:"track_name"`
-	Duration         int32       `json:"duration"`
-	Popularity       int32       `json:"popularity"`
-	AlbumID          string      `json:"album_id"`
-	ArtistID         string      `json:"artist_id"`
-	Artists          []string    `json:"artists"`
-	TrackReleaseDate pgtype.Date `json:"track_release_date"`
-	Downloaded       bool        `json:"downloaded"`
-	YoutubeUrl       string      `json:"youtube_url"`
-	Lyrics           string      `json:"lyrics"`
-	AlbumID_2        string      `json:"album_id_2"`
-	AlbumName        string      `json:"album_name"`
-	ArtistID_2       string      `json:"artist_id_2"`
-	CoverUrl         string      `json:"cover_url"`
-	AlbumReleaseDate pgtype.Date `json:"album_release_date"`
-	ArtistID_3       string      `json:"artist_id_3"`
-	ArtistName       string      `json:"artist_name"`
+type ListAlbumTracksRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
 }
 
-func (q *Queries) SearchTrackByName(ctx context.Context, arg SearchTrackByNameParams) ([]SearchTrackByNameRow, error) {
-	rows, err := q.db.Query(ctx, searchTrackByName, arg.Column1, arg.Limit, arg.Offset)
+// the tracks of the album that are known (in the library or cached from search results) with the same
+// columns as ListLibraryTracks.
+func (q *Queries) ListAlbumTracks(ctx context.Context, arg ListAlbumTracksParams) ([]ListAlbumTracksRow, error) {
+	rows, err := q.db.Query(ctx, listAlbumTracks, arg.UserID, arg.AlbumID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []SearchTrackByNameRow
+	var items []ListAlbumTracksRow
 	for rows.Next() {
-		var i SearchTrackByNameRow
+		var i ListAlbumTracksRow
 		if err := rows.Scan(
 			&i.TrackID,
 			&i.TrackName,
@@ -474,15 +1251,15 @@ func (q *Queries) SearchTrackByName(ctx context.Context, arg SearchTrackByNamePa
 			&i.Artists,
 			&i.TrackReleaseDate,
 			&i.Downloaded,
-			&i.YoutubeUrl,
-			&i.Lyrics,
-			&i.AlbumID_2,
+			&i.AddedAt,
 			&i.AlbumName,
-			&i.ArtistID_2,
 			&i.CoverUrl,
-			&i.AlbumReleaseDate,
-			&i.ArtistID_3,
 			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
 		); err != nil {
 			return nil, err
 		}
@@ -493,3 +1270,2233 @@ func (q *Queries) SearchTrackByName(ctx context.Context, arg SearchTrackByNamePa
 	}
 	return items, nil
 }
+
+const listDueWebhookDeliveries = `-- name: ListDueWebhookDeliveries :many
+SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE d.status = 'pending' AND d.next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY d.next_attempt_at
+LIMIT $1
+`
+
+type ListDueWebhookDeliveriesRow struct {
+	ID       pgtype.UUID     `json:"id"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int32           `json:"attempts"`
+	Url      string          `json:"url"`
+	Secret   string          `json:"secret"`
+}
+
+func (q *Queries) ListDueWebhookDeliveries(ctx context.Context, limit int32) ([]ListDueWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, listDueWebhookDeliveries, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDueWebhookDeliveriesRow
+	for rows.Next() {
+		var i ListDueWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Event,
+			&i.Payload,
+			&i.Attempts,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFailedDownloads = `-- name: ListFailedDownloads :many
+SELECT thing, error, attempts, failed_at FROM failed_downloads ORDER BY failed_at DESC
+`
+
+func (q *Queries) ListFailedDownloads(ctx context.Context) ([]FailedDownload, error) {
+	rows, err := q.db.Query(ctx, listFailedDownloads)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FailedDownload
+	for rows.Next() {
+		var i FailedDownload
+		if err := rows.Scan(
+			&i.Thing,
+			&i.Error,
+			&i.Attempts,
+			&i.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFolders = `-- name: ListFolders :many
+SELECT id, name, parent_id, created_at, user_id FROM playlist_folders WHERE user_id = $1 ORDER BY name
+`
+
+func (q *Queries) ListFolders(ctx context.Context, userID pgtype.UUID) ([]PlaylistFolder, error) {
+	rows, err := q.db.Query(ctx, listFolders, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PlaylistFolder
+	for rows.Next() {
+		var i PlaylistFolder
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.ParentID,
+			&i.CreatedAt,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGenres = `-- name: ListGenres :many
+SELECT g.genre::text AS genre, count(DISTINCT t.track_id) AS track_count
+FROM artists ar
+CROSS JOIN LATERAL unnest(ar.genres) AS g(genre)
+JOIN tracks t ON t.artist_id = ar.artist_id
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = $1
+)
+GROUP BY g.genre
+ORDER BY g.genre
+`
+
+type ListGenresRow struct {
+	Genre      string `json:"genre"`
+	TrackCount int64  `json:"track_count"`
+}
+
+// the genres of the artists of library tracks and how many library tracks each has.
+func (q *Queries) ListGenres(ctx context.Context, userID pgtype.UUID) ([]ListGenresRow, error) {
+	rows, err := q.db.Query(ctx, listGenres, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGenresRow
+	for rows.Next() {
+		var i ListGenresRow
+		if err := rows.Scan(&i.Genre, &i.TrackCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLibraryTracks = `-- name: ListLibraryTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = $1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = $1
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = $1
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+`
+
+type ListLibraryTracksRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// tracks that are in the user's library (downloaded or in one of their regular playlists) along with their
+// play stats, like and rating. tracks that were only cached from search results are left out.
+func (q *Queries) ListLibraryTracks(ctx context.Context, userID pgtype.UUID) ([]ListLibraryTracksRow, error) {
+	rows, err := q.db.Query(ctx, listLibraryTracks, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLibraryTracksRow
+	for rows.Next() {
+		var i ListLibraryTracksRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.AddedAt,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLikedTracks = `-- name: ListLikedTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM track_ratings r
+JOIN tracks t ON t.track_id = r.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = r.user_id
+WHERE r.user_id = $1 AND r.liked_at IS NOT NULL
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY r.liked_at DESC
+`
+
+type ListLikedTracksRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// the liked tracks, most recently liked first, with the same columns as ListLibraryTracks.
+func (q *Queries) ListLikedTracks(ctx context.Context, userID pgtype.UUID) ([]ListLikedTracksRow, error) {
+	rows, err := q.db.Query(ctx, listLikedTracks, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLikedTracksRow
+	for rows.Next() {
+		var i ListLikedTracksRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.AddedAt,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPlaylistTrackIDs = `-- name: ListPlaylistTrackIDs :many
+SELECT track_id FROM playlist_tracks WHERE playlist_id = $1 ORDER BY position
+`
+
+func (q *Queries) ListPlaylistTrackIDs(ctx context.Context, playlistID pgtype.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, listPlaylistTrackIDs, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var track_id string
+		if err := rows.Scan(&track_id); err != nil {
+			return nil, err
+		}
+		items = append(items, track_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPlaylists = `-- name: ListPlaylists :many
+SELECT id, name, description, image_url, created_at, rules, folder_id, user_id FROM playlists WHERE user_id = $1 ORDER BY created_at DESC, id
+`
+
+func (q *Queries) ListPlaylists(ctx context.Context, userID pgtype.UUID) ([]Playlist, error) {
+	rows, err := q.db.Query(ctx, listPlaylists, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Playlist
+	for rows.Next() {
+		var i Playlist
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.ImageUrl,
+			&i.CreatedAt,
+			&i.Rules,
+			&i.FolderID,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTags = `-- name: ListTags :many
+SELECT tag, count(*) AS count FROM tags GROUP BY tag ORDER BY tag
+`
+
+type ListTagsRow struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// every tag and how many tracks, albums and artists have it.
+func (q *Queries) ListTags(ctx context.Context) ([]ListTagsRow, error) {
+	rows, err := q.db.Query(ctx, listTags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTagsRow
+	for rows.Next() {
+		var i ListTagsRow
+		if err := rows.Scan(&i.Tag, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTagsOf = `-- name: ListTagsOf :many
+SELECT tag FROM tags WHERE target_type = $1 AND target_id = $2 ORDER BY tag
+`
+
+type ListTagsOfParams struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+func (q *Queries) ListTagsOf(ctx context.Context, arg ListTagsOfParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, listTagsOf, arg.TargetType, arg.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		items = append(items, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrackDownloadStates = `-- name: ListTrackDownloadStates :many
+SELECT track_id, downloaded FROM tracks ORDER BY track_id
+`
+
+type ListTrackDownloadStatesRow struct {
+	TrackID    string `json:"track_id"`
+	Downloaded bool   `json:"downloaded"`
+}
+
+func (q *Queries) ListTrackDownloadStates(ctx context.Context) ([]ListTrackDownloadStatesRow, error) {
+	rows, err := q.db.Query(ctx, listTrackDownloadStates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTrackDownloadStatesRow
+	for rows.Next() {
+		var i ListTrackDownloadStatesRow
+		if err := rows.Scan(&i.TrackID, &i.Downloaded); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTracksByGenre = `-- name: ListTracksByGenre :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = $1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = $1
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = $1
+)) AND EXISTS (
+    SELECT 1 FROM unnest(ar.genres) AS g(genre) WHERE lower(g.genre) = lower($2::text)
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name)
+`
+
+type ListTracksByGenreParams struct {
+	UserID pgtype.UUID `json:"user_id"`
+	Genre  string      `json:"genre"`
+}
+
+type ListTracksByGenreRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// the library tracks whose artist has the genre (case insensitive), with the same columns as ListLibraryTracks.
+func (q *Queries) ListTracksByGenre(ctx context.Context, arg ListTracksByGenreParams) ([]ListTracksByGenreRow, error) {
+	rows, err := q.db.Query(ctx, listTracksByGenre, arg.UserID, arg.Genre)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTracksByGenreRow
+	for rows.Next() {
+		var i ListTracksByGenreRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.AddedAt,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTracksByTag = `-- name: ListTracksByTag :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = $1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = $1
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = $1
+)) AND EXISTS (
+    SELECT 1 FROM tags tg
+    WHERE tg.tag = $2 AND (
+        (tg.target_type = 'track' AND tg.target_id = t.track_id)
+        OR (tg.target_type = 'album' AND tg.target_id = t.album_id)
+        OR (tg.target_type = 'artist' AND tg.target_id = t.artist_id)
+    )
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name)
+`
+
+type ListTracksByTagParams struct {
+	UserID pgtype.UUID `json:"user_id"`
+	Tag    string      `json:"tag"`
+}
+
+type ListTracksByTagRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumName        string           `json:"album_name"`
+	CoverUrl         string           `json:"cover_url"`
+	ArtistName       string           `json:"artist_name"`
+	PlayCount        int64            `json:"play_count"`
+	SkipCount        int64            `json:"skip_count"`
+	LastPlayedAt     pgtype.Timestamp `json:"last_played_at"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// the library tracks that have the tag themselves or through their album or (main) artist, with the same
+// columns as ListLibraryTracks.
+func (q *Queries) ListTracksByTag(ctx context.Context, arg ListTracksByTagParams) ([]ListTracksByTagRow, error) {
+	rows, err := q.db.Query(ctx, listTracksByTag, arg.UserID, arg.Tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTracksByTagRow
+	for rows.Next() {
+		var i ListTracksByTagRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.AddedAt,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.LastPlayedAt,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT id, username, is_admin, created_at FROM users ORDER BY created_at
+`
+
+type ListUsersRow struct {
+	ID        pgtype.UUID      `json:"id"`
+	Username  string           `json:"username"`
+	IsAdmin   bool             `json:"is_admin"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+func (q *Queries) ListUsers(ctx context.Context) ([]ListUsersRow, error) {
+	rows, err := q.db.Query(ctx, listUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.IsAdmin,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT id, webhook_id, event, payload, status, attempts, last_status_code, last_error, next_attempt_at, created_at, delivered_at FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id LIMIT $2
+`
+
+type ListWebhookDeliveriesParams struct {
+	WebhookID pgtype.UUID `json:"webhook_id"`
+	Limit     int32       `json:"limit"`
+}
+
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries, arg.WebhookID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.WebhookID,
+			&i.Event,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastStatusCode,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhooks = `-- name: ListWebhooks :many
+SELECT id, user_id, url, secret, events, created_at FROM webhooks WHERE user_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) ListWebhooks(ctx context.Context, userID pgtype.UUID) ([]Webhook, error) {
+	rows, err := q.db.Query(ctx, listWebhooks, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Url,
+			&i.Secret,
+			&i.Events,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listeningByHour = `-- name: ListeningByHour :many
+SELECT
+    extract(hour FROM (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE $1::text)::integer AS hour,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $2 AND p.played_at >= $3 AND p.played_at < $4
+GROUP BY hour
+ORDER BY hour
+`
+
+type ListeningByHourParams struct {
+	TimeZone  string           `json:"time_zone"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type ListeningByHourRow struct {
+	Hour            int32 `json:"hour"`
+	PlayCount       int64 `json:"play_count"`
+	SecondsListened int64 `json:"seconds_listened"`
+}
+
+func (q *Queries) ListeningByHour(ctx context.Context, arg ListeningByHourParams) ([]ListeningByHourRow, error) {
+	rows, err := q.db.Query(ctx, listeningByHour,
+		arg.TimeZone,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListeningByHourRow
+	for rows.Next() {
+		var i ListeningByHourRow
+		if err := rows.Scan(&i.Hour, &i.PlayCount, &i.SecondsListened); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listeningByMonth = `-- name: ListeningByMonth :many
+SELECT
+    date_trunc('month', (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE $1::text)::date AS month,
+    count(*) AS play_count,
+    count(DISTINCT p.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $2 AND p.played_at >= $3 AND p.played_at < $4
+GROUP BY month
+ORDER BY month
+`
+
+type ListeningByMonthParams struct {
+	TimeZone  string           `json:"time_zone"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type ListeningByMonthRow struct {
+	Month           pgtype.Date `json:"month"`
+	PlayCount       int64       `json:"play_count"`
+	TrackCount      int64       `json:"track_count"`
+	SecondsListened int64       `json:"seconds_listened"`
+}
+
+func (q *Queries) ListeningByMonth(ctx context.Context, arg ListeningByMonthParams) ([]ListeningByMonthRow, error) {
+	rows, err := q.db.Query(ctx, listeningByMonth,
+		arg.TimeZone,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListeningByMonthRow
+	for rows.Next() {
+		var i ListeningByMonthRow
+		if err := rows.Scan(
+			&i.Month,
+			&i.PlayCount,
+			&i.TrackCount,
+			&i.SecondsListened,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listeningByWeekday = `-- name: ListeningByWeekday :many
+SELECT
+    extract(dow FROM (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE $1::text)::integer AS weekday,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $2 AND p.played_at >= $3 AND p.played_at < $4
+GROUP BY weekday
+ORDER BY weekday
+`
+
+type ListeningByWeekdayParams struct {
+	TimeZone  string           `json:"time_zone"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type ListeningByWeekdayRow struct {
+	Weekday         int32 `json:"weekday"`
+	PlayCount       int64 `json:"play_count"`
+	SecondsListened int64 `json:"seconds_listened"`
+}
+
+func (q *Queries) ListeningByWeekday(ctx context.Context, arg ListeningByWeekdayParams) ([]ListeningByWeekdayRow, error) {
+	rows, err := q.db.Query(ctx, listeningByWeekday,
+		arg.TimeZone,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListeningByWeekdayRow
+	for rows.Next() {
+		var i ListeningByWeekdayRow
+		if err := rows.Scan(&i.Weekday, &i.PlayCount, &i.SecondsListened); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listeningDays = `-- name: ListeningDays :many
+SELECT DISTINCT
+    ((p.played_at AT TIME ZONE 'UTC') AT TIME ZONE $1::text)::date AS day
+FROM plays p
+WHERE p.user_id = $2 AND p.played_at >= $3 AND p.played_at < $4
+ORDER BY day
+`
+
+type ListeningDaysParams struct {
+	TimeZone  string           `json:"time_zone"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+func (q *Queries) ListeningDays(ctx context.Context, arg ListeningDaysParams) ([]pgtype.Date, error) {
+	rows, err := q.db.Query(ctx, listeningDays,
+		arg.TimeZone,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []pgtype.Date
+	for rows.Next() {
+		var day pgtype.Date
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		items = append(items, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listeningTotals = `-- name: ListeningTotals :one
+SELECT
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    count(DISTINCT p.track_id) AS track_count,
+    COALESCE(sum(COALESCE(p.skipped_at, t.duration)), 0)::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+`
+
+type ListeningTotalsParams struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type ListeningTotalsRow struct {
+	PlayCount       int64 `json:"play_count"`
+	SkipCount       int64 `json:"skip_count"`
+	TrackCount      int64 `json:"track_count"`
+	SecondsListened int64 `json:"seconds_listened"`
+}
+
+func (q *Queries) ListeningTotals(ctx context.Context, arg ListeningTotalsParams) (ListeningTotalsRow, error) {
+	row := q.db.QueryRow(ctx, listeningTotals, arg.UserID, arg.StartTime, arg.EndTime)
+	var i ListeningTotalsRow
+	err := row.Scan(
+		&i.PlayCount,
+		&i.SkipCount,
+		&i.TrackCount,
+		&i.SecondsListened,
+	)
+	return i, err
+}
+
+const lockPlaylist = `-- name: LockPlaylist :one
+SELECT id FROM playlists WHERE id = $1 FOR UPDATE
+`
+
+// locks the playlist until the end of the transaction so changes to the positions of its tracks don't race.
+func (q *Queries) LockPlaylist(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, lockPlaylist, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const lockUsers = `-- name: LockUsers :exec
+LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE
+`
+
+// keeps two first-run setups from both creating an admin.
+func (q *Queries) LockUsers(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockUsers)
+	return err
+}
+
+const markTrackAsDownloaded = `-- name: MarkTrackAsDownloaded :exec
+UPDATE tracks
+SET downloaded = TRUE
+WHERE track_id = $1
+`
+
+func (q *Queries) MarkTrackAsDownloaded(ctx context.Context, trackID string) error {
+	_, err := q.db.Exec(ctx, markTrackAsDownloaded, trackID)
+	return err
+}
+
+const markTrackAsNotDownloaded = `-- name: MarkTrackAsNotDownloaded :exec
+UPDATE tracks
+SET downloaded = FALSE
+WHERE track_id = $1
+`
+
+func (q *Queries) MarkTrackAsNotDownloaded(ctx context.Context, trackID string) error {
+	_, err := q.db.Exec(ctx, markTrackAsNotDownloaded, trackID)
+	return err
+}
+
+const monthlyTopTracks = `-- name: MonthlyTopTracks :many
+WITH monthly AS (
+    SELECT
+        date_trunc('month', (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE $1::text)::date AS month,
+        p.track_id,
+        count(*) AS play_count
+    FROM plays p
+    WHERE p.user_id = $2 AND p.played_at >= $3 AND p.played_at < $4
+    GROUP BY month, p.track_id
+)
+SELECT DISTINCT ON (m.month)
+    m.month,
+    t.track_id,
+    t.track_name,
+    t.artists,
+    m.play_count
+FROM monthly m
+JOIN tracks t ON t.track_id = m.track_id
+ORDER BY m.month, m.play_count DESC, t.track_name
+`
+
+type MonthlyTopTracksParams struct {
+	TimeZone  string           `json:"time_zone"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type MonthlyTopTracksRow struct {
+	Month     pgtype.Date `json:"month"`
+	TrackID   string      `json:"track_id"`
+	TrackName string      `json:"track_name"`
+	Artists   []string    `json:"artists"`
+	PlayCount int64       `json:"play_count"`
+}
+
+func (q *Queries) MonthlyTopTracks(ctx context.Context, arg MonthlyTopTracksParams) ([]MonthlyTopTracksRow, error) {
+	rows, err := q.db.Query(ctx, monthlyTopTracks,
+		arg.TimeZone,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MonthlyTopTracksRow
+	for rows.Next() {
+		var i MonthlyTopTracksRow
+		if err := rows.Scan(
+			&i.Month,
+			&i.TrackID,
+			&i.TrackName,
+			&i.Artists,
+			&i.PlayCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const mostSkippedTrack = `-- name: MostSkippedTrack :one
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+GROUP BY t.track_id
+HAVING count(p.skipped_at) > 0
+ORDER BY skip_count DESC, play_count ASC
+LIMIT 1
+`
+
+type MostSkippedTrackParams struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type MostSkippedTrackRow struct {
+	TrackID   string   `json:"track_id"`
+	TrackName string   `json:"track_name"`
+	Artists   []string `json:"artists"`
+	PlayCount int64    `json:"play_count"`
+	SkipCount int64    `json:"skip_count"`
+}
+
+func (q *Queries) MostSkippedTrack(ctx context.Context, arg MostSkippedTrackParams) (MostSkippedTrackRow, error) {
+	row := q.db.QueryRow(ctx, mostSkippedTrack, arg.UserID, arg.StartTime, arg.EndTime)
+	var i MostSkippedTrackRow
+	err := row.Scan(
+		&i.TrackID,
+		&i.TrackName,
+		&i.Artists,
+		&i.PlayCount,
+		&i.SkipCount,
+	)
+	return i, err
+}
+
+const moveFolder = `-- name: MoveFolder :exec
+UPDATE playlist_folders SET parent_id = $2 WHERE id = $1
+`
+
+type MoveFolderParams struct {
+	ID       pgtype.UUID `json:"id"`
+	ParentID pgtype.UUID `json:"parent_id"`
+}
+
+func (q *Queries) MoveFolder(ctx context.Context, arg MoveFolderParams) error {
+	_, err := q.db.Exec(ctx, moveFolder, arg.ID, arg.ParentID)
+	return err
+}
+
+const moveFolderPlaylistsToRoot = `-- name: MoveFolderPlaylistsToRoot :exec
+WITH RECURSIVE subtree AS (
+    SELECT f.id FROM playlist_folders f WHERE f.id = $1
+    UNION ALL
+    SELECT f.id FROM playlist_folders f JOIN subtree s ON f.parent_id = s.id
+)
+UPDATE playlists SET folder_id = NULL
+WHERE folder_id IN (SELECT id FROM subtree)
+`
+
+// moves the playlists in the folder and all of its subfolders to the root.
+func (q *Queries) MoveFolderPlaylistsToRoot(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, moveFolderPlaylistsToRoot, id)
+	return err
+}
+
+const movePlaylistToFolder = `-- name: MovePlaylistToFolder :exec
+UPDATE playlists SET folder_id = $2 WHERE id = $1
+`
+
+type MovePlaylistToFolderParams struct {
+	ID       pgtype.UUID `json:"id"`
+	FolderID pgtype.UUID `json:"folder_id"`
+}
+
+func (q *Queries) MovePlaylistToFolder(ctx context.Context, arg MovePlaylistToFolderParams) error {
+	_, err := q.db.Exec(ctx, movePlaylistToFolder, arg.ID, arg.FolderID)
+	return err
+}
+
+const movePlaylistTracks = `-- name: MovePlaylistTracks :exec
+UPDATE playlist_tracks SET position = CASE
+    WHEN position >= $1::integer AND position < $1::integer + $2::integer
+        THEN position - $1::integer + $3::integer
+    WHEN $3::integer > $1::integer
+        THEN position - $2::integer
+    ELSE position + $2::integer
+END
+WHERE playlist_id = $4
+AND position >= LEAST($1::integer, $3::integer)
+AND position < GREATEST($1::integer, $3::integer) + $2::integer
+`
+
+type MovePlaylistTracksParams struct {
+	FromPosition int32       `json:"from_position"`
+	Count        int32       `json:"count"`
+	ToPosition   int32       `json:"to_position"`
+	PlaylistID   pgtype.UUID `json:"playlist_id"`
+}
+
+// moves count tracks starting at from_position so they start at to_position (an index in the reordered
+// playlist), shifting the tracks in between.
+func (q *Queries) MovePlaylistTracks(ctx context.Context, arg MovePlaylistTracksParams) error {
+	_, err := q.db.Exec(ctx, movePlaylistTracks,
+		arg.FromPosition,
+		arg.Count,
+		arg.ToPosition,
+		arg.PlaylistID,
+	)
+	return err
+}
+
+const newArtists = `-- name: NewArtists :many
+WITH first_plays AS (
+    SELECT t.artist_id, min(p.played_at)::timestamp AS first_played_at
+    FROM plays p
+    JOIN tracks t ON t.track_id = p.track_id
+    WHERE p.user_id = $1
+    GROUP BY t.artist_id
+)
+SELECT ar.artist_id, ar.artist_name, fp.first_played_at
+FROM first_plays fp
+JOIN artists ar ON ar.artist_id = fp.artist_id
+WHERE fp.first_played_at >= $2 AND fp.first_played_at < $3
+ORDER BY fp.first_played_at
+`
+
+type NewArtistsParams struct {
+	UserID    pgtype.UUID      `json:"user_id"`
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type NewArtistsRow struct {
+	ArtistID      string           `json:"artist_id"`
+	ArtistName    string           `json:"artist_name"`
+	FirstPlayedAt pgtype.Timestamp `json:"first_played_at"`
+}
+
+func (q *Queries) NewArtists(ctx context.Context, arg NewArtistsParams) ([]NewArtistsRow, error) {
+	rows, err := q.db.Query(ctx, newArtists, arg.UserID, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NewArtistsRow
+	for rows.Next() {
+		var i NewArtistsRow
+		if err := rows.Scan(&i.ArtistID, &i.ArtistName, &i.FirstPlayedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const playlistImageInUse = `-- name: PlaylistImageInUse :one
+SELECT EXISTS (
+    SELECT 1 FROM playlists WHERE image_url = $1
+)
+`
+
+func (q *Queries) PlaylistImageInUse(ctx context.Context, imageUrl string) (bool, error) {
+	row := q.db.QueryRow(ctx, playlistImageInUse, imageUrl)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const playlistWithNameExists = `-- name: PlaylistWithNameExists :one
+SELECT EXISTS (
+    SELECT 1 FROM playlists WHERE name = $1 AND user_id = $2
+)
+`
+
+type PlaylistWithNameExistsParams struct {
+	Name   string      `json:"name"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) PlaylistWithNameExists(ctx context.Context, arg PlaylistWithNameExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, playlistWithNameExists, arg.Name, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const radioCoListenCandidates = `-- name: RadioCoListenCandidates :many
+SELECT next_plays.track_id, count(*) AS co_plays
+FROM plays p
+JOIN LATERAL (
+    SELECT p2.track_id FROM plays p2
+    WHERE p2.user_id = p.user_id AND p2.played_at > p.played_at AND p2.played_at <= p.played_at + interval '30 minutes'
+    ORDER BY p2.played_at
+    LIMIT 3
+) next_plays ON TRUE
+WHERE p.track_id = ANY($1::text[]) AND p.user_id = $2
+AND NOT (next_plays.track_id = ANY($1::text[]))
+GROUP BY next_plays.track_id
+`
+
+type RadioCoListenCandidatesParams struct {
+	SeedIds []string    `json:"seed_ids"`
+	UserID  pgtype.UUID `json:"user_id"`
+}
+
+type RadioCoListenCandidatesRow struct {
+	TrackID string `json:"track_id"`
+	CoPlays int64  `json:"co_plays"`
+}
+
+// tracks that were played shortly after one of the seed tracks, with the number of times that happened.
+func (q *Queries) RadioCoListenCandidates(ctx context.Context, arg RadioCoListenCandidatesParams) ([]RadioCoListenCandidatesRow, error) {
+	rows, err := q.db.Query(ctx, radioCoListenCandidates, arg.SeedIds, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RadioCoListenCandidatesRow
+	for rows.Next() {
+		var i RadioCoListenCandidatesRow
+		if err := rows.Scan(&i.TrackID, &i.CoPlays); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const radioPlaylistCandidates = `-- name: RadioPlaylistCandidates :many
+SELECT pt.track_id, count(DISTINCT pt.playlist_id) AS shared_playlists
+FROM playlist_tracks pt
+WHERE pt.playlist_id IN (
+    SELECT seed.playlist_id FROM playlist_tracks seed
+    JOIN playlists pl ON pl.id = seed.playlist_id
+    WHERE seed.track_id = ANY($1::text[]) AND pl.user_id = $2
+)
+AND NOT (pt.track_id = ANY($1::text[]))
+GROUP BY pt.track_id
+`
+
+type RadioPlaylistCandidatesParams struct {
+	SeedIds []string    `json:"seed_ids"`
+	UserID  pgtype.UUID `json:"user_id"`
+}
+
+type RadioPlaylistCandidatesRow struct {
+	TrackID         string `json:"track_id"`
+	SharedPlaylists int64  `json:"shared_playlists"`
+}
+
+// tracks that share a playlist with any of the seed tracks, with the number of playlists they share.
+func (q *Queries) RadioPlaylistCandidates(ctx context.Context, arg RadioPlaylistCandidatesParams) ([]RadioPlaylistCandidatesRow, error) {
+	rows, err := q.db.Query(ctx, radioPlaylistCandidates, arg.SeedIds, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RadioPlaylistCandidatesRow
+	for rows.Next() {
+		var i RadioPlaylistCandidatesRow
+		if err := rows.Scan(&i.TrackID, &i.SharedPlaylists); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recentlyPlayedTrackIDs = `-- name: RecentlyPlayedTrackIDs :many
+SELECT DISTINCT track_id FROM plays WHERE played_at >= $1 AND user_id = $2
+`
+
+type RecentlyPlayedTrackIDsParams struct {
+	PlayedAt pgtype.Timestamp `json:"played_at"`
+	UserID   pgtype.UUID      `json:"user_id"`
+}
+
+func (q *Queries) RecentlyPlayedTrackIDs(ctx context.Context, arg RecentlyPlayedTrackIDsParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, recentlyPlayedTrackIDs, arg.PlayedAt, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var track_id string
+		if err := rows.Scan(&track_id); err != nil {
+			return nil, err
+		}
+		items = append(items, track_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordFailedDownload = `-- name: RecordFailedDownload :exec
+INSERT INTO failed_downloads (thing, error)
+VALUES ($1, $2)
+ON CONFLICT (thing) DO UPDATE SET
+    error = EXCLUDED.error,
+    attempts = failed_downloads.attempts + 1,
+    failed_at = CURRENT_TIMESTAMP
+`
+
+type RecordFailedDownloadParams struct {
+	Thing string `json:"thing"`
+	Error string `json:"error"`
+}
+
+func (q *Queries) RecordFailedDownload(ctx context.Context, arg RecordFailedDownloadParams) error {
+	_, err := q.db.Exec(ctx, recordFailedDownload, arg.Thing, arg.Error)
+	return err
+}
+
+const recordPlay = `-- name: RecordPlay :one
+INSERT INTO plays (track_id, played_at, skipped_at, user_id)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, track_id, played_at) DO NOTHING
+RETURNING play_id
+`
+
+type RecordPlayParams struct {
+	TrackID   string           `json:"track_id"`
+	PlayedAt  pgtype.Timestamp `json:"played_at"`
+	SkippedAt pgtype.Int4      `json:"skipped_at"`
+	UserID    pgtype.UUID      `json:"user_id"`
+}
+
+func (q *Queries) RecordPlay(ctx context.Context, arg RecordPlayParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, recordPlay,
+		arg.TrackID,
+		arg.PlayedAt,
+		arg.SkippedAt,
+		arg.UserID,
+	)
+	var play_id pgtype.UUID
+	err := row.Scan(&play_id)
+	return play_id, err
+}
+
+const recordSkip = `-- name: RecordSkip :exec
+UPDATE plays
+SET skipped_at = $1
+WHERE play_id = $2 AND user_id = $3
+`
+
+type RecordSkipParams struct {
+	SkippedAt pgtype.Int4 `json:"skipped_at"`
+	PlayID    pgtype.UUID `json:"play_id"`
+	UserID    pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) RecordSkip(ctx context.Context, arg RecordSkipParams) error {
+	_, err := q.db.Exec(ctx, recordSkip, arg.SkippedAt, arg.PlayID, arg.UserID)
+	return err
+}
+
+const recordWebhookAttempt = `-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries SET
+    attempts = attempts + 1,
+    status = $1::text,
+    last_status_code = $2,
+    last_error = $3,
+    next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $4::integer),
+    delivered_at = CASE WHEN $1::text = 'delivered' THEN CURRENT_TIMESTAMP END
+WHERE id = $5
+`
+
+type RecordWebhookAttemptParams struct {
+	Status         string      `json:"status"`
+	LastStatusCode pgtype.Int4 `json:"last_status_code"`
+	LastError      pgtype.Text `json:"last_error"`
+	RetryAfter     int32       `json:"retry_after"`
+	ID             pgtype.UUID `json:"id"`
+}
+
+// records an attempt at a delivery. a delivery that's still pending is attempted again in retry_after seconds.
+func (q *Queries) RecordWebhookAttempt(ctx context.Context, arg RecordWebhookAttemptParams) error {
+	_, err := q.db.Exec(ctx, recordWebhookAttempt,
+		arg.Status,
+		arg.LastStatusCode,
+		arg.LastError,
+		arg.RetryAfter,
+		arg.ID,
+	)
+	return err
+}
+
+const removeTag = `-- name: RemoveTag :exec
+DELETE FROM tags WHERE tag = $1 AND target_type = $2 AND target_id = $3
+`
+
+type RemoveTagParams struct {
+	Tag        string `json:"tag"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+func (q *Queries) RemoveTag(ctx context.Context, arg RemoveTagParams) error {
+	_, err := q.db.Exec(ctx, removeTag, arg.Tag, arg.TargetType, arg.TargetID)
+	return err
+}
+
+const removeTrackFromPlaylist = `-- name: RemoveTrackFromPlaylist :exec
+DELETE FROM playlist_tracks
+WHERE playlist_id = $1 AND track_id = $2
+`
+
+type RemoveTrackFromPlaylistParams struct {
+	PlaylistID pgtype.UUID `json:"playlist_id"`
+	TrackID    string      `json:"track_id"`
+}
+
+func (q *Queries) RemoveTrackFromPlaylist(ctx context.Context, arg RemoveTrackFromPlaylistParams) error {
+	_, err := q.db.Exec(ctx, removeTrackFromPlaylist, arg.PlaylistID, arg.TrackID)
+	return err
+}
+
+const renameFolder = `-- name: RenameFolder :exec
+UPDATE playlist_folders SET name = $2 WHERE id = $1
+`
+
+type RenameFolderParams struct {
+	ID   pgtype.UUID `json:"id"`
+	Name string      `json:"name"`
+}
+
+func (q *Queries) RenameFolder(ctx context.Context, arg RenameFolderParams) error {
+	_, err := q.db.Exec(ctx, renameFolder, arg.ID, arg.Name)
+	return err
+}
+
+const renumberPlaylistTracks = `-- name: RenumberPlaylistTracks :exec
+UPDATE playlist_tracks pt SET position = numbered.new_position
+FROM (
+    SELECT track_id, (row_number() OVER (ORDER BY position, added_at, track_id) - 1)::integer AS new_position
+    FROM playlist_tracks
+    WHERE playlist_id = $1
+) numbered
+WHERE pt.playlist_id = $1 AND pt.track_id = numbered.track_id AND pt.position <> numbered.new_position
+`
+
+// closes the gaps in the positions of the playlist (e.g. after a track is removed).
+func (q *Queries) RenumberPlaylistTracks(ctx context.Context, playlistID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, renumberPlaylistTracks, playlistID)
+	return err
+}
+
+const retryWebhookDelivery = `-- name: RetryWebhookDelivery :execrows
+UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND webhook_id = $2 AND status = 'failed'
+`
+
+type RetryWebhookDeliveryParams struct {
+	ID        pgtype.UUID `json:"id"`
+	WebhookID pgtype.UUID `json:"webhook_id"`
+}
+
+// sends a failed delivery again, with a new set of attempts.
+func (q *Queries) RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) (int64, error) {
+	result, err := q.db.Exec(ctx, retryWebhookDelivery, arg.ID, arg.WebhookID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeAPIToken = `-- name: RevokeAPIToken :execrows
+UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeAPITokenParams struct {
+	ID     pgtype.UUID `json:"id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) RevokeAPIToken(ctx context.Context, arg RevokeAPITokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeAPIToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const searchTrackByName = `-- name: SearchTrackByName :many
+SELECT tracks.track_id, tracks.track_name, tracks.duration, tracks.popularity, tracks.album_id, tracks.artist_id, tracks.artists, tracks.track_release_date, tracks.downloaded, tracks.youtube_url, tracks.lyrics, tracks.added_at, albums.album_id, albums.album_name, albums.artist_id, albums.cover_url, albums.album_release_date, artists.artist_id, artists.artist_name, artists.genres, track_ratings.liked_at, track_ratings.rating FROM tracks
+JOIN albums ON tracks.album_id = albums.album_id
+JOIN artists ON tracks.artist_id = artists.artist_id
+LEFT JOIN track_ratings ON track_ratings.track_id = tracks.track_id AND track_ratings.user_id = $4
+WHERE track_name ILIKE '%' || $1 || '%'
+LIMIT $2 OFFSET $3
+`
+
+type SearchTrackByNameParams struct {
+	Column1 pgtype.Text `json:"column_1"`
+	Limit   int32       `json:"limit"`
+	Offset  int32       `json:"offset"`
+	UserID  pgtype.UUID `json:"user_id"`
+}
+
+type SearchTrackByNameRow struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	ArtistID         string           `json:"artist_id"`
+	Artists          []string         `json:"artists"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	Downloaded       bool             `json:"downloaded"`
+	YoutubeUrl       string           `json:"youtube_url"`
+	Lyrics           string           `json:"lyrics"`
+	AddedAt          pgtype.Timestamp `json:"added_at"`
+	AlbumID_2        string           `json:"album_id_2"`
+	AlbumName        string           `json:"album_name"`
+	ArtistID_2       string           `json:"artist_id_2"`
+	CoverUrl         string           `json:"cover_url"`
+	AlbumReleaseDate pgtype.Date      `json:"album_release_date"`
+	ArtistID_3       string           `json:"artist_id_3"`
+	ArtistName       string           `json:"artist_name"`
+	Genres           []string         `json:"genres"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+func (q *Queries) SearchTrackByName(ctx context.Context, arg SearchTrackByNameParams) ([]SearchTrackByNameRow, error) {
+	rows, err := q.db.Query(ctx, searchTrackByName,
+		arg.Column1,
+		arg.Limit,
+		arg.Offset,
+		arg.UserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchTrackByNameRow
+	for rows.Next() {
+		var i SearchTrackByNameRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Duration,
+			&i.Popularity,
+			&i.AlbumID,
+			&i.ArtistID,
+			&i.Artists,
+			&i.TrackReleaseDate,
+			&i.Downloaded,
+			&i.YoutubeUrl,
+			&i.Lyrics,
+			&i.AddedAt,
+			&i.AlbumID_2,
+			&i.AlbumName,
+			&i.ArtistID_2,
+			&i.CoverUrl,
+			&i.AlbumReleaseDate,
+			&i.ArtistID_3,
+			&i.ArtistName,
+			&i.Genres,
+			&i.LikedAt,
+			&i.Rating,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setArtistGenres = `-- name: SetArtistGenres :exec
+UPDATE artists SET genres = $2 WHERE artist_id = $1
+`
+
+type SetArtistGenresParams struct {
+	ArtistID string   `json:"artist_id"`
+	Genres   []string `json:"genres"`
+}
+
+func (q *Queries) SetArtistGenres(ctx context.Context, arg SetArtistGenresParams) error {
+	_, err := q.db.Exec(ctx, setArtistGenres, arg.ArtistID, arg.Genres)
+	return err
+}
+
+const setSubsonicPassword = `-- name: SetSubsonicPassword :exec
+INSERT INTO subsonic_passwords (user_id, password)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET password = EXCLUDED.password, created_at = CURRENT_TIMESTAMP
+`
+
+type SetSubsonicPasswordParams struct {
+	UserID   pgtype.UUID `json:"user_id"`
+	Password string      `json:"password"`
+}
+
+func (q *Queries) SetSubsonicPassword(ctx context.Context, arg SetSubsonicPasswordParams) error {
+	_, err := q.db.Exec(ctx, setSubsonicPassword, arg.UserID, arg.Password)
+	return err
+}
+
+const setTrackLiked = `-- name: SetTrackLiked :exec
+INSERT INTO track_ratings (user_id, track_id, liked_at)
+VALUES ($1, $2, CASE WHEN $3::boolean THEN CURRENT_TIMESTAMP END)
+ON CONFLICT (user_id, track_id) DO UPDATE SET
+    liked_at = CASE WHEN $3::boolean THEN COALESCE(track_ratings.liked_at, CURRENT_TIMESTAMP) END
+`
+
+type SetTrackLikedParams struct {
+	UserID  pgtype.UUID `json:"user_id"`
+	TrackID string      `json:"track_id"`
+	Liked   bool        `json:"liked"`
+}
+
+// likes (keeping the original like date if it's already liked) or unlikes the track.
+func (q *Queries) SetTrackLiked(ctx context.Context, arg SetTrackLikedParams) error {
+	_, err := q.db.Exec(ctx, setTrackLiked, arg.UserID, arg.TrackID, arg.Liked)
+	return err
+}
+
+const setTrackRating = `-- name: SetTrackRating :exec
+INSERT INTO track_ratings (user_id, track_id, rating)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, track_id) DO UPDATE SET rating = EXCLUDED.rating
+`
+
+type SetTrackRatingParams struct {
+	UserID  pgtype.UUID `json:"user_id"`
+	TrackID string      `json:"track_id"`
+	Rating  pgtype.Int4 `json:"rating"`
+}
+
+func (q *Queries) SetTrackRating(ctx context.Context, arg SetTrackRatingParams) error {
+	_, err := q.db.Exec(ctx, setTrackRating, arg.UserID, arg.TrackID, arg.Rating)
+	return err
+}
+
+const shiftPlaylistTracks = `-- name: ShiftPlaylistTracks :exec
+UPDATE playlist_tracks SET position = position + 1
+WHERE playlist_id = $1 AND position >= $2
+`
+
+type ShiftPlaylistTracksParams struct {
+	PlaylistID pgtype.UUID `json:"playlist_id"`
+	Position   int32       `json:"position"`
+}
+
+// moves every track at or after position down by one to make room for a track at position.
+func (q *Queries) ShiftPlaylistTracks(ctx context.Context, arg ShiftPlaylistTracksParams) error {
+	_, err := q.db.Exec(ctx, shiftPlaylistTracks, arg.PlaylistID, arg.Position)
+	return err
+}
+
+const skipRates = `-- name: SkipRates :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    (count(p.skipped_at)::float8 / count(*)::float8)::float8 AS skip_rate
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+GROUP BY t.track_id
+HAVING count(*) >= $4::bigint
+ORDER BY skip_rate DESC, play_count DESC
+LIMIT $5
+`
+
+type SkipRatesParams struct {
+	UserID     pgtype.UUID      `json:"user_id"`
+	StartTime  pgtype.Timestamp `json:"start_time"`
+	EndTime    pgtype.Timestamp `json:"end_time"`
+	MinPlays   int64            `json:"min_plays"`
+	MaxResults int32            `json:"max_results"`
+}
+
+type SkipRatesRow struct {
+	TrackID   string   `json:"track_id"`
+	TrackName string   `json:"track_name"`
+	Artists   []string `json:"artists"`
+	PlayCount int64    `json:"play_count"`
+	SkipCount int64    `json:"skip_count"`
+	SkipRate  float64  `json:"skip_rate"`
+}
+
+func (q *Queries) SkipRates(ctx context.Context, arg SkipRatesParams) ([]SkipRatesRow, error) {
+	rows, err := q.db.Query(ctx, skipRates,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+		arg.MinPlays,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SkipRatesRow
+	for rows.Next() {
+		var i SkipRatesRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Artists,
+			&i.PlayCount,
+			&i.SkipCount,
+			&i.SkipRate,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tagTargetExists = `-- name: TagTargetExists :one
+SELECT CASE $1::text
+    WHEN 'track' THEN EXISTS (SELECT 1 FROM tracks WHERE track_id = $2::text)
+    WHEN 'album' THEN EXISTS (SELECT 1 FROM albums WHERE album_id = $2::text)
+    WHEN 'artist' THEN EXISTS (SELECT 1 FROM artists WHERE artist_id = $2::text)
+    ELSE FALSE
+END::boolean AS exists
+`
+
+type TagTargetExistsParams struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+func (q *Queries) TagTargetExists(ctx context.Context, arg TagTargetExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, tagTargetExists, arg.TargetType, arg.TargetID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const topAlbums = `-- name: TopAlbums :many
+SELECT
+    a.album_id,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = a.artist_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+GROUP BY a.album_id, ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT $4
+`
+
+type TopAlbumsParams struct {
+	UserID     pgtype.UUID      `json:"user_id"`
+	StartTime  pgtype.Timestamp `json:"start_time"`
+	EndTime    pgtype.Timestamp `json:"end_time"`
+	MaxResults int32            `json:"max_results"`
+}
+
+type TopAlbumsRow struct {
+	AlbumID         string `json:"album_id"`
+	AlbumName       string `json:"album_name"`
+	CoverUrl        string `json:"cover_url"`
+	ArtistName      string `json:"artist_name"`
+	PlayCount       int64  `json:"play_count"`
+	TrackCount      int64  `json:"track_count"`
+	SecondsListened int64  `json:"seconds_listened"`
+}
+
+func (q *Queries) TopAlbums(ctx context.Context, arg TopAlbumsParams) ([]TopAlbumsRow, error) {
+	rows, err := q.db.Query(ctx, topAlbums,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TopAlbumsRow
+	for rows.Next() {
+		var i TopAlbumsRow
+		if err := rows.Scan(
+			&i.AlbumID,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.TrackCount,
+			&i.SecondsListened,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const topArtists = `-- name: TopArtists :many
+SELECT
+    ar.artist_id,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+GROUP BY ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT $4
+`
+
+type TopArtistsParams struct {
+	UserID     pgtype.UUID      `json:"user_id"`
+	StartTime  pgtype.Timestamp `json:"start_time"`
+	EndTime    pgtype.Timestamp `json:"end_time"`
+	MaxResults int32            `json:"max_results"`
+}
+
+type TopArtistsRow struct {
+	ArtistID        string `json:"artist_id"`
+	ArtistName      string `json:"artist_name"`
+	PlayCount       int64  `json:"play_count"`
+	TrackCount      int64  `json:"track_count"`
+	SecondsListened int64  `json:"seconds_listened"`
+}
+
+func (q *Queries) TopArtists(ctx context.Context, arg TopArtistsParams) ([]TopArtistsRow, error) {
+	rows, err := q.db.Query(ctx, topArtists,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TopArtistsRow
+	for rows.Next() {
+		var i TopArtistsRow
+		if err := rows.Scan(
+			&i.ArtistID,
+			&i.ArtistName,
+			&i.PlayCount,
+			&i.TrackCount,
+			&i.SecondsListened,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const topTracks = `-- name: TopTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.album_name,
+    a.cover_url,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = $1 AND p.played_at >= $2 AND p.played_at < $3
+GROUP BY t.track_id, a.album_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT $4
+`
+
+type TopTracksParams struct {
+	UserID     pgtype.UUID      `json:"user_id"`
+	StartTime  pgtype.Timestamp `json:"start_time"`
+	EndTime    pgtype.Timestamp `json:"end_time"`
+	MaxResults int32            `json:"max_results"`
+}
+
+type TopTracksRow struct {
+	TrackID         string   `json:"track_id"`
+	TrackName       string   `json:"track_name"`
+	Artists         []string `json:"artists"`
+	AlbumName       string   `json:"album_name"`
+	CoverUrl        string   `json:"cover_url"`
+	PlayCount       int64    `json:"play_count"`
+	SecondsListened int64    `json:"seconds_listened"`
+}
+
+func (q *Queries) TopTracks(ctx context.Context, arg TopTracksParams) ([]TopTracksRow, error) {
+	rows, err := q.db.Query(ctx, topTracks,
+		arg.UserID,
+		arg.StartTime,
+		arg.EndTime,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TopTracksRow
+	for rows.Next() {
+		var i TopTracksRow
+		if err := rows.Scan(
+			&i.TrackID,
+			&i.TrackName,
+			&i.Artists,
+			&i.AlbumName,
+			&i.CoverUrl,
+			&i.PlayCount,
+			&i.SecondsListened,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const touchAPIToken = `-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - interval '1 minute')
+`
+
+// records that the token was used. it's only written once a minute so every request doesn't write.
+func (q *Queries) TouchAPIToken(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, touchAPIToken, id)
+	return err
+}
+
+const updatePlaylist = `-- name: UpdatePlaylist :exec
+UPDATE playlists
+SET name = $2, description = $3, image_url = $4
+WHERE id = $1
+`
+
+type UpdatePlaylistParams struct {
+	ID          pgtype.UUID `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	ImageUrl    string      `json:"image_url"`
+}
+
+func (q *Queries) UpdatePlaylist(ctx context.Context, arg UpdatePlaylistParams) error {
+	_, err := q.db.Exec(ctx, updatePlaylist,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.ImageUrl,
+	)
+	return err
+}
+
+const updatePlaylistRules = `-- name: UpdatePlaylistRules :exec
+UPDATE playlists
+SET rules = $1
+WHERE id = $2
+`
+
+type UpdatePlaylistRulesParams struct {
+	Rules json.RawMessage `json:"rules"`
+	ID    pgtype.UUID     `json:"id"`
+}
+
+func (q *Queries) UpdatePlaylistRules(ctx context.Context, arg UpdatePlaylistRulesParams) error {
+	_, err := q.db.Exec(ctx, updatePlaylistRules, arg.Rules, arg.ID)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = $2 WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID           pgtype.UUID `json:"id"`
+	PasswordHash string      `json:"password_hash"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const updateWebhook = `-- name: UpdateWebhook :execrows
+UPDATE webhooks SET url = $3, events = $4 WHERE id = $1 AND user_id = $2
+`
+
+type UpdateWebhookParams struct {
+	ID     pgtype.UUID `json:"id"`
+	UserID pgtype.UUID `json:"user_id"`
+	Url    string      `json:"url"`
+	Events []string    `json:"events"`
+}
+
+func (q *Queries) UpdateWebhook(ctx context.Context, arg UpdateWebhookParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateWebhook,
+		arg.ID,
+		arg.UserID,
+		arg.Url,
+		arg.Events,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upsertPlaybackSession = `-- name: UpsertPlaybackSession :one
+INSERT INTO playback_sessions (session_id, current_track_id, position, queue, history, shuffle, repeat, source_playlist_id, shuffle_seed, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+ON CONFLICT (session_id) DO UPDATE SET
+    current_track_id = EXCLUDED.current_track_id,
+    position = EXCLUDED.position,
+    queue = EXCLUDED.queue,
+    history = EXCLUDED.history,
+    shuffle = EXCLUDED.shuffle,
+    repeat = EXCLUDED.repeat,
+    source_playlist_id = EXCLUDED.source_playlist_id,
+    shuffle_seed = EXCLUDED.shuffle_seed,
+    updated_at = EXCLUDED.updated_at
+RETURNING updated_at
+`
+
+type UpsertPlaybackSessionParams struct {
+	SessionID        string      `json:"session_id"`
+	CurrentTrackID   pgtype.Text `json:"current_track_id"`
+	Position         float64     `json:"position"`
+	Queue            []string    `json:"queue"`
+	History          []string    `json:"history"`
+	Shuffle          bool        `json:"shuffle"`
+	Repeat           string      `json:"repeat"`
+	SourcePlaylistID pgtype.UUID `json:"source_playlist_id"`
+	ShuffleSeed      pgtype.Int8 `json:"shuffle_seed"`
+}
+
+func (q *Queries) UpsertPlaybackSession(ctx context.Context, arg UpsertPlaybackSessionParams) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, upsertPlaybackSession,
+		arg.SessionID,
+		arg.CurrentTrackID,
+		arg.Position,
+		arg.Queue,
+		arg.History,
+		arg.Shuffle,
+		arg.Repeat,
+		arg.SourcePlaylistID,
+		arg.ShuffleSeed,
+	)
+	var updated_at pgtype.Timestamp
+	err := row.Scan(&updated_at)
+	return updated_at, err
+}
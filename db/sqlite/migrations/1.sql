@@ -0,0 +1,138 @@
+-- the schema of ../../../schema.sql as it is after all of its ALTERs. see the comments there for what the
+-- tables and columns are. the differences are sqlite's:
+--   - uuids are text, generated by the default expression below (version 4, like gen_random_uuid())
+--   - text[] and jsonb are JSON text
+--   - booleans are 0 and 1
+CREATE TABLE artists (
+    artist_id text PRIMARY KEY,
+    artist_name text NOT NULL,
+    genres text
+);
+CREATE TABLE albums (
+    album_id text PRIMARY KEY,
+    album_name text NOT NULL,
+    artist_id text REFERENCES artists(artist_id) NOT NULL,
+    cover_url text NOT NULL,
+    album_release_date DATE
+);
+CREATE TABLE tracks (
+    track_id text PRIMARY KEY,
+    track_name text NOT NULL,
+    duration integer NOT NULL,
+    popularity integer NOT NULL,
+    album_id text REFERENCES albums(album_id) NOT NULL,
+    artist_id text REFERENCES artists(artist_id) NOT NULL,
+    artists text NOT NULL,
+    track_release_date DATE NOT NULL,
+    downloaded BOOLEAN NOT NULL DEFAULT FALSE,
+    youtube_url text NOT NULL DEFAULT '',
+    lyrics text NOT NULL DEFAULT '',
+    added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE users (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    username text UNIQUE NOT NULL,
+    password_hash text NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE user_sessions (
+    token_hash text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE api_tokens (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    user_id text NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    token_hash text UNIQUE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    scopes text NOT NULL DEFAULT '["read","playlists:write","downloads","admin"]',
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE TABLE subsonic_passwords (
+    user_id text PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    password text NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE playlist_folders (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name text NOT NULL,
+    parent_id text REFERENCES playlist_folders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    user_id text REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE playlists (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    image_url text NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    rules text,
+    folder_id text REFERENCES playlist_folders(id) ON DELETE CASCADE,
+    user_id text REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX playlists_user_id_name_idx ON playlists (user_id, name);
+
+CREATE TABLE playlist_tracks (
+    playlist_id text NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    track_id text NOT NULL REFERENCES tracks(track_id) ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (playlist_id, track_id)
+);
+CREATE INDEX playlist_tracks_position_idx ON playlist_tracks (playlist_id, position);
+
+CREATE TABLE plays (
+    play_id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    track_id text NOT NULL REFERENCES tracks(track_id) ON DELETE CASCADE,
+    played_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    skipped_at integer,
+    user_id text REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX plays_played_at_idx ON plays (played_at);
+CREATE UNIQUE INDEX plays_user_id_track_id_played_at_idx ON plays (user_id, track_id, played_at);
+CREATE INDEX plays_user_id_played_at_idx ON plays (user_id, played_at);
+
+CREATE TABLE playback_sessions (
+    session_id text PRIMARY KEY,
+    current_track_id text REFERENCES tracks(track_id) ON DELETE SET NULL,
+    position REAL NOT NULL DEFAULT 0,
+    queue text NOT NULL DEFAULT '[]',
+    history text NOT NULL DEFAULT '[]',
+    shuffle BOOLEAN NOT NULL DEFAULT FALSE,
+    repeat text NOT NULL DEFAULT 'off',
+    source_playlist_id text REFERENCES playlists(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    shuffle_seed integer
+);
+
+CREATE TABLE track_ratings (
+    track_id text NOT NULL REFERENCES tracks(track_id) ON DELETE CASCADE,
+    liked_at TIMESTAMP,
+    rating integer CHECK (rating BETWEEN 1 AND 5),
+    user_id text REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX track_ratings_liked_at_idx ON track_ratings (liked_at) WHERE liked_at IS NOT NULL;
+CREATE UNIQUE INDEX track_ratings_user_id_track_id_idx ON track_ratings (user_id, track_id);
+
+CREATE TABLE tags (
+    tag text NOT NULL,
+    target_type text NOT NULL CHECK (target_type IN ('track', 'album', 'artist')),
+    target_id text NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tag, target_type, target_id)
+);
+CREATE INDEX tags_target_idx ON tags (target_type, target_id);
+
+CREATE TABLE failed_downloads (
+    thing text PRIMARY KEY,
+    error text NOT NULL,
+    attempts integer NOT NULL DEFAULT 1,
+    failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
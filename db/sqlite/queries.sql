@@ -0,0 +1,929 @@
+-- the queries of ../../queries.sql in sqlite's dialect. sqlc only generates code for postgres, so the sqlite
+-- backend runs the generated code with these instead: every query here has the same name, parameters and
+-- columns (in the same order) as the one it replaces. keep them in sync when changing queries.sql.
+--
+-- arrays (text[]) are stored as JSON arrays, so "= ANY($1::text[])" is "IN (SELECT value FROM json_each(?1))".
+-- timestamps are stored as UTC text like CURRENT_TIMESTAMP's and local_time() (see sqlite.go) does what
+-- AT TIME ZONE does in postgres.
+
+-- name: AddTag :exec
+INSERT INTO tags (tag, target_type, target_id)
+VALUES (?1, ?2, ?3)
+ON CONFLICT DO NOTHING;
+
+-- name: AddTrackToPlaylist :exec
+INSERT INTO playlist_tracks (playlist_id, track_id, position)
+VALUES (?1, ?2, ?3);
+
+-- name: ArtistGenresFetched :one
+SELECT genres IS NOT NULL AS fetched FROM artists WHERE artist_id = ?1;
+
+-- name: ClaimUnownedData :exec
+UPDATE playlists SET user_id = ?1 WHERE playlists.user_id IS NULL;
+UPDATE playlist_folders SET user_id = ?1 WHERE playlist_folders.user_id IS NULL;
+UPDATE plays SET user_id = ?1 WHERE plays.user_id IS NULL;
+UPDATE playback_sessions SET session_id = ?1 WHERE session_id = 'default';
+UPDATE track_ratings SET user_id = ?1 WHERE track_ratings.user_id IS NULL;
+
+-- name: CountPlaylistTracks :one
+SELECT count(*) FROM playlist_tracks WHERE playlist_id = ?1;
+
+-- name: CountUsers :one
+SELECT count(*) FROM users;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES (?1, ?2, ?3, ?4, ?5)
+RETURNING id, created_at;
+
+-- name: CreateFolder :one
+INSERT INTO playlist_folders (name, parent_id, user_id)
+VALUES (?1, ?2, ?3)
+RETURNING id;
+
+-- name: CreatePlaylist :one
+INSERT INTO playlists (name, description, image_url, user_id)
+VALUES (?1, ?2, ?3, ?4)
+RETURNING id;
+
+-- name: CreateSmartPlaylist :one
+INSERT INTO playlists (name, description, image_url, rules, user_id)
+VALUES (?1, ?2, ?3, ?4, ?5)
+RETURNING id;
+
+-- name: CreateUser :one
+INSERT INTO users (username, password_hash, is_admin)
+VALUES (?1, ?2, ?3)
+RETURNING id, username, password_hash, is_admin, created_at;
+
+-- name: CreateUserSession :exec
+INSERT INTO user_sessions (token_hash, user_id, expires_at)
+VALUES (?1, ?2, ?3);
+
+-- name: CreateWebhook :one
+INSERT INTO webhooks (user_id, url, secret, events)
+VALUES (?1, ?2, ?3, ?4)
+RETURNING *;
+
+-- name: DeleteExpiredUserSessions :exec
+DELETE FROM user_sessions WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- name: DeleteFailedDownload :exec
+DELETE FROM failed_downloads WHERE thing = ?1;
+
+-- name: DeleteFolder :exec
+DELETE FROM playlist_folders WHERE id = ?1;
+
+-- name: DeleteOldWebhookDeliveries :exec
+DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < datetime('now', '-7 days');
+
+-- name: DeletePlaybackSession :exec
+DELETE FROM playback_sessions WHERE session_id = ?1;
+
+-- name: DeletePlaylist :exec
+DELETE FROM playlists WHERE id = ?1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = ?1;
+
+-- name: DeleteUserSession :exec
+DELETE FROM user_sessions WHERE token_hash = ?1;
+
+-- name: DeleteUserSessionsExcept :exec
+DELETE FROM user_sessions WHERE user_id = ?1 AND token_hash <> ?2;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE id = ?1 AND user_id = ?2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (webhook_id, event, payload)
+SELECT id, ?1, ?2 FROM webhooks
+WHERE ?1 IN (SELECT value FROM json_each(webhooks.events))
+    AND user_id = ?3;
+
+-- name: FirstPlay :one
+SELECT
+    p.play_id,
+    p.played_at,
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.cover_url
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+ORDER BY p.played_at
+LIMIT 1;
+
+-- name: FolderIsWithin :one
+WITH RECURSIVE chain AS (
+    SELECT f.id, f.parent_id FROM playlist_folders f WHERE f.id = ?1
+    UNION ALL
+    SELECT f.id, f.parent_id FROM playlist_folders f JOIN chain c ON f.id = c.parent_id
+)
+SELECT EXISTS (
+    SELECT 1 FROM chain WHERE chain.id = ?2
+);
+
+-- name: GetFolder :one
+SELECT id, name, parent_id, created_at, user_id FROM playlist_folders WHERE id = ?1;
+
+-- name: GetPlaybackSession :one
+SELECT session_id, current_track_id, position, queue, history, shuffle, repeat, source_playlist_id, updated_at, shuffle_seed FROM playback_sessions WHERE session_id = ?1;
+
+-- name: GetPlaylist :one
+SELECT
+    p.id,
+    p.name,
+    p.description,
+    p.image_url,
+    p.created_at,
+    p.rules,
+    p.folder_id,
+    p.user_id,
+    json_group_array(
+        json_object(
+            'track_id', t.track_id,
+            'track_name', t.track_name,
+            'duration', t.duration,
+            'popularity', t.popularity,
+            'album_id', t.album_id,
+            'album_name', a.album_name,
+            'artist_id', t.artist_id,
+            'artist_name', ar.artist_name,
+            'artists', json(t.artists),
+            'cover_url', a.cover_url,
+            'downloaded', json(CASE WHEN t.downloaded THEN 'true' ELSE 'false' END),
+            'track_release_date', t.track_release_date,
+            'lyrics', t.lyrics,
+            'play_count', COALESCE(pc.play_count, 0),
+            'position', pt.position,
+            'added_at', strftime('%Y-%m-%dT%H:%M:%f', pt.added_at),
+            'liked_at', strftime('%Y-%m-%dT%H:%M:%f', r.liked_at),
+            'rating', r.rating
+        )
+        ORDER BY
+            CASE WHEN NOT ?1 THEN
+                CASE ?2 WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+            END ASC,
+            CASE WHEN ?1 THEN
+                CASE ?2 WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+            END DESC,
+            CASE WHEN NOT ?1 THEN
+                CASE ?2 WHEN 'added_at' THEN julianday(pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN COALESCE(pc.play_count, 0) END
+            END ASC,
+            CASE WHEN ?1 THEN
+                CASE ?2 WHEN 'added_at' THEN julianday(pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN COALESCE(pc.play_count, 0) END
+            END DESC,
+            pt.position
+    ) FILTER (WHERE t.track_id IS NOT NULL) AS tracks
+FROM playlists p
+LEFT JOIN playlist_tracks pt ON pt.playlist_id = p.id
+LEFT JOIN tracks t ON t.track_id = pt.track_id
+LEFT JOIN albums a ON t.album_id = a.album_id
+LEFT JOIN artists ar ON t.artist_id = ar.artist_id
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = p.user_id
+LEFT JOIN (
+    SELECT plays.track_id, plays.user_id, count(*) AS play_count FROM plays GROUP BY plays.track_id, plays.user_id
+) pc ON pc.track_id = t.track_id AND pc.user_id = p.user_id
+WHERE p.id = ?3
+GROUP BY p.id;
+
+-- name: GetPlaylistByID :one
+SELECT id, name, description, image_url, created_at, rules, folder_id, user_id FROM playlists WHERE id = ?1;
+
+-- name: GetPlaylistTracksNotDownloaded :many
+SELECT tracks.track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, tracks.added_at, playlist_id, playlist_tracks.track_id, position, playlist_tracks.added_at FROM tracks
+JOIN playlist_tracks ON tracks.track_id = playlist_tracks.track_id
+WHERE playlist_tracks.playlist_id = ?1 AND tracks.downloaded = FALSE;
+
+-- name: GetSubsonicPassword :one
+SELECT password FROM subsonic_passwords WHERE user_id = ?1;
+
+-- name: GetTrackByID :one
+SELECT track_id, track_name, duration, popularity, album_id, artist_id, artists, track_release_date, downloaded, youtube_url, lyrics, added_at FROM tracks WHERE track_id = ?1;
+
+-- name: GetTrackLyrics :one
+SELECT lyrics FROM tracks WHERE track_id = ?1;
+
+-- name: GetTrackRating :one
+SELECT track_id, liked_at, rating, user_id FROM track_ratings WHERE user_id = ?1 AND track_id = ?2;
+
+-- name: GetTracksByIDs :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = ?1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = ?1
+WHERE t.track_id IN (SELECT value FROM json_each(?2))
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id;
+
+-- name: GetUserByAPIToken :one
+SELECT users.id, users.username, users.password_hash, users.is_admin, users.created_at,
+    api_tokens.id AS token_id, api_tokens.scopes
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = ?1 AND api_tokens.revoked_at IS NULL
+    AND (api_tokens.expires_at IS NULL OR api_tokens.expires_at > CURRENT_TIMESTAMP);
+
+-- name: GetUserBySession :one
+SELECT users.id, users.username, users.password_hash, users.is_admin, users.created_at FROM user_sessions
+JOIN users ON users.id = user_sessions.user_id
+WHERE user_sessions.token_hash = ?1 AND user_sessions.expires_at > CURRENT_TIMESTAMP;
+
+-- name: GetUserByUsername :one
+SELECT id, username, password_hash, is_admin, created_at FROM users WHERE username = ?1;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks WHERE id = ?1 AND user_id = ?2;
+
+-- name: GetYoutubeURLByTrackID :one
+SELECT youtube_url FROM tracks WHERE track_id = ?1;
+
+-- name: InsertTrack :exec
+INSERT INTO artists (artist_id, artist_name)
+VALUES (?1, ?2)
+ON CONFLICT (artist_id) DO NOTHING;
+INSERT INTO albums (album_id, album_name, artist_id, cover_url, album_release_date)
+VALUES (?3, ?4, ?1, ?5, ?6)
+ON CONFLICT (album_id) DO NOTHING;
+INSERT INTO tracks (
+    track_id,
+    track_name,
+    duration,
+    popularity,
+    album_id,
+    artist_id,
+    artists,
+    track_release_date,
+    downloaded,
+    lyrics,
+    youtube_url
+)
+VALUES (
+    ?7,
+    ?8,
+    ?9,
+    ?10,
+    ?3,
+    ?1,
+    ?11,
+    ?12,
+    ?13,
+    ?14,
+    ?15
+)
+ON CONFLICT (track_id) DO UPDATE
+SET
+    track_name = EXCLUDED.track_name,
+    duration = EXCLUDED.duration,
+    popularity = EXCLUDED.popularity,
+    album_id = EXCLUDED.album_id,
+    artist_id = EXCLUDED.artist_id,
+    artists = EXCLUDED.artists,
+    youtube_url = EXCLUDED.youtube_url,
+    track_release_date = EXCLUDED.track_release_date,
+    lyrics = CASE
+    WHEN EXCLUDED.lyrics = '' THEN tracks.lyrics
+    WHEN EXCLUDED.youtube_url = '' THEN tracks.youtube_url
+    ELSE EXCLUDED.lyrics
+END;
+
+-- name: LastPlayedBefore :many
+SELECT track_id, max(played_at) AS last_played_at
+FROM plays
+WHERE track_id IN (SELECT value FROM json_each(?1)) AND played_at < ?2 AND user_id = ?3
+GROUP BY track_id;
+
+-- name: LibraryCounts :one
+SELECT
+    (SELECT count(*) FROM tracks) AS tracks,
+    (SELECT count(*) FROM tracks WHERE downloaded) AS downloaded_tracks,
+    (SELECT count(*) FROM albums) AS albums,
+    (SELECT count(*) FROM artists) AS artists,
+    (SELECT count(*) FROM playlists) AS playlists,
+    (SELECT count(*) FROM users) AS users,
+    (SELECT count(*) FROM plays) AS plays,
+    (SELECT count(*) FROM failed_downloads) AS failed_downloads;
+
+-- name: ListAPITokens :many
+SELECT id, name, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_tokens
+WHERE user_id = ?1 ORDER BY created_at DESC;
+
+-- name: ListAlbumTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = ?1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = ?1
+WHERE t.album_id = ?2
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY t.track_name;
+
+-- name: ListDueWebhookDeliveries :many
+SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE d.status = 'pending' AND d.next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY d.next_attempt_at
+LIMIT ?1;
+
+-- name: ListFailedDownloads :many
+SELECT thing, error, attempts, failed_at FROM failed_downloads ORDER BY failed_at DESC;
+
+-- name: ListFolders :many
+SELECT id, name, parent_id, created_at, user_id FROM playlist_folders WHERE user_id = ?1 ORDER BY name;
+
+-- name: ListGenres :many
+SELECT g.value AS genre, count(DISTINCT t.track_id) AS track_count
+FROM artists ar
+JOIN json_each(ar.genres) AS g
+JOIN tracks t ON t.artist_id = ar.artist_id
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = ?1
+)
+GROUP BY g.value
+ORDER BY g.value;
+
+-- name: ListLibraryTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = ?1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = ?1
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = ?1
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id;
+
+-- name: ListLikedTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM track_ratings r
+JOIN tracks t ON t.track_id = r.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = r.user_id
+WHERE r.user_id = ?1 AND r.liked_at IS NOT NULL
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY r.liked_at DESC;
+
+-- name: ListPlaylistTrackIDs :many
+SELECT track_id FROM playlist_tracks WHERE playlist_id = ?1 ORDER BY position;
+
+-- name: ListPlaylists :many
+SELECT id, name, description, image_url, created_at, rules, folder_id, user_id FROM playlists WHERE user_id = ?1 ORDER BY created_at DESC, id;
+
+-- name: ListTags :many
+SELECT tag, count(*) AS count FROM tags GROUP BY tag ORDER BY tag;
+
+-- name: ListTagsOf :many
+SELECT tag FROM tags WHERE target_type = ?1 AND target_id = ?2 ORDER BY tag;
+
+-- name: ListTrackDownloadStates :many
+SELECT track_id, downloaded FROM tracks ORDER BY track_id;
+
+-- name: ListTracksByGenre :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = ?1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = ?1
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = ?1
+)) AND EXISTS (
+    SELECT 1 FROM json_each(ar.genres) AS g WHERE lower(g.value) = lower(?2)
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name);
+
+-- name: ListTracksByTag :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at) AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = ?1
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = ?1
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = ?1
+)) AND EXISTS (
+    SELECT 1 FROM tags tg
+    WHERE tg.tag = ?2 AND (
+        (tg.target_type = 'track' AND tg.target_id = t.track_id)
+        OR (tg.target_type = 'album' AND tg.target_id = t.album_id)
+        OR (tg.target_type = 'artist' AND tg.target_id = t.artist_id)
+    )
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name);
+
+-- name: ListUsers :many
+SELECT id, username, is_admin, created_at FROM users ORDER BY created_at;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries WHERE webhook_id = ?1 ORDER BY created_at DESC, id LIMIT ?2;
+
+-- name: ListWebhooks :many
+SELECT * FROM webhooks WHERE user_id = ?1 ORDER BY created_at;
+
+-- name: ListeningByHour :many
+SELECT
+    CAST(strftime('%H', local_time(p.played_at, ?1)) AS INTEGER) AS hour,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?2 AND p.played_at >= ?3 AND p.played_at < ?4
+GROUP BY hour
+ORDER BY hour;
+
+-- name: ListeningByMonth :many
+SELECT
+    strftime('%Y-%m-01', local_time(p.played_at, ?1)) AS month,
+    count(*) AS play_count,
+    count(DISTINCT p.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?2 AND p.played_at >= ?3 AND p.played_at < ?4
+GROUP BY month
+ORDER BY month;
+
+-- name: ListeningByWeekday :many
+SELECT
+    CAST(strftime('%w', local_time(p.played_at, ?1)) AS INTEGER) AS weekday,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?2 AND p.played_at >= ?3 AND p.played_at < ?4
+GROUP BY weekday
+ORDER BY weekday;
+
+-- name: ListeningDays :many
+SELECT DISTINCT
+    date(local_time(p.played_at, ?1)) AS day
+FROM plays p
+WHERE p.user_id = ?2 AND p.played_at >= ?3 AND p.played_at < ?4
+ORDER BY day;
+
+-- name: ListeningTotals :one
+SELECT
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    count(DISTINCT p.track_id) AS track_count,
+    COALESCE(sum(COALESCE(p.skipped_at, t.duration)), 0) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3;
+
+-- name: LockPlaylist :one
+-- transactions take the write lock when they begin (_txlock=immediate), so this only checks the playlist exists.
+SELECT id FROM playlists WHERE id = ?1;
+
+-- name: LockUsers :exec
+-- see LockPlaylist, the transaction already has the database to itself.
+SELECT 1;
+
+-- name: MarkTrackAsDownloaded :exec
+UPDATE tracks
+SET downloaded = TRUE
+WHERE track_id = ?1;
+
+-- name: MarkTrackAsNotDownloaded :exec
+UPDATE tracks
+SET downloaded = FALSE
+WHERE track_id = ?1;
+
+-- name: MonthlyTopTracks :many
+WITH monthly AS (
+    SELECT
+        strftime('%Y-%m-01', local_time(p.played_at, ?1)) AS month,
+        p.track_id,
+        count(*) AS play_count
+    FROM plays p
+    WHERE p.user_id = ?2 AND p.played_at >= ?3 AND p.played_at < ?4
+    GROUP BY month, p.track_id
+), ranked AS (
+    SELECT
+        m.month,
+        t.track_id,
+        t.track_name,
+        t.artists,
+        m.play_count,
+        row_number() OVER (PARTITION BY m.month ORDER BY m.play_count DESC, t.track_name) AS n
+    FROM monthly m
+    JOIN tracks t ON t.track_id = m.track_id
+)
+SELECT month, track_id, track_name, artists, play_count
+FROM ranked
+WHERE n = 1
+ORDER BY month;
+
+-- name: MostSkippedTrack :one
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+GROUP BY t.track_id
+HAVING count(p.skipped_at) > 0
+ORDER BY skip_count DESC, play_count ASC
+LIMIT 1;
+
+-- name: MoveFolder :exec
+UPDATE playlist_folders SET parent_id = ?2 WHERE id = ?1;
+
+-- name: MoveFolderPlaylistsToRoot :exec
+WITH RECURSIVE subtree AS (
+    SELECT f.id FROM playlist_folders f WHERE f.id = ?1
+    UNION ALL
+    SELECT f.id FROM playlist_folders f JOIN subtree s ON f.parent_id = s.id
+)
+UPDATE playlists SET folder_id = NULL
+WHERE folder_id IN (SELECT id FROM subtree);
+
+-- name: MovePlaylistToFolder :exec
+UPDATE playlists SET folder_id = ?2 WHERE id = ?1;
+
+-- name: MovePlaylistTracks :exec
+UPDATE playlist_tracks SET position = CASE
+    WHEN position >= ?1 AND position < ?1 + ?2
+        THEN position - ?1 + ?3
+    WHEN ?3 > ?1
+        THEN position - ?2
+    ELSE position + ?2
+END
+WHERE playlist_id = ?4
+AND position >= min(?1, ?3)
+AND position < max(?1, ?3) + ?2;
+
+-- name: NewArtists :many
+WITH first_plays AS (
+    SELECT t.artist_id, min(p.played_at) AS first_played_at
+    FROM plays p
+    JOIN tracks t ON t.track_id = p.track_id
+    WHERE p.user_id = ?1
+    GROUP BY t.artist_id
+)
+SELECT ar.artist_id, ar.artist_name, fp.first_played_at
+FROM first_plays fp
+JOIN artists ar ON ar.artist_id = fp.artist_id
+WHERE fp.first_played_at >= ?2 AND fp.first_played_at < ?3
+ORDER BY fp.first_played_at;
+
+-- name: PlaylistImageInUse :one
+SELECT EXISTS (
+    SELECT 1 FROM playlists WHERE image_url = ?1
+);
+
+-- name: PlaylistWithNameExists :one
+SELECT EXISTS (
+    SELECT 1 FROM playlists WHERE name = ?1 AND user_id = ?2
+);
+
+-- name: RadioCoListenCandidates :many
+SELECT next_plays.track_id, count(*) AS co_plays
+FROM plays p
+JOIN plays next_plays ON next_plays.play_id IN (
+    SELECT p2.play_id FROM plays p2
+    WHERE p2.user_id = p.user_id AND p2.played_at > p.played_at AND p2.played_at <= strftime('%Y-%m-%d %H:%M:%f', p.played_at, '+30 minutes')
+    ORDER BY p2.played_at
+    LIMIT 3
+)
+WHERE p.track_id IN (SELECT value FROM json_each(?1)) AND p.user_id = ?2
+AND NOT (next_plays.track_id IN (SELECT value FROM json_each(?1)))
+GROUP BY next_plays.track_id;
+
+-- name: RadioPlaylistCandidates :many
+SELECT pt.track_id, count(DISTINCT pt.playlist_id) AS shared_playlists
+FROM playlist_tracks pt
+WHERE pt.playlist_id IN (
+    SELECT seed.playlist_id FROM playlist_tracks seed
+    JOIN playlists pl ON pl.id = seed.playlist_id
+    WHERE seed.track_id IN (SELECT value FROM json_each(?1)) AND pl.user_id = ?2
+)
+AND NOT (pt.track_id IN (SELECT value FROM json_each(?1)))
+GROUP BY pt.track_id;
+
+-- name: RecentlyPlayedTrackIDs :many
+SELECT DISTINCT track_id FROM plays WHERE played_at >= ?1 AND user_id = ?2;
+
+-- name: RecordFailedDownload :exec
+INSERT INTO failed_downloads (thing, error)
+VALUES (?1, ?2)
+ON CONFLICT (thing) DO UPDATE SET
+    error = EXCLUDED.error,
+    attempts = failed_downloads.attempts + 1,
+    failed_at = CURRENT_TIMESTAMP;
+
+-- name: RecordPlay :one
+INSERT INTO plays (track_id, played_at, skipped_at, user_id)
+VALUES (?1, ?2, ?3, ?4)
+ON CONFLICT (user_id, track_id, played_at) DO NOTHING
+RETURNING play_id;
+
+-- name: RecordSkip :exec
+UPDATE plays
+SET skipped_at = ?1
+WHERE play_id = ?2 AND user_id = ?3;
+
+-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries SET
+    attempts = attempts + 1,
+    status = ?1,
+    last_status_code = ?2,
+    last_error = ?3,
+    next_attempt_at = datetime('now', '+' || ?4 || ' seconds'),
+    delivered_at = CASE WHEN ?1 = 'delivered' THEN CURRENT_TIMESTAMP END
+WHERE id = ?5;
+
+-- name: RemoveTag :exec
+DELETE FROM tags WHERE tag = ?1 AND target_type = ?2 AND target_id = ?3;
+
+-- name: RemoveTrackFromPlaylist :exec
+DELETE FROM playlist_tracks
+WHERE playlist_id = ?1 AND track_id = ?2;
+
+-- name: RenameFolder :exec
+UPDATE playlist_folders SET name = ?2 WHERE id = ?1;
+
+-- name: RenumberPlaylistTracks :exec
+UPDATE playlist_tracks AS pt SET position = numbered.new_position
+FROM (
+    SELECT track_id, row_number() OVER (ORDER BY position, added_at, track_id) - 1 AS new_position
+    FROM playlist_tracks
+    WHERE playlist_id = ?1
+) AS numbered
+WHERE pt.playlist_id = ?1 AND pt.track_id = numbered.track_id AND pt.position <> numbered.new_position;
+
+-- name: RetryWebhookDelivery :execrows
+UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = ?1 AND webhook_id = ?2 AND status = 'failed';
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?1 AND user_id = ?2 AND revoked_at IS NULL;
+
+-- name: SearchTrackByName :many
+SELECT tracks.track_id, tracks.track_name, tracks.duration, tracks.popularity, tracks.album_id, tracks.artist_id, tracks.artists, tracks.track_release_date, tracks.downloaded, tracks.youtube_url, tracks.lyrics, tracks.added_at, albums.album_id, albums.album_name, albums.artist_id, albums.cover_url, albums.album_release_date, artists.artist_id, artists.artist_name, artists.genres, track_ratings.liked_at, track_ratings.rating FROM tracks
+JOIN albums ON tracks.album_id = albums.album_id
+JOIN artists ON tracks.artist_id = artists.artist_id
+LEFT JOIN track_ratings ON track_ratings.track_id = tracks.track_id AND track_ratings.user_id = ?4
+WHERE track_name LIKE '%' || ?1 || '%'
+LIMIT ?2 OFFSET ?3;
+
+-- name: SetArtistGenres :exec
+UPDATE artists SET genres = ?2 WHERE artist_id = ?1;
+
+-- name: SetSubsonicPassword :exec
+INSERT INTO subsonic_passwords (user_id, password)
+VALUES (?1, ?2)
+ON CONFLICT (user_id) DO UPDATE SET password = EXCLUDED.password, created_at = CURRENT_TIMESTAMP;
+
+-- name: SetTrackLiked :exec
+INSERT INTO track_ratings (user_id, track_id, liked_at)
+VALUES (?1, ?2, CASE WHEN ?3 THEN CURRENT_TIMESTAMP END)
+ON CONFLICT (user_id, track_id) DO UPDATE SET
+    liked_at = CASE WHEN ?3 THEN COALESCE(track_ratings.liked_at, CURRENT_TIMESTAMP) END;
+
+-- name: SetTrackRating :exec
+INSERT INTO track_ratings (user_id, track_id, rating)
+VALUES (?1, ?2, ?3)
+ON CONFLICT (user_id, track_id) DO UPDATE SET rating = EXCLUDED.rating;
+
+-- name: ShiftPlaylistTracks :exec
+UPDATE playlist_tracks SET position = position + 1
+WHERE playlist_id = ?1 AND position >= ?2;
+
+-- name: SkipRates :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    CAST(count(p.skipped_at) AS REAL) / count(*) AS skip_rate
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+GROUP BY t.track_id
+HAVING count(*) >= ?4
+ORDER BY skip_rate DESC, play_count DESC
+LIMIT ?5;
+
+-- name: TagTargetExists :one
+SELECT CASE ?1
+    WHEN 'track' THEN EXISTS (SELECT 1 FROM tracks WHERE track_id = ?2)
+    WHEN 'album' THEN EXISTS (SELECT 1 FROM albums WHERE album_id = ?2)
+    WHEN 'artist' THEN EXISTS (SELECT 1 FROM artists WHERE artist_id = ?2)
+    ELSE FALSE
+END AS "exists";
+
+-- name: TopAlbums :many
+SELECT
+    a.album_id,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = a.artist_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+GROUP BY a.album_id, ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT ?4;
+
+-- name: TopArtists :many
+SELECT
+    ar.artist_id,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+GROUP BY ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT ?4;
+
+-- name: TopTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.album_name,
+    a.cover_url,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration)) AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = ?1 AND p.played_at >= ?2 AND p.played_at < ?3
+GROUP BY t.track_id, a.album_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT ?4;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = ?1 AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'));
+
+-- name: UpdatePlaylist :exec
+UPDATE playlists
+SET name = ?2, description = ?3, image_url = ?4
+WHERE id = ?1;
+
+-- name: UpdatePlaylistRules :exec
+UPDATE playlists
+SET rules = ?1
+WHERE id = ?2;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = ?2 WHERE id = ?1;
+
+-- name: UpdateWebhook :execrows
+UPDATE webhooks SET url = ?3, events = ?4 WHERE id = ?1 AND user_id = ?2;
+
+-- name: UpsertPlaybackSession :one
+INSERT INTO playback_sessions (session_id, current_track_id, position, queue, history, shuffle, repeat, source_playlist_id, shuffle_seed, updated_at)
+VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, CURRENT_TIMESTAMP)
+ON CONFLICT (session_id) DO UPDATE SET
+    current_track_id = EXCLUDED.current_track_id,
+    position = EXCLUDED.position,
+    queue = EXCLUDED.queue,
+    history = EXCLUDED.history,
+    shuffle = EXCLUDED.shuffle,
+    repeat = EXCLUDED.repeat,
+    source_playlist_id = EXCLUDED.source_playlist_id,
+    shuffle_seed = EXCLUDED.shuffle_seed,
+    updated_at = EXCLUDED.updated_at
+RETURNING updated_at;
@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var (
+	postgresParam = regexp.MustCompile(`\$(\d+)`)
+	namedParam    = regexp.MustCompile(`sqlc\.n?arg\((\w+)\)`)
+	sqliteParam   = regexp.MustCompile(`\?(\d*)`)
+)
+
+// TestQueriesMatch checks that every query in queries.sql has a sqlite version of the same kind that takes
+// the same parameters, and that there are no sqlite queries left over.
+func TestQueriesMatch(t *testing.T) {
+	data, err := os.ReadFile("../../queries.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres := parseQueries(string(data))
+	if len(postgres) == 0 {
+		t.Fatal("no queries in queries.sql")
+	}
+
+	for name, pq := range postgres {
+		sq, ok := statements[name]
+		if !ok {
+			t.Errorf("%s: there's no sqlite version", name)
+			continue
+		}
+		if pk, sk := queryKind(pq), queryKind(sq); pk != sk {
+			t.Errorf("%s: it's %s in queries.sql but %s in sqlite", name, pk, sk)
+		}
+		sqliteParams := 0
+		for _, m := range sqliteParam.FindAllStringSubmatch(sq, -1) {
+			if m[1] == "" {
+				t.Errorf("%s: sqlite uses ? instead of ?N", name)
+				continue
+			}
+			n, _ := strconv.Atoi(m[1])
+			sqliteParams = max(sqliteParams, n)
+		}
+		if pn := postgresParams(pq); pn != sqliteParams {
+			t.Errorf("%s: it takes %d parameters in queries.sql but %d in sqlite", name, pn, sqliteParams)
+		}
+	}
+	for name := range statements {
+		if _, ok := postgres[name]; !ok {
+			t.Errorf("%s: it's only in the sqlite queries", name)
+		}
+	}
+
+	// and the code of package db runs the queries of queries.sql
+	generated, err := os.ReadFile("../queries.sql.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range regexp.MustCompile("(?m)^const \\w+ = `-- name: (\\w+)").FindAllSubmatch(generated, -1) {
+		if _, ok := postgres[string(m[1])]; !ok {
+			t.Errorf("%s: package db has it but queries.sql doesn't", m[1])
+		}
+	}
+}
+
+// queryKind returns what sqlc makes of a query (:one, :many, :exec...).
+func queryKind(query string) string {
+	return regexp.MustCompile(`^-- name: \w+ (:\w+)`).FindStringSubmatch(query)[1]
+}
+
+// postgresParams returns how many parameters a query of queries.sql takes: the highest numbered one, plus one
+// for each sqlc.arg (which sqlc numbers after them).
+func postgresParams(query string) int {
+	n := 0
+	for _, m := range postgresParam.FindAllStringSubmatch(query, -1) {
+		i, _ := strconv.Atoi(m[1])
+		n = max(n, i)
+	}
+	named := make(map[string]bool)
+	for _, m := range namedParam.FindAllStringSubmatch(query, -1) {
+		named[m[1]] = true
+	}
+	return n + len(named)
+}
@@ -0,0 +1,425 @@
+// Package sqlite keeps the library in an embedded SQLite database instead of postgres. the code sqlc
+// generates (package db) is written against pgx, so it runs on an adapter (dbtx) that swaps each query for
+// its sqlite version from queries.sql and converts the parameters and results between the pgx types and
+// what sqlite stores.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	moderncsqlite "modernc.org/sqlite"
+)
+
+//go:embed queries.sql
+var queriesSQL string
+
+// statements are the sqlite versions of the queries by name.
+var statements = parseQueries(queriesSQL)
+
+var queryName = regexp.MustCompile(`^-- name: (\w+) :\w+`)
+
+func init() {
+	moderncsqlite.MustRegisterDeterministicScalarFunction("local_time", 2, localTime)
+}
+
+// parseQueries splits a file of sqlc style queries ("-- name: X :kind" followed by the statements) by name.
+func parseQueries(s string) map[string]string {
+	byName := make(map[string]string)
+	var name string
+	var b strings.Builder
+	for line := range strings.Lines(s) {
+		if m := queryName.FindStringSubmatch(line); m != nil {
+			if name != "" {
+				byName[name] = b.String()
+			}
+			name = m[1]
+			b.Reset()
+		}
+		b.WriteString(line)
+	}
+	if name != "" {
+		byName[name] = b.String()
+	}
+	return byName
+}
+
+// statement returns the sqlite version of a query of package db, which starts with its name.
+func statement(query string) (string, error) {
+	m := queryName.FindStringSubmatch(query)
+	if m == nil {
+		return "", errors.New("sqlite: query without a name")
+	}
+	s, ok := statements[m[1]]
+	if !ok {
+		return "", fmt.Errorf("sqlite: there's no sqlite version of query %s", m[1])
+	}
+	return s, nil
+}
+
+// conn is what queries run on, the database or a transaction.
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// dbtx implements db.DBTX on a sqlite database.
+type dbtx struct {
+	c conn
+}
+
+func (d dbtx) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	s, err := statement(query)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	params, err := convertArgs(args)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	res, err := d.c.ExecContext(ctx, s, params...)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	// sqlc only reads the number of rows from the tag
+	return pgconn.NewCommandTag("EXEC " + strconv.FormatInt(n, 10)), nil
+}
+
+func (d dbtx) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	s, err := statement(query)
+	if err != nil {
+		return nil, err
+	}
+	params, err := convertArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	r, err := d.c.QueryContext(ctx, s, params...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r: r}, nil
+}
+
+func (d dbtx) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	r, err := d.Query(ctx, query, args...)
+	return row{r: r, err: err}
+}
+
+// row is the pgx.Row of QueryRow: the first row of the result, pgx.ErrNoRows if there isn't one.
+type row struct {
+	r   pgx.Rows
+	err error
+}
+
+func (r row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.r.Close()
+	if !r.r.Next() {
+		if err := r.r.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+	return r.r.Scan(dest...)
+}
+
+// rows implements pgx.Rows (as much as sqlc uses of it) on sql.Rows.
+type rows struct {
+	r      *sql.Rows
+	values []any
+	err    error
+}
+
+func (r *rows) Close() { r.r.Close() }
+
+func (r *rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.r.Err()
+}
+
+func (r *rows) CommandTag() pgconn.CommandTag { return pgconn.CommandTag{} }
+
+func (r *rows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+
+func (r *rows) Next() bool {
+	if r.err != nil || !r.r.Next() {
+		return false
+	}
+	columns, err := r.r.Columns()
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.values = make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range r.values {
+		ptrs[i] = &r.values[i]
+	}
+	if err := r.r.Scan(ptrs...); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+func (r *rows) Scan(dest ...any) error {
+	if len(dest) != len(r.values) {
+		return fmt.Errorf("sqlite: %d columns scanned into %d values", len(r.values), len(dest))
+	}
+	for i, d := range dest {
+		if err := assign(d, r.values[i]); err != nil {
+			return fmt.Errorf("sqlite: scan column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *rows) Values() ([]any, error) { return r.values, r.Err() }
+
+func (r *rows) RawValues() [][]byte { return nil }
+
+func (r *rows) Conn() *pgx.Conn { return nil }
+
+// timestamps are stored like CURRENT_TIMESTAMP stores them (but with the fractional seconds) so they
+// compare correctly as text.
+const (
+	timestampLayout = "2006-01-02 15:04:05.999999"
+	dateLayout      = "2006-01-02"
+)
+
+// convertArgs converts the parameters of a query to what sqlite stores.
+func convertArgs(args []any) ([]any, error) {
+	params := make([]any, len(args))
+	for i, a := range args {
+		p, err := convertArg(a)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: parameter %d: %w", i+1, err)
+		}
+		params[i] = p
+	}
+	return params, nil
+}
+
+func convertArg(a any) (any, error) {
+	switch v := a.(type) {
+	case nil:
+		return nil, nil
+	case pgtype.Timestamp:
+		if !v.Valid {
+			return nil, nil
+		}
+		return v.Time.Format(timestampLayout), nil
+	case pgtype.Date:
+		if !v.Valid {
+			return nil, nil
+		}
+		return v.Time.Format(dateLayout), nil
+	case time.Time:
+		return v.UTC().Format(timestampLayout), nil
+	case []string:
+		if v == nil { // like pgx, which sends a nil slice as NULL
+			return nil, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	case json.RawMessage:
+		if v == nil {
+			return nil, nil
+		}
+		return string(v), nil
+	case int32:
+		return int64(v), nil
+	case driver.Valuer: // uuid, text and the ints
+		return v.Value()
+	}
+	return a, nil
+}
+
+// assign sets dest (a pointer to a field of a row struct of package db) to the value sqlite returned.
+func assign(dest, src any) error {
+	switch d := dest.(type) {
+	case *string:
+		switch s := src.(type) {
+		case string:
+			*d = s
+		case []byte:
+			*d = string(s)
+		case int64:
+			*d = strconv.FormatInt(s, 10)
+		case time.Time:
+			*d = s.Format(timestampLayout)
+		default:
+			return fmt.Errorf("can't scan %T into a string", src)
+		}
+	case *int32:
+		n, err := toInt(src)
+		*d = int32(n)
+		return err
+	case *int64:
+		n, err := toInt(src)
+		*d = n
+		return err
+	case *float64:
+		switch s := src.(type) {
+		case float64:
+			*d = s
+		case int64:
+			*d = float64(s)
+		default:
+			return fmt.Errorf("can't scan %T into a float", src)
+		}
+	case *bool:
+		switch s := src.(type) {
+		case bool:
+			*d = s
+		case int64:
+			*d = s != 0
+		default:
+			return fmt.Errorf("can't scan %T into a bool", src)
+		}
+	case *[]string:
+		if src == nil {
+			*d = nil
+			return nil
+		}
+		s, err := toText(src)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(s), d)
+	case *json.RawMessage:
+		if src == nil {
+			*d = nil
+			return nil
+		}
+		s, err := toText(src)
+		*d = json.RawMessage(s)
+		return err
+	case *interface{}:
+		// sqlc types json columns it can't see the type of as interface{}, which pgx decodes
+		if s, ok := src.(string); ok && json.Valid([]byte(s)) && strings.ContainsAny(s[:1], "[{") {
+			return json.Unmarshal([]byte(s), d)
+		}
+		*d = src
+	case *pgtype.Timestamp:
+		if src == nil {
+			*d = pgtype.Timestamp{}
+			return nil
+		}
+		t, err := toTime(src)
+		*d = pgtype.Timestamp{Time: t, Valid: err == nil}
+		return err
+	case *pgtype.Date:
+		if src == nil {
+			*d = pgtype.Date{}
+			return nil
+		}
+		t, err := toTime(src)
+		*d = pgtype.Date{Time: t, Valid: err == nil}
+		return err
+	case sql.Scanner: // uuid, text and the ints
+		return d.Scan(src)
+	default:
+		return fmt.Errorf("can't scan into %T", dest)
+	}
+	return nil
+}
+
+func toInt(src any) (int64, error) {
+	switch s := src.(type) {
+	case int64:
+		return s, nil
+	case float64:
+		return int64(s), nil
+	case bool:
+		if s {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(s, 10, 64)
+	}
+	return 0, fmt.Errorf("can't scan %T into an int", src)
+}
+
+func toText(src any) (string, error) {
+	switch s := src.(type) {
+	case string:
+		return s, nil
+	case []byte:
+		return string(s), nil
+	}
+	return "", fmt.Errorf("can't scan %T into json", src)
+}
+
+// the layouts times are read in: how they're written, how CURRENT_TIMESTAMP writes them, and what sqlite's
+// date functions (and other programs) may have written.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func toTime(src any) (time.Time, error) {
+	switch s := src.(type) {
+	case time.Time:
+		return s, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("can't parse time %q", s)
+	}
+	return time.Time{}, fmt.Errorf("can't scan %T into a time", src)
+}
+
+var locations sync.Map // name -> *time.Location
+
+// localTime is the sql function local_time(timestamp, time zone), the local time in the time zone of a UTC
+// timestamp. it's what "(ts AT TIME ZONE 'UTC') AT TIME ZONE tz" is in postgres.
+func localTime(_ *moderncsqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	t, err := toTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	name, _ := args[1].(string)
+	loc, ok := locations.Load(name)
+	if !ok {
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("local_time: %w", err)
+		}
+		loc, _ = locations.LoadOrStore(name, l)
+	}
+	return t.In(loc.(*time.Location)).Format(timestampLayout), nil
+}
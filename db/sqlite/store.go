@@ -0,0 +1,250 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// migrations are the schema changes by version (migrations/<version>.sql). they're run in order on a
+// database whose user_version is lower and set it to their version. a migration never changes once it's
+// released, a change to the schema is a new migration.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// backupName is the name of the database in a backup.
+const backupName = "music.sqlite"
+
+// Store is a library.Store on a SQLite database.
+type Store struct {
+	db      *sql.DB
+	queries *queries.Queries
+}
+
+// Open opens the SQLite database at path, creating it if it doesn't exist, and migrates it.
+func Open(ctx context.Context, path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create database directory: %w", err)
+	}
+	// transactions take the write lock when they begin so they never fail halfway through because another
+	// one is writing, and writers wait for each other instead of failing with SQLITE_BUSY.
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	s := &Store{db: db, queries: queries.New(dbtx{c: db})}
+	if err := s.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Queries() *queries.Queries {
+	return s.queries
+}
+
+func (s *Store) InTx(ctx context.Context, fn func(q *queries.Queries) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op after commit
+
+	if err := fn(queries.New(dbtx{c: tx})); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Migrate runs the migrations the database doesn't have yet.
+func (s *Store) Migrate(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("get schema version: %w", err)
+	}
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	var versions []int
+	for _, e := range entries {
+		v, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".sql"))
+		if err != nil {
+			return fmt.Errorf("bad migration name %s", e.Name())
+		}
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+
+	for _, v := range versions {
+		if v <= version {
+			continue
+		}
+		migration, err := migrations.ReadFile(fmt.Sprintf("migrations/%d.sql", v))
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, string(migration)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("run migration %d: %w", v, err)
+		}
+		// user_version is in the database header, so it's set in the same transaction
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", v)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("set schema version: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// Ping checks that the database can be read.
+func (s *Store) Ping(ctx context.Context) error {
+	var version int
+	return s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+}
+
+func (s *Store) Backend() string {
+	return "sqlite"
+}
+
+// Backup adds a copy of the whole database (made with VACUUM INTO, so it's consistent) to the backup.
+func (s *Store) Backup(ctx context.Context, add func(name string, data []byte) error) error {
+	dir, err := os.MkdirTemp("", "music-backup")
+	if err != nil {
+		return fmt.Errorf("create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, backupName)
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", p); err != nil {
+		return fmt.Errorf("copy database: %w", err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("read database copy: %w", err)
+	}
+	return add(backupName, data)
+}
+
+// Restore replaces the rows of every table with the ones in the database of a backup. the rows are copied
+// by column name, so a backup of a database with an older schema can be restored (the database has to be
+// migrated, which Open does).
+func (s *Store) Restore(ctx context.Context, files map[string][]byte) error {
+	data, ok := files[backupName]
+	if !ok {
+		return fmt.Errorf("the backup doesn't have a %s", backupName)
+	}
+	dir, err := os.MkdirTemp("", "music-restore")
+	if err != nil {
+		return fmt.Errorf("create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, backupName)
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return fmt.Errorf("write database copy: %w", err)
+	}
+
+	// the backup is attached to one connection, which the transaction has to use too
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, "ATTACH DATABASE ? AS backup", p); err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer c.ExecContext(context.Background(), "DETACH DATABASE backup")
+
+	tables, err := tableColumns(ctx, c, "main")
+	if err != nil {
+		return err
+	}
+	backupTables, err := tableColumns(ctx, c, "backup")
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op after commit
+	// the tables are filled in any order, so the foreign keys are checked at the commit
+	if _, err := tx.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON"); err != nil {
+		return fmt.Errorf("defer foreign keys: %w", err)
+	}
+	for table := range tables {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM main.%q`, table)); err != nil {
+			return fmt.Errorf("empty table %s: %w", table, err)
+		}
+	}
+	for table, columns := range tables {
+		var common []string
+		for _, c := range columns {
+			if slices.Contains(backupTables[table], c) {
+				common = append(common, fmt.Sprintf("%q", c))
+			}
+		}
+		if len(common) == 0 {
+			continue
+		}
+		cols := strings.Join(common, ", ")
+		sql := fmt.Sprintf(`INSERT INTO main.%q (%s) SELECT %s FROM backup.%q`, table, cols, cols, table)
+		if _, err := tx.ExecContext(ctx, sql); err != nil {
+			return fmt.Errorf("restore table %s: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// tableColumns returns the columns of each table of a database (main or an attached one).
+func tableColumns(ctx context.Context, c *sql.Conn, schema string) (map[string][]string, error) {
+	rows, err := c.QueryContext(ctx, fmt.Sprintf(`SELECT m.name, p.name FROM %q.sqlite_schema m
+JOIN pragma_table_info(m.name, %s) p
+WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%%'`, schema, quote(schema)))
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	defer rows.Close()
+	tables := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("list tables: %w", err)
+		}
+		tables[table] = append(tables[table], column)
+	}
+	return tables, rows.Err()
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
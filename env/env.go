@@ -1,70 +1,288 @@
 package env
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// Environment is the configuration. every setting has its key in the config file (toml) and the env var that
+// overrides it (env). secret settings are redacted when the configuration is shown.
 type Environment struct {
-	Debug                   bool
-	PathToSpotDL            string
-	PathToYtDL              string
-	SpotifyClientID         string
-	SpotifyClientSecret     string
-	ServerAddress           string
-	StorageURL              string
-	StorageAPISecret        string
-	CertPath                string
-	KeyPath                 string
-	PostgresURL             string
-	DataPath                string
-	ServerURL               string
-	MaximumOngoingDownloads int
+	Debug                   bool   `toml:"debug" env:"DEBUG"`
+	PathToSpotDL            string `toml:"spotdl_path" env:"SPOTDL_PATH"`
+	PathToYtDL              string `toml:"yt_dlp_path" env:"YT_DLP_PATH"`
+	SpotifyClientID         string `toml:"spotify_client_id" env:"SPOTIFY_CLIENT_ID"`
+	SpotifyClientSecret     string `toml:"spotify_client_secret" env:"SPOTIFY_CLIENT_SECRET" secret:"true"`
+	ServerAddress           string `toml:"server_address" env:"SERVER_ADDRESS"`
+	StorageURL              string `toml:"storage_url" env:"STORAGE_URL"`
+	StorageAPISecret        string `toml:"storage_api_secret" env:"STORAGE_API_SECRET" secret:"true"`
+	CertPath                string `toml:"cert_path" env:"CERT_PATH"`
+	KeyPath                 string `toml:"key_path" env:"KEY_PATH"`
+	PostgresURL             string `toml:"postgres_url" env:"POSTGRES_URL" secret:"password"`
+	SQLitePath              string `toml:"sqlite_path" env:"SQLITE_PATH"` // the library is in a sqlite database there instead of postgres if set
+	DataPath                string `toml:"data_path" env:"DATA_PATH"`
+	ServerURL               string `toml:"server_url" env:"SERVER_URL"`
+	MaximumOngoingDownloads int    `toml:"max_concurrent_downloads" env:"MAX_CONCURRENT_DOWNLOADS"`
+	MPDAddress              string `toml:"mpd_address" env:"MPD_ADDRESS"`
+
+	// YtDLPArgs are passed to yt-dlp before the video url, after the arguments that make it extract the audio
+	// into the data directory (-x --audio-format m4a --output ...).
+	YtDLPArgs        []string      `toml:"yt_dlp_args" env:"YT_DLP_ARGS"`
+	DownloadAttempts int           `toml:"download_attempts" env:"DOWNLOAD_ATTEMPTS"`
+	DownloadTimeout  time.Duration `toml:"download_timeout" env:"DOWNLOAD_TIMEOUT"` // of a track requested from the api
+	// PlaylistDownloadTimeout is how long downloading the tracks of a playlist from the api can take.
+	PlaylistDownloadTimeout time.Duration `toml:"playlist_download_timeout" env:"PLAYLIST_DOWNLOAD_TIMEOUT"`
+	// SearchLimit is the number of results from the library that's enough for a search to not ask spotify.
+	SearchLimit        int `toml:"search_limit" env:"SEARCH_LIMIT"`
+	SpotifySearchLimit int `toml:"spotify_search_limit" env:"SPOTIFY_SEARCH_LIMIT"` // results asked from spotify, at most 50
+	// ShutdownTimeout is how long the server waits for requests and downloads to finish when it's stopped
+	// before it interrupts them.
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+
+	// used by the cli to work with a running server instead of the database
+	RemoteServerURL string `toml:"remote_server_url" env:"MUSIC_SERVER"`
+	RemoteAPIToken  string `toml:"remote_api_token" env:"MUSIC_API_TOKEN" secret:"true"`
 }
 
-var DefaultEnv = Environment{
-	Debug:               dv(os.Getenv("DEBUG"), "false") == "true",
-	PathToSpotDL:        dv(os.Getenv("SPOTDL_PATH"), "spotdl"),
-	PathToYtDL:          dv(os.Getenv("YT_DLP_PATH"), "yt-dlp"),
-	SpotifyClientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
-	SpotifyClientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
-	ServerAddress:       dv(os.Getenv("SERVER_ADDRESS"), ":8080"),
+// Defaults is the configuration without a config file or env vars.
+var Defaults = Environment{
+	PathToSpotDL:  "spotdl",
+	PathToYtDL:    "yt-dlp",
+	ServerAddress: ":8080",
+	PostgresURL:   "postgres://musicer:@localhost:5432/music",
+	DataPath:      "/var/lib/musicer/data",
+
+	MaximumOngoingDownloads: 10,
+
+	YtDLPArgs: []string{
+		"--extractor-args", "youtube:player_client=default,ios,-android_sdkless;formats=missing_pot",
+		"--format", "bv[protocol=m3u8_native]+ba[protocol=m3u8_native]/b[protocol=m3u8_native]",
+	},
+	DownloadAttempts:        3,
+	DownloadTimeout:         time.Minute,
+	PlaylistDownloadTimeout: 10 * time.Minute,
+	SearchLimit:             75,
+	SpotifySearchLimit:      50,
+	ShutdownTimeout:         30 * time.Second,
+}
 
-	StorageURL:       os.Getenv("STORAGE_URL"),
-	StorageAPISecret: os.Getenv("STORAGE_API_SECRET"),
-	ServerURL:        os.Getenv("SERVER_URL"),
+// DefaultEnv is the configuration in use, Defaults until Load is called.
+var DefaultEnv = Defaults
 
-	CertPath:    os.Getenv("CERT_PATH"),
-	KeyPath:     os.Getenv("KEY_PATH"),
-	PostgresURL: dv(os.Getenv("POSTGRES_URL"), "postgres://musicer:@localhost:5432/music"),
-	DataPath:    dv(os.Getenv("DATA_PATH"), "/var/lib/musicer/data"),
+// Load reads the configuration into DefaultEnv: the defaults, overridden by the config file at CONFIG_FILE
+// (if it's set) and then by the env vars. it returns an error for an unknown setting in the file or a value
+// that doesn't parse, but doesn't validate the settings (see Init).
+func Load() error {
+	e := Defaults
+	e.YtDLPArgs = slices.Clone(Defaults.YtDLPArgs)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		md, err := toml.DecodeFile(path, &e)
+		if err != nil {
+			return fmt.Errorf("read config file: %w", err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("read config file: unknown setting %s", undecoded[0])
+		}
+	}
 
-	MaximumOngoingDownloads: dvi(os.Getenv("MAX_CONCURRENT_DOWNLOADS"), 10),
+	v := reflect.ValueOf(&e).Elem()
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Tag.Get("env")
+		if value := os.Getenv(name); value != "" {
+			if err := setFromEnv(v.Field(i), value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	DefaultEnv = e
+	return nil
 }
 
-// Init initializes the environment by checking required variables. It returns an error if any required variable is missing.
+func setFromEnv(f reflect.Value, value string) error {
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		f.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		f.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration like 90s or 5m")
+		}
+		f.SetInt(int64(d))
+	case []string: // separated by spaces
+		f.Set(reflect.ValueOf(strings.Fields(value)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.Type())
+	}
+	return nil
+}
+
+// Init checks that the configuration can run the server (or download): the required settings are set and
+// the others are valid. the error lists every problem, not just the first one.
 func Init() error {
-	if DefaultEnv.PathToSpotDL == "" || DefaultEnv.SpotifyClientID == "" || DefaultEnv.SpotifyClientSecret == "" {
-		return errors.New("one or more required environment variables are not set")
+	e := DefaultEnv
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(e.SpotifyClientID != "", "SPOTIFY_CLIENT_ID is required")
+	check(e.SpotifyClientSecret != "", "SPOTIFY_CLIENT_SECRET is required")
+	for _, tool := range []struct{ setting, path string }{{"SPOTDL_PATH", e.PathToSpotDL}, {"YT_DLP_PATH", e.PathToYtDL}} {
+		_, err := exec.LookPath(tool.path)
+		check(err == nil, "%s: %q isn't an executable (is it installed?)", tool.setting, tool.path)
+	}
+	// spotdl and yt-dlp run ffmpeg from PATH to convert what they download
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		check(false, "ffmpeg isn't in PATH (spotdl and yt-dlp need it)")
+	}
+
+	if err := os.MkdirAll(e.DataPath, 0755); err != nil {
+		errs = append(errs, fmt.Errorf("DATA_PATH: %w", err))
+	}
+	if e.SQLitePath != "" {
+		info, err := os.Stat(filepath.Dir(e.SQLitePath))
+		check(err == nil && info.IsDir(), "SQLITE_PATH: the directory %s doesn't exist", filepath.Dir(e.SQLitePath))
+	} else if _, err := pgconn.ParseConfig(e.PostgresURL); err != nil {
+		check(false, "POSTGRES_URL: %v", err)
+	}
+
+	check(e.ServerURL == "" || isHTTPURL(e.ServerURL), "SERVER_URL: %q isn't an http(s) url", e.ServerURL)
+	check(e.StorageURL == "" || isHTTPURL(e.StorageURL), "STORAGE_URL: %q isn't an http(s) url", e.StorageURL)
+	check((e.StorageURL == "") == (e.StorageAPISecret == ""), "STORAGE_URL and STORAGE_API_SECRET have to be set together")
+
+	check((e.CertPath == "") == (e.KeyPath == ""), "CERT_PATH and KEY_PATH have to be set together")
+	if e.CertPath != "" && e.KeyPath != "" {
+		if _, err := tls.LoadX509KeyPair(e.CertPath, e.KeyPath); err != nil {
+			check(false, "CERT_PATH and KEY_PATH: %v", err)
+		}
+	}
+
+	_, _, err := net.SplitHostPort(e.ServerAddress)
+	check(err == nil, "SERVER_ADDRESS: %q isn't host:port", e.ServerAddress)
+	if e.MPDAddress != "" {
+		_, _, err := net.SplitHostPort(e.MPDAddress)
+		check(err == nil, "MPD_ADDRESS: %q isn't host:port", e.MPDAddress)
+	}
+
+	check(e.MaximumOngoingDownloads >= 1, "MAX_CONCURRENT_DOWNLOADS must be at least 1")
+	check(e.DownloadAttempts >= 1, "DOWNLOAD_ATTEMPTS must be at least 1")
+	check(e.DownloadTimeout > 0, "DOWNLOAD_TIMEOUT must be more than 0")
+	check(e.PlaylistDownloadTimeout > 0, "PLAYLIST_DOWNLOAD_TIMEOUT must be more than 0")
+	check(e.SearchLimit >= 1, "SEARCH_LIMIT must be at least 1")
+	check(e.SpotifySearchLimit >= 1 && e.SpotifySearchLimit <= 50, "SPOTIFY_SEARCH_LIMIT must be between 1 and 50")
+	check(e.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be more than 0")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
 	}
 	return nil
 }
 
-func dv(value, def string) string {
-	if value == "" {
-		return def
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Redacted returns the configuration in the format of the config file with the secrets replaced, to show
+// what's in use.
+func (e Environment) Redacted() string {
+	var b strings.Builder
+	e.eachRedacted(func(key string, value any) {
+		line, err := toml.Marshal(map[string]any{key: value})
+		if err != nil {
+			fmt.Fprintf(&b, "# %s: %v\n", key, err)
+			return
+		}
+		b.Write(line)
+	})
+	return b.String()
+}
+
+// LogValue logs the configuration with the secrets replaced.
+func (e Environment) LogValue() slog.Value {
+	var attrs []slog.Attr
+	e.eachRedacted(func(key string, value any) {
+		attrs = append(attrs, slog.Any(key, value))
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// eachRedacted calls fn with the config file key and value of every setting, secrets replaced.
+func (e Environment) eachRedacted(fn func(key string, value any)) {
+	v := reflect.ValueOf(e)
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		value := v.Field(i).Interface()
+		switch field.Tag.Get("secret") {
+		case "true":
+			if value != "" {
+				value = "<redacted>"
+			}
+		case "password": // a connection string that can have a password in it
+			value = redactPassword(value.(string))
+		}
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		fn(field.Tag.Get("toml"), value)
 	}
-	return value
 }
 
-func dvi(value string, def int) int {
-	if value == "" {
-		return def
+// redactPassword replaces the password in a postgres connection url, in its user info or its password
+// parameter. a value that isn't a url (like "host=... password=...") or doesn't parse is redacted whole.
+func redactPassword(dsn string) string {
+	if dsn == "" {
+		return ""
 	}
-	i, err := strconv.Atoi(value)
+	config, err := pgconn.ParseConfig(dsn)
 	if err != nil {
-		return def
+		return "<redacted>"
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "<redacted>"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", "redacted")
+		u.RawQuery = q.Encode()
+	}
+	redacted := u.String()
+	// the password can also come from PGPASSWORD or a passfile, which aren't shown, so it's only a problem if
+	// it's still in there
+	if config.Password != "" && strings.Contains(redacted, config.Password) {
+		return "<redacted>"
 	}
-	return i
+	return redacted
 }
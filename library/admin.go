@@ -0,0 +1,136 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// Migrate brings the database up to date (see Store.Migrate).
+func (l *Library) Migrate(ctx context.Context) error {
+	return l.store.Migrate(ctx)
+}
+
+// FailedDownloads lists the downloads that failed, most recent first.
+func (l *Library) FailedDownloads(ctx context.Context) ([]queries.FailedDownload, error) {
+	failed, err := l.queries.ListFailedDownloads(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list failed downloads: %w", err)
+	}
+	return orEmpty(failed), nil
+}
+
+// RetryFailedDownload downloads a failed download again for the user retrying it. it's removed from the failed
+// downloads if it works this time.
+func (l *Library) RetryFailedDownload(ctx context.Context, userID pgtype.UUID, thing string) error {
+	failed, err := l.FailedDownloads(ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range failed {
+		if f.Thing == thing {
+			return l.Download(ctx, userID, thing)
+		}
+	}
+	return fmt.Errorf("%q is not a failed download", thing)
+}
+
+// ScanResult is what Scan found and fixed.
+type ScanResult struct {
+	Checked          int      `json:"checked"`           // number of tracks
+	MarkedDownloaded []string `json:"marked_downloaded"` // tracks with a file that weren't marked downloaded
+	MarkedMissing    []string `json:"marked_missing"`    // tracks marked downloaded without a file
+	Orphans          []string `json:"orphans"`           // audio files that don't belong to a track
+}
+
+// Scan compares the tracks in the database with the audio files in storage, fixes which tracks are marked
+// as downloaded and reports audio files that don't belong to any track (they're left alone).
+func (l *Library) Scan(ctx context.Context) (ScanResult, error) {
+	tracks, err := l.queries.ListTrackDownloadStates(ctx)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("list tracks: %w", err)
+	}
+	entries, err := os.ReadDir(l.storagePath)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("read storage directory: %w", err)
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		if trackID, ok := strings.CutSuffix(e.Name(), ".m4a"); ok && e.Type().IsRegular() {
+			files[trackID] = true
+		}
+	}
+
+	result := ScanResult{Checked: len(tracks), MarkedDownloaded: []string{}, MarkedMissing: []string{}, Orphans: []string{}}
+	for _, t := range tracks {
+		switch {
+		case files[t.TrackID] && !t.Downloaded:
+			if err := l.queries.MarkTrackAsDownloaded(ctx, t.TrackID); err != nil {
+				return result, fmt.Errorf("mark track as downloaded: %w", err)
+			}
+			result.MarkedDownloaded = append(result.MarkedDownloaded, t.TrackID)
+		case !files[t.TrackID] && t.Downloaded:
+			if err := l.queries.MarkTrackAsNotDownloaded(ctx, t.TrackID); err != nil {
+				return result, fmt.Errorf("mark track as not downloaded: %w", err)
+			}
+			result.MarkedMissing = append(result.MarkedMissing, t.TrackID)
+		}
+		delete(files, t.TrackID)
+	}
+	for trackID := range files {
+		result.Orphans = append(result.Orphans, l.PathToTrackFile(trackID))
+	}
+	return result, nil
+}
+
+// LibraryStats are the sizes of the whole library (of every user).
+type LibraryStats struct {
+	queries.LibraryCountsRow
+	StorageBytes int64 `json:"storage_bytes"` // of everything in the data directory
+}
+
+// Stats counts what's in the library.
+func (l *Library) Stats(ctx context.Context) (LibraryStats, error) {
+	counts, err := l.queries.LibraryCounts(ctx)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("count library: %w", err)
+	}
+	stats := LibraryStats{LibraryCountsRow: counts}
+	err = filepath.WalkDir(l.storagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			stats.StorageBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("measure storage: %w", err)
+	}
+	return stats, nil
+}
+
+// UserByUsername returns the user with the username.
+func (l *Library) UserByUsername(ctx context.Context, username string) (User, error) {
+	u, err := l.queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, fmt.Errorf("there's no user %q", username)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("get user: %w", err)
+	}
+	return userFromRow(u), nil
+}
@@ -0,0 +1,392 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionDuration is how long a login lasts.
+const SessionDuration = 30 * 24 * time.Hour
+
+// ErrInvalidCredentials is returned when logging in with a wrong username or password, and when a session or
+// api token isn't valid (anymore).
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrSetupDone is returned by Setup once the first user exists.
+var ErrSetupDone = errors.New("setup has already been completed")
+
+// ErrUsernameTaken is returned by CreateUser when there already is a user with the username.
+var ErrUsernameTaken = errors.New("username is taken")
+
+// ErrNoScopes is returned by CreateAPIToken for a token without scopes.
+var ErrNoScopes = errors.New("a token needs at least one scope")
+
+// ErrUnknownScope is wrapped by the error CreateAPIToken returns for a scope that isn't one of Scopes.
+var ErrUnknownScope = errors.New("unknown scope")
+
+// ErrExpiryInPast is returned by CreateAPIToken for a token that would already have expired.
+var ErrExpiryInPast = errors.New("expires_at must be in the future")
+
+// errAPITokenNotFound is returned by RevokeAPIToken for tokens that don't exist, are another user's or are
+// already revoked.
+var errAPITokenNotFound = fmt.Errorf("api token not found (or already revoked): %w", pgx.ErrNoRows)
+
+// User is a user as the api shows it (without the password hash).
+type User struct {
+	ID        pgtype.UUID      `json:"id"`
+	Username  string           `json:"username"`
+	IsAdmin   bool             `json:"is_admin"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+// api token scopes. a token can only use the routes its scopes allow (see the server's requiredScope).
+const (
+	ScopeRead           = "read"            // everything that only reads
+	ScopePlaylistsWrite = "playlists:write" // changes to the user's library: playlists, folders, likes, tags, plays, the session...
+	ScopeDownloads      = "downloads"       // downloading tracks and playlists
+	ScopeAdmin          = "admin"           // api tokens, the password, webhooks and (for admins) managing users
+)
+
+// Scopes are all the api token scopes.
+var Scopes = []string{ScopeRead, ScopePlaylistsWrite, ScopeDownloads, ScopeAdmin}
+
+// APIToken is an api token without the token itself, which is only shown when it's created. revoked tokens
+// are kept so they still show up in the list.
+type APIToken struct {
+	ID         pgtype.UUID      `json:"id"`
+	Name       string           `json:"name"`
+	Scopes     []string         `json:"scopes"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamp `json:"revoked_at"`
+}
+
+// dummyPasswordHash is compared against when the username doesn't exist so a login takes about as long
+// either way.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// NeedsSetup reports whether the first-run setup still has to create the admin account.
+func (l *Library) NeedsSetup(ctx context.Context) (bool, error) {
+	count, err := l.queries.CountUsers(ctx)
+	if err != nil {
+		return false, fmt.Errorf("count users: %w", err)
+	}
+	return count == 0, nil
+}
+
+// Setup creates the admin account. it only works while there are no users. the playlists, plays and
+// playback session from before there were users become the admin's.
+func (l *Library) Setup(ctx context.Context, username, password string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("hash password: %w", err)
+	}
+	var user queries.User
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if err := q.LockUsers(ctx); err != nil {
+			return fmt.Errorf("lock users: %w", err)
+		}
+		count, err := q.CountUsers(ctx)
+		if err != nil {
+			return fmt.Errorf("count users: %w", err)
+		}
+		if count > 0 {
+			return ErrSetupDone
+		}
+		user, err = q.CreateUser(ctx, queries.CreateUserParams{
+			Username:     username,
+			PasswordHash: string(hash),
+			IsAdmin:      true,
+		})
+		if err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+		if err := q.ClaimUnownedData(ctx, user.ID); err != nil {
+			return fmt.Errorf("claim unowned data: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	slog.Info("created admin account", "username", username)
+	return userFromRow(user), nil
+}
+
+// ListUsers returns every user, oldest first.
+func (l *Library) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := l.queries.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	users := make([]User, len(rows))
+	for i, row := range rows {
+		users[i] = User(row)
+	}
+	return users, nil
+}
+
+// CreateUser creates a (non-admin) user. users start out with an empty library but share the downloaded
+// tracks with everyone else.
+func (l *Library) CreateUser(ctx context.Context, username, password string) (User, error) {
+	if _, err := l.queries.GetUserByUsername(ctx, username); err == nil {
+		return User{}, ErrUsernameTaken
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return User{}, fmt.Errorf("get user: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("hash password: %w", err)
+	}
+	user, err := l.queries.CreateUser(ctx, queries.CreateUserParams{
+		Username:     username,
+		PasswordHash: string(hash),
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("create user: %w", err)
+	}
+	slog.Info("created user", "username", username)
+	return userFromRow(user), nil
+}
+
+// DeleteUser deletes the user along with their playlists, plays, ratings, sessions and api tokens. the
+// tracks they downloaded stay in the library.
+func (l *Library) DeleteUser(ctx context.Context, userID string) error {
+	id, err := uuid.Parse(userID) // validate uuid
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
+	}
+	return l.inTx(ctx, func(q *queries.Queries) error {
+		n, err := q.DeleteUser(ctx, optuuid(id))
+		if err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("user not found")
+		}
+		if err := q.DeletePlaybackSession(ctx, id.String()); err != nil {
+			return fmt.Errorf("delete playback session: %w", err)
+		}
+		return nil
+	})
+}
+
+// ChangePassword changes the user's password after checking their current one and logs them out of every
+// session but keepSession (the token of the session the password is changed from, "" for none) so a leaked
+// session doesn't outlive the old password.
+func (l *Library) ChangePassword(ctx context.Context, username, currentPassword, newPassword, keepSession string) error {
+	user, err := l.queries.GetUserByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+		return ErrInvalidCredentials
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	var keep string
+	if keepSession != "" {
+		keep = hashToken(keepSession)
+	}
+	return l.inTx(ctx, func(q *queries.Queries) error {
+		err := q.UpdateUserPassword(ctx, queries.UpdateUserPasswordParams{
+			ID:           user.ID,
+			PasswordHash: string(hash),
+		})
+		if err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+		err = q.DeleteUserSessionsExcept(ctx, queries.DeleteUserSessionsExceptParams{
+			UserID:    user.ID,
+			TokenHash: keep,
+		})
+		if err != nil {
+			return fmt.Errorf("delete other sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+// Login checks the username and password and starts a session. it returns the session token, which the
+// server puts in a cookie.
+func (l *Library) Login(ctx context.Context, username, password string) (string, User, error) {
+	user, err := l.queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return "", User{}, fmt.Errorf("get user: %w", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", User{}, ErrInvalidCredentials
+	}
+
+	if err := l.queries.DeleteExpiredUserSessions(ctx); err != nil {
+		slog.Warn("delete expired sessions", "error", err)
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		return "", User{}, err
+	}
+	err = l.queries.CreateUserSession(ctx, queries.CreateUserSessionParams{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: opttime(time.Now().UTC().Add(SessionDuration)),
+	})
+	if err != nil {
+		return "", User{}, fmt.Errorf("create session: %w", err)
+	}
+	return token, userFromRow(user), nil
+}
+
+// Logout ends the session.
+func (l *Library) Logout(ctx context.Context, sessionToken string) error {
+	if err := l.queries.DeleteUserSession(ctx, hashToken(sessionToken)); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+// UserBySession returns the user the session token belongs to, or ErrInvalidCredentials if the session
+// doesn't exist or has expired.
+func (l *Library) UserBySession(ctx context.Context, sessionToken string) (User, error) {
+	user, err := l.queries.GetUserBySession(ctx, hashToken(sessionToken))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, fmt.Errorf("get session: %w", err)
+	}
+	return userFromRow(user), nil
+}
+
+// CreateAPIToken creates an api token for the user with the scopes. the token expires at expiresAt, or
+// never if it's zero. the token is only returned here, the database just has its hash.
+func (l *Library) CreateAPIToken(ctx context.Context, userID pgtype.UUID, name string, scopes []string, expiresAt time.Time) (string, APIToken, error) {
+	if len(scopes) == 0 {
+		return "", APIToken{}, ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", APIToken{}, fmt.Errorf("%w %q", ErrUnknownScope, scope)
+		}
+	}
+	scopes = slices.Compact(slices.Sorted(slices.Values(scopes)))
+	var expires pgtype.Timestamp
+	if !expiresAt.IsZero() {
+		if !expiresAt.After(time.Now()) {
+			return "", APIToken{}, ErrExpiryInPast
+		}
+		expires = opttime(expiresAt.UTC())
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	row, err := l.queries.CreateAPIToken(ctx, queries.CreateAPITokenParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return "", APIToken{}, fmt.Errorf("create api token: %w", err)
+	}
+	return token, APIToken{ID: row.ID, Name: name, Scopes: scopes, CreatedAt: row.CreatedAt, ExpiresAt: expires}, nil
+}
+
+// ListAPITokens returns the user's api tokens (including expired and revoked ones), newest first.
+func (l *Library) ListAPITokens(ctx context.Context, userID pgtype.UUID) ([]APIToken, error) {
+	rows, err := l.queries.ListAPITokens(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens: %w", err)
+	}
+	tokens := make([]APIToken, len(rows))
+	for i, row := range rows {
+		tokens[i] = APIToken(row)
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes one of the user's api tokens. it can't be used anymore.
+func (l *Library) RevokeAPIToken(ctx context.Context, userID pgtype.UUID, tokenID string) error {
+	id, err := uuid.Parse(tokenID) // validate uuid
+	if err != nil {
+		return errAPITokenNotFound
+	}
+	n, err := l.queries.RevokeAPIToken(ctx, queries.RevokeAPITokenParams{
+		ID:     optuuid(id),
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("revoke api token: %w", err)
+	}
+	if n == 0 {
+		return errAPITokenNotFound
+	}
+	return nil
+}
+
+// UserByAPIToken returns the user the api token belongs to and the token's scopes, or ErrInvalidCredentials
+// if there's no such token or it has expired or been revoked. it also records that the token was used.
+func (l *Library) UserByAPIToken(ctx context.Context, token string) (User, []string, error) {
+	row, err := l.queries.GetUserByAPIToken(ctx, hashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, nil, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, nil, fmt.Errorf("get api token: %w", err)
+	}
+	if err := l.queries.TouchAPIToken(ctx, row.TokenID); err != nil {
+		slog.Warn("record api token use", "error", err)
+	}
+	user := User{
+		ID:        row.ID,
+		Username:  row.Username,
+		IsAdmin:   row.IsAdmin,
+		CreatedAt: row.CreatedAt,
+	}
+	return user, row.Scopes, nil
+}
+
+func userFromRow(u queries.User) User {
+	return User{
+		ID:        u.ID,
+		Username:  u.Username,
+		IsAdmin:   u.IsAdmin,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// newToken generates a random session or api token and returns it along with the hash that's stored.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a token for storage. tokens are random so a plain sha256 is enough (unlike passwords).
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,161 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+func TestSessions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		if needsSetup, err := l.NeedsSetup(ctx); err != nil || !needsSetup {
+			t.Fatalf("needs setup = %v, %v, want true", needsSetup, err)
+		}
+		alice := testUser(t, l, "alice")
+		if needsSetup, err := l.NeedsSetup(ctx); err != nil || needsSetup {
+			t.Errorf("needs setup after the setup = %v, %v, want false", needsSetup, err)
+		}
+		if _, err := l.Setup(ctx, "mallory", "password"); !errors.Is(err, ErrSetupDone) {
+			t.Errorf("second setup: err = %v, want ErrSetupDone", err)
+		}
+		if _, _, err := l.Login(ctx, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login with the wrong password: err = %v, want ErrInvalidCredentials", err)
+		}
+		if _, _, err := l.Login(ctx, "nobody", "password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login of a user that doesn't exist: err = %v, want ErrInvalidCredentials", err)
+		}
+
+		login := func(password string) string {
+			t.Helper()
+			token, user, err := l.Login(ctx, "alice", password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != alice.ID || !user.IsAdmin {
+				t.Fatalf("logged in as %+v, want alice", user)
+			}
+			return token
+		}
+		loggedIn := func(token string) bool {
+			t.Helper()
+			user, err := l.UserBySession(ctx, token)
+			if errors.Is(err, ErrInvalidCredentials) {
+				return false
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != alice.ID {
+				t.Fatalf("session of %+v, want alice", user)
+			}
+			return true
+		}
+
+		first, second, third := login("password"), login("password"), login("password")
+		if !loggedIn(first) || !loggedIn(second) || !loggedIn(third) {
+			t.Fatal("a new session isn't logged in")
+		}
+		if err := l.Logout(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		if loggedIn(first) || !loggedIn(second) {
+			t.Error("logging out didn't end just its session")
+		}
+
+		// changing the password ends every other session
+		if err := l.ChangePassword(ctx, "alice", "wrong", "new password", second); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("change password with the wrong password: err = %v, want ErrInvalidCredentials", err)
+		}
+		if err := l.ChangePassword(ctx, "alice", "password", "new password", second); err != nil {
+			t.Fatal(err)
+		}
+		if !loggedIn(second) || loggedIn(third) {
+			t.Error("changing the password didn't end just the other sessions")
+		}
+		if _, _, err := l.Login(ctx, "alice", "password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login with the old password: err = %v, want ErrInvalidCredentials", err)
+		}
+		login("new password")
+
+		token, hash, err := newToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.queries.CreateUserSession(ctx, queries.CreateUserSessionParams{
+			TokenHash: hash,
+			UserID:    alice.ID,
+			ExpiresAt: opttime(time.Now().UTC().Add(-time.Minute)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loggedIn(token) {
+			t.Error("an expired session is logged in")
+		}
+	})
+}
+
+func TestAPITokens(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		alice := testUser(t, l, "alice")
+		bob := testUser(t, l, "bob")
+
+		if _, _, err := l.CreateAPIToken(ctx, alice.ID, "bad", nil, time.Time{}); !errors.Is(err, ErrNoScopes) {
+			t.Errorf("token without scopes: err = %v, want ErrNoScopes", err)
+		}
+		if _, _, err := l.CreateAPIToken(ctx, alice.ID, "bad", []string{"read", "everything"}, time.Time{}); !errors.Is(err, ErrUnknownScope) {
+			t.Errorf("token with an unknown scope: err = %v, want ErrUnknownScope", err)
+		}
+		if _, _, err := l.CreateAPIToken(ctx, alice.ID, "bad", []string{ScopeRead}, time.Now().Add(-time.Hour)); !errors.Is(err, ErrExpiryInPast) {
+			t.Errorf("token that has expired: err = %v, want ErrExpiryInPast", err)
+		}
+
+		token, created, err := l.CreateAPIToken(ctx, alice.ID, "script", []string{ScopeRead, ScopeDownloads, ScopeRead}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, scopes, err := l.UserByAPIToken(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != alice.ID || fmt.Sprint(scopes) != "[downloads read]" {
+			t.Errorf("token of %s with scopes %v, want alice with [downloads read]", user.Username, scopes)
+		}
+		if _, _, err := l.UserByAPIToken(ctx, token+"x"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("wrong token: err = %v, want ErrInvalidCredentials", err)
+		}
+
+		tokens, err := l.ListAPITokens(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 1 || tokens[0].ID != created.ID || !tokens[0].LastUsedAt.Valid || !tokens[0].ExpiresAt.Valid {
+			t.Errorf("alice's tokens = %+v, want the used one", tokens)
+		}
+		if tokens, err := l.ListAPITokens(ctx, bob.ID); err != nil || len(tokens) != 0 {
+			t.Errorf("bob's tokens = %+v, %v, want none", tokens, err)
+		}
+
+		if err := l.RevokeAPIToken(ctx, bob.ID, created.ID.String()); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("bob revoking alice's token: err = %v, want ErrNoRows", err)
+		}
+		if err := l.RevokeAPIToken(ctx, alice.ID, created.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := l.UserByAPIToken(ctx, token); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("revoked token: err = %v, want ErrInvalidCredentials", err)
+		}
+		if err := l.RevokeAPIToken(ctx, alice.ID, created.ID.String()); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("revoking a token twice: err = %v, want ErrNoRows", err)
+		}
+		if tokens, err := l.ListAPITokens(ctx, alice.ID); err != nil || len(tokens) != 1 || !tokens[0].RevokedAt.Valid {
+			t.Errorf("alice's tokens after revoking = %+v, %v, want the revoked one", tokens, err)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package library
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// a backup is a gzipped tar archive of:
+//   - manifest.json: the backupManifest
+//   - db/<name>: the database, as the files the Store's Backup makes (for postgres the rows of each table as
+//     CSV, for sqlite a copy of the database file)
+//   - files/<path>: the files in the data directory, if the backup includes them
+
+// backupManifest describes a backup.
+type backupManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Backend   string    `json:"backend"` // of the Store, backups from before there was more than one are postgres
+	Files     bool      `json:"files"`
+}
+
+// Backup writes a backup of the database to w, and of the data directory (the downloaded audio, lyrics and
+// stored images) too if withFiles is true.
+func (l *Library) Backup(ctx context.Context, w io.Writer, withFiles bool) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	manifest, err := json.MarshalIndent(backupManifest{CreatedAt: time.Now().UTC(), Backend: l.store.Backend(), Files: withFiles}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := writeTarFile(tw, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	// tar needs the size before the contents, so the store hands over each file in one piece
+	err = l.store.Backup(ctx, func(name string, data []byte) error {
+		return writeTarFile(tw, "db/"+name, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	if withFiles {
+		if err := l.backupFiles(tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("close archive: %w", err)
+	}
+	return nil
+}
+
+// backupFiles adds every regular file in the data directory to the archive under files/.
+func (l *Library) backupFiles(tw *tar.Writer) error {
+	return filepath.WalkDir(l.storagePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.storagePath, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", rel, err)
+		}
+		defer f.Close()
+		err = tw.WriteHeader(&tar.Header{
+			Name:    "files/" + filepath.ToSlash(rel),
+			Mode:    0644,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		if err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("write %s to archive: %w", rel, err)
+		}
+		return nil
+	})
+}
+
+// Restore replaces the contents of the database (and of the data directory, if the backup includes files)
+// with a backup written by Backup. the database has to be migrated first so every table exists. the
+// database is restored in one transaction, so it's left as it was if restoring it fails.
+func (l *Library) Restore(ctx context.Context, r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "manifest.json" {
+		return errors.New("not a backup: the archive doesn't start with a manifest")
+	}
+	var manifest backupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("decode manifest: %w", err)
+	}
+	backend := cmp.Or(manifest.Backend, "postgres")
+	if backend != l.store.Backend() {
+		return fmt.Errorf("the backup is of a %s database and can't be restored into %s", backend, l.store.Backend())
+	}
+
+	// the database files come before the data directory's, which are only written once the database is
+	// restored
+	dbFiles := make(map[string][]byte)
+	restored := false
+	restoreDB := func() error {
+		restored = true
+		return l.store.Restore(ctx, dbFiles)
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+
+		if name, ok := strings.CutPrefix(hdr.Name, "db/"); ok {
+			if restored {
+				return fmt.Errorf("%s comes after the files in the archive", hdr.Name)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", hdr.Name, err)
+			}
+			dbFiles[name] = data
+			continue
+		}
+
+		if rel, ok := strings.CutPrefix(hdr.Name, "files/"); ok {
+			if !restored {
+				if err := restoreDB(); err != nil {
+					return err
+				}
+			}
+			if err := l.restoreFile(rel, hdr, tr); err != nil {
+				return err
+			}
+		}
+	}
+	if !restored {
+		return restoreDB()
+	}
+	return nil
+}
+
+// restoreFile writes a file from the archive into the data directory.
+func (l *Library) restoreFile(rel string, hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return fmt.Errorf("bad file in archive: %s", hdr.Name)
+	}
+	p := filepath.Join(l.storagePath, filepath.FromSlash(path.Clean(rel)))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", rel, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", rel, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", rel, err)
+	}
+	return os.Chtimes(p, hdr.ModTime, hdr.ModTime)
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()})
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
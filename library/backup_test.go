@@ -0,0 +1,66 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		user := testUser(t, l, "alice")
+		testTracks(t, l, "a", "b")
+		kept, err := l.CreatePlaylist(ctx, user.ID, "kept", "in the backup", "https://example.com/a.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"b", "a"} {
+			if err := l.AddTrackToPlaylist(ctx, user.ID, kept, id, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(l.storagePath, "a.lrc"), []byte("la la"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var backup bytes.Buffer
+		if err := l.Backup(ctx, &backup, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// changes after the backup are undone by restoring it
+		if err := l.DeletePlaylist(ctx, user.ID, kept); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.CreatePlaylist(ctx, user.ID, "dropped", "not in the backup", "https://example.com/b.png"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(l.storagePath, "a.lrc")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := l.Restore(ctx, &backup); err != nil {
+			t.Fatal(err)
+		}
+		playlists, err := l.ListPlaylists(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(playlists) != 1 || playlists[0].Name != "kept" {
+			t.Fatalf("playlists after restore = %v, want just kept", playlistNames(playlists))
+		}
+		if got := testPlaylistTrackIDs(t, l, user.ID, kept); fmt.Sprint(got) != "[b a]" {
+			t.Errorf("tracks after restore = %v, want [b a]", got)
+		}
+		if data, err := os.ReadFile(filepath.Join(l.storagePath, "a.lrc")); err != nil || string(data) != "la la" {
+			t.Errorf("a.lrc after restore = %q, %v", data, err)
+		}
+		if _, _, err := l.Login(ctx, "alice", "password"); err != nil {
+			t.Errorf("login after restore: %v", err)
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// FolderTree is a folder with the folders and playlists in it. the root of the tree has no ID.
+type FolderTree struct {
+	ID        pgtype.UUID        `json:"id"`
+	Name      string             `json:"name"`
+	Folders   []*FolderTree      `json:"folders"`
+	Playlists []queries.Playlist `json:"playlists"`
+}
+
+// PlaylistTree returns every folder and playlist of the user as a tree. folders are sorted by name and playlists
+// by when they were created (newest first) like ListPlaylists.
+func (l *Library) PlaylistTree(ctx context.Context, userID pgtype.UUID) (*FolderTree, error) {
+	folders, err := l.queries.ListFolders(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list folders: %w", err)
+	}
+	playlists, err := l.queries.ListPlaylists(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list playlists: %w", err)
+	}
+
+	root := &FolderTree{Folders: []*FolderTree{}, Playlists: []queries.Playlist{}}
+	nodes := make(map[pgtype.UUID]*FolderTree, len(folders))
+	for _, f := range folders {
+		nodes[f.ID] = &FolderTree{ID: f.ID, Name: f.Name, Folders: []*FolderTree{}, Playlists: []queries.Playlist{}}
+	}
+	for _, f := range folders {
+		parent, ok := nodes[f.ParentID]
+		if !ok {
+			parent = root
+		}
+		parent.Folders = append(parent.Folders, nodes[f.ID])
+	}
+	for _, p := range playlists {
+		folder, ok := nodes[p.FolderID]
+		if !ok {
+			folder = root
+		}
+		folder.Playlists = append(folder.Playlists, p)
+	}
+	return root, nil
+}
+
+// find returns the folder with the id in the tree, or nil if it isn't in it.
+func (t *FolderTree) find(id pgtype.UUID) *FolderTree {
+	if t.ID == id {
+		return t
+	}
+	for _, f := range t.Folders {
+		if found := f.find(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// allPlaylists returns the playlists in the folder and all of its subfolders.
+func (t *FolderTree) allPlaylists() []queries.Playlist {
+	if t == nil {
+		return nil
+	}
+	playlists := t.Playlists
+	for _, f := range t.Folders {
+		playlists = append(playlists, f.allPlaylists()...)
+	}
+	return playlists
+}
+
+// CreateFolder creates a folder in the parent folder (or at the root if parentID is "") and returns its ID.
+func (l *Library) CreateFolder(ctx context.Context, userID pgtype.UUID, name, parentID string) (string, error) {
+	parent, err := l.optFolderID(ctx, userID, parentID)
+	if err != nil {
+		return "", err
+	}
+	id, err := l.queries.CreateFolder(ctx, queries.CreateFolderParams{
+		Name:     name,
+		ParentID: parent,
+		UserID:   userID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("create folder: %w", err)
+	}
+	return id.String(), nil
+}
+
+// RenameFolder renames the folder.
+func (l *Library) RenameFolder(ctx context.Context, userID pgtype.UUID, folderID, name string) error {
+	folder, err := l.getFolder(ctx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	return l.queries.RenameFolder(ctx, queries.RenameFolderParams{
+		ID:   folder.ID,
+		Name: name,
+	})
+}
+
+// MoveFolder moves the folder into the parent folder (or to the root if parentID is ""). a folder
+// can't be moved into itself or one of its subfolders.
+func (l *Library) MoveFolder(ctx context.Context, userID pgtype.UUID, folderID, parentID string) error {
+	folder, err := l.getFolder(ctx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	parent, err := l.optFolderID(ctx, userID, parentID)
+	if err != nil {
+		return err
+	}
+	if parent.Valid {
+		within, err := l.queries.FolderIsWithin(ctx, queries.FolderIsWithinParams{
+			FolderID:   parent,
+			AncestorID: folder.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("check folder parent: %w", err)
+		}
+		if within {
+			return fmt.Errorf("a folder can't be moved into itself or one of its subfolders")
+		}
+	}
+	return l.queries.MoveFolder(ctx, queries.MoveFolderParams{
+		ID:       folder.ID,
+		ParentID: parent,
+	})
+}
+
+// DeleteFolder deletes the folder and its subfolders. if keepPlaylists is true the playlists in them are
+// moved to the root, otherwise they are deleted too.
+func (l *Library) DeleteFolder(ctx context.Context, userID pgtype.UUID, folderID string, keepPlaylists bool) error {
+	folder, err := l.getFolder(ctx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	// the playlists are moved or deleted by the database, so which ones they are is found out first for the
+	// webhook events
+	tree, err := l.PlaylistTree(ctx, userID)
+	if err != nil {
+		return err
+	}
+	playlists := tree.find(folder.ID).allPlaylists()
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if keepPlaylists {
+			if err := q.MoveFolderPlaylistsToRoot(ctx, folder.ID); err != nil {
+				return fmt.Errorf("move playlists to root: %w", err)
+			}
+		}
+		if err := q.DeleteFolder(ctx, folder.ID); err != nil {
+			return fmt.Errorf("delete folder: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range playlists {
+		if keepPlaylists {
+			l.emitPlaylistChanged(userID, p, "folder", "")
+		} else {
+			l.emit(userID, EventPlaylistDeleted, playlistEvent{PlaylistID: p.ID, Name: p.Name})
+		}
+	}
+	return nil
+}
+
+// MovePlaylistToFolder moves the playlist into the folder (or to the root if folderID is "").
+func (l *Library) MovePlaylistToFolder(ctx context.Context, userID pgtype.UUID, playlistID, folderID string) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return err
+	}
+	folder, err := l.optFolderID(ctx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	err = l.queries.MovePlaylistToFolder(ctx, queries.MovePlaylistToFolderParams{
+		ID:       playlist.ID,
+		FolderID: folder,
+	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "folder", "")
+	return nil
+}
+
+// getFolder returns the user's folder. other users' folders are not found.
+func (l *Library) getFolder(ctx context.Context, userID pgtype.UUID, folderID string) (queries.PlaylistFolder, error) {
+	id, err := uuid.Parse(folderID) // validate uuid
+	if err != nil {
+		return queries.PlaylistFolder{}, fmt.Errorf("invalid folder id: %w", err)
+	}
+	folder, err := l.queries.GetFolder(ctx, optuuid(id))
+	if err != nil {
+		return queries.PlaylistFolder{}, fmt.Errorf("get folder: %w", err)
+	}
+	if folder.UserID != userID {
+		return queries.PlaylistFolder{}, fmt.Errorf("get folder: %w", pgx.ErrNoRows)
+	}
+	return folder, nil
+}
+
+// optFolderID returns the ID of the folder, or NULL for the root if folderID is "".
+func (l *Library) optFolderID(ctx context.Context, userID pgtype.UUID, folderID string) (pgtype.UUID, error) {
+	if folderID == "" {
+		return pgtype.UUID{}, nil
+	}
+	folder, err := l.getFolder(ctx, userID, folderID)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	return folder.ID, nil
+}
@@ -0,0 +1,101 @@
+package library
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// describe writes the tree as "folder{subfolders playlists}". the playlists are sorted by name, since ones
+// created at once can be in any order.
+func (t *FolderTree) describe() string {
+	var parts []string
+	for _, f := range t.Folders {
+		parts = append(parts, f.Name+"{"+f.describe()+"}")
+	}
+	return strings.Join(append(parts, slices.Sorted(slices.Values(playlistNames(t.Playlists)))...), " ")
+}
+
+func TestFolders(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		user := testUser(t, l, "alice")
+		check := func(want string) {
+			t.Helper()
+			tree, err := l.PlaylistTree(ctx, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tree.describe(); got != want {
+				t.Fatalf("tree = %s, want %s", got, want)
+			}
+		}
+		folder := func(name, parentID string) string {
+			t.Helper()
+			id, err := l.CreateFolder(ctx, user.ID, name, parentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		playlist := func(name, folderID string) string {
+			t.Helper()
+			id, err := l.CreatePlaylist(ctx, user.ID, name, name, "https://example.com/"+name+".png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := l.MovePlaylistToFolder(ctx, user.ID, id, folderID); err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		rock := folder("rock", "")
+		jazz := folder("jazz", "")
+		classic := folder("classic", rock)
+		playlist("loose", "")
+		playlist("riffs", rock)
+		playlist("oldies", classic)
+		smooth := playlist("smooth", jazz)
+		check("jazz{smooth} rock{classic{oldies} riffs} loose")
+
+		if err := l.MoveFolder(ctx, user.ID, rock, classic); err == nil {
+			t.Fatal("moving a folder into its subfolder worked")
+		}
+		if err := l.MoveFolder(ctx, user.ID, rock, rock); err == nil {
+			t.Fatal("moving a folder into itself worked")
+		}
+		if err := l.MoveFolder(ctx, user.ID, classic, jazz); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.RenameFolder(ctx, user.ID, jazz, "mellow"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.MovePlaylistToFolder(ctx, user.ID, smooth, ""); err != nil {
+			t.Fatal(err)
+		}
+		check("mellow{classic{oldies}} rock{riffs} loose smooth")
+
+		// deleting a folder deletes its subfolders, and moves their playlists to the root or deletes them
+		if err := l.DeleteFolder(ctx, user.ID, jazz, true); err != nil {
+			t.Fatal(err)
+		}
+		check("rock{riffs} loose oldies smooth")
+		if err := l.DeleteFolder(ctx, user.ID, rock, false); err != nil {
+			t.Fatal(err)
+		}
+		check("loose oldies smooth")
+
+		// other users can't see or use the folders
+		mine := folder("mine", "")
+		bob := testUser(t, l, "bob")
+		if _, err := l.CreateFolder(ctx, bob.ID, "theirs", mine); err == nil {
+			t.Error("bob created a folder in alice's")
+		}
+		if err := l.DeleteFolder(ctx, bob.ID, mine, false); err == nil {
+			t.Error("bob deleted alice's folder")
+		}
+		check("mine{} loose oldies smooth")
+	})
+}
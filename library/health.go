@@ -0,0 +1,93 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiredkangaroo/music/env"
+)
+
+// toolsCheckInterval is how long the result of checking the tools is reused. spotdl takes a while to start,
+// and health checks can come every few seconds.
+const toolsCheckInterval = time.Minute
+
+// Tool is an external program downloading needs and whether it can be run.
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// toolsCheck is the last result of checking the tools.
+type toolsCheck struct {
+	mx        sync.Mutex
+	tools     []Tool
+	checkedAt time.Time
+}
+
+// Ping checks that the database can be used.
+func (l *Library) Ping(ctx context.Context) error {
+	return l.store.Ping(ctx)
+}
+
+// Tools runs spotdl, yt-dlp and ffmpeg (which they both use) to get their versions. a tool that can't be run
+// has an Error.
+func (l *Library) Tools(ctx context.Context) []Tool {
+	l.toolsCheck.mx.Lock()
+	defer l.toolsCheck.mx.Unlock()
+	if time.Since(l.toolsCheck.checkedAt) < toolsCheckInterval {
+		return l.toolsCheck.tools
+	}
+
+	tools := []struct{ name, path, versionFlag string }{
+		{"spotdl", env.DefaultEnv.PathToSpotDL, "--version"},
+		{"yt-dlp", env.DefaultEnv.PathToYtDL, "--version"},
+		{"ffmpeg", "ffmpeg", "-version"},
+	}
+	results := make([]Tool, len(tools))
+	var wg sync.WaitGroup
+	for i, t := range tools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = Tool{Name: t.name}
+			version, err := toolVersion(ctx, t.name, t.path, t.versionFlag)
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Version = version
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a check cut short by ctx says nothing about the tools, so it isn't reused
+	if ctx.Err() == nil {
+		l.toolsCheck.tools, l.toolsCheck.checkedAt = results, time.Now()
+	}
+	return results
+}
+
+// toolVersion runs a tool with its version flag and returns the version it prints, which is the first line
+// ("4.2.5") or what follows "<name> version" in it ("ffmpeg version 5.1.6-0+deb12u1 Copyright ...").
+func toolVersion(ctx context.Context, name, path, versionFlag string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, path, versionFlag)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("run %s %s: %w", path, versionFlag, err)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+	if v, ok := strings.CutPrefix(line, name+" version "); ok {
+		line, _, _ = strings.Cut(v, " ")
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -2,9 +2,11 @@ package library
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -18,11 +20,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/jackc/pgx/v5/pgxpool"
 	queries "github.com/tiredkangaroo/music/db"
 	"github.com/tiredkangaroo/music/env"
 )
@@ -30,7 +33,7 @@ import (
 // Library represents a music library.
 type Library struct {
 	storagePath string
-	pool        *pgxpool.Pool
+	store       Store
 	queries     *queries.Queries
 
 	spotifyToken *spotifyToken
@@ -41,38 +44,84 @@ type Library struct {
 	ongoingDownloads        *slots
 	maximumOngoingDownloads int
 
+	// running downloads, which Shutdown waits for. once shuttingDown is set no more are started, and abort is
+	// cancelled when Shutdown interrupts the ones left.
+	downloads      sync.WaitGroup
+	downloadsMx    sync.Mutex
+	shuttingDown   bool
+	abort          context.Context
+	abortDownloads context.CancelFunc
+
 	youtubeURLRegexp *regexp.Regexp
+
+	webhookWake chan struct{} // see wakeWebhooks
+
+	radios    *radioStations
+	sessionMx sync.Mutex // serializes playback session updates
+
+	toolsCheck toolsCheck
 }
 
 // Download downloads tracks, albums or playlists specified in things slice. A thing can be a
 // link to a spotify track, album or playlist, or it can be a search query like
-// "Blinding Lights - The Weeknd".
-func (l *Library) Download(ctx context.Context, thing string) error {
+// "Blinding Lights - The Weeknd". userID is the user who started the download, whose webhooks are told
+// how it went.
+func (l *Library) Download(ctx context.Context, userID pgtype.UUID, thing string) error {
 	slog.Info("starting download", "thing", thing)
+	if err := l.beginDownload(); err != nil {
+		return err
+	}
+	defer l.downloads.Done()
 
 	if !l.dlNoDuplicate.Add(thing) { // make sure we only do the preDownload() -> download() flow once
 		slog.Info("download already in progress, skipping", "thing", thing)
-		return l.dlNoDuplicate.Wait(thing)
+		err := l.dlNoDuplicate.Wait(thing)
+		l.emitDownload(userID, thing, "", err)
+		return err
 	}
 	slog.Info("acquired download lock", "thing", thing)
+	start := time.Now()
 
 	trackID, youtubeURL, err := l.preDownload(thing)
 	if err != nil {
 		slog.Info("pre-download failed (releasing lock)", "thing", thing, "error", err)
+		observeDownload(ctx, start, err)
+		l.recordDownloadResult(thing, err)
+		l.emitDownload(userID, thing, "", err)
 		l.dlNoDuplicate.Remove(thing, err)
 		return err
 	}
 	slog.Info("pre-download completed", "thing", thing, "track_id", trackID, "youtube_url", youtubeURL)
 	err = l.download(ctx, trackID, youtubeURL)
 	slog.Info("download completed (releasing lock)", "thing", thing, "error", err)
+	observeDownload(ctx, start, err)
+	l.recordDownloadResult(thing, err)
+	l.emitDownload(userID, thing, trackID, err)
 	l.dlNoDuplicate.Remove(thing, err)
 	return err
 }
 
-func (l *Library) DownloadPlaylist(ctx context.Context, playlistID string) (int, chan error, error) {
+// recordDownloadResult adds the download to the failed downloads if it failed and removes it if it worked.
+// it uses a background context since the download's context may be what made it fail.
+func (l *Library) recordDownloadResult(thing string, downloadErr error) {
+	var err error
+	if downloadErr != nil {
+		err = l.queries.RecordFailedDownload(context.Background(), queries.RecordFailedDownloadParams{
+			Thing: thing,
+			Error: downloadErr.Error(),
+		})
+	} else {
+		err = l.queries.DeleteFailedDownload(context.Background(), thing)
+	}
+	if err != nil {
+		slog.Error("record download result", "thing", thing, "error", err)
+	}
+}
+
+func (l *Library) DownloadPlaylist(ctx context.Context, userID pgtype.UUID, playlistID string) (int, chan error, error) {
 	// gets all the tracks from the playlist not downloaded
 	// then downloads them all
-	tracks, err := l.queries.GetPlaylistTracksNotDownloaded(ctx, optuuid(uuid.MustParse(playlistID)))
+	tracks, err := l.playlistTracksNotDownloaded(ctx, userID, playlistID)
 	if err != nil {
 		return 0, nil, fmt.Errorf("get playlist: %w", err)
 	}
@@ -84,12 +133,10 @@ func (l *Library) DownloadPlaylist(ctx context.Context, playlistID string) (int,
 		var wg sync.WaitGroup
 		wg.Add(len(tracks))
 
-		for _, track := range tracks {
-			trackID := track.TrackID
-
+		for _, trackID := range tracks {
 			go func(trackID string) {
 				defer wg.Done()
-				err := l.Download(ctx, "https://open.spotify.com/track/"+trackID)
+				err := l.Download(ctx, userID, "https://open.spotify.com/track/"+trackID)
 				errs <- err
 			}(trackID)
 		}
@@ -101,6 +148,41 @@ func (l *Library) DownloadPlaylist(ctx context.Context, playlistID string) (int,
 	return len(tracks), errs, nil
 }
 
+// playlistTracksNotDownloaded returns the IDs of the tracks in the playlist that are not downloaded yet.
+func (l *Library) playlistTracksNotDownloaded(ctx context.Context, userID pgtype.UUID, playlistID string) ([]string, error) {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	var trackIDs []string
+	if playlist.Rules != nil {
+		var rules SmartRules
+		if err := json.Unmarshal(playlist.Rules, &rules); err != nil {
+			return nil, fmt.Errorf("decode smart playlist rules: %w", err)
+		}
+		tracks, err := l.evaluateSmartRules(ctx, playlist.UserID, rules)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tracks {
+			if !t.Downloaded {
+				trackIDs = append(trackIDs, t.TrackID)
+			}
+		}
+		return trackIDs, nil
+	}
+
+	tracks, err := l.queries.GetPlaylistTracksNotDownloaded(ctx, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		trackIDs = append(trackIDs, t.TrackID)
+	}
+	return trackIDs, nil
+}
+
 // CreateSkeletonTrack creates a skeleton track entry with the track ID (to avoid fkey errors
 // when adding to playlists) but with no metadata. the metadata can be filled in later bc the insert
 // track is upsert.
@@ -114,27 +196,43 @@ func (l *Library) createSkeletonTrack(ctx context.Context, trackID string) error
 }
 
 func (l *Library) download(ctx context.Context, trackID, youtubeURL string) error {
+	ctx, cancel := l.abortable(ctx)
+	defer cancel()
+
 	// acquire a slot to perform downloading
-	l.ongoingDownloads.Acquire()
+	downloadsQueued.Inc()
+	err := l.ongoingDownloads.Acquire(ctx)
+	downloadsQueued.Dec()
+	if err != nil {
+		return err
+	}
 	defer l.ongoingDownloads.Release()
+	downloadSlotsInUse.Inc()
+	defer downloadSlotsInUse.Dec()
 
-	// download audio using yt-dlp
+	// download audio using yt-dlp (the rest of the arguments are configurable, see env.Environment.YtDLPArgs)
+	output := filepath.Join(l.storagePath, fmt.Sprintf("%s.m4a", trackID))
 	ydlArgs := []string{
 		"-x",
 		"--audio-format", "m4a",
-		"--output", filepath.Join(l.storagePath, fmt.Sprintf("%s.m4a", trackID)),
-		"--extractor-args", "youtube:player_client=default,ios,-android_sdkless;formats=missing_pot",
-		"--format", "bv[protocol=m3u8_native]+ba[protocol=m3u8_native]/b[protocol=m3u8_native]",
+		"--output", output,
 	}
+	ydlArgs = append(ydlArgs, env.DefaultEnv.YtDLPArgs...)
 	ydlArgs = append(ydlArgs, youtubeURL)
 
 	ydlLogs := new(bytes.Buffer)
-	ydlCmd := exec.CommandContext(ctx, "yt-dlp", ydlArgs...)
+	ydlCmd := exec.CommandContext(ctx, env.DefaultEnv.PathToYtDL, ydlArgs...)
 	ydlCmd.Stdout = ydlLogs
 	ydlCmd.Stderr = ydlLogs
 	ydlCmd.Dir = l.storagePath
+	ydlCmd.WaitDelay = abortWaitDelay
 
 	if err := ydlCmd.Run(); err != nil {
+		if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+			// the file may be the video yt-dlp hadn't extracted the audio from yet
+			os.Remove(output)
+			return ErrShuttingDown
+		}
 		if strings.Contains(ydlLogs.String(), "This video is age-restricted") {
 			err = fmt.Errorf("track is age-restricted")
 			return err
@@ -158,19 +256,31 @@ func (l *Library) download(ctx context.Context, trackID, youtubeURL string) erro
 }
 
 // downloadIfNotExists checks if the track with the specified ID exists in storage,
-// and downloads it if it is missing.
-func (l *Library) downloadIfNotExists(ctx context.Context, trackID, youtubeURL string) error {
+// and downloads it if it is missing. userID is the user who started the download.
+func (l *Library) downloadIfNotExists(ctx context.Context, userID pgtype.UUID, trackID, youtubeURL string) error {
+	if err := l.beginDownload(); err != nil {
+		// it's not tracked by Download, so it's recorded here to be retried
+		l.recordDownloadResult("https://open.spotify.com/track/"+trackID, err)
+		return err
+	}
+	defer l.downloads.Done()
+
 	p := filepath.Join(l.storagePath, filepath.Clean(trackID)+".m4a")
 	if _, err := os.Stat(p); os.IsNotExist(err) {
-		for range 3 { // try downloading 3 times
-			if err := l.download(ctx, trackID, youtubeURL); err != nil {
-				if strings.Contains(err.Error(), "age-restricted") {
-					return err
-				}
-			} else {
-				return nil
+		start := time.Now()
+		for range env.DefaultEnv.DownloadAttempts {
+			err = l.download(ctx, trackID, youtubeURL)
+			if err == nil || errors.Is(err, ErrShuttingDown) || strings.Contains(err.Error(), "age-restricted") {
+				break
 			}
 		}
+		observeDownload(ctx, start, err)
+		thing := "https://open.spotify.com/track/" + trackID
+		// it's not tracked by Download either, so failures land in the failed downloads
+		// to be retried and a success clears an earlier failure
+		l.recordDownloadResult(thing, err)
+		l.emitDownload(userID, thing, trackID, err)
+		return err
 	} else {
 		slog.Info("track already exists, skipping download", "track_id", trackID)
 		l.queries.MarkTrackAsDownloaded(ctx, trackID)
@@ -208,12 +318,16 @@ func (l *Library) preDownload(thing string) (string, string, error) {
 	args = append(args, "--client-secret", env.DefaultEnv.SpotifyClientSecret)
 
 	logs := new(bytes.Buffer)
-	cmd := exec.Command(env.DefaultEnv.PathToSpotDL, args...)
+	cmd := exec.CommandContext(l.abort, env.DefaultEnv.PathToSpotDL, args...)
 	cmd.Stdout = logs
 	cmd.Stderr = logs
 	cmd.Dir = l.storagePath
+	cmd.WaitDelay = abortWaitDelay
 
 	if err := cmd.Run(); err != nil {
+		if l.abort.Err() != nil {
+			return "", "", ErrShuttingDown
+		}
 		return "", "", fmt.Errorf("spotdl command failed: %w\nlogs: %s", err, logs.String())
 	}
 	ytURLmatches := l.youtubeURLRegexp.FindAllString(logs.String(), -1)
@@ -226,7 +340,10 @@ func (l *Library) preDownload(thing string) (string, string, error) {
 
 	args[0] = "save"
 	args = append(args, "--lyrics", "synced", "--generate-lrc")
-	if err := exec.Command(env.DefaultEnv.PathToSpotDL, args...).Run(); err != nil {
+	if err := exec.CommandContext(l.abort, env.DefaultEnv.PathToSpotDL, args...).Run(); err != nil {
+		if l.abort.Err() != nil {
+			return "", "", ErrShuttingDown
+		}
 		return "", "", fmt.Errorf("spotdl save command failed: %w", err)
 	}
 
@@ -288,21 +405,24 @@ func (l *Library) preDownload(thing string) (string, string, error) {
 	if err != nil {
 		return "", "", fmt.Errorf("insert track: %w", err)
 	}
+	if err := l.updateArtistGenres(context.Background(), m.ArtistID); err != nil {
+		slog.Warn("update artist genres", "error", err, "artist_id", m.ArtistID)
+	}
 	return m.ID, youtubeURL, nil
 }
 
 // DownloadIfNotExists checks if the track with the specified ID exists in storage,
 // and downloads it if it is missing. It is intended to be slightly cheaper than Download
 // because it does not run spotdl and does not update the database and extract metadata if
-// the track already exists.
-func (l *Library) DownloadIfNotExists(ctx context.Context, trackIDs ...string) error {
+// the track already exists. userID is the user who started the download, as for Download.
+func (l *Library) DownloadIfNotExists(ctx context.Context, userID pgtype.UUID, trackIDs ...string) error {
 	// using ctx.Background() in this function since download if not exists is called
 	// in a goroutine and we shouldn't pass down a request context to pass down
 
 	for i, trackID := range trackIDs {
 		p := filepath.Join(l.storagePath, filepath.Clean(trackID)+".m4a")
 		if _, err := os.Stat(p); os.IsNotExist(err) {
-			if err := l.Download(ctx, "https://open.spotify.com/track/"+trackID); err != nil {
+			if err := l.Download(ctx, userID, "https://open.spotify.com/track/"+trackID); err != nil {
 				slog.Error("download track", "error", err, "track_id", trackID, "index", i, "total", len(trackIDs))
 				return err
 			}
@@ -316,75 +436,279 @@ func (l *Library) DownloadIfNotExists(ctx context.Context, trackIDs ...string) e
 	return nil
 }
 
-// ListPlaylists returns all playlists in the library.
-func (l *Library) ListPlaylists(ctx context.Context) ([]queries.Playlist, error) {
-	return l.queries.ListPlaylists(ctx)
+// ListPlaylists returns all of the user's playlists.
+func (l *Library) ListPlaylists(ctx context.Context, userID pgtype.UUID) ([]queries.Playlist, error) {
+	return l.queries.ListPlaylists(ctx, userID)
 }
 
-// CreatePlaylist creates a new playlist with the specified name and returns its ID and any error if encountered.
-func (l *Library) CreatePlaylist(ctx context.Context, name string, description string, imageURL string) (string, error) {
-	exists, err := l.queries.PlaylistWithNameExists(ctx, name)
+// CreatePlaylist creates a new playlist for the user with the specified name and returns its ID and any
+// error if encountered. playlist names are unique per user.
+func (l *Library) CreatePlaylist(ctx context.Context, userID pgtype.UUID, name string, description string, imageURL string) (string, error) {
+	id, err := createPlaylist(ctx, l.queries, userID, name, description, imageURL)
 	if err != nil {
-		return "", fmt.Errorf("check if playlist with name exists: %w", err)
+		return "", err
+	}
+	l.emit(userID, EventPlaylistCreated, playlistEvent{PlaylistID: id, Name: name})
+	return id.String(), nil
+}
+
+// createPlaylist creates a playlist with q, which can be a transaction's, if the user has none with the name.
+func createPlaylist(ctx context.Context, q *queries.Queries, userID pgtype.UUID, name, description, imageURL string) (pgtype.UUID, error) {
+	exists, err := q.PlaylistWithNameExists(ctx, queries.PlaylistWithNameExistsParams{
+		Name:   name,
+		UserID: userID,
+	})
+	if err != nil {
+		return pgtype.UUID{}, fmt.Errorf("check if playlist with name exists: %w", err)
 	}
 	if exists {
-		return "", fmt.Errorf("playlist names must be unique")
+		return pgtype.UUID{}, fmt.Errorf("playlist names must be unique")
 	}
-	id, err := l.queries.CreatePlaylist(ctx, queries.CreatePlaylistParams{
+	return q.CreatePlaylist(ctx, queries.CreatePlaylistParams{
 		Name:        name,
 		Description: description,
 		ImageUrl:    imageURL,
+		UserID:      userID,
 	})
-	return id.String(), err
 }
 
-// DeletePlaylist deletes the playlist with the specified ID.
-func (l *Library) DeletePlaylist(ctx context.Context, playlistID string) error {
+// UpdatePlaylist updates the name, description and image of the playlist. if the image was replaced and
+// no other playlist uses the old image, the old image URL is returned so it can be removed from storage.
+func (l *Library) UpdatePlaylist(ctx context.Context, userID pgtype.UUID, playlistID, name, description, imageURL string) (string, error) {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return "", err
+	}
+	if name != playlist.Name {
+		exists, err := l.queries.PlaylistWithNameExists(ctx, queries.PlaylistWithNameExistsParams{
+			Name:   name,
+			UserID: userID,
+		})
+		if err != nil {
+			return "", fmt.Errorf("check if playlist with name exists: %w", err)
+		}
+		if exists {
+			return "", fmt.Errorf("playlist names must be unique")
+		}
+	}
+	err = l.queries.UpdatePlaylist(ctx, queries.UpdatePlaylistParams{
+		ID:          playlist.ID,
+		Name:        name,
+		Description: description,
+		ImageUrl:    imageURL,
+	})
+	if err != nil {
+		return "", fmt.Errorf("update playlist: %w", err)
+	}
+	playlist.Name = name
+	l.emitPlaylistChanged(userID, playlist, "details", "")
+
+	if imageURL == playlist.ImageUrl {
+		return "", nil
+	}
+	inUse, err := l.queries.PlaylistImageInUse(ctx, playlist.ImageUrl)
+	if err != nil {
+		return "", fmt.Errorf("check if image is in use: %w", err)
+	}
+	if inUse {
+		return "", nil
+	}
+	return playlist.ImageUrl, nil
+}
+
+// GetPlaylistByID returns the user's playlist without its tracks. other users' playlists are not found.
+func (l *Library) GetPlaylistByID(ctx context.Context, userID pgtype.UUID, playlistID string) (queries.Playlist, error) {
 	id, err := uuid.Parse(playlistID) // validate uuid
 	if err != nil {
-		return fmt.Errorf("invalid playlist id: %w", err)
+		return queries.Playlist{}, fmt.Errorf("invalid playlist id: %w", err)
+	}
+	playlist, err := l.queries.GetPlaylistByID(ctx, optuuid(id))
+	if err != nil {
+		return queries.Playlist{}, fmt.Errorf("get playlist: %w", err)
+	}
+	if playlist.UserID != userID {
+		return queries.Playlist{}, errPlaylistNotFound
+	}
+	return playlist, nil
+}
+
+// errPlaylistNotFound is returned for playlists of other users, the same as for ones that don't exist.
+var errPlaylistNotFound = fmt.Errorf("get playlist: %w", pgx.ErrNoRows)
+
+// DeletePlaylist deletes the playlist with the specified ID.
+func (l *Library) DeletePlaylist(ctx context.Context, userID pgtype.UUID, playlistID string) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return err
 	}
-	return l.queries.DeletePlaylist(ctx, optuuid(id))
+	if err := l.queries.DeletePlaylist(ctx, playlist.ID); err != nil {
+		return err
+	}
+	l.emit(userID, EventPlaylistDeleted, playlistEvent{PlaylistID: playlist.ID, Name: playlist.Name})
+	return nil
 }
 
-// GetPlaylist returns information about the specified playlist including its tracks.
-func (l *Library) GetPlaylist(ctx context.Context, playlistID string) (queries.GetPlaylistRow, error) {
+// GetPlaylist returns information about the specified playlist including its tracks. the tracks of
+// a smart playlist are evaluated from its rules every time it is read (and sorted by the rules, so sort
+// only applies to regular playlists). see PlaylistSorts for the values of sort, "" is playlist order.
+func (l *Library) GetPlaylist(ctx context.Context, userID pgtype.UUID, playlistID, sort string, descending bool) (queries.GetPlaylistRow, error) {
 	id, err := uuid.Parse(playlistID) // validate uuid
 	if err != nil {
 		return queries.GetPlaylistRow{}, fmt.Errorf("invalid playlist id: %w", err)
 	}
-	return l.queries.GetPlaylist(ctx, optuuid(id))
+	if sort != "" && !slices.Contains(PlaylistSorts, sort) {
+		return queries.GetPlaylistRow{}, fmt.Errorf("unknown sort %q", sort)
+	}
+	playlist, err := l.queries.GetPlaylist(ctx, queries.GetPlaylistParams{
+		ID:         optuuid(id),
+		Sort:       sort,
+		Descending: descending,
+	})
+	if err != nil {
+		return queries.GetPlaylistRow{}, err
+	}
+	if playlist.UserID != userID {
+		return queries.GetPlaylistRow{}, errPlaylistNotFound
+	}
+	if playlist.Rules == nil {
+		return playlist, nil
+	}
+
+	var rules SmartRules
+	if err := json.Unmarshal(playlist.Rules, &rules); err != nil {
+		return queries.GetPlaylistRow{}, fmt.Errorf("decode smart playlist rules: %w", err)
+	}
+	tracks, err := l.evaluateSmartRules(ctx, userID, rules)
+	if err != nil {
+		return queries.GetPlaylistRow{}, err
+	}
+	playlist.Tracks = tracks
+	return playlist, nil
 }
 
-// AddTrackToPlaylist adds the specified track to the specified playlist.
-func (l *Library) AddTrackToPlaylist(ctx context.Context, playlistID, trackID string) error {
-	pid, err := uuid.Parse(playlistID) // validate uuid
+// AddTrackToPlaylist adds the specified track to the specified playlist at position, or at the end if
+// position is nil.
+func (l *Library) AddTrackToPlaylist(ctx context.Context, userID pgtype.UUID, playlistID, trackID string, position *int) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
 	if err != nil {
-		return fmt.Errorf("invalid playlist id: %w", err)
+		return err
 	}
-	go l.DownloadIfNotExists(context.TODO(), trackID) // best effort download, ignore error, work in a seperate goroutine
-	return l.queries.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
-		PlaylistID: optuuid(pid),
-		TrackID:    trackID,
+	if playlist.Rules != nil {
+		return fmt.Errorf("tracks can't be added to a smart playlist")
+	}
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if _, err := q.LockPlaylist(ctx, playlist.ID); err != nil {
+			return fmt.Errorf("lock playlist: %w", err)
+		}
+		count, err := q.CountPlaylistTracks(ctx, playlist.ID)
+		if err != nil {
+			return fmt.Errorf("count playlist tracks: %w", err)
+		}
+		pos := int32(count)
+		if position != nil {
+			if *position < 0 || int64(*position) > count {
+				return fmt.Errorf("position must be between 0 and %d", count)
+			}
+			pos = int32(*position)
+			err = q.ShiftPlaylistTracks(ctx, queries.ShiftPlaylistTracksParams{
+				PlaylistID: playlist.ID,
+				Position:   pos,
+			})
+			if err != nil {
+				return fmt.Errorf("shift playlist tracks: %w", err)
+			}
+		}
+		return q.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
+			PlaylistID: playlist.ID,
+			TrackID:    trackID,
+			Position:   pos,
+		})
 	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "track_added", trackID)
+	l.downloadInBackground(userID, trackID)
+	return nil
 }
 
 // RemoveTrackFromPlaylist removes the specified track from the specified playlist.
-func (l *Library) RemoveTrackFromPlaylist(ctx context.Context, playlistID, trackID string) error {
-	pid, err := uuid.Parse(playlistID) // validate uuid
+func (l *Library) RemoveTrackFromPlaylist(ctx context.Context, userID pgtype.UUID, playlistID, trackID string) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return err
+	}
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if _, err := q.LockPlaylist(ctx, playlist.ID); err != nil {
+			return fmt.Errorf("lock playlist: %w", err)
+		}
+		err := q.RemoveTrackFromPlaylist(ctx, queries.RemoveTrackFromPlaylistParams{
+			PlaylistID: playlist.ID,
+			TrackID:    trackID,
+		})
+		if err != nil {
+			return err
+		}
+		return q.RenumberPlaylistTracks(ctx, playlist.ID)
+	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "track_removed", trackID)
+	return nil
+}
+
+// SetPlaylistTracks replaces the tracks of the specified playlist with trackIDs, in that order. it's done in one
+// transaction, so the playlist is never left half replaced.
+func (l *Library) SetPlaylistTracks(ctx context.Context, userID pgtype.UUID, playlistID string, trackIDs []string) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
 	if err != nil {
-		return fmt.Errorf("invalid playlist id: %w", err)
+		return err
 	}
-	return l.queries.RemoveTrackFromPlaylist(ctx, queries.RemoveTrackFromPlaylistParams{
-		PlaylistID: optuuid(pid),
-		TrackID:    trackID,
+	if playlist.Rules != nil {
+		return fmt.Errorf("the tracks of a smart playlist can't be replaced")
+	}
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if _, err := q.LockPlaylist(ctx, playlist.ID); err != nil {
+			return fmt.Errorf("lock playlist: %w", err)
+		}
+		old, err := q.ListPlaylistTrackIDs(ctx, playlist.ID)
+		if err != nil {
+			return fmt.Errorf("list playlist tracks: %w", err)
+		}
+		for _, trackID := range old {
+			err := q.RemoveTrackFromPlaylist(ctx, queries.RemoveTrackFromPlaylistParams{
+				PlaylistID: playlist.ID,
+				TrackID:    trackID,
+			})
+			if err != nil {
+				return fmt.Errorf("remove track: %w", err)
+			}
+		}
+		for i, trackID := range trackIDs {
+			err := q.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
+				PlaylistID: playlist.ID,
+				TrackID:    trackID,
+				Position:   int32(i),
+			})
+			if err != nil {
+				return fmt.Errorf("add track %s: %w", trackID, err)
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "tracks_replaced", "")
+	l.downloadInBackground(userID, trackIDs...)
+	return nil
 }
 
-// Play returns a ReadCloser for the audio file for the specified track.
+// Play opens the audio file for the specified track, which the caller has to close.
 // It does NOT record the play in the database. If the file is not found in storage,
-// it downloads the track before returning the ReadCloser.
-func (l *Library) Play(ctx context.Context, trackID string) (io.ReadCloser, error) {
+// it downloads the track for the user before opening it.
+func (l *Library) Play(ctx context.Context, userID pgtype.UUID, trackID string) (*os.File, error) {
 	// where older tracks may be deleted from storage for space management
 	// but their metadata remains in the database so it can be played
 	// the file should be re-downloaded if not found
@@ -401,7 +725,7 @@ func (l *Library) Play(ctx context.Context, trackID string) (io.ReadCloser, erro
 	}
 
 	// file not found, re-download
-	if err := l.Download(ctx, "https://open.spotify.com/track/"+trackID); err != nil {
+	if err := l.Download(ctx, userID, "https://open.spotify.com/track/"+trackID); err != nil {
 		slog.Error("download track", "error", err, "track_id", trackID)
 		return nil, err
 	}
@@ -412,24 +736,35 @@ func (l *Library) PathToTrackFile(trackID string) string {
 	return filepath.Join(l.storagePath, filepath.Clean(trackID)+".m4a")
 }
 
-// RecordPlay records a play of the specified track in the database.
-func (l *Library) RecordPlay(ctx context.Context, trackID string) (string, error) {
+// RecordPlay records a play of the specified track by the user in the database. plays are stored in UTC
+// so statistics can be computed in any time zone.
+func (l *Library) RecordPlay(ctx context.Context, userID pgtype.UUID, trackID string) (string, error) {
 	id, err := l.queries.RecordPlay(ctx, queries.RecordPlayParams{
 		TrackID:  trackID,
-		PlayedAt: opttime(time.Now()),
+		PlayedAt: opttime(time.Now().UTC()),
+		UserID:   userID,
 	})
-	return id.String(), err
+	if err != nil {
+		return "", err
+	}
+	l.emit(userID, EventTrackPlayed, map[string]string{"play_id": id.String(), "track_id": trackID})
+	return id.String(), nil
 }
 
-// RecordSkip records that the specified track was skipped at the given second.
-func (l *Library) RecordSkip(ctx context.Context, playID string, skippedAt int32) error {
+// RecordSkip records that the specified track was skipped at the given second. the play must be the user's.
+func (l *Library) RecordSkip(ctx context.Context, userID pgtype.UUID, playID string, skippedAt int32) error {
+	id, err := uuid.Parse(playID) // validate uuid
+	if err != nil {
+		return fmt.Errorf("invalid play id: %w", err)
+	}
 	return l.queries.RecordSkip(ctx, queries.RecordSkipParams{
-		PlayID:    optuuid(uuid.MustParse(playID)),
+		PlayID:    optuuid(id),
 		SkippedAt: optint32(skippedAt),
+		UserID:    userID,
 	})
 }
 
-func (l *Library) Search(ctx context.Context, query string) ([]queries.SearchTrackByNameRow, error) {
+func (l *Library) Search(ctx context.Context, userID pgtype.UUID, query string) ([]queries.SearchTrackByNameRow, error) {
 	// var t time.Time
 	// if env.DefaultEnv.Debug {
 	// 	t = time.Now()
@@ -441,7 +776,8 @@ func (l *Library) Search(ctx context.Context, query string) ([]queries.SearchTra
 	tracks, err := l.queries.SearchTrackByName(ctx, queries.SearchTrackByNameParams{
 		Column1: optstring(query),
 		Offset:  0,
-		Limit:   75,
+		Limit:   int32(env.DefaultEnv.SearchLimit),
+		UserID:  userID,
 	})
 	if err != nil {
 		slog.Error("search in db", "error", err)
@@ -449,7 +785,7 @@ func (l *Library) Search(ctx context.Context, query string) ([]queries.SearchTra
 	}
 	results := tracks
 
-	if len(results) >= 75 {
+	if len(results) >= env.DefaultEnv.SearchLimit {
 		results = reorderSearchResults(results, query)
 		// slog.Debug("search found enough results in database", "query", query, "count", len(results))
 		return results, nil
@@ -459,7 +795,7 @@ func (l *Library) Search(ctx context.Context, query string) ([]queries.SearchTra
 	q := u.Query()
 	q.Add("q", query)
 	q.Add("type", "track")
-	q.Add("limit", "50")
+	q.Add("limit", strconv.Itoa(env.DefaultEnv.SpotifySearchLimit))
 	q.Add("offset", "0")
 	u.RawQuery = q.Encode()
 
@@ -473,7 +809,7 @@ func (l *Library) Search(ctx context.Context, query string) ([]queries.SearchTra
 	}
 	req.Header.Set("Authorization", "Bearer "+tk)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doSpotifyRequest(req, "search")
 	if err != nil {
 		return nil, fmt.Errorf("perform search request: %w", err)
 	}
@@ -569,6 +905,11 @@ func reorderSearchResults(results []queries.SearchTrackByNameRow, query string)
 			return 1
 		}
 
+		// tracks the user likes or rated highly
+		if c := cmp.Compare(searchPreference(b), searchPreference(a)); c != 0 {
+			return c
+		}
+
 		// popularity
 		if a.Popularity != b.Popularity {
 			return int(b.Popularity - a.Popularity)
@@ -592,9 +933,9 @@ func reorderSearchResults(results []queries.SearchTrackByNameRow, query string)
 	return results
 }
 
-// Import imports a playlist from Spotify given its playlist ID. It returns the created playlist ID in the local library
-// as well as any error encountered.
-func (l *Library) Import(ctx context.Context, spotifyPlaylistID string) (queries.Playlist, error) {
+// Import imports a playlist from Spotify given its playlist ID into the user's library. It returns the created
+// playlist as well as any error encountered.
+func (l *Library) Import(ctx context.Context, userID pgtype.UUID, spotifyPlaylistID string) (queries.Playlist, error) {
 	// we need two things: get playlist name, description, cover image
 	// and get playlist tracks
 
@@ -611,7 +952,7 @@ func (l *Library) Import(ctx context.Context, spotifyPlaylistID string) (queries
 		return queries.Playlist{}, fmt.Errorf("create get playlist request: %w", err)
 	}
 	playlistReq.Header.Set("Authorization", "Bearer "+tk)
-	playlistResp, err := http.DefaultClient.Do(playlistReq)
+	playlistResp, err := doSpotifyRequest(playlistReq, "playlist")
 	if err != nil {
 		return queries.Playlist{}, fmt.Errorf("perform get playlist request: %w", err)
 	}
@@ -649,7 +990,7 @@ func (l *Library) Import(ctx context.Context, spotifyPlaylistID string) (queries
 			return queries.Playlist{}, fmt.Errorf("create playlist tracks request: %w", err)
 		}
 		tracksReq.Header.Set("Authorization", "Bearer "+tk)
-		tracksResp, err := http.DefaultClient.Do(tracksReq)
+		tracksResp, err := doSpotifyRequest(tracksReq, "playlist_tracks")
 		if err != nil {
 			return queries.Playlist{}, fmt.Errorf("perform playlist tracks request: %w", err)
 		}
@@ -680,15 +1021,16 @@ func (l *Library) Import(ctx context.Context, spotifyPlaylistID string) (queries
 		offset += len(tracksData.Items) // next page
 	}
 
-	playlistID, err := l.CreatePlaylist(ctx, playlistData.Name, playlistData.Description, coverURL)
+	playlistID, err := l.CreatePlaylist(ctx, userID, playlistData.Name, playlistData.Description, coverURL)
 	if err != nil {
 		return queries.Playlist{}, fmt.Errorf("create playlist in library: %w", err)
 	}
 
 	wg := sync.WaitGroup{}
-	for _, ctrack := range tracks {
+	var failed atomic.Int32
+	for i, ctrack := range tracks {
 		wg.Add(1)
-		go func(track queries.InsertTrackParams) {
+		go func(track queries.InsertTrackParams, position int32) {
 			defer wg.Done()
 			// avoid fkey errors by doing predownload which also inserts the track metadata
 			trackURL := "https://open.spotify.com/track/" + track.TrackID
@@ -696,24 +1038,43 @@ func (l *Library) Import(ctx context.Context, spotifyPlaylistID string) (queries
 			_, youtube_url, err := l.preDownload(trackURL)
 			if err != nil {
 				slog.Warn("pre-download track for imported playlist", "error", err, "track_id", track.TrackID)
+				failed.Add(1)
 				return
 			}
 
-			go l.downloadIfNotExists(context.Background(), track.TrackID, youtube_url)
+			go l.downloadIfNotExists(context.Background(), userID, track.TrackID, youtube_url)
 			err = l.queries.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
 				PlaylistID: optuuid(uuid.MustParse(playlistID)),
 				TrackID:    track.TrackID,
+				Position:   position,
 			})
-		}(ctrack)
+			if err != nil {
+				slog.Warn("add track to imported playlist", "error", err, "track_id", track.TrackID)
+				failed.Add(1)
+			}
+		}(ctrack, int32(i))
 	}
 	wg.Wait()
 
+	// tracks that failed to import leave gaps in the positions
+	if err := l.queries.RenumberPlaylistTracks(ctx, optuuid(uuid.MustParse(playlistID))); err != nil {
+		slog.Warn("renumber imported playlist", "error", err, "playlist_id", playlistID)
+	}
+	l.emit(userID, EventImportFinished, map[string]any{
+		"playlist_id":         playlistID,
+		"spotify_playlist_id": spotifyPlaylistID,
+		"name":                playlistData.Name,
+		"tracks":              len(tracks) - int(failed.Load()),
+		"failed_tracks":       failed.Load(),
+	})
+
 	return queries.Playlist{
 		ID:          optuuid(uuid.MustParse(playlistID)),
 		Name:        playlistData.Name,
 		Description: playlistData.Description,
 		ImageUrl:    coverURL,
 		CreatedAt:   opttime(time.Now()),
+		UserID:      userID,
 	}, nil
 }
 
@@ -740,17 +1101,21 @@ func releaseDate(d string) pgtype.Date {
 	return track_date
 }
 
-func NewLibrary(storagePath string, pool *pgxpool.Pool) *Library {
-	q := queries.New(pool)
+func NewLibrary(storagePath string, store Store) *Library {
 	os.MkdirAll(storagePath, 0755) // especially needed if not using local storage
+	abort, abortDownloads := context.WithCancel(context.Background())
 	return &Library{
 		storagePath:             storagePath,
-		pool:                    pool,
-		queries:                 q,
+		store:                   store,
+		queries:                 store.Queries(),
 		spotifyToken:            new(spotifyToken),
 		dlNoDuplicate:           newNoDuplicate[error](),
 		youtubeURLRegexp:        regexp.MustCompile(`https://(?:(?:www|m|music)\.)?youtube\.com/[^\s]+`),
 		ongoingDownloads:        newSlots(env.DefaultEnv.MaximumOngoingDownloads),
 		maximumOngoingDownloads: env.DefaultEnv.MaximumOngoingDownloads,
+		radios:                  newRadioStations(),
+		abort:                   abort,
+		abortDownloads:          abortDownloads,
+		webhookWake:             make(chan struct{}, 1),
 	}
 }
@@ -0,0 +1,108 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPlaylistPositions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		user := testUser(t, l, "alice")
+		testTracks(t, l, "a", "b", "c", "d", "e")
+		playlistID, err := l.CreatePlaylist(ctx, user.ID, "mix", "a mix", "https://example.com/mix.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := func(want string) {
+			t.Helper()
+			if got := fmt.Sprint(testPlaylistTrackIDs(t, l, user.ID, playlistID)); got != want {
+				t.Fatalf("tracks = %s, want %s", got, want)
+			}
+		}
+		at := func(i int) *int { return &i }
+
+		for _, id := range []string{"a", "b", "c"} {
+			if err := l.AddTrackToPlaylist(ctx, user.ID, playlistID, id, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check("[a b c]")
+		if err := l.AddTrackToPlaylist(ctx, user.ID, playlistID, "d", at(1)); err != nil {
+			t.Fatal(err)
+		}
+		check("[a d b c]")
+		if err := l.AddTrackToPlaylist(ctx, user.ID, playlistID, "e", at(5)); err == nil {
+			t.Fatal("adding a track past the end worked")
+		}
+
+		// removing renumbers the positions, so the end is at 3 again
+		if err := l.RemoveTrackFromPlaylist(ctx, user.ID, playlistID, "a"); err != nil {
+			t.Fatal(err)
+		}
+		check("[d b c]")
+		if err := l.AddTrackToPlaylist(ctx, user.ID, playlistID, "e", at(3)); err != nil {
+			t.Fatal(err)
+		}
+		check("[d b c e]")
+
+		if err := l.MovePlaylistTracks(ctx, user.ID, playlistID, 0, 2, 2); err != nil {
+			t.Fatal(err)
+		}
+		check("[c e d b]")
+		if err := l.MovePlaylistTracks(ctx, user.ID, playlistID, 3, 1, 0); err != nil {
+			t.Fatal(err)
+		}
+		check("[b c e d]")
+		if err := l.MovePlaylistTracks(ctx, user.ID, playlistID, 2, 2, 3); err == nil {
+			t.Fatal("moving tracks past the end worked")
+		}
+
+		if err := l.SetPlaylistTracks(ctx, user.ID, playlistID, []string{"e", "a"}); err != nil {
+			t.Fatal(err)
+		}
+		check("[e a]")
+		// a replacement that fails leaves the playlist as it was
+		if err := l.SetPlaylistTracks(ctx, user.ID, playlistID, []string{"b", "missing"}); err == nil {
+			t.Fatal("replacing the tracks with one that doesn't exist worked")
+		}
+		check("[e a]")
+	})
+}
+
+func TestPlaylistsOfOtherUsers(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		alice := testUser(t, l, "alice")
+		bob := testUser(t, l, "bob")
+		testTracks(t, l, "a")
+		playlistID, err := l.CreatePlaylist(ctx, alice.ID, "mine", "alice's", "https://example.com/mine.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := l.GetPlaylistByID(ctx, bob.ID, playlistID); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("bob getting alice's playlist: err = %v, want ErrNoRows", err)
+		}
+		if err := l.AddTrackToPlaylist(ctx, bob.ID, playlistID, "a", nil); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("bob adding to alice's playlist: err = %v, want ErrNoRows", err)
+		}
+		if err := l.DeletePlaylist(ctx, bob.ID, playlistID); err == nil {
+			t.Error("bob deleted alice's playlist")
+		}
+		playlists, err := l.ListPlaylists(ctx, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(playlists) != 0 {
+			t.Errorf("bob's playlists = %v, want none", playlistNames(playlists))
+		}
+		if _, err := l.GetPlaylistByID(ctx, alice.ID, playlistID); err != nil {
+			t.Errorf("alice getting her playlist: %v", err)
+		}
+	})
+}
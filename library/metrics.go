@@ -0,0 +1,94 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tiredkangaroo/music/env"
+)
+
+// the metrics of downloads and the spotify api, served by the server at /metrics.
+var (
+	downloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "music_downloads_total",
+		Help: "downloads by result: ok, or the reason they failed.",
+	}, []string{"result"})
+	downloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "music_download_duration_seconds",
+		Help:    "how long downloads took (spotdl and yt-dlp, including waiting for a slot), by whether they worked (ok or failed).",
+		Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"result"})
+	downloadsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "music_downloads_queued",
+		Help: "downloads waiting for a download slot.",
+	})
+	downloadSlotsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "music_download_slots_in_use",
+		Help: "download slots in use (yt-dlp running).",
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "music_download_slots",
+		Help: "download slots (MAX_CONCURRENT_DOWNLOADS).",
+	}, func() float64 { return float64(env.DefaultEnv.MaximumOngoingDownloads) })
+
+	spotifyRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "music_spotify_requests_total",
+		Help: "requests to the spotify api by endpoint and status code (error if there was no response).",
+	}, []string{"endpoint", "code"})
+)
+
+// observeDownload records a download that started at start and ended with err.
+func observeDownload(ctx context.Context, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	downloadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	if err != nil {
+		result = downloadFailureReason(ctx, err)
+	}
+	downloadsTotal.WithLabelValues(result).Inc()
+}
+
+// downloadFailureReason is the reason label of a failed download.
+func downloadFailureReason(ctx context.Context, err error) string {
+	// yt-dlp killed because the context is done fails with "signal: killed", so the context is checked too
+	switch {
+	case errors.Is(err, ErrShuttingDown):
+		return "shutting_down"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return "canceled"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "age-restricted"):
+		return "age_restricted"
+	case strings.Contains(msg, "could not find youtube url"):
+		return "no_youtube_url"
+	case strings.HasPrefix(msg, "spotdl"), strings.Contains(msg, "metadata"):
+		return "spotdl"
+	case strings.HasPrefix(msg, "yt-dlp"):
+		return "yt_dlp"
+	}
+	return "other"
+}
+
+// doSpotifyRequest sends a request to the spotify api and counts it by endpoint (a fixed name, not the url)
+// and status code.
+func doSpotifyRequest(req *http.Request, endpoint string) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		spotifyRequestsTotal.WithLabelValues(endpoint, "error").Inc()
+		return nil, err
+	}
+	spotifyRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
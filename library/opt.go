@@ -106,6 +106,14 @@ func filter[T any](slice []T, predicate func(T) bool) []T {
 	return result[:i]
 }
 
+// orEmpty returns an empty slice instead of nil so it is encoded as [] and not null.
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
 func maxLengthString(s string, max int) string {
 	if len(s) <= max {
 		return s
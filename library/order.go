@@ -0,0 +1,63 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// PlaylistSorts are the ways the tracks of a regular playlist can be sorted when it is read.
+var PlaylistSorts = []string{"title", "artist", "album", "added_at", "duration", "play_count"}
+
+// MovePlaylistTracks moves count tracks starting at position from so they start at position to, where to
+// is the position of the first moved track after the move (so moving a track one down is from=i, to=i+1).
+// this is what a drag and drop of a range of tracks does.
+func (l *Library) MovePlaylistTracks(ctx context.Context, userID pgtype.UUID, playlistID string, from, count, to int) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return err
+	}
+	if playlist.Rules != nil {
+		return fmt.Errorf("the tracks of a smart playlist can't be reordered")
+	}
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		if _, err := q.LockPlaylist(ctx, playlist.ID); err != nil {
+			return fmt.Errorf("lock playlist: %w", err)
+		}
+		total, err := q.CountPlaylistTracks(ctx, playlist.ID)
+		if err != nil {
+			return fmt.Errorf("count playlist tracks: %w", err)
+		}
+		if count < 1 {
+			return fmt.Errorf("count must be at least 1")
+		}
+		if from < 0 || int64(from+count) > total {
+			return fmt.Errorf("tracks %d to %d are not in the playlist", from, from+count-1)
+		}
+		if to < 0 || int64(to+count) > total {
+			return fmt.Errorf("to must be between 0 and %d", total-int64(count))
+		}
+		err = q.MovePlaylistTracks(ctx, queries.MovePlaylistTracksParams{
+			PlaylistID:   playlist.ID,
+			FromPosition: int32(from),
+			Count:        int32(count),
+			ToPosition:   int32(to),
+		})
+		if err != nil {
+			return fmt.Errorf("move playlist tracks: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "tracks_moved", "")
+	return nil
+}
+
+// inTx runs fn in a transaction, which is committed if fn doesn't return an error.
+func (l *Library) inTx(ctx context.Context, fn func(q *queries.Queries) error) error {
+	return l.store.InTx(ctx, fn)
+}
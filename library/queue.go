@@ -1,6 +1,7 @@
 package library
 
 import (
+	"context"
 	"log/slog"
 	"sync"
 )
@@ -67,8 +68,14 @@ type slots struct {
 	sem chan struct{}
 }
 
-func (s *slots) Acquire() {
-	s.sem <- struct{}{}
+// Acquire waits for a slot, or until ctx is done.
+func (s *slots) Acquire(ctx context.Context) error {
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
 }
 
 func (s *slots) Release() {
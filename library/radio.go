@@ -0,0 +1,340 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// radio seed types
+const (
+	RadioSeedTrack    = "track"
+	RadioSeedArtist   = "artist"
+	RadioSeedPlaylist = "playlist"
+)
+
+const (
+	// radioRecentWindow is how far back plays are considered recent repeats to avoid.
+	radioRecentWindow = 3 * time.Hour
+	// radioStationTTL is how long an unused station is kept around.
+	radioStationTTL = 12 * time.Hour
+	// maxRadioBatch is the most tracks that can be requested at once.
+	maxRadioBatch = 50
+)
+
+// radio scoring weights. every library track is a candidate, these decide which ones come first.
+const (
+	radioWeightArtist   = 3.0 // shares an artist with a seed track
+	radioWeightAlbum    = 2.0 // on the same album as a seed track
+	radioWeightPlaylist = 2.0 // in the same playlist as a seed track
+	radioWeightCoListen = 3.0 // played right after a seed track before
+	radioWeightSeed     = 2.0 // is one of the seeds (artist and playlist radio only)
+	radioJitter         = 1.0 // randomness so the same seed doesn't always give the same radio
+)
+
+// radioStation is an endless queue generated from a seed. it remembers which tracks it has handed
+// out so it doesn't repeat them until it runs out of tracks.
+type radioStation struct {
+	ID       string
+	SeedType string
+	SeedID   string
+
+	userID   pgtype.UUID     // the user the station belongs to
+	seeds    []string        // seed track IDs
+	served   map[string]bool // tracks already handed out
+	lastUsed time.Time
+	mx       sync.Mutex
+}
+
+type radioStations struct {
+	stations map[string]*radioStation
+	mx       sync.Mutex
+}
+
+func (rs *radioStations) Get(id string) (*radioStation, bool) {
+	rs.mx.Lock()
+	defer rs.mx.Unlock()
+	s, ok := rs.stations[id]
+	return s, ok
+}
+
+// Add adds a station and drops the ones that haven't been used in a while.
+func (rs *radioStations) Add(s *radioStation) {
+	rs.mx.Lock()
+	defer rs.mx.Unlock()
+	for id, station := range rs.stations {
+		station.mx.Lock()
+		expired := time.Since(station.lastUsed) > radioStationTTL
+		station.mx.Unlock()
+		if expired {
+			delete(rs.stations, id)
+		}
+	}
+	rs.stations[s.ID] = s
+}
+
+func newRadioStations() *radioStations {
+	return &radioStations{stations: make(map[string]*radioStation)}
+}
+
+// StartRadio creates a radio station for the user from a seed track, artist or playlist and returns its ID.
+func (l *Library) StartRadio(ctx context.Context, userID pgtype.UUID, seedType, seedID string) (string, error) {
+	var seeds []string
+	switch seedType {
+	case RadioSeedTrack:
+		track, err := l.queries.GetTrackByID(ctx, seedID)
+		if err != nil {
+			return "", fmt.Errorf("get seed track: %w", err)
+		}
+		seeds = []string{track.TrackID}
+	case RadioSeedArtist:
+		tracks, err := l.queries.ListLibraryTracks(ctx, userID)
+		if err != nil {
+			return "", fmt.Errorf("list library tracks: %w", err)
+		}
+		for _, t := range tracks {
+			if t.ArtistID == seedID {
+				seeds = append(seeds, t.TrackID)
+			}
+		}
+	case RadioSeedPlaylist:
+		playlist, err := l.GetPlaylistByID(ctx, userID, seedID)
+		if err != nil {
+			return "", err
+		}
+		seeds, err = l.playlistTrackIDs(ctx, playlist)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("seed type must be track, artist or playlist")
+	}
+	if len(seeds) == 0 {
+		return "", fmt.Errorf("there are no tracks in the library to seed the radio with")
+	}
+
+	station := &radioStation{
+		ID:       uuid.New().String(),
+		SeedType: seedType,
+		SeedID:   seedID,
+		userID:   userID,
+		seeds:    seeds,
+		served:   make(map[string]bool),
+		lastUsed: time.Now(),
+	}
+	if seedType == RadioSeedTrack {
+		station.served[seedID] = true // the seed track is already playing
+	}
+	l.radios.Add(station)
+	return station.ID, nil
+}
+
+// NextRadioTracks returns the next n tracks of the radio station and starts downloading them in
+// the background so they are ready by the time they're played.
+func (l *Library) NextRadioTracks(ctx context.Context, userID pgtype.UUID, radioID string, n int) ([]Track, error) {
+	station, ok := l.radios.Get(radioID)
+	if !ok || station.userID != userID {
+		return nil, fmt.Errorf("radio station not found")
+	}
+	if n <= 0 || n > maxRadioBatch {
+		return nil, fmt.Errorf("the number of tracks must be between 1 and %d", maxRadioBatch)
+	}
+
+	// only one request per station at a time so two requests don't hand out the same tracks
+	station.mx.Lock()
+	defer station.mx.Unlock()
+	station.lastUsed = time.Now()
+
+	scored, err := l.scoreRadioCandidates(ctx, station)
+	if err != nil {
+		return nil, err
+	}
+
+	recentIDs, err := l.queries.RecentlyPlayedTrackIDs(ctx, queries.RecentlyPlayedTrackIDsParams{
+		PlayedAt: opttime(time.Now().UTC().Add(-radioRecentWindow)),
+		UserID:   userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recently played tracks: %w", err)
+	}
+	recent := make(map[string]bool, len(recentIDs))
+	for _, id := range recentIDs {
+		recent[id] = true
+	}
+	available := filter(scored, func(c radioCandidate) bool {
+		return !station.served[c.track.TrackID] && !recent[c.track.TrackID]
+	})
+	if len(available) < n {
+		// we ran out of fresh tracks, start over instead of ending the radio
+		clear(station.served)
+		available = scored
+	}
+
+	picked := pickRadioTracks(available, n)
+	tracks := make([]Track, len(picked))
+	for i, c := range picked {
+		station.served[c.track.TrackID] = true
+		tracks[i] = trackFromLibraryRow(c.track)
+		if !c.track.Downloaded {
+			go func(trackID string) {
+				if err := l.DownloadIfNotExists(context.Background(), station.userID, trackID); err != nil {
+					slog.Warn("prefetch radio track", "error", err, "track_id", trackID)
+				}
+			}(c.track.TrackID)
+		}
+	}
+	return tracks, nil
+}
+
+type radioCandidate struct {
+	track queries.ListLibraryTracksRow
+	score float64
+}
+
+// scoreRadioCandidates scores every track in the user's library by how related it is to the station's seeds.
+func (l *Library) scoreRadioCandidates(ctx context.Context, station *radioStation) ([]radioCandidate, error) {
+	library, err := l.queries.ListLibraryTracks(ctx, station.userID)
+	if err != nil {
+		return nil, fmt.Errorf("list library tracks: %w", err)
+	}
+	playlistCandidates, err := l.queries.RadioPlaylistCandidates(ctx, queries.RadioPlaylistCandidatesParams{
+		SeedIds: station.seeds,
+		UserID:  station.userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("radio playlist candidates: %w", err)
+	}
+	coListenCandidates, err := l.queries.RadioCoListenCandidates(ctx, queries.RadioCoListenCandidatesParams{
+		SeedIds: station.seeds,
+		UserID:  station.userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("radio co-listen candidates: %w", err)
+	}
+
+	// the artists and albums of the seeds. a track seed may not be in the library yet (e.g. it was
+	// played from search results) so it is looked up on its own.
+	seedArtists := make(map[string]int)
+	seedAlbums := make(map[string]int)
+	isSeed := make(map[string]bool, len(station.seeds))
+	for _, id := range station.seeds {
+		isSeed[id] = true
+	}
+	addSeed := func(artistID, albumID string, artists []string) {
+		seedArtists[artistID]++
+		for _, name := range artists {
+			seedArtists[strings.ToLower(name)]++
+		}
+		seedAlbums[albumID]++
+	}
+	if station.SeedType == RadioSeedTrack {
+		seed, err := l.queries.GetTrackByID(ctx, station.seeds[0])
+		if err != nil {
+			return nil, fmt.Errorf("get seed track: %w", err)
+		}
+		addSeed(seed.ArtistID, seed.AlbumID, seed.Artists)
+	} else {
+		for _, t := range library {
+			if isSeed[t.TrackID] {
+				addSeed(t.ArtistID, t.AlbumID, t.Artists)
+			}
+		}
+	}
+
+	sharedPlaylists := make(map[string]int64, len(playlistCandidates))
+	for _, c := range playlistCandidates {
+		sharedPlaylists[c.TrackID] = c.SharedPlaylists
+	}
+	coPlays := make(map[string]int64, len(coListenCandidates))
+	for _, c := range coListenCandidates {
+		coPlays[c.TrackID] = c.CoPlays
+	}
+
+	candidates := make([]radioCandidate, 0, len(library))
+	for _, t := range library {
+		if station.SeedType == RadioSeedTrack && isSeed[t.TrackID] {
+			continue
+		}
+		score := rand.Float64() * radioJitter
+
+		artistMatches := seedArtists[t.ArtistID]
+		for _, name := range t.Artists {
+			artistMatches += seedArtists[strings.ToLower(name)]
+		}
+		score += radioWeightArtist * math.Log1p(float64(artistMatches))
+		score += radioWeightAlbum * math.Log1p(float64(seedAlbums[t.AlbumID]))
+		score += radioWeightPlaylist * math.Log1p(float64(sharedPlaylists[t.TrackID]))
+		score += radioWeightCoListen * math.Log1p(float64(coPlays[t.TrackID]))
+		if isSeed[t.TrackID] {
+			score += radioWeightSeed
+		}
+		candidates = append(candidates, radioCandidate{track: t, score: score})
+	}
+	slices.SortFunc(candidates, func(a, b radioCandidate) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	return candidates, nil
+}
+
+// pickRadioTracks picks n tracks from candidates (sorted by score) while trying not to play the
+// same artist twice in a row.
+func pickRadioTracks(candidates []radioCandidate, n int) []radioCandidate {
+	picked := make([]radioCandidate, 0, n)
+	used := make([]bool, len(candidates))
+	lastArtist := ""
+	for len(picked) < n && len(picked) < len(candidates) {
+		choice := -1
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+			if choice == -1 {
+				choice = i // best remaining track, used if every track left is by the last artist
+			}
+			if c.track.ArtistID != lastArtist {
+				choice = i
+				break
+			}
+		}
+		used[choice] = true
+		picked = append(picked, candidates[choice])
+		lastArtist = candidates[choice].track.ArtistID
+	}
+	return picked
+}
+
+// playlistTrackIDs returns the IDs of the tracks in the playlist (evaluating the rules of a smart playlist).
+func (l *Library) playlistTrackIDs(ctx context.Context, playlist queries.Playlist) ([]string, error) {
+	if playlist.Rules == nil {
+		ids, err := l.queries.ListPlaylistTrackIDs(ctx, playlist.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list playlist tracks: %w", err)
+		}
+		return ids, nil
+	}
+	var rules SmartRules
+	if err := json.Unmarshal(playlist.Rules, &rules); err != nil {
+		return nil, fmt.Errorf("decode smart playlist rules: %w", err)
+	}
+	tracks, err := l.evaluateSmartRules(ctx, playlist.UserID, rules)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.TrackID
+	}
+	return ids, nil
+}
@@ -0,0 +1,74 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// LikeTrack likes or unlikes the track. liking a track that is already liked keeps its original like date.
+func (l *Library) LikeTrack(ctx context.Context, userID pgtype.UUID, trackID string, liked bool) error {
+	if err := l.checkTracksExist(ctx, []string{trackID}); err != nil {
+		return err
+	}
+	err := l.queries.SetTrackLiked(ctx, queries.SetTrackLikedParams{
+		TrackID: trackID,
+		Liked:   liked,
+		UserID:  userID,
+	})
+	if err != nil {
+		return fmt.Errorf("set track liked: %w", err)
+	}
+	return nil
+}
+
+// RateTrack gives the track a rating of 1 to 5 stars. a rating of 0 clears it.
+func (l *Library) RateTrack(ctx context.Context, userID pgtype.UUID, trackID string, rating int) error {
+	if rating < 0 || rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5 (or 0 to clear it)")
+	}
+	if err := l.checkTracksExist(ctx, []string{trackID}); err != nil {
+		return err
+	}
+	var r pgtype.Int4
+	if rating != 0 {
+		r = optint32(int32(rating))
+	}
+	err := l.queries.SetTrackRating(ctx, queries.SetTrackRatingParams{
+		TrackID: trackID,
+		Rating:  r,
+		UserID:  userID,
+	})
+	if err != nil {
+		return fmt.Errorf("set track rating: %w", err)
+	}
+	return nil
+}
+
+// LikedTracks returns the liked tracks (the virtual "Liked Songs" playlist), most recently liked first.
+func (l *Library) LikedTracks(ctx context.Context, userID pgtype.UUID) ([]Track, error) {
+	rows, err := l.queries.ListLikedTracks(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list liked tracks: %w", err)
+	}
+	tracks := make([]Track, len(rows))
+	for i, row := range rows {
+		tracks[i] = trackFromLibraryRow(queries.ListLibraryTracksRow(row))
+	}
+	return tracks, nil
+}
+
+// searchPreference is how much the user prefers a search result: liking a track counts as much as
+// rating it 5 stars, and a 1 or 2 star rating pushes it down.
+func searchPreference(t queries.SearchTrackByNameRow) int {
+	var p int
+	if t.LikedAt.Valid {
+		p += 2
+	}
+	if t.Rating.Valid {
+		p += int(t.Rating.Int32) - 3
+	}
+	return p
+}
@@ -0,0 +1,199 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// recapPlaylistSize is the number of tracks put in a recap playlist.
+const recapPlaylistSize = 100
+
+// Recap is a summary of the listening in a time window (usually a year).
+type Recap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	TopTracks  []queries.TopTracksRow  `json:"top_tracks"`
+	TopArtists []queries.TopArtistsRow `json:"top_artists"`
+	TopAlbums  []queries.TopAlbumsRow  `json:"top_albums"`
+
+	PlayCount       int64 `json:"play_count"`
+	TrackCount      int64 `json:"track_count"`
+	MinutesListened int64 `json:"minutes_listened"`
+
+	// MostSkipped and FirstTrack are nil if there were no skips or no plays in the window.
+	MostSkipped *queries.MostSkippedTrackRow `json:"most_skipped"`
+	FirstTrack  *queries.FirstPlayRow        `json:"first_track"`
+
+	// NewArtists are the artists played for the very first time in the window.
+	NewArtists []queries.NewArtistsRow `json:"new_artists"`
+	Months     []RecapMonth            `json:"months"`
+}
+
+// RecapMonth is the listening in a single month of a recap.
+type RecapMonth struct {
+	Month           string                       `json:"month"` // YYYY-MM
+	PlayCount       int64                        `json:"play_count"`
+	TrackCount      int64                        `json:"track_count"`
+	MinutesListened int64                        `json:"minutes_listened"`
+	TopTrack        *queries.MonthlyTopTracksRow `json:"top_track"`
+}
+
+// Recap generates a recap of the user's listening in the window.
+func (l *Library) Recap(ctx context.Context, userID pgtype.UUID, w StatsWindow) (Recap, error) {
+	r := Recap{
+		From: w.start(),
+		To:   w.end(),
+	}
+	var err error
+
+	if r.TopTracks, err = l.TopTracks(ctx, userID, w, 5); err != nil {
+		return Recap{}, err
+	}
+	if r.TopArtists, err = l.TopArtists(ctx, userID, w, 5); err != nil {
+		return Recap{}, err
+	}
+	if r.TopAlbums, err = l.TopAlbums(ctx, userID, w, 5); err != nil {
+		return Recap{}, err
+	}
+
+	totals, err := l.ListeningTotals(ctx, userID, w)
+	if err != nil {
+		return Recap{}, err
+	}
+	r.PlayCount = totals.PlayCount
+	r.TrackCount = totals.TrackCount
+	r.MinutesListened = totals.SecondsListened / 60
+
+	mostSkipped, err := l.queries.MostSkippedTrack(ctx, queries.MostSkippedTrackParams{
+		UserID:    userID,
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err == nil {
+		r.MostSkipped = &mostSkipped
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return Recap{}, fmt.Errorf("most skipped track: %w", err)
+	}
+
+	first, err := l.queries.FirstPlay(ctx, queries.FirstPlayParams{
+		UserID:    userID,
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err == nil {
+		r.FirstTrack = &first
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return Recap{}, fmt.Errorf("first play: %w", err)
+	}
+
+	r.NewArtists, err = l.queries.NewArtists(ctx, queries.NewArtistsParams{
+		UserID:    userID,
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return Recap{}, fmt.Errorf("new artists: %w", err)
+	}
+
+	r.Months, err = l.recapMonths(ctx, userID, w)
+	if err != nil {
+		return Recap{}, err
+	}
+
+	r.TopTracks = orEmpty(r.TopTracks)
+	r.TopArtists = orEmpty(r.TopArtists)
+	r.TopAlbums = orEmpty(r.TopAlbums)
+	r.NewArtists = orEmpty(r.NewArtists)
+	r.Months = orEmpty(r.Months)
+	return r, nil
+}
+
+// recapMonths returns the month by month breakdown of the window, including months without any plays.
+func (l *Library) recapMonths(ctx context.Context, userID pgtype.UUID, w StatsWindow) ([]RecapMonth, error) {
+	months, err := l.queries.ListeningByMonth(ctx, queries.ListeningByMonthParams{
+		UserID:    userID,
+		TimeZone:  w.timeZone(),
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listening by month: %w", err)
+	}
+	topTracks, err := l.queries.MonthlyTopTracks(ctx, queries.MonthlyTopTracksParams{
+		UserID:    userID,
+		TimeZone:  w.timeZone(),
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("monthly top tracks: %w", err)
+	}
+
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	from := w.start().In(loc)
+	to := w.end().In(loc)
+
+	var result []RecapMonth
+	index := make(map[string]int)
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); m.Before(to); m = m.AddDate(0, 1, 0) {
+		key := m.Format("2006-01")
+		index[key] = len(result)
+		result = append(result, RecapMonth{Month: key})
+	}
+	for _, m := range months {
+		i, ok := index[m.Month.Time.Format("2006-01")]
+		if !ok {
+			continue
+		}
+		result[i].PlayCount = m.PlayCount
+		result[i].TrackCount = m.TrackCount
+		result[i].MinutesListened = m.SecondsListened / 60
+	}
+	for _, t := range topTracks {
+		i, ok := index[t.Month.Time.Format("2006-01")]
+		if !ok {
+			continue
+		}
+		result[i].TopTrack = &t
+	}
+	return result, nil
+}
+
+// CreateRecapPlaylist creates a playlist from the most played tracks in the window and returns its ID.
+func (l *Library) CreateRecapPlaylist(ctx context.Context, userID pgtype.UUID, w StatsWindow, name, description string) (string, error) {
+	tracks, err := l.TopTracks(ctx, userID, w, recapPlaylistSize)
+	if err != nil {
+		return "", err
+	}
+	if len(tracks) == 0 {
+		return "", fmt.Errorf("no plays in this time range")
+	}
+
+	playlistID, err := l.CreatePlaylist(ctx, userID, name, description, tracks[0].CoverUrl)
+	if err != nil {
+		return "", err
+	}
+	pid := optuuid(uuid.MustParse(playlistID))
+	for i, t := range tracks {
+		err := l.queries.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
+			PlaylistID: pid,
+			TrackID:    t.TrackID,
+			Position:   int32(i),
+		})
+		if err != nil {
+			return playlistID, fmt.Errorf("add track to recap playlist: %w", err)
+		}
+	}
+	return playlistID, nil
+}
@@ -0,0 +1,353 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// repeat modes of a playback session
+const (
+	RepeatOff = "off"
+	RepeatOne = "one"
+	RepeatAll = "all"
+)
+
+const (
+	// maxSessionQueue is the most tracks that can be queued in a session.
+	maxSessionQueue = 5000
+	// maxSessionHistory is the number of previously played tracks a session keeps. older ones are dropped.
+	maxSessionHistory = 200
+)
+
+// PlaybackState is a playback session as it's stored, with the tracks referenced by their IDs.
+type PlaybackState struct {
+	CurrentTrackID   string   `json:"current_track_id"`
+	Position         float64  `json:"position"` // seconds into the current track
+	Queue            []string `json:"queue"`
+	History          []string `json:"history"` // oldest first
+	Shuffle          bool     `json:"shuffle"`
+	Repeat           string   `json:"repeat"`
+	SourcePlaylistID string   `json:"source_playlist_id"`
+	// ShuffleSeed is the seed of the server side shuffle (see ShufflePlaylist) the queue came from, if any.
+	ShuffleSeed *int64 `json:"shuffle_seed"`
+}
+
+// Validate checks the state and fills in defaults.
+func (s *PlaybackState) Validate() error {
+	switch s.Repeat {
+	case "":
+		s.Repeat = RepeatOff
+	case RepeatOff, RepeatOne, RepeatAll:
+	default:
+		return fmt.Errorf("repeat must be off, one or all")
+	}
+	if s.Position < 0 {
+		return fmt.Errorf("position may not be negative")
+	}
+	if s.CurrentTrackID == "" && s.Position != 0 {
+		return fmt.Errorf("position requires a current track")
+	}
+	if len(s.Queue) > maxSessionQueue {
+		return fmt.Errorf("the queue may have at most %d tracks", maxSessionQueue)
+	}
+	if len(s.History) > maxSessionHistory {
+		s.History = s.History[len(s.History)-maxSessionHistory:]
+	}
+	if s.SourcePlaylistID != "" {
+		if _, err := uuid.Parse(s.SourcePlaylistID); err != nil {
+			return fmt.Errorf("invalid source playlist id: %w", err)
+		}
+	}
+	return nil
+}
+
+// PlaybackSession is a playback session with the tracks filled in.
+type PlaybackSession struct {
+	CurrentTrack     *Track    `json:"current_track"`
+	Position         float64   `json:"position"`
+	Queue            []Track   `json:"queue"`
+	History          []Track   `json:"history"`
+	Shuffle          bool      `json:"shuffle"`
+	Repeat           string    `json:"repeat"`
+	SourcePlaylistID string    `json:"source_playlist_id"`
+	ShuffleSeed      *int64    `json:"shuffle_seed"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// PlaybackSession returns the user's playback session. every user has one session, shared by all of
+// their devices.
+func (l *Library) PlaybackSession(ctx context.Context, userID pgtype.UUID) (PlaybackSession, error) {
+	state, updatedAt, err := l.loadPlaybackState(ctx, userID)
+	if err != nil {
+		return PlaybackSession{}, err
+	}
+	return l.expandPlaybackState(ctx, userID, state, updatedAt)
+}
+
+// SetPlaybackSession replaces the playback session.
+func (l *Library) SetPlaybackSession(ctx context.Context, userID pgtype.UUID, state PlaybackState) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		ids := append([]string{state.CurrentTrackID}, state.Queue...)
+		if err := l.checkTracksExist(ctx, append(ids, state.History...)); err != nil {
+			return err
+		}
+		*s = state
+		return nil
+	})
+}
+
+// SessionNext moves to the next track in the queue. when the queue runs out and repeat is all, the
+// source playlist (or the history if there isn't one) is queued again.
+func (l *Library) SessionNext(ctx context.Context, userID pgtype.UUID) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if len(s.Queue) == 0 && s.Repeat == RepeatAll && s.CurrentTrackID != "" {
+			if s.SourcePlaylistID != "" {
+				playlist, err := l.GetPlaylistByID(ctx, userID, s.SourcePlaylistID)
+				if err != nil {
+					return err
+				}
+				if s.Queue, err = l.playlistTrackIDs(ctx, playlist); err != nil {
+					return err
+				}
+			} else {
+				s.Queue = append(slices.Clone(s.History), s.CurrentTrackID)
+			}
+		}
+		if len(s.Queue) == 0 {
+			return fmt.Errorf("the queue is empty")
+		}
+		if s.CurrentTrackID != "" {
+			s.History = append(s.History, s.CurrentTrackID)
+		}
+		s.CurrentTrackID = s.Queue[0]
+		s.Queue = s.Queue[1:]
+		s.Position = 0
+		return nil
+	})
+}
+
+// SessionPrevious goes back to the last played track and puts the current track at the front of the queue.
+func (l *Library) SessionPrevious(ctx context.Context, userID pgtype.UUID) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if len(s.History) == 0 {
+			return fmt.Errorf("there is no previous track")
+		}
+		if s.CurrentTrackID != "" {
+			s.Queue = append([]string{s.CurrentTrackID}, s.Queue...)
+		}
+		s.CurrentTrackID = s.History[len(s.History)-1]
+		s.History = s.History[:len(s.History)-1]
+		s.Position = 0
+		return nil
+	})
+}
+
+// SetSessionPosition updates the position in the current track. trackID must be the current track so
+// position updates that arrive after the track has changed are rejected.
+func (l *Library) SetSessionPosition(ctx context.Context, userID pgtype.UUID, trackID string, position float64) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if s.CurrentTrackID == "" || s.CurrentTrackID != trackID {
+			return fmt.Errorf("track %s is not the current track", trackID)
+		}
+		s.Position = position
+		return nil
+	})
+}
+
+// SetSessionMode sets the shuffle and repeat modes. nil leaves the mode as it is.
+func (l *Library) SetSessionMode(ctx context.Context, userID pgtype.UUID, shuffle *bool, repeat *string) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if shuffle != nil {
+			s.Shuffle = *shuffle
+		}
+		if repeat != nil {
+			s.Repeat = *repeat
+		}
+		return nil
+	})
+}
+
+// QueueTracks adds tracks to the end of the queue, or to the front if next is true.
+func (l *Library) QueueTracks(ctx context.Context, userID pgtype.UUID, trackIDs []string, next bool) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if len(trackIDs) == 0 {
+			return fmt.Errorf("no tracks to queue")
+		}
+		if err := l.checkTracksExist(ctx, trackIDs); err != nil {
+			return err
+		}
+		if next {
+			s.Queue = append(slices.Clone(trackIDs), s.Queue...)
+		} else {
+			s.Queue = append(s.Queue, trackIDs...)
+		}
+		return nil
+	})
+}
+
+// RemoveFromQueue removes the track at index from the queue.
+func (l *Library) RemoveFromQueue(ctx context.Context, userID pgtype.UUID, index int) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		if index < 0 || index >= len(s.Queue) {
+			return fmt.Errorf("queue index out of range")
+		}
+		s.Queue = slices.Delete(s.Queue, index, index+1)
+		return nil
+	})
+}
+
+// ClearQueue removes every track from the queue.
+func (l *Library) ClearQueue(ctx context.Context, userID pgtype.UUID) (PlaybackSession, error) {
+	return l.updatePlaybackSession(ctx, userID, func(s *PlaybackState) error {
+		s.Queue = nil
+		return nil
+	})
+}
+
+// updatePlaybackSession applies fn to the stored session and saves it. updates are serialized so two
+// clients changing the session at the same time don't overwrite each other's changes.
+func (l *Library) updatePlaybackSession(ctx context.Context, userID pgtype.UUID, fn func(s *PlaybackState) error) (PlaybackSession, error) {
+	l.sessionMx.Lock()
+	defer l.sessionMx.Unlock()
+
+	state, _, err := l.loadPlaybackState(ctx, userID)
+	if err != nil {
+		return PlaybackSession{}, err
+	}
+	if err := fn(&state); err != nil {
+		return PlaybackSession{}, err
+	}
+	if err := state.Validate(); err != nil {
+		return PlaybackSession{}, err
+	}
+
+	var sourcePlaylistID pgtype.UUID
+	if state.SourcePlaylistID != "" {
+		sourcePlaylistID = optuuid(uuid.MustParse(state.SourcePlaylistID)) // already validated
+	}
+	var currentTrackID pgtype.Text
+	if state.CurrentTrackID != "" {
+		currentTrackID = optstring(state.CurrentTrackID)
+	}
+	var shuffleSeed pgtype.Int8
+	if state.ShuffleSeed != nil {
+		shuffleSeed = pgtype.Int8{Int64: *state.ShuffleSeed, Valid: true}
+	}
+	updatedAt, err := l.queries.UpsertPlaybackSession(ctx, queries.UpsertPlaybackSessionParams{
+		SessionID:        playbackSessionID(userID),
+		CurrentTrackID:   currentTrackID,
+		Position:         state.Position,
+		Queue:            orEmpty(state.Queue),
+		History:          orEmpty(state.History),
+		Shuffle:          state.Shuffle,
+		Repeat:           state.Repeat,
+		SourcePlaylistID: sourcePlaylistID,
+		ShuffleSeed:      shuffleSeed,
+	})
+	if err != nil {
+		return PlaybackSession{}, fmt.Errorf("save playback session: %w", err)
+	}
+	return l.expandPlaybackState(ctx, userID, state, updatedAt.Time)
+}
+
+// loadPlaybackState returns the stored session, or an empty one if there isn't one yet.
+func (l *Library) loadPlaybackState(ctx context.Context, userID pgtype.UUID) (PlaybackState, time.Time, error) {
+	session, err := l.queries.GetPlaybackSession(ctx, playbackSessionID(userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PlaybackState{Repeat: RepeatOff}, time.Time{}, nil
+	}
+	if err != nil {
+		return PlaybackState{}, time.Time{}, fmt.Errorf("get playback session: %w", err)
+	}
+	state := PlaybackState{
+		CurrentTrackID: session.CurrentTrackID.String,
+		Position:       session.Position,
+		Queue:          session.Queue,
+		History:        session.History,
+		Shuffle:        session.Shuffle,
+		Repeat:         session.Repeat,
+	}
+	if session.SourcePlaylistID.Valid {
+		state.SourcePlaylistID = uuid.UUID(session.SourcePlaylistID.Bytes).String()
+	}
+	if session.ShuffleSeed.Valid {
+		state.ShuffleSeed = &session.ShuffleSeed.Int64
+	}
+	return state, session.UpdatedAt.Time, nil
+}
+
+// expandPlaybackState fills in the tracks of the state. tracks that no longer exist are left out.
+func (l *Library) expandPlaybackState(ctx context.Context, userID pgtype.UUID, state PlaybackState, updatedAt time.Time) (PlaybackSession, error) {
+	ids := append([]string{state.CurrentTrackID}, state.Queue...)
+	rows, err := l.queries.GetTracksByIDs(ctx, queries.GetTracksByIDsParams{
+		UserID:   userID,
+		TrackIds: append(ids, state.History...),
+	})
+	if err != nil {
+		return PlaybackSession{}, fmt.Errorf("get session tracks: %w", err)
+	}
+	tracks := make(map[string]Track, len(rows))
+	for _, row := range rows {
+		tracks[row.TrackID] = trackFromLibraryRow(queries.ListLibraryTracksRow(row))
+	}
+	lookup := func(ids []string) []Track {
+		result := make([]Track, 0, len(ids))
+		for _, id := range ids {
+			if t, ok := tracks[id]; ok {
+				result = append(result, t)
+			}
+		}
+		return result
+	}
+
+	session := PlaybackSession{
+		Position:         state.Position,
+		Queue:            lookup(state.Queue),
+		History:          lookup(state.History),
+		Shuffle:          state.Shuffle,
+		Repeat:           state.Repeat,
+		SourcePlaylistID: state.SourcePlaylistID,
+		ShuffleSeed:      state.ShuffleSeed,
+		UpdatedAt:        updatedAt,
+	}
+	if t, ok := tracks[state.CurrentTrackID]; ok {
+		session.CurrentTrack = &t
+	}
+	return session, nil
+}
+
+// playbackSessionID returns the ID of the user's playback session, which is the user's ID.
+func playbackSessionID(userID pgtype.UUID) string {
+	return uuid.UUID(userID.Bytes).String()
+}
+
+// checkTracksExist returns an error if any of the (non-empty) track IDs isn't a known track.
+func (l *Library) checkTracksExist(ctx context.Context, trackIDs []string) error {
+	trackIDs = filter(trackIDs, func(id string) bool { return id != "" })
+	if len(trackIDs) == 0 {
+		return nil
+	}
+	// the user only matters for the play stats and ratings, which aren't needed here
+	rows, err := l.queries.GetTracksByIDs(ctx, queries.GetTracksByIDsParams{TrackIds: trackIDs})
+	if err != nil {
+		return fmt.Errorf("get tracks: %w", err)
+	}
+	found := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		found[row.TrackID] = true
+	}
+	for _, id := range trackIDs {
+		if !found[id] {
+			return fmt.Errorf("track %s not found", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// maxShuffleSeed keeps generated seeds within the integers a javascript number can hold exactly.
+const maxShuffleSeed = 1 << 53
+
+// shuffleRecentBlend is how much the order leans toward less recently played tracks when weighting by
+// recency (0 is not at all, 1 ignores spreading artists apart).
+const shuffleRecentBlend = 0.5
+
+// ShuffleOptions are the options of ShufflePlaylist.
+type ShuffleOptions struct {
+	// Seed makes the shuffle reproducible. nil picks a random seed.
+	Seed *int64
+	// WeightRecent puts tracks that haven't been played in a while earlier.
+	WeightRecent bool
+	// AsOf is the point in time the recency weighting is computed at. it's part of what makes the shuffle
+	// reproducible since plays recorded after it are ignored. zero means now.
+	AsOf time.Time
+}
+
+// Shuffle is a shuffled ordering of a playlist.
+type Shuffle struct {
+	Seed         int64     `json:"seed"`
+	WeightRecent bool      `json:"weight_recent"`
+	AsOf         time.Time `json:"as_of"`
+	Tracks       []Track   `json:"tracks"`
+}
+
+// ShufflePlaylist returns the tracks of the playlist in a shuffled order that spreads artists and albums
+// apart. the same seed (and AsOf when weighting by recency) on the same playlist gives the same order.
+func (l *Library) ShufflePlaylist(ctx context.Context, userID pgtype.UUID, playlistID string, opts ShuffleOptions) (Shuffle, error) {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return Shuffle{}, err
+	}
+	ids, err := l.playlistTrackIDs(ctx, playlist)
+	if err != nil {
+		return Shuffle{}, err
+	}
+	rows, err := l.queries.GetTracksByIDs(ctx, queries.GetTracksByIDsParams{
+		UserID:   userID,
+		TrackIds: ids,
+	})
+	if err != nil {
+		return Shuffle{}, fmt.Errorf("get playlist tracks: %w", err)
+	}
+
+	s := Shuffle{WeightRecent: opts.WeightRecent, AsOf: opts.AsOf}
+	if opts.Seed != nil {
+		s.Seed = *opts.Seed
+	} else {
+		s.Seed = rand.Int64N(maxShuffleSeed)
+	}
+	if s.AsOf.IsZero() {
+		s.AsOf = time.Now().UTC()
+	}
+
+	var lastPlayed map[string]time.Time
+	if opts.WeightRecent {
+		plays, err := l.queries.LastPlayedBefore(ctx, queries.LastPlayedBeforeParams{
+			TrackIds: ids,
+			Before:   opttime(s.AsOf.UTC()),
+			UserID:   userID,
+		})
+		if err != nil {
+			return Shuffle{}, fmt.Errorf("last played: %w", err)
+		}
+		lastPlayed = make(map[string]time.Time, len(plays))
+		for _, p := range plays {
+			lastPlayed[p.TrackID] = p.LastPlayedAt.Time
+		}
+	}
+
+	tracks := make([]Track, len(rows))
+	for i, row := range rows {
+		tracks[i] = trackFromLibraryRow(queries.ListLibraryTracksRow(row))
+	}
+	s.Tracks = shuffleTracks(tracks, uint64(s.Seed), lastPlayed, s.AsOf)
+	return s, nil
+}
+
+// shuffleTracks orders the tracks so tracks by the same artist (and from the same album) are spread
+// evenly through the playlist. every artist's tracks get evenly spaced positions with a random offset
+// and the tracks are sorted by position. if lastPlayed isn't nil the positions are blended with a random
+// order weighted toward tracks that haven't been played in a while.
+func shuffleTracks(tracks []Track, seed uint64, lastPlayed map[string]time.Time, asOf time.Time) []Track {
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	n := len(tracks)
+	if n == 0 {
+		return tracks
+	}
+
+	// the query returns tracks in no particular order, sort them so the same seed gives the same result
+	tracks = slices.Clone(tracks)
+	slices.SortFunc(tracks, func(a, b Track) int { return strings.Compare(a.TrackID, b.TrackID) })
+
+	// the base order is either uniformly random or a weighted random order where each track's key is
+	// u^(1/weight) (so tracks with a bigger weight tend to come first).
+	keys := make(map[string]float64, n)
+	for _, t := range tracks {
+		keys[t.TrackID] = rng.Float64()
+	}
+	if lastPlayed != nil {
+		for _, t := range tracks {
+			keys[t.TrackID] = math.Pow(keys[t.TrackID], 1/recencyWeight(lastPlayed, t.TrackID, asOf))
+		}
+	}
+	base := slices.Clone(tracks)
+	slices.SortStableFunc(base, func(a, b Track) int { return cmp.Compare(keys[b.TrackID], keys[a.TrackID]) })
+	baseRank := make(map[string]int, n)
+	for i, t := range base {
+		baseRank[t.TrackID] = i
+	}
+
+	// group the tracks by artist in base order, and alternate albums within each artist
+	var artists []string
+	byArtist := make(map[string][]Track)
+	for _, t := range base {
+		if _, ok := byArtist[t.ArtistID]; !ok {
+			artists = append(artists, t.ArtistID)
+		}
+		byArtist[t.ArtistID] = append(byArtist[t.ArtistID], t)
+	}
+
+	position := make(map[string]float64, n)
+	for _, artist := range artists {
+		group := alternateAlbums(byArtist[artist])
+		k := float64(len(group))
+		offset := rng.Float64() / k
+		for i, t := range group {
+			jitter := (rng.Float64() - 0.5) * 0.2 / k
+			position[t.TrackID] = offset + float64(i)/k + jitter
+		}
+	}
+	if lastPlayed != nil {
+		for id, p := range position {
+			position[id] = (1-shuffleRecentBlend)*p + shuffleRecentBlend*float64(baseRank[id])/float64(n)
+		}
+	}
+
+	result := slices.Clone(base)
+	slices.SortStableFunc(result, func(a, b Track) int {
+		return cmp.Compare(position[a.TrackID], position[b.TrackID])
+	})
+	separateNeighbours(result)
+	return result
+}
+
+// recencyWeight is the weight of a track in the weighted order. tracks that were never played get the
+// biggest weight, then the longer ago a track was played the bigger its weight.
+func recencyWeight(lastPlayed map[string]time.Time, trackID string, asOf time.Time) float64 {
+	t, ok := lastPlayed[trackID]
+	if !ok {
+		return 8
+	}
+	days := asOf.Sub(t).Hours() / 24
+	return 1 + min(math.Log1p(max(days, 0)), 7)
+}
+
+// alternateAlbums reorders tracks (of one artist) so consecutive tracks are from different albums where
+// possible, keeping the relative order of tracks from the same album.
+func alternateAlbums(tracks []Track) []Track {
+	var albums []string
+	byAlbum := make(map[string][]Track)
+	for _, t := range tracks {
+		if _, ok := byAlbum[t.AlbumID]; !ok {
+			albums = append(albums, t.AlbumID)
+		}
+		byAlbum[t.AlbumID] = append(byAlbum[t.AlbumID], t)
+	}
+	result := make([]Track, 0, len(tracks))
+	for len(result) < len(tracks) {
+		for _, album := range albums {
+			if len(byAlbum[album]) > 0 {
+				result = append(result, byAlbum[album][0])
+				byAlbum[album] = byAlbum[album][1:]
+			}
+		}
+	}
+	return result
+}
+
+// separateNeighbours swaps tracks so no two consecutive tracks share an artist (or album) when there's
+// a later track that can be moved up instead.
+func separateNeighbours(tracks []Track) {
+	for i := 1; i < len(tracks); i++ {
+		prev := tracks[i-1]
+		if tracks[i].ArtistID != prev.ArtistID && tracks[i].AlbumID != prev.AlbumID {
+			continue
+		}
+		for j := i + 1; j < len(tracks); j++ {
+			if tracks[j].ArtistID != prev.ArtistID && tracks[j].AlbumID != prev.AlbumID {
+				tracks[i], tracks[j] = tracks[j], tracks[i]
+				break
+			}
+		}
+	}
+}
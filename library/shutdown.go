@@ -0,0 +1,105 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrShuttingDown is the error of a download that was started after Shutdown was called, or that Shutdown
+// interrupted.
+var ErrShuttingDown = errors.New("the library is shutting down")
+
+// abortWaitDelay is how long an interrupted yt-dlp or spotdl can keep its output open (through the processes
+// it started, like ffmpeg) before it's abandoned.
+const abortWaitDelay = 2 * time.Second
+
+// temporaryFiles are what yt-dlp and spotdl leave in the storage directory when they're stopped halfway
+// (partial downloads and fragments, unconverted video and metadata files).
+var temporaryFiles = []string{"*.part", "*.part-Frag*", "*.ytdl", "*.temp.*", "*.mp4", "*-metadata.spotdl"}
+
+// beginDownload registers a running download, which Shutdown waits for. call l.downloads.Done when it's done.
+func (l *Library) beginDownload() error {
+	l.downloadsMx.Lock()
+	defer l.downloadsMx.Unlock()
+	if l.shuttingDown {
+		return ErrShuttingDown
+	}
+	l.downloads.Add(1)
+	return nil
+}
+
+// downloadInBackground downloads the tracks that aren't downloaded yet in a goroutine, which Shutdown waits for.
+// errors are only logged, it's for best effort downloads.
+func (l *Library) downloadInBackground(userID pgtype.UUID, trackIDs ...string) {
+	if err := l.beginDownload(); err != nil {
+		return
+	}
+	go func() {
+		defer l.downloads.Done()
+		if err := l.DownloadIfNotExists(context.Background(), userID, trackIDs...); err != nil {
+			slog.Warn("background download", "error", err)
+		}
+	}()
+}
+
+// abortable returns a context that's also cancelled (with ErrShuttingDown) when Shutdown interrupts the
+// downloads.
+func (l *Library) abortable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(l.abort, func() { cancel(ErrShuttingDown) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// Shutdown stops the library from starting downloads and waits for the running ones to finish. if ctx is
+// done first they're interrupted: yt-dlp and spotdl are killed and the downloads are recorded as failed
+// downloads, so they can be retried once the server is back. then the temporary files downloads leave behind
+// are removed.
+func (l *Library) Shutdown(ctx context.Context) error {
+	l.downloadsMx.Lock()
+	l.shuttingDown = true
+	l.downloadsMx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.downloads.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("interrupting the downloads that are still running")
+		l.abortDownloads()
+		<-done
+		err = fmt.Errorf("downloads interrupted: %w", ctx.Err())
+	}
+	l.abortDownloads()
+
+	l.removeTemporaryFiles()
+	return err
+}
+
+// removeTemporaryFiles removes the temporary files of downloads from the storage directory. no download may
+// be running.
+func (l *Library) removeTemporaryFiles() {
+	for _, pattern := range temporaryFiles {
+		matches, _ := filepath.Glob(filepath.Join(l.storagePath, pattern))
+		for _, m := range matches {
+			if err := os.Remove(m); err != nil {
+				slog.Warn("remove temporary file", "path", m, "error", err)
+			} else {
+				slog.Debug("removed temporary file", "path", m)
+			}
+		}
+	}
+}
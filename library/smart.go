@@ -0,0 +1,348 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// smart playlist rule types
+const (
+	RuleArtistIs           = "artist_is"            // Artist matches the name or ID of any artist on the track
+	RuleReleaseYearBetween = "release_year_between" // release year is between From and To (inclusive)
+	RuleAddedWithinDays    = "added_within_days"    // added to the library in the last Days days
+	RulePlayCountAbove     = "play_count_above"     // played more than Count times
+	RuleNeverPlayed        = "never_played"         // never played
+	RuleSkippedMoreThan    = "skipped_more_than"    // skipped in more than Percent% of plays
+	RuleDownloaded         = "downloaded"           // only downloaded tracks
+)
+
+// maxSmartPlaylistLimit is the most tracks a smart playlist may evaluate to.
+const maxSmartPlaylistLimit = 5000
+
+// SmartRules defines which tracks are in a smart playlist.
+type SmartRules struct {
+	// Match is either "all" (default) where every rule must match, or "any" where one rule matching is enough.
+	Match string      `json:"match"`
+	Rules []SmartRule `json:"rules"`
+	// Sort is one of title, artist, album, release_date, added_at, play_count, last_played, popularity,
+	// duration or random. Order is asc (default) or desc.
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+	// Limit is the maximum number of tracks, 0 means no limit.
+	Limit int `json:"limit"`
+}
+
+// SmartRule is a single condition of a smart playlist. only the fields used by Type are read.
+type SmartRule struct {
+	Type    string  `json:"type"`
+	Artist  string  `json:"artist,omitempty"`
+	From    int     `json:"from,omitempty"`
+	To      int     `json:"to,omitempty"`
+	Days    int     `json:"days,omitempty"`
+	Count   int64   `json:"count,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+// Validate checks the rules and fills in defaults.
+func (r *SmartRules) Validate() error {
+	switch r.Match {
+	case "":
+		r.Match = "all"
+	case "all", "any":
+	default:
+		return fmt.Errorf("match must be all or any")
+	}
+	if len(r.Rules) == 0 {
+		return fmt.Errorf("a smart playlist needs at least one rule")
+	}
+	for i, rule := range r.Rules {
+		switch rule.Type {
+		case RuleArtistIs:
+			if strings.TrimSpace(rule.Artist) == "" {
+				return fmt.Errorf("rule %d: artist is required", i)
+			}
+		case RuleReleaseYearBetween:
+			if rule.From <= 0 || rule.To <= 0 || rule.From > rule.To {
+				return fmt.Errorf("rule %d: from and to must be years with from <= to", i)
+			}
+		case RuleAddedWithinDays:
+			if rule.Days <= 0 {
+				return fmt.Errorf("rule %d: days must be positive", i)
+			}
+		case RulePlayCountAbove:
+			if rule.Count < 0 {
+				return fmt.Errorf("rule %d: count may not be negative", i)
+			}
+		case RuleSkippedMoreThan:
+			if rule.Percent < 0 || rule.Percent >= 100 {
+				return fmt.Errorf("rule %d: percent must be between 0 and 100", i)
+			}
+		case RuleNeverPlayed, RuleDownloaded:
+		default:
+			return fmt.Errorf("rule %d: unknown rule type %q", i, rule.Type)
+		}
+	}
+	switch r.Sort {
+	case "", "title", "artist", "album", "release_date", "added_at", "play_count", "last_played", "popularity", "duration", "random":
+	default:
+		return fmt.Errorf("unknown sort %q", r.Sort)
+	}
+	switch r.Order {
+	case "":
+		r.Order = "asc"
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("order must be asc or desc")
+	}
+	if r.Limit < 0 || r.Limit > maxSmartPlaylistLimit {
+		return fmt.Errorf("limit must be between 0 and %d", maxSmartPlaylistLimit)
+	}
+	return nil
+}
+
+func (r SmartRule) matches(t queries.ListLibraryTracksRow, now time.Time) bool {
+	switch r.Type {
+	case RuleArtistIs:
+		if t.ArtistID == r.Artist || strings.EqualFold(t.ArtistName, r.Artist) {
+			return true
+		}
+		return slices.ContainsFunc(t.Artists, func(a string) bool { return strings.EqualFold(a, r.Artist) })
+	case RuleReleaseYearBetween:
+		if !t.TrackReleaseDate.Valid {
+			return false
+		}
+		y := t.TrackReleaseDate.Time.Year()
+		return y >= r.From && y <= r.To
+	case RuleAddedWithinDays:
+		return t.AddedAt.Valid && now.Sub(t.AddedAt.Time) <= time.Duration(r.Days)*24*time.Hour
+	case RulePlayCountAbove:
+		return t.PlayCount > r.Count
+	case RuleNeverPlayed:
+		return t.PlayCount == 0
+	case RuleSkippedMoreThan:
+		return t.PlayCount > 0 && float64(t.SkipCount)/float64(t.PlayCount)*100 > r.Percent
+	case RuleDownloaded:
+		return t.Downloaded
+	}
+	return false
+}
+
+// Track is a track with its album and artist and the user's plays, like and rating, as it appears in
+// GetPlaylist's tracks.
+type Track struct {
+	TrackID          string           `json:"track_id"`
+	TrackName        string           `json:"track_name"`
+	Duration         int32            `json:"duration"`
+	Popularity       int32            `json:"popularity"`
+	AlbumID          string           `json:"album_id"`
+	AlbumName        string           `json:"album_name"`
+	ArtistID         string           `json:"artist_id"`
+	ArtistName       string           `json:"artist_name"`
+	Artists          []string         `json:"artists"`
+	CoverURL         string           `json:"cover_url"`
+	Downloaded       bool             `json:"downloaded"`
+	TrackReleaseDate pgtype.Date      `json:"track_release_date"`
+	PlayCount        int64            `json:"play_count"`
+	LikedAt          pgtype.Timestamp `json:"liked_at"`
+	Rating           pgtype.Int4      `json:"rating"`
+}
+
+// evaluateSmartRules returns the library tracks that match the rules, sorted and limited.
+func (l *Library) evaluateSmartRules(ctx context.Context, userID pgtype.UUID, rules SmartRules) ([]Track, error) {
+	candidates, err := l.queries.ListLibraryTracks(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list library tracks: %w", err)
+	}
+
+	now := time.Now()
+	matched := filter(candidates, func(t queries.ListLibraryTracksRow) bool {
+		if rules.Match == "any" {
+			return slices.ContainsFunc(rules.Rules, func(r SmartRule) bool { return r.matches(t, now) })
+		}
+		for _, r := range rules.Rules {
+			if !r.matches(t, now) {
+				return false
+			}
+		}
+		return true
+	})
+
+	sortLibraryTracks(matched, rules.Sort, rules.Order == "desc")
+	if rules.Limit > 0 && len(matched) > rules.Limit {
+		matched = matched[:rules.Limit]
+	}
+
+	tracks := make([]Track, len(matched))
+	for i, t := range matched {
+		tracks[i] = trackFromLibraryRow(t)
+	}
+	return tracks, nil
+}
+
+func trackFromLibraryRow(t queries.ListLibraryTracksRow) Track {
+	return Track{
+		TrackID:          t.TrackID,
+		TrackName:        t.TrackName,
+		Duration:         t.Duration,
+		Popularity:       t.Popularity,
+		AlbumID:          t.AlbumID,
+		AlbumName:        t.AlbumName,
+		ArtistID:         t.ArtistID,
+		ArtistName:       t.ArtistName,
+		Artists:          t.Artists,
+		CoverURL:         t.CoverUrl,
+		Downloaded:       t.Downloaded,
+		TrackReleaseDate: t.TrackReleaseDate,
+		PlayCount:        t.PlayCount,
+		LikedAt:          t.LikedAt,
+		Rating:           t.Rating,
+	}
+}
+
+func sortLibraryTracks(tracks []queries.ListLibraryTracksRow, by string, desc bool) {
+	if by == "random" {
+		rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+		return
+	}
+	slices.SortStableFunc(tracks, func(a, b queries.ListLibraryTracksRow) int {
+		var c int
+		switch by {
+		case "", "title":
+			c = strings.Compare(strings.ToLower(a.TrackName), strings.ToLower(b.TrackName))
+		case "artist":
+			c = strings.Compare(strings.ToLower(a.ArtistName), strings.ToLower(b.ArtistName))
+		case "album":
+			c = strings.Compare(strings.ToLower(a.AlbumName), strings.ToLower(b.AlbumName))
+		case "release_date":
+			c = a.TrackReleaseDate.Time.Compare(b.TrackReleaseDate.Time)
+		case "added_at":
+			c = a.AddedAt.Time.Compare(b.AddedAt.Time)
+		case "play_count":
+			c = cmp.Compare(a.PlayCount, b.PlayCount)
+		case "last_played":
+			c = a.LastPlayedAt.Time.Compare(b.LastPlayedAt.Time)
+		case "popularity":
+			c = cmp.Compare(a.Popularity, b.Popularity)
+		case "duration":
+			c = cmp.Compare(a.Duration, b.Duration)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+// CreateSmartPlaylist creates a new smart playlist and returns its ID and any error if encountered.
+func (l *Library) CreateSmartPlaylist(ctx context.Context, userID pgtype.UUID, name, description, imageURL string, rules SmartRules) (string, error) {
+	if err := rules.Validate(); err != nil {
+		return "", err
+	}
+	exists, err := l.queries.PlaylistWithNameExists(ctx, queries.PlaylistWithNameExistsParams{
+		Name:   name,
+		UserID: userID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("check if playlist with name exists: %w", err)
+	}
+	if exists {
+		return "", fmt.Errorf("playlist names must be unique")
+	}
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return "", fmt.Errorf("encode rules: %w", err)
+	}
+	id, err := l.queries.CreateSmartPlaylist(ctx, queries.CreateSmartPlaylistParams{
+		Name:        name,
+		Description: description,
+		ImageUrl:    imageURL,
+		Rules:       b,
+		UserID:      userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	l.emit(userID, EventPlaylistCreated, playlistEvent{PlaylistID: id, Name: name})
+	return id.String(), nil
+}
+
+// UpdateSmartPlaylistRules replaces the rules of the specified smart playlist.
+func (l *Library) UpdateSmartPlaylistRules(ctx context.Context, userID pgtype.UUID, playlistID string, rules SmartRules) error {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return err
+	}
+	if playlist.Rules == nil {
+		return fmt.Errorf("playlist is not a smart playlist")
+	}
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return fmt.Errorf("encode rules: %w", err)
+	}
+	err = l.queries.UpdatePlaylistRules(ctx, queries.UpdatePlaylistRulesParams{
+		Rules: b,
+		ID:    playlist.ID,
+	})
+	if err != nil {
+		return err
+	}
+	l.emitPlaylistChanged(userID, playlist, "rules", "")
+	return nil
+}
+
+// SnapshotSmartPlaylist creates a regular playlist named name with the tracks the smart playlist
+// currently evaluates to, and returns the ID of the new playlist.
+func (l *Library) SnapshotSmartPlaylist(ctx context.Context, userID pgtype.UUID, playlistID, name string) (string, error) {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return "", err
+	}
+	if playlist.Rules == nil {
+		return "", fmt.Errorf("playlist is not a smart playlist")
+	}
+	var rules SmartRules
+	if err := json.Unmarshal(playlist.Rules, &rules); err != nil {
+		return "", fmt.Errorf("decode rules: %w", err)
+	}
+	tracks, err := l.evaluateSmartRules(ctx, userID, rules)
+	if err != nil {
+		return "", err
+	}
+
+	// in one transaction so a snapshot that fails partway doesn't leave a playlist holding the name
+	var snapshotID pgtype.UUID
+	err = l.inTx(ctx, func(q *queries.Queries) error {
+		snapshotID, err = createPlaylist(ctx, q, userID, name, playlist.Description, playlist.ImageUrl)
+		if err != nil {
+			return err
+		}
+		for i, t := range tracks {
+			err := q.AddTrackToPlaylist(ctx, queries.AddTrackToPlaylistParams{
+				PlaylistID: snapshotID,
+				TrackID:    t.TrackID,
+				Position:   int32(i),
+			})
+			if err != nil {
+				return fmt.Errorf("add track to snapshot: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	l.emit(userID, EventPlaylistCreated, playlistEvent{PlaylistID: snapshotID, Name: name})
+	return snapshotID.String(), nil
+}
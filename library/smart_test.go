@@ -0,0 +1,88 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSmartRulesValidate(t *testing.T) {
+	rules := SmartRules{Rules: []SmartRule{{Type: RuleNeverPlayed}}}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rules.Match != "all" || rules.Order != "asc" {
+		t.Errorf("defaults = match %q and order %q, want all and asc", rules.Match, rules.Order)
+	}
+
+	for _, bad := range []SmartRules{
+		{},
+		{Match: "some", Rules: []SmartRule{{Type: RuleNeverPlayed}}},
+		{Rules: []SmartRule{{Type: "loud"}}},
+		{Rules: []SmartRule{{Type: RuleArtistIs, Artist: " "}}},
+		{Rules: []SmartRule{{Type: RuleReleaseYearBetween, From: 2020, To: 2010}}},
+		{Rules: []SmartRule{{Type: RuleSkippedMoreThan, Percent: 100}}},
+		{Rules: []SmartRule{{Type: RuleNeverPlayed}}, Sort: "mood"},
+		{Rules: []SmartRule{{Type: RuleNeverPlayed}}, Limit: maxSmartPlaylistLimit + 1},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v is valid", bad)
+		}
+	}
+}
+
+func TestSmartPlaylists(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		user := testUser(t, l, "alice")
+		testTracks(t, l, "a", "b", "c")
+		testPlay(t, l, user.ID, "a", "2024-03-09 12:00:00", 0)
+		testPlay(t, l, user.ID, "a", "2024-03-09 12:10:00", 0)
+		testPlay(t, l, user.ID, "a", "2024-03-09 12:20:00", 0)
+		testPlay(t, l, user.ID, "b", "2024-03-09 13:00:00", 10)
+
+		for i, tt := range []struct {
+			rules SmartRules
+			want  []string
+		}{
+			{SmartRules{Rules: []SmartRule{{Type: RulePlayCountAbove}}, Sort: "play_count", Order: "desc"}, []string{"a", "b"}},
+			{SmartRules{Rules: []SmartRule{{Type: RuleNeverPlayed}}}, []string{"c"}},
+			{SmartRules{Rules: []SmartRule{{Type: RuleSkippedMoreThan, Percent: 50}}}, []string{"b"}},
+			{SmartRules{Match: "any", Rules: []SmartRule{{Type: RuleArtistIs, Artist: "artist c"}, {Type: RulePlayCountAbove, Count: 2}}}, []string{"a", "c"}},
+			{SmartRules{Rules: []SmartRule{{Type: RuleDownloaded}}, Sort: "title", Order: "desc", Limit: 2}, []string{"c", "b"}},
+		} {
+			id, err := l.CreateSmartPlaylist(ctx, user.ID, fmt.Sprint("smart ", i), "", "https://example.com/smart.png", tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			playlist, err := l.GetPlaylist(ctx, user.ID, id, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, track := range playlist.Tracks.([]Track) {
+				got = append(got, track.TrackID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%+v: tracks = %v, want %v", tt.rules, got, tt.want)
+			}
+		}
+
+		// a snapshot is a regular playlist with the tracks the rules evaluate to when it's taken
+		smartID, err := l.CreateSmartPlaylist(ctx, user.ID, "played", "", "https://example.com/smart.png", SmartRules{Rules: []SmartRule{{Type: RulePlayCountAbove}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshotID, err := l.SnapshotSmartPlaylist(ctx, user.ID, smartID, "played in march")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testPlaylistTrackIDs(t, l, user.ID, snapshotID); fmt.Sprint(got) != "[a b]" {
+			t.Errorf("snapshot tracks = %v, want [a b]", got)
+		}
+		if _, err := l.SnapshotSmartPlaylist(ctx, user.ID, smartID, "played in march"); err == nil {
+			t.Error("a snapshot took the name of another playlist")
+		}
+	})
+}
@@ -43,7 +43,7 @@ func (t *spotifyToken) getToken(ctx context.Context) error {
 	req.SetBasicAuth(env.DefaultEnv.SpotifyClientID, env.DefaultEnv.SpotifyClientSecret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doSpotifyRequest(req, "token")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,219 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// StatsWindow is the time range that listening statistics are computed over. statistics only ever
+// include the plays of one user. From is inclusive and To is exclusive. Location is used for anything
+// that depends on the time of day (hour of day, weekday and streaks), it defaults to UTC.
+type StatsWindow struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+}
+
+func (w StatsWindow) start() time.Time {
+	return w.From.UTC()
+}
+
+func (w StatsWindow) end() time.Time {
+	if w.To.IsZero() {
+		return time.Now().UTC()
+	}
+	return w.To.UTC()
+}
+
+func (w StatsWindow) timeZone() string {
+	if w.Location == nil {
+		return "UTC"
+	}
+	return w.Location.String()
+}
+
+// Streaks describes consecutive days with at least one play.
+type Streaks struct {
+	// Current is the streak that includes today (or yesterday, since today may not have any plays yet).
+	Current      int       `json:"current"`
+	CurrentStart time.Time `json:"current_start,omitzero"`
+	Longest      int       `json:"longest"`
+	LongestStart time.Time `json:"longest_start,omitzero"`
+	LongestEnd   time.Time `json:"longest_end,omitzero"`
+	// DaysListened is the number of distinct days with at least one play.
+	DaysListened int `json:"days_listened"`
+}
+
+// TopTracks returns the most played tracks in the window.
+func (l *Library) TopTracks(ctx context.Context, userID pgtype.UUID, w StatsWindow, limit int32) ([]queries.TopTracksRow, error) {
+	rows, err := l.queries.TopTracks(ctx, queries.TopTracksParams{
+		UserID:     userID,
+		StartTime:  opttime(w.start()),
+		EndTime:    opttime(w.end()),
+		MaxResults: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("top tracks: %w", err)
+	}
+	return rows, nil
+}
+
+// TopArtists returns the most played artists in the window. Plays are attributed to the primary
+// artist of a track.
+func (l *Library) TopArtists(ctx context.Context, userID pgtype.UUID, w StatsWindow, limit int32) ([]queries.TopArtistsRow, error) {
+	rows, err := l.queries.TopArtists(ctx, queries.TopArtistsParams{
+		UserID:     userID,
+		StartTime:  opttime(w.start()),
+		EndTime:    opttime(w.end()),
+		MaxResults: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("top artists: %w", err)
+	}
+	return rows, nil
+}
+
+// TopAlbums returns the most played albums in the window.
+func (l *Library) TopAlbums(ctx context.Context, userID pgtype.UUID, w StatsWindow, limit int32) ([]queries.TopAlbumsRow, error) {
+	rows, err := l.queries.TopAlbums(ctx, queries.TopAlbumsParams{
+		UserID:     userID,
+		StartTime:  opttime(w.start()),
+		EndTime:    opttime(w.end()),
+		MaxResults: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("top albums: %w", err)
+	}
+	return rows, nil
+}
+
+// ListeningTotals returns the number of plays, skips, distinct tracks and seconds listened in the window.
+// a skipped play counts up to the second it was skipped at, otherwise the full track duration is counted.
+func (l *Library) ListeningTotals(ctx context.Context, userID pgtype.UUID, w StatsWindow) (queries.ListeningTotalsRow, error) {
+	totals, err := l.queries.ListeningTotals(ctx, queries.ListeningTotalsParams{
+		UserID:    userID,
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return queries.ListeningTotalsRow{}, fmt.Errorf("listening totals: %w", err)
+	}
+	return totals, nil
+}
+
+// SkipRates returns the tracks with the highest skip rate in the window. tracks played less than
+// minPlays times are left out so a single skip doesn't dominate the list.
+func (l *Library) SkipRates(ctx context.Context, userID pgtype.UUID, w StatsWindow, minPlays int64, limit int32) ([]queries.SkipRatesRow, error) {
+	rows, err := l.queries.SkipRates(ctx, queries.SkipRatesParams{
+		UserID:     userID,
+		StartTime:  opttime(w.start()),
+		EndTime:    opttime(w.end()),
+		MinPlays:   minPlays,
+		MaxResults: limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("skip rates: %w", err)
+	}
+	return rows, nil
+}
+
+// ListeningByHour returns plays grouped by the hour of day (0-23) in the window's location.
+// hours without plays are included with zero values.
+func (l *Library) ListeningByHour(ctx context.Context, userID pgtype.UUID, w StatsWindow) ([]queries.ListeningByHourRow, error) {
+	rows, err := l.queries.ListeningByHour(ctx, queries.ListeningByHourParams{
+		UserID:    userID,
+		TimeZone:  w.timeZone(),
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listening by hour: %w", err)
+	}
+	hours := make([]queries.ListeningByHourRow, 24)
+	for i := range hours {
+		hours[i].Hour = int32(i)
+	}
+	for _, row := range rows {
+		hours[row.Hour] = row
+	}
+	return hours, nil
+}
+
+// ListeningByWeekday returns plays grouped by weekday (0 is sunday, like time.Weekday) in the
+// window's location. weekdays without plays are included with zero values.
+func (l *Library) ListeningByWeekday(ctx context.Context, userID pgtype.UUID, w StatsWindow) ([]queries.ListeningByWeekdayRow, error) {
+	rows, err := l.queries.ListeningByWeekday(ctx, queries.ListeningByWeekdayParams{
+		UserID:    userID,
+		TimeZone:  w.timeZone(),
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listening by weekday: %w", err)
+	}
+	weekdays := make([]queries.ListeningByWeekdayRow, 7)
+	for i := range weekdays {
+		weekdays[i].Weekday = int32(i)
+	}
+	for _, row := range rows {
+		weekdays[row.Weekday] = row
+	}
+	return weekdays, nil
+}
+
+// Streaks returns the current and longest listening streaks in the window.
+func (l *Library) Streaks(ctx context.Context, userID pgtype.UUID, w StatsWindow) (Streaks, error) {
+	days, err := l.queries.ListeningDays(ctx, queries.ListeningDaysParams{
+		UserID:    userID,
+		TimeZone:  w.timeZone(),
+		StartTime: opttime(w.start()),
+		EndTime:   opttime(w.end()),
+	})
+	if err != nil {
+		return Streaks{}, fmt.Errorf("listening days: %w", err)
+	}
+
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	y, m, d := w.end().In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return computeStreaks(days, today), nil
+}
+
+// computeStreaks computes streaks from a sorted list of distinct days. today must be a midnight UTC
+// date in the same calendar as days.
+func computeStreaks(days []pgtype.Date, today time.Time) Streaks {
+	var s Streaks
+	var runStart, prev time.Time
+	run := 0
+	for _, day := range days {
+		if !day.Valid {
+			continue
+		}
+		s.DaysListened++
+		if run > 0 && day.Time.Sub(prev) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+			runStart = day.Time
+		}
+		prev = day.Time
+		if run > s.Longest {
+			s.Longest = run
+			s.LongestStart = runStart
+			s.LongestEnd = day.Time
+		}
+	}
+	// the last run is only current if it reaches today or yesterday
+	if run > 0 && today.Sub(prev) <= 24*time.Hour {
+		s.Current = run
+		s.CurrentStart = runStart
+	}
+	return s
+}
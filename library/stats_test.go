@@ -0,0 +1,120 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// testPlay records a play at a time in UTC, skipped at skippedAt seconds if it isn't 0.
+func testPlay(t *testing.T, l *Library, userID pgtype.UUID, trackID string, playedAt string, skippedAt int32) {
+	at, err := time.Parse(time.DateTime, playedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := queries.RecordPlayParams{TrackID: trackID, PlayedAt: opttime(at), UserID: userID}
+	if skippedAt != 0 {
+		params.SkippedAt = optint32(skippedAt)
+	}
+	if _, err := l.queries.RecordPlay(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatsTimeZones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		user := testUser(t, l, "alice")
+		other := testUser(t, l, "bob")
+		testTracks(t, l, "a", "b")
+		// new york moved its clocks forward at 2024-03-10 07:00 UTC, from UTC-5 to UTC-4
+		testPlay(t, l, user.ID, "a", "2024-03-09 23:30:00", 0)  // saturday 18:30 in new york
+		testPlay(t, l, user.ID, "a", "2024-03-10 06:30:00", 0)  // sunday 01:30
+		testPlay(t, l, user.ID, "a", "2024-03-10 08:00:00", 0)  // sunday 04:00
+		testPlay(t, l, user.ID, "b", "2024-03-12 02:00:00", 30) // monday 22:00
+		testPlay(t, l, other.ID, "b", "2024-03-10 12:00:00", 0)
+
+		end := time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+		utc := StatsWindow{To: end}
+		ny := StatsWindow{To: end, Location: newYork}
+
+		totals, err := l.ListeningTotals(ctx, user.ID, utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (queries.ListeningTotalsRow{PlayCount: 4, SkipCount: 1, TrackCount: 2, SecondsListened: 3*180 + 30}); totals != want {
+			t.Errorf("totals = %+v, want %+v", totals, want)
+		}
+		totals, err = l.ListeningTotals(ctx, user.ID, StatsWindow{From: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), To: end})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totals.PlayCount != 3 {
+			t.Errorf("plays from march 10 = %d, want 3", totals.PlayCount)
+		}
+
+		top, err := l.TopTracks(ctx, user.ID, utc, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(top) != 2 || top[0].TrackID != "a" || top[0].PlayCount != 3 || top[1].TrackID != "b" || top[1].SecondsListened != 30 {
+			t.Errorf("top tracks = %+v", top)
+		}
+
+		for _, tt := range []struct {
+			w                StatsWindow
+			hours, weekdays  string
+			longest, current int
+		}{
+			// hour:plays/seconds and weekday:plays (0 is sunday) that have plays
+			{utc, "2:1/30 6:1/180 8:1/180 23:1/180", "0:2 2:1 6:1", 2, 1},
+			{ny, "1:1/180 4:1/180 18:1/180 22:1/30", "0:2 1:1 6:1", 3, 3},
+		} {
+			hours, err := l.ListeningByHour(ctx, user.ID, tt.w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotHours string
+			for _, h := range hours {
+				if h.PlayCount > 0 {
+					gotHours += fmt.Sprintf(" %d:%d/%d", h.Hour, h.PlayCount, h.SecondsListened)
+				}
+			}
+			if len(hours) != 24 || strings.TrimSpace(gotHours) != tt.hours {
+				t.Errorf("%s: by hour = %s (%d hours), want %s", tt.w.timeZone(), gotHours, len(hours), tt.hours)
+			}
+
+			weekdays, err := l.ListeningByWeekday(ctx, user.ID, tt.w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotWeekdays string
+			for _, d := range weekdays {
+				if d.PlayCount > 0 {
+					gotWeekdays += fmt.Sprintf(" %d:%d", d.Weekday, d.PlayCount)
+				}
+			}
+			if len(weekdays) != 7 || strings.TrimSpace(gotWeekdays) != tt.weekdays {
+				t.Errorf("%s: by weekday = %s, want %s", tt.w.timeZone(), gotWeekdays, tt.weekdays)
+			}
+
+			streaks, err := l.Streaks(ctx, user.ID, tt.w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if streaks.Longest != tt.longest || streaks.Current != tt.current || streaks.DaysListened != 3 {
+				t.Errorf("%s: streaks = %+v, want longest %d and current %d of 3 days", tt.w.timeZone(), streaks, tt.longest, tt.current)
+			}
+		}
+	})
+}
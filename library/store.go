@@ -0,0 +1,231 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// Store is the database the library is kept in: postgres (NewPostgresStore) or sqlite (package db/sqlite).
+// both run the same queries (package db).
+type Store interface {
+	// Queries returns the queries, which run outside of a transaction.
+	Queries() *queries.Queries
+	// InTx runs fn in a transaction, which is committed if fn doesn't return an error.
+	InTx(ctx context.Context, fn func(q *queries.Queries) error) error
+	// Migrate brings the schema of the database up to date.
+	Migrate(ctx context.Context) error
+	// Ping checks that the database can be used.
+	Ping(ctx context.Context) error
+	// Backend is the name of the database. a backup can only be restored into the same one.
+	Backend() string
+	// Backup calls add with each file of a consistent copy of the database.
+	Backup(ctx context.Context, add func(name string, data []byte) error) error
+	// Restore replaces the contents of the database with the files of a backup.
+	Restore(ctx context.Context, files map[string][]byte) error
+	Close()
+}
+
+// PostgresStore is a Store on a postgres database.
+type PostgresStore struct {
+	pool    *pgxpool.Pool
+	queries *queries.Queries
+	schema  string
+}
+
+// NewPostgresStore returns a store on the database of pool. schema (schema.sql) is run by Migrate.
+func NewPostgresStore(pool *pgxpool.Pool, schema string) *PostgresStore {
+	return &PostgresStore{pool: pool, queries: queries.New(pool), schema: schema}
+}
+
+func (s *PostgresStore) Queries() *queries.Queries {
+	return s.queries
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(q *queries.Queries) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op after commit
+
+	if err := fn(s.queries.WithTx(tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Migrate runs the schema, which only creates and alters what doesn't exist yet so it can be run on a
+// database of any version. it runs at every start, so it takes a lock for instances starting at once.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op after commit
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('music schema'))"); err != nil {
+		return fmt.Errorf("lock schema: %w", err)
+	}
+	// without arguments pgx uses the simple protocol, which allows more than one statement
+	if _, err := tx.Exec(ctx, s.schema); err != nil {
+		return fmt.Errorf("run schema: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *PostgresStore) Backend() string {
+	return "postgres"
+}
+
+// Backup adds the rows of each table as CSV with a header (COPY ... WITH (FORMAT csv, HEADER)) as
+// <table>.csv. the tables are read in one transaction so they're consistent.
+func (s *PostgresStore) Backup(ctx context.Context, add func(name string, data []byte) error) error {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tables, err := tablesInDependencyOrder(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		var buf bytes.Buffer
+		sql := fmt.Sprintf("COPY %s TO STDOUT WITH (FORMAT csv, HEADER)", pgx.Identifier{table}.Sanitize())
+		if _, err := tx.Conn().PgConn().CopyTo(ctx, &buf, sql); err != nil {
+			return fmt.Errorf("copy table %s: %w", table, err)
+		}
+		if err := add(table+".csv", buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore empties the tables of the backup and copies its rows into them, in one transaction so the
+// database is left as it was if it fails. the rows are copied by column name so a backup can be restored
+// into a database whose columns are in another order (as they are when columns were added by migrations).
+func (s *PostgresStore) Restore(ctx context.Context, files map[string][]byte) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op after commit
+
+	tables, err := tablesInDependencyOrder(ctx, tx)
+	if err != nil {
+		return err
+	}
+	var restored, names []string
+	for _, t := range tables {
+		if _, ok := files[t+".csv"]; ok {
+			restored = append(restored, t)
+			names = append(names, pgx.Identifier{t}.Sanitize())
+		}
+	}
+	for name := range files {
+		if !slices.Contains(restored, strings.TrimSuffix(name, ".csv")) {
+			return fmt.Errorf("table %s isn't in the database", strings.TrimSuffix(name, ".csv"))
+		}
+	}
+	if len(names) > 0 {
+		if _, err := tx.Exec(ctx, "TRUNCATE "+strings.Join(names, ", ")+" CASCADE"); err != nil {
+			return fmt.Errorf("empty tables: %w", err)
+		}
+	}
+	for _, t := range restored {
+		if err := restoreTable(ctx, tx, t, files[t+".csv"]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+// restoreTable copies the CSV rows of a table into the database.
+func restoreTable(ctx context.Context, tx pgx.Tx, table string, data []byte) error {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	var columns []string
+	for c := range strings.SplitSeq(strings.TrimSpace(string(header)), ",") {
+		columns = append(columns, pgx.Identifier{strings.Trim(c, `"`)}.Sanitize())
+	}
+	sql := fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FORMAT csv, HEADER)", pgx.Identifier{table}.Sanitize(), strings.Join(columns, ", "))
+	if _, err := tx.Conn().PgConn().CopyFrom(ctx, bytes.NewReader(data), sql); err != nil {
+		return fmt.Errorf("restore table %s: %w", table, err)
+	}
+	return nil
+}
+
+// tablesInDependencyOrder returns the tables of the public schema ordered so that every table comes after
+// the tables its foreign keys reference.
+func tablesInDependencyOrder(ctx context.Context, tx pgx.Tx) ([]string, error) {
+	rows, _ := tx.Query(ctx, "SELECT tablename::text FROM pg_tables WHERE schemaname = 'public' ORDER BY tablename")
+	tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	rows, _ = tx.Query(ctx, `SELECT c.conrelid::regclass::text, c.confrelid::regclass::text
+FROM pg_constraint c
+WHERE c.contype = 'f' AND c.connamespace = 'public'::regnamespace`)
+	type reference struct{ From, To string }
+	refs, err := pgx.CollectRows(rows, pgx.RowToStructByPos[reference])
+	if err != nil {
+		return nil, fmt.Errorf("list foreign keys: %w", err)
+	}
+	dependsOn := make(map[string][]string)
+	for _, r := range refs {
+		if r.From != r.To { // a table referencing itself is fine, COPY checks the keys after all rows are in
+			dependsOn[r.From] = append(dependsOn[r.From], r.To)
+		}
+	}
+
+	var ordered []string
+	for len(ordered) < len(tables) {
+		progressed := false
+		for _, t := range tables {
+			if slices.Contains(ordered, t) {
+				continue
+			}
+			ready := true
+			for _, dep := range dependsOn[t] {
+				if !slices.Contains(ordered, dep) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, t)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil, errors.New("the foreign keys between the tables form a cycle")
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,150 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	queries "github.com/tiredkangaroo/music/db"
+	"github.com/tiredkangaroo/music/db/sqlite"
+)
+
+// testPostgresURL is the environment variable with the postgres database the tests also run on if it's set.
+// the tests empty it, so it has to be a database just for them.
+const testPostgresURL = "MUSIC_TEST_POSTGRES_URL"
+
+// forEachDatabase runs test on a library in a new sqlite database, and in the postgres database of
+// MUSIC_TEST_POSTGRES_URL.
+func forEachDatabase(t *testing.T, test func(t *testing.T, l *Library)) {
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "music.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, newTestLibrary(t, store))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv(testPostgresURL)
+		if url == "" {
+			t.Skip(testPostgresURL + " isn't set")
+		}
+		test(t, newTestLibrary(t, openTestPostgres(t, url)))
+	})
+}
+
+// openTestPostgres migrates the postgres database and empties its tables.
+func openTestPostgres(t *testing.T, url string) *PostgresStore {
+	ctx := context.Background()
+	schema, err := os.ReadFile("../schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewPostgresStore(pool, string(schema))
+	if err := store.Migrate(ctx); err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		tables, err := tablesInDependencyOrder(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for i, table := range tables {
+			tables[i] = pgx.Identifier{table}.Sanitize()
+		}
+		_, err = tx.Exec(ctx, "TRUNCATE "+strings.Join(tables, ", ")+" CASCADE")
+		return err
+	})
+	if err != nil {
+		store.Close()
+		t.Fatalf("empty tables: %v", err)
+	}
+	return store
+}
+
+// newTestLibrary returns a library on the store, which is shut down (so the downloads it started in the
+// background are done) and closed at the end of the test.
+func newTestLibrary(t *testing.T, store Store) *Library {
+	l := NewLibrary(t.TempDir(), store)
+	t.Cleanup(func() {
+		l.Shutdown(context.Background())
+		store.Close()
+	})
+	return l
+}
+
+// testUser creates a user (the admin, if it's the first).
+func testUser(t *testing.T, l *Library, username string) User {
+	ctx := context.Background()
+	needsSetup, err := l.NeedsSetup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if needsSetup {
+		user, err = l.Setup(ctx, username, "password")
+	} else {
+		user, err = l.CreateUser(ctx, username, "password")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testTracks adds downloaded tracks with the ids (each 3 minutes long, by artist-<id> on album-<id>), with
+// empty audio files so nothing tries to download them.
+func testTracks(t *testing.T, l *Library, ids ...string) {
+	for _, id := range ids {
+		err := l.queries.InsertTrack(context.Background(), queries.InsertTrackParams{
+			ArtistID:         "artist-" + id,
+			ArtistName:       "Artist " + id,
+			AlbumID:          "album-" + id,
+			AlbumName:        "Album " + id,
+			AlbumReleaseDate: optdate(time.Time{}),
+			TrackID:          id,
+			TrackName:        "Track " + id,
+			Duration:         180,
+			Artists:          []string{"Artist " + id},
+			TrackReleaseDate: optdate(time.Time{}),
+			Downloaded:       true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(l.PathToTrackFile(id), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testPlaylistTrackIDs returns the tracks of a regular playlist in order.
+func testPlaylistTrackIDs(t *testing.T, l *Library, userID pgtype.UUID, playlistID string) []string {
+	playlist, err := l.GetPlaylistByID(context.Background(), userID, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := l.queries.ListPlaylistTrackIDs(context.Background(), playlist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func playlistNames(playlists []queries.Playlist) []string {
+	names := make([]string, len(playlists))
+	for i, p := range playlists {
+		names[i] = p.Name
+	}
+	return names
+}
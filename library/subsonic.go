@@ -0,0 +1,133 @@
+package library
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// SetSubsonicPassword generates a new password the user logs in to subsonic clients with (replacing the old
+// one). subsonic clients send md5(password + salt), so it can't be the login password, which is only kept
+// hashed.
+func (l *Library) SetSubsonicPassword(ctx context.Context, userID pgtype.UUID) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate password: %w", err)
+	}
+	password := hex.EncodeToString(b) // hex so it's easy to type into a client
+	err := l.queries.SetSubsonicPassword(ctx, queries.SetSubsonicPasswordParams{
+		UserID:   userID,
+		Password: password,
+	})
+	if err != nil {
+		return "", fmt.Errorf("set subsonic password: %w", err)
+	}
+	return password, nil
+}
+
+// SubsonicLogin checks the credentials a subsonic client sent: either token (md5(password + salt) in hex) and
+// salt, or the password itself. it returns ErrInvalidCredentials if they're wrong or the user hasn't set a
+// subsonic password.
+func (l *Library) SubsonicLogin(ctx context.Context, username, token, salt, password string) (User, error) {
+	user, err := l.queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, fmt.Errorf("get user: %w", err)
+	}
+	stored, err := l.queries.GetSubsonicPassword(ctx, user.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, fmt.Errorf("get subsonic password: %w", err)
+	}
+
+	var expected, got string
+	if token != "" {
+		sum := md5.Sum([]byte(stored + salt))
+		expected, got = hex.EncodeToString(sum[:]), strings.ToLower(token)
+	} else {
+		expected, got = stored, password
+		if enc, ok := strings.CutPrefix(password, "enc:"); ok {
+			b, err := hex.DecodeString(enc)
+			if err != nil {
+				return User{}, ErrInvalidCredentials
+			}
+			got = string(b)
+		}
+	}
+	if got == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+		return User{}, ErrInvalidCredentials
+	}
+	return userFromRow(user), nil
+}
+
+// LibraryTracks returns every track in the user's library.
+func (l *Library) LibraryTracks(ctx context.Context, userID pgtype.UUID) ([]Track, error) {
+	rows, err := l.queries.ListLibraryTracks(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list library tracks: %w", err)
+	}
+	tracks := make([]Track, len(rows))
+	for i, row := range rows {
+		tracks[i] = trackFromLibraryRow(row)
+	}
+	return tracks, nil
+}
+
+// AlbumTracks returns the known tracks of the album, which are the ones in someone's library or seen in
+// search results (not necessarily the whole album).
+func (l *Library) AlbumTracks(ctx context.Context, userID pgtype.UUID, albumID string) ([]Track, error) {
+	rows, err := l.queries.ListAlbumTracks(ctx, queries.ListAlbumTracksParams{
+		UserID:  userID,
+		AlbumID: albumID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list album tracks: %w", err)
+	}
+	tracks := make([]Track, len(rows))
+	for i, row := range rows {
+		tracks[i] = trackFromLibraryRow(queries.ListLibraryTracksRow(row))
+	}
+	return tracks, nil
+}
+
+// PlaylistTracks returns the playlist along with its tracks in playlist order (or evaluated from the rules
+// of a smart playlist).
+func (l *Library) PlaylistTracks(ctx context.Context, userID pgtype.UUID, playlistID string) (queries.Playlist, []Track, error) {
+	playlist, err := l.GetPlaylistByID(ctx, userID, playlistID)
+	if err != nil {
+		return queries.Playlist{}, nil, err
+	}
+	ids, err := l.playlistTrackIDs(ctx, playlist)
+	if err != nil {
+		return queries.Playlist{}, nil, err
+	}
+	rows, err := l.queries.GetTracksByIDs(ctx, queries.GetTracksByIDsParams{
+		UserID:   userID,
+		TrackIds: ids,
+	})
+	if err != nil {
+		return queries.Playlist{}, nil, fmt.Errorf("get playlist tracks: %w", err)
+	}
+	byID := make(map[string]Track, len(rows))
+	for _, row := range rows {
+		byID[row.TrackID] = trackFromLibraryRow(queries.ListLibraryTracksRow(row))
+	}
+	tracks := make([]Track, 0, len(ids))
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return playlist, tracks, nil
+}
@@ -0,0 +1,80 @@
+package library
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestSubsonicLogin(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		alice := testUser(t, l, "alice")
+		if _, err := l.SubsonicLogin(ctx, "alice", "", "", "password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login without a subsonic password: err = %v, want ErrInvalidCredentials", err)
+		}
+
+		password, err := l.SetSubsonicPassword(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum([]byte(password + "salt"))
+		for _, tt := range []struct {
+			name, username, token, salt, password string
+			ok                                    bool
+		}{
+			{"token", "alice", hex.EncodeToString(sum[:]), "salt", "", true},
+			{"token with another salt", "alice", hex.EncodeToString(sum[:]), "pepper", "", false},
+			{"password", "alice", "", "", password, true},
+			{"hex password", "alice", "", "", "enc:" + hex.EncodeToString([]byte(password)), true},
+			{"login password", "alice", "", "", "password", false},
+			{"no password", "alice", "", "", "", false},
+			{"other user", "bob", "", "", password, false},
+		} {
+			user, err := l.SubsonicLogin(ctx, tt.username, tt.token, tt.salt, tt.password)
+			if tt.ok && (err != nil || user.ID != alice.ID) {
+				t.Errorf("%s: logged in as %+v, %v, want alice", tt.name, user, err)
+			} else if !tt.ok && !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("%s: err = %v, want ErrInvalidCredentials", tt.name, err)
+			}
+		}
+	})
+}
+
+func TestSubsonicPlaylistTracks(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		alice := testUser(t, l, "alice")
+		bob := testUser(t, l, "bob")
+		testTracks(t, l, "a", "b", "c")
+		playlistID, err := l.CreatePlaylist(ctx, alice.ID, "mix", "a mix", "https://example.com/mix.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"c", "a", "b"} {
+			if err := l.AddTrackToPlaylist(ctx, alice.ID, playlistID, id, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		playlist, tracks, err := l.PlaylistTracks(ctx, alice.ID, playlistID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, track := range tracks {
+			got = append(got, track.TrackID)
+		}
+		if playlist.Name != "mix" || fmt.Sprint(got) != "[c a b]" {
+			t.Errorf("playlist %s has tracks %v, want mix with [c a b]", playlist.Name, got)
+		}
+		if _, _, err := l.PlaylistTracks(ctx, bob.ID, playlistID); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("bob getting alice's playlist: err = %v, want ErrNoRows", err)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// TagTargets are the kinds of things that can be tagged.
+var TagTargets = []string{"track", "album", "artist"}
+
+// maxTagLength is the longest a tag may be.
+const maxTagLength = 40
+
+// AddTag puts the tag on the track, album or artist. tags are case insensitive (they're stored lowercase)
+// and adding a tag that's already there does nothing.
+func (l *Library) AddTag(ctx context.Context, targetType, targetID, tag string) error {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	if err := l.checkTagTarget(ctx, targetType, targetID); err != nil {
+		return err
+	}
+	err = l.queries.AddTag(ctx, queries.AddTagParams{
+		Tag:        tag,
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+	if err != nil {
+		return fmt.Errorf("add tag: %w", err)
+	}
+	return nil
+}
+
+// RemoveTag removes the tag from the track, album or artist.
+func (l *Library) RemoveTag(ctx context.Context, targetType, targetID, tag string) error {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	err = l.queries.RemoveTag(ctx, queries.RemoveTagParams{
+		Tag:        tag,
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+	if err != nil {
+		return fmt.Errorf("remove tag: %w", err)
+	}
+	return nil
+}
+
+// TagsOf returns the tags of the track, album or artist sorted by name.
+func (l *Library) TagsOf(ctx context.Context, targetType, targetID string) ([]string, error) {
+	if err := l.checkTagTarget(ctx, targetType, targetID); err != nil {
+		return nil, err
+	}
+	tags, err := l.queries.ListTagsOf(ctx, queries.ListTagsOfParams{
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	return tags, nil
+}
+
+// ListTags returns every tag and how many things have it.
+func (l *Library) ListTags(ctx context.Context) ([]queries.ListTagsRow, error) {
+	tags, err := l.queries.ListTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	return tags, nil
+}
+
+// ListGenres returns the genres in the user's library and how many tracks each has.
+func (l *Library) ListGenres(ctx context.Context, userID pgtype.UUID) ([]queries.ListGenresRow, error) {
+	genres, err := l.queries.ListGenres(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list genres: %w", err)
+	}
+	return genres, nil
+}
+
+// BrowseTracks returns the tracks in the user's library with the tag (on the track, its album or its artist) or the
+// genre (of its artist). exactly one of tag and genre must be given.
+func (l *Library) BrowseTracks(ctx context.Context, userID pgtype.UUID, tag, genre string) ([]Track, error) {
+	if (tag == "") == (genre == "") {
+		return nil, fmt.Errorf("either a tag or a genre is required")
+	}
+	var rows []queries.ListLibraryTracksRow
+	if tag != "" {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		tagged, err := l.queries.ListTracksByTag(ctx, queries.ListTracksByTagParams{
+			UserID: userID,
+			Tag:    tag,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list tracks by tag: %w", err)
+		}
+		for _, row := range tagged {
+			rows = append(rows, queries.ListLibraryTracksRow(row))
+		}
+	} else {
+		inGenre, err := l.queries.ListTracksByGenre(ctx, queries.ListTracksByGenreParams{
+			UserID: userID,
+			Genre:  genre,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list tracks by genre: %w", err)
+		}
+		for _, row := range inGenre {
+			rows = append(rows, queries.ListLibraryTracksRow(row))
+		}
+	}
+
+	tracks := make([]Track, len(rows))
+	for i, row := range rows {
+		tracks[i] = trackFromLibraryRow(row)
+	}
+	return tracks, nil
+}
+
+func (l *Library) checkTagTarget(ctx context.Context, targetType, targetID string) error {
+	if !slices.Contains(TagTargets, targetType) {
+		return fmt.Errorf("only tracks, albums and artists can be tagged")
+	}
+	exists, err := l.queries.TagTargetExists(ctx, queries.TagTargetExistsParams{
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+	if err != nil {
+		return fmt.Errorf("check %s exists: %w", targetType, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s %s not found", targetType, targetID)
+	}
+	return nil
+}
+
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag is required")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tags may be at most %d characters", maxTagLength)
+	}
+	return tag, nil
+}
+
+// updateArtistGenres fetches the genres of the artist from spotify if they haven't been fetched yet.
+func (l *Library) updateArtistGenres(ctx context.Context, artistID string) error {
+	fetched, err := l.queries.ArtistGenresFetched(ctx, artistID)
+	if err != nil {
+		return fmt.Errorf("check artist genres: %w", err)
+	}
+	if fetched {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.spotify.com/v1/artists/"+url.PathEscape(artistID), nil)
+	if err != nil {
+		return fmt.Errorf("create get artist request: %w", err)
+	}
+	tk, err := l.spotifyToken.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("get spotify token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tk)
+
+	resp, err := doSpotifyRequest(req, "artist")
+	if err != nil {
+		return fmt.Errorf("perform get artist request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		slog.Error("get artist request failed", "status", resp.StatusCode, "body", string(b))
+		return fmt.Errorf("get artist request failed: status %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Genres []string `json:"genres"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("decode artist response: %w", err)
+	}
+	if data.Genres == nil {
+		data.Genres = []string{} // fetched, but the artist has none
+	}
+	err = l.queries.SetArtistGenres(ctx, queries.SetArtistGenresParams{
+		ArtistID: artistID,
+		Genres:   data.Genres,
+	})
+	if err != nil {
+		return fmt.Errorf("set artist genres: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	queries "github.com/tiredkangaroo/music/db"
+)
+
+// webhook events. they're sent to the webhooks of the user they happened to, which for download events is
+// the user who started the download (the tracks are shared, but not who downloads them).
+const (
+	EventDownloadCompleted = "download.completed" // a track was downloaded
+	EventDownloadFailed    = "download.failed"    // downloading a track failed
+	EventImportFinished    = "import.finished"    // a spotify playlist was imported
+	EventPlaylistCreated   = "playlist.created"
+	EventPlaylistChanged   = "playlist.changed" // its details, rules, tracks, track order or folder changed
+	EventPlaylistDeleted   = "playlist.deleted"
+	EventTrackPlayed       = "track.played"
+)
+
+// WebhookEvents are all the webhook events.
+var WebhookEvents = []string{
+	EventDownloadCompleted, EventDownloadFailed, EventImportFinished,
+	EventPlaylistCreated, EventPlaylistChanged, EventPlaylistDeleted, EventTrackPlayed,
+}
+
+const (
+	// webhookPollInterval is how often the deliveries that are due are looked for. new events are sent right
+	// away, this is for the retries.
+	webhookPollInterval = 5 * time.Second
+	// webhookBatchSize is how many deliveries are read at a time.
+	webhookBatchSize = 20
+	// webhookTimeout is how long a webhook has to respond.
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts is how many times a delivery is attempted before it fails. with the backoff
+	// (webhookRetryDelay) the last attempt is about an hour after the first.
+	webhookMaxAttempts = 8
+	// webhookCleanupInterval is how often deliveries older than a week are deleted.
+	webhookCleanupInterval = time.Hour
+)
+
+// Webhook is a webhook without its secret, which is only shown when it's created.
+type Webhook struct {
+	ID        pgtype.UUID      `json:"id"`
+	URL       string           `json:"url"`
+	Events    []string         `json:"events"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+// webhookPayload is the body of a webhook request.
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserID    pgtype.UUID `json:"user_id"`
+	Data      any         `json:"data"`
+}
+
+// CreateWebhook creates a webhook that's sent the events (see WebhookEvents) and returns its secret, which
+// signs the requests.
+func (l *Library) CreateWebhook(ctx context.Context, userID pgtype.UUID, webhookURL string, events []string) (string, Webhook, error) {
+	events, err := validateWebhook(webhookURL, events)
+	if err != nil {
+		return "", Webhook{}, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Webhook{}, fmt.Errorf("generate secret: %w", err)
+	}
+	secret := hex.EncodeToString(b)
+	row, err := l.queries.CreateWebhook(ctx, queries.CreateWebhookParams{
+		UserID: userID,
+		Url:    webhookURL,
+		Secret: secret,
+		Events: events,
+	})
+	if err != nil {
+		return "", Webhook{}, fmt.Errorf("create webhook: %w", err)
+	}
+	return secret, webhookFromRow(row), nil
+}
+
+// ListWebhooks returns the user's webhooks, oldest first.
+func (l *Library) ListWebhooks(ctx context.Context, userID pgtype.UUID) ([]Webhook, error) {
+	rows, err := l.queries.ListWebhooks(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	webhooks := make([]Webhook, len(rows))
+	for i, row := range rows {
+		webhooks[i] = webhookFromRow(row)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes the url and events of one of the user's webhooks. the secret stays the same.
+func (l *Library) UpdateWebhook(ctx context.Context, userID pgtype.UUID, webhookID, webhookURL string, events []string) error {
+	id, err := uuid.Parse(webhookID) // validate uuid
+	if err != nil {
+		return fmt.Errorf("invalid webhook id: %w", err)
+	}
+	events, err = validateWebhook(webhookURL, events)
+	if err != nil {
+		return err
+	}
+	n, err := l.queries.UpdateWebhook(ctx, queries.UpdateWebhookParams{
+		ID:     optuuid(id),
+		UserID: userID,
+		Url:    webhookURL,
+		Events: events,
+	})
+	if err != nil {
+		return fmt.Errorf("update webhook: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// DeleteWebhook deletes one of the user's webhooks and its deliveries.
+func (l *Library) DeleteWebhook(ctx context.Context, userID pgtype.UUID, webhookID string) error {
+	id, err := uuid.Parse(webhookID) // validate uuid
+	if err != nil {
+		return fmt.Errorf("invalid webhook id: %w", err)
+	}
+	n, err := l.queries.DeleteWebhook(ctx, queries.DeleteWebhookParams{
+		ID:     optuuid(id),
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// WebhookDeliveries returns the latest deliveries (at most limit) of one of the user's webhooks, newest
+// first. deliveries are kept for a week.
+func (l *Library) WebhookDeliveries(ctx context.Context, userID pgtype.UUID, webhookID string, limit int32) ([]queries.WebhookDelivery, error) {
+	webhook, err := l.getWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := l.queries.ListWebhookDeliveries(ctx, queries.ListWebhookDeliveriesParams{
+		WebhookID: webhook.ID,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery sends a failed delivery of one of the user's webhooks again.
+func (l *Library) RetryWebhookDelivery(ctx context.Context, userID pgtype.UUID, webhookID, deliveryID string) error {
+	webhook, err := l.getWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(deliveryID) // validate uuid
+	if err != nil {
+		return fmt.Errorf("invalid delivery id: %w", err)
+	}
+	n, err := l.queries.RetryWebhookDelivery(ctx, queries.RetryWebhookDeliveryParams{
+		ID:        optuuid(id),
+		WebhookID: webhook.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("retry webhook delivery: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("delivery not found (or it hasn't failed)")
+	}
+	l.wakeWebhooks()
+	return nil
+}
+
+// getWebhook returns the user's webhook. other users' webhooks are not found.
+func (l *Library) getWebhook(ctx context.Context, userID pgtype.UUID, webhookID string) (queries.Webhook, error) {
+	id, err := uuid.Parse(webhookID) // validate uuid
+	if err != nil {
+		return queries.Webhook{}, fmt.Errorf("invalid webhook id: %w", err)
+	}
+	webhook, err := l.queries.GetWebhook(ctx, queries.GetWebhookParams{
+		ID:     optuuid(id),
+		UserID: userID,
+	})
+	if err != nil {
+		return queries.Webhook{}, fmt.Errorf("get webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// validateWebhook checks the url and events of a webhook and returns the events sorted without duplicates.
+func validateWebhook(webhookURL string, events []string) ([]string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("the url must be an http or https url")
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("a webhook needs at least one event")
+	}
+	for _, event := range events {
+		if !slices.Contains(WebhookEvents, event) {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(events))), nil
+}
+
+func webhookFromRow(row queries.Webhook) Webhook {
+	return Webhook{ID: row.ID, URL: row.Url, Events: row.Events, CreatedAt: row.CreatedAt}
+}
+
+// emit queues the event for the webhooks of the user it happened to that are subscribed to it. it uses a
+// background context since it's called once the thing the event is about is done, when the request's
+// context may already be.
+func (l *Library) emit(userID pgtype.UUID, event string, data any) {
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: time.Now().UTC(), UserID: userID, Data: data})
+	if err != nil {
+		slog.Error("encode webhook payload", "event", event, "error", err)
+		return
+	}
+	n, err := l.queries.EnqueueWebhookDeliveries(context.Background(), queries.EnqueueWebhookDeliveriesParams{
+		Event:   event,
+		Payload: payload,
+		UserID:  userID,
+	})
+	if err != nil {
+		slog.Error("queue webhook deliveries", "event", event, "error", err)
+		return
+	}
+	if n > 0 {
+		l.wakeWebhooks()
+	}
+}
+
+// emitDownload emits download.completed or download.failed to the user who started a download of thing
+// (trackID is "" if it failed before the track was known).
+func (l *Library) emitDownload(userID pgtype.UUID, thing, trackID string, err error) {
+	data := map[string]string{"thing": thing, "track_id": trackID}
+	if err != nil {
+		data["error"] = err.Error()
+		l.emit(userID, EventDownloadFailed, data)
+		return
+	}
+	l.emit(userID, EventDownloadCompleted, data)
+}
+
+// playlistEvent is the data of the playlist events. Change is what changed for playlist.changed: details,
+// rules, track_added, track_removed, tracks_moved, tracks_replaced or folder (TrackID is set for the track ones).
+type playlistEvent struct {
+	PlaylistID pgtype.UUID `json:"playlist_id"`
+	Name       string      `json:"name"`
+	Change     string      `json:"change,omitempty"`
+	TrackID    string      `json:"track_id,omitempty"`
+}
+
+// emitPlaylistChanged emits playlist.changed for one of the user's playlists.
+func (l *Library) emitPlaylistChanged(userID pgtype.UUID, playlist queries.Playlist, change, trackID string) {
+	l.emit(userID, EventPlaylistChanged, playlistEvent{PlaylistID: playlist.ID, Name: playlist.Name, Change: change, TrackID: trackID})
+}
+
+// wakeWebhooks makes DeliverWebhooks look for deliveries now instead of at its next poll.
+func (l *Library) wakeWebhooks() {
+	select {
+	case l.webhookWake <- struct{}{}:
+	default: // it's already going to
+	}
+}
+
+// DeliverWebhooks sends the webhook deliveries that are due until ctx is done. a delivery that doesn't get
+// a 2xx response is attempted again with a backoff (webhookRetryDelay) until it has been attempted
+// webhookMaxAttempts times, then it fails and can be retried through the api. a delivery may be sent more
+// than once (if the server stops while it's being sent), the X-Webhook-ID header tells them apart.
+func (l *Library) DeliverWebhooks(ctx context.Context) {
+	poll := time.NewTicker(webhookPollInterval)
+	defer poll.Stop()
+	var cleanedAt time.Time
+	for {
+		if time.Since(cleanedAt) >= webhookCleanupInterval {
+			if err := l.queries.DeleteOldWebhookDeliveries(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("delete old webhook deliveries", "error", err)
+			}
+			cleanedAt = time.Now()
+		}
+		l.deliverDueWebhooks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-l.webhookWake:
+		}
+	}
+}
+
+// deliverDueWebhooks sends the deliveries that are due, a batch at a time.
+func (l *Library) deliverDueWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := l.queries.ListDueWebhookDeliveries(ctx, webhookBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("list due webhook deliveries", "error", err)
+			}
+			return
+		}
+		for _, d := range due {
+			l.deliverWebhook(ctx, d)
+		}
+		if len(due) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverWebhook attempts a delivery and records the attempt.
+func (l *Library) deliverWebhook(ctx context.Context, d queries.ListDueWebhookDeliveriesRow) {
+	statusCode, err := sendWebhook(ctx, d)
+	if err != nil && ctx.Err() != nil {
+		return // interrupted, it's still pending so it's sent again next time
+	}
+
+	params := queries.RecordWebhookAttemptParams{Status: "delivered", ID: d.ID}
+	if statusCode != 0 {
+		params.LastStatusCode = optint32(int32(statusCode))
+	}
+	if err != nil {
+		params.LastError = optstring(err.Error())
+		params.Status = "pending"
+		params.RetryAfter = int32(webhookRetryDelay(int(d.Attempts) + 1).Seconds())
+		if d.Attempts+1 >= webhookMaxAttempts {
+			params.Status = "failed"
+		}
+		slog.Warn("webhook delivery failed", "delivery_id", d.ID.String(), "event", d.Event, "attempt", d.Attempts+1, "error", err)
+	}
+	if err := l.queries.RecordWebhookAttempt(context.Background(), params); err != nil {
+		slog.Error("record webhook attempt", "delivery_id", d.ID.String(), "error", err)
+	}
+}
+
+// sendWebhook sends a delivery and returns the status code of the response (0 if there wasn't one). the
+// request is signed like the storage server's: X-Webhook-Signature is the hex HMAC-SHA256 (keyed with the
+// webhook's secret) of "<X-Webhook-ID>\n<X-Webhook-Timestamp>\n<body>".
+func sendWebhook(ctx context.Context, d queries.ListDueWebhookDeliveriesRow) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	id := d.ID.String()
+	timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.Secret))
+	mac.Write([]byte(id + "\n" + timestamp + "\n"))
+	mac.Write(d.Payload)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", id)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // so the connection can be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookRetryDelay is how long to wait after the attempt-th failed attempt: 30 seconds doubling each time,
+// at most an hour.
+func webhookRetryDelay(attempt int) time.Duration {
+	return min(30*time.Second<<(attempt-1), time.Hour)
+}
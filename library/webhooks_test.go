@@ -0,0 +1,50 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestDownloadEventsGoToTheirUser(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, l *Library) {
+		ctx := context.Background()
+		alice := testUser(t, l, "alice")
+		bob := testUser(t, l, "bob")
+		webhook := func(userID pgtype.UUID) string {
+			t.Helper()
+			_, w, err := l.CreateWebhook(ctx, userID, "https://example.com/hook", []string{EventDownloadCompleted, EventDownloadFailed})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return uuid.UUID(w.ID.Bytes).String()
+		}
+		aliceHook, bobHook := webhook(alice.ID), webhook(bob.ID)
+		deliveries := func(userID pgtype.UUID, webhookID string) []string {
+			t.Helper()
+			ds, err := l.WebhookDeliveries(ctx, userID, webhookID, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := make([]string, len(ds))
+			for i, d := range ds {
+				events[i] = d.Event
+			}
+			return events
+		}
+
+		l.emitDownload(alice.ID, "https://open.spotify.com/track/a", "a", nil)
+		l.emitDownload(bob.ID, "https://open.spotify.com/track/b", "b", errors.New("no youtube url"))
+		l.emitDownload(pgtype.UUID{}, "https://open.spotify.com/track/c", "c", nil)
+
+		if got := deliveries(alice.ID, aliceHook); len(got) != 1 || got[0] != EventDownloadCompleted {
+			t.Errorf("alice's deliveries = %v, want [%s]", got, EventDownloadCompleted)
+		}
+		if got := deliveries(bob.ID, bobHook); len(got) != 1 || got[0] != EventDownloadFailed {
+			t.Errorf("bob's deliveries = %v, want [%s]", got, EventDownloadFailed)
+		}
+	})
+}
@@ -2,19 +2,32 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tiredkangaroo/music/db/sqlite"
 	"github.com/tiredkangaroo/music/env"
 	"github.com/tiredkangaroo/music/library"
+	"github.com/tiredkangaroo/music/mpd"
 	"github.com/tiredkangaroo/music/server"
 	"github.com/tiredkangaroo/music/storage"
 )
 
+// schema is run by the migrate command (and before a restore) on postgres.
+//
+//go:embed schema.sql
+var schema string
+
 func main() {
-	if err := env.Init(); err != nil {
-		panic(err)
+	if err := env.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 	if env.DefaultEnv.Debug {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
@@ -22,14 +35,25 @@ func main() {
 		slog.SetLogLoggerLevel(slog.LevelInfo)
 	}
 
-	ctx := context.Background()
-	pool, err := pgxpool.New(ctx, env.DefaultEnv.PostgresURL)
+	// without a command (or with serve) the binary runs the server like it always has
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	serve()
+}
+
+func serve() {
+	if err := env.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.Info("configuration", "config", env.DefaultEnv)
+
+	lib, closeLib, err := openLibrary()
 	if err != nil {
 		panic(err)
 	}
-	defer pool.Close()
-
-	lib := library.NewLibrary(env.DefaultEnv.DataPath, pool)
+	defer closeLib()
 
 	var s storage.Storage
 	if env.DefaultEnv.StorageURL != "" && env.DefaultEnv.StorageAPISecret != "" {
@@ -40,8 +64,75 @@ func main() {
 		s = storage.NewLocalStorage(filepath.Join(env.DefaultEnv.DataPath, "storage"))
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var mpdServer *mpd.Server
+	if env.DefaultEnv.MPDAddress != "" {
+		mpdServer = mpd.NewServer(lib)
+		go func() {
+			if err := mpdServer.ListenAndServe(env.DefaultEnv.MPDAddress); err != nil {
+				slog.Error("mpd listener", "error", err)
+			}
+		}()
+	}
+
+	// deliveries stop at the first signal, the ones left (and the events of the shutdown) are sent once the
+	// server is started again
+	go lib.DeliverWebhooks(ctx)
+
 	srv := server.NewServer(lib, s)
-	if err := srv.Serve(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve() }()
+	select {
+	case err := <-serveErr:
 		panic(err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the server right away
+
+	slog.Info("shutting down", "timeout", env.DefaultEnv.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), env.DefaultEnv.ShutdownTimeout)
+	defer cancel()
+	if mpdServer != nil {
+		mpdServer.Close()
+	}
+	// the library stops starting downloads right away, while the requests (some waiting for downloads) finish
+	libDone := make(chan struct{})
+	go func() {
+		defer close(libDone)
+		if err := lib.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shut down library", "error", err)
+		}
+	}()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("shut down server", "error", err)
+	}
+	<-libDone
+	slog.Info("shut down")
+}
+
+// openLibrary opens the library with the configured database and data path. the returned func closes it.
+func openLibrary() (*library.Library, func(), error) {
+	var store library.Store
+	if env.DefaultEnv.SQLitePath != "" {
+		slog.Info("using sqlite", "path", env.DefaultEnv.SQLitePath)
+		s, err := sqlite.Open(context.Background(), env.DefaultEnv.SQLitePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open database: %w", err)
+		}
+		store = s
+	} else {
+		pool, err := pgxpool.New(context.Background(), env.DefaultEnv.PostgresURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to database: %w", err)
+		}
+		store = library.NewPostgresStore(pool, schema)
+		// like sqlite.Open, so an upgrade doesn't fail on the columns a new version added
+		if err := store.Migrate(context.Background()); err != nil {
+			store.Close()
+			return nil, nil, fmt.Errorf("migrate database: %w", err)
+		}
 	}
+	return library.NewLibrary(env.DefaultEnv.DataPath, store), store.Close, nil
 }
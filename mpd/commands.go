@@ -0,0 +1,964 @@
+package mpd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	queries "github.com/tiredkangaroo/music/db"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// command is an mpd command. scope is the api token scope it needs, "" if it can be used before logging in.
+type command struct {
+	scope string
+	run   func(s *Server, ctx context.Context, c *client, args []string, resp *response) error
+}
+
+var commands map[string]command
+
+func init() {
+	read, write := library.ScopeRead, library.ScopePlaylistsWrite
+	commands = map[string]command{
+		// connection
+		"password":           {"", cmdPassword},
+		"ping":               {"", cmdNothing},
+		"commands":           {"", cmdCommands},
+		"notcommands":        {"", cmdNotCommands},
+		"tagtypes":           {"", cmdTagTypes},
+		"outputs":            {read, cmdNothing},
+		"decoders":           {read, cmdNothing},
+		"urlhandlers":        {read, cmdNothing},
+		"replay_gain_status": {read, cmdReplayGainStatus},
+
+		// status & the queue (the playback session: the current track followed by the queued tracks)
+		"status":         {read, cmdStatus},
+		"stats":          {read, cmdStats},
+		"currentsong":    {read, cmdCurrentSong},
+		"playlistinfo":   {read, cmdPlaylistInfo},
+		"playlistid":     {read, cmdPlaylistID},
+		"plchanges":      {read, cmdPlChanges},
+		"plchangesposid": {read, cmdPlChangesPosID},
+		"add":            {write, cmdAdd},
+		"findadd":        {write, cmdFindAdd},
+		"searchadd":      {write, cmdSearchAdd},
+		"delete":         {write, cmdDelete},
+		"deleteid":       {write, cmdDeleteID},
+		"clear":          {write, cmdClear},
+		"next":           {write, cmdNext},
+		"previous":       {write, cmdPrevious},
+		"random":         {write, cmdRandom},
+		"repeat":         {write, cmdRepeat},
+		"single":         {write, cmdSingle},
+
+		// the library
+		"lsinfo": {read, cmdLsInfo},
+		"list":   {read, cmdList},
+		"find":   {read, cmdFind},
+		"search": {read, cmdSearch},
+
+		// stored playlists
+		"listplaylists":    {read, cmdListPlaylists},
+		"listplaylist":     {read, cmdListPlaylist},
+		"listplaylistinfo": {read, cmdListPlaylistInfo},
+		"load":             {write, cmdLoad},
+		"save":             {write, cmdSave},
+		"playlistadd":      {write, cmdPlaylistAdd},
+		"playlistdelete":   {write, cmdPlaylistDelete},
+		"playlistclear":    {write, cmdPlaylistClear},
+		"rename":           {write, cmdRename},
+		"rm":               {write, cmdRm},
+	}
+}
+
+// the tags songs have, by the lowercase name commands use
+var tagNames = map[string]string{
+	"artist":      "Artist",
+	"albumartist": "AlbumArtist",
+	"album":       "Album",
+	"title":       "Title",
+	"date":        "Date",
+	"file":        "file",
+}
+
+func cmdNothing(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return nil
+}
+
+func cmdPassword(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	user, scopes, err := s.lib.UserByAPIToken(ctx, args[0])
+	if errors.Is(err, library.ErrInvalidCredentials) {
+		return ack(ackErrorPassword, "incorrect password")
+	} else if err != nil {
+		return err
+	}
+	c.user, c.scopes = user, scopes
+	return nil
+}
+
+func cmdCommands(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		if scope := commands[name].scope; scope == "" || slices.Contains(c.scopes, scope) {
+			resp.add("command", name)
+		}
+	}
+	resp.add("command", "close")
+	resp.add("command", "idle")
+	resp.add("command", "noidle")
+	return nil
+}
+
+func cmdNotCommands(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		if scope := commands[name].scope; scope != "" && !slices.Contains(c.scopes, scope) {
+			resp.add("command", name)
+		}
+	}
+	return nil
+}
+
+// tagtypes lists the tags, changing which ones are sent (tagtypes clear/enable/...) is accepted but ignored
+func cmdTagTypes(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if len(args) > 0 {
+		return nil
+	}
+	for _, tag := range []string{"Artist", "AlbumArtist", "Album", "Title", "Date"} {
+		resp.add("tagtype", tag)
+	}
+	return nil
+}
+
+func cmdReplayGainStatus(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	resp.add("replay_gain_mode", "off")
+	return nil
+}
+
+func cmdStatus(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	queue := queueOf(ps)
+	resp.add("volume", -1)
+	resp.add("repeat", boolArg(ps.Repeat != library.RepeatOff))
+	resp.add("random", boolArg(ps.Shuffle))
+	resp.add("single", boolArg(ps.Repeat == library.RepeatOne))
+	resp.add("consume", 0)
+	resp.add("playlist", queueVersion(ps))
+	resp.add("playlistlength", len(queue))
+	if ps.CurrentTrack == nil {
+		resp.add("state", "stop")
+		return nil
+	}
+	// there's no player here, so the current track is always paused as far as mpd clients know
+	resp.add("state", "pause")
+	resp.add("song", 0)
+	resp.add("songid", 1)
+	resp.add("time", fmt.Sprintf("%d:%d", int(ps.Position), ps.CurrentTrack.Duration))
+	resp.add("elapsed", fmt.Sprintf("%.3f", ps.Position))
+	resp.add("duration", ps.CurrentTrack.Duration)
+	if len(queue) > 1 {
+		resp.add("nextsong", 1)
+		resp.add("nextsongid", 2)
+	}
+	return nil
+}
+
+func cmdStats(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	artists, albums := map[string]bool{}, map[string]bool{}
+	var playtime int
+	var updated time.Time
+	for _, t := range tracks {
+		artists[t.ArtistID] = true
+		albums[t.AlbumID] = true
+		playtime += int(t.Duration)
+	}
+	if ps, err := s.lib.PlaybackSession(ctx, c.user.ID); err == nil {
+		updated = ps.UpdatedAt
+	}
+	resp.add("artists", len(artists))
+	resp.add("albums", len(albums))
+	resp.add("songs", len(tracks))
+	resp.add("uptime", int(time.Since(s.started).Seconds()))
+	resp.add("db_playtime", playtime)
+	resp.add("db_update", updated.Unix())
+	resp.add("playtime", 0)
+	return nil
+}
+
+func cmdCurrentSong(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	if ps.CurrentTrack != nil {
+		writeSong(resp, *ps.CurrentTrack, 0)
+	}
+	return nil
+}
+
+// playlistinfo [POS|START:END]
+func cmdPlaylistInfo(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	queue := queueOf(ps)
+	start, end := 0, len(queue)
+	if len(args) > 0 && args[0] != "-1" {
+		if start, end, err = parseRange(args[0], len(queue)); err != nil {
+			return err
+		}
+	}
+	for i := start; i < end; i++ {
+		writeSong(resp, queue[i], i)
+	}
+	return nil
+}
+
+// playlistid [ID]. song ids are their position + 1.
+func cmdPlaylistID(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if len(args) == 0 {
+		return cmdPlaylistInfo(s, ctx, c, nil, resp)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		return ack(ackErrorArg, "invalid song id %q", args[0])
+	}
+	return cmdPlaylistInfo(s, ctx, c, []string{strconv.Itoa(id - 1)}, resp)
+}
+
+// plchanges VERSION responds with the whole queue, changes since a version aren't tracked
+func cmdPlChanges(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return cmdPlaylistInfo(s, ctx, c, nil, resp)
+}
+
+func cmdPlChangesPosID(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range queueOf(ps) {
+		resp.add("cpos", i)
+		resp.add("Id", i+1)
+	}
+	return nil
+}
+
+// add URI adds a song or everything in a directory to the end of the queue
+func cmdAdd(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 2); err != nil {
+		return err
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	return s.queue(ctx, c, tracksAt(tracks, args[0]))
+}
+
+func cmdFindAdd(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return s.findAndQueue(ctx, c, args, true)
+}
+
+func cmdSearchAdd(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return s.findAndQueue(ctx, c, args, false)
+}
+
+func (s *Server) findAndQueue(ctx context.Context, c *client, args []string, exact bool) error {
+	f, err := parseFilters(args, exact)
+	if err != nil {
+		return err
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	return s.queue(ctx, c, f.apply(tracks))
+}
+
+// queue adds the tracks to the end of the queue.
+func (s *Server) queue(ctx context.Context, c *client, tracks []library.Track) error {
+	if len(tracks) == 0 {
+		return ack(ackErrorNoExist, "No such song")
+	}
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.TrackID
+	}
+	_, err := s.lib.QueueTracks(ctx, c.user.ID, ids, false)
+	return err
+}
+
+// delete POS|START:END
+func cmdDelete(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	return s.deleteFromQueue(ctx, c, args[0])
+}
+
+func cmdDeleteID(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		return ack(ackErrorArg, "invalid song id %q", args[0])
+	}
+	return s.deleteFromQueue(ctx, c, strconv.Itoa(id-1))
+}
+
+func (s *Server) deleteFromQueue(ctx context.Context, c *client, arg string) error {
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseRange(arg, len(queueOf(ps)))
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if ps.CurrentTrack != nil {
+		offset = 1
+		if start == 0 {
+			return ack(ackErrorArg, "the current song can't be deleted")
+		}
+	}
+	for i := end - 1; i >= start; i-- { // from the back so the indexes don't shift
+		if _, err := s.lib.RemoveFromQueue(ctx, c.user.ID, i-offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clear empties the queue (the current track stays)
+func cmdClear(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	_, err := s.lib.ClearQueue(ctx, c.user.ID)
+	return err
+}
+
+func cmdNext(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if _, err := s.lib.SessionNext(ctx, c.user.ID); err != nil {
+		return ack(ackErrorArg, "%s", err)
+	}
+	return nil
+}
+
+func cmdPrevious(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if _, err := s.lib.SessionPrevious(ctx, c.user.ID); err != nil {
+		return ack(ackErrorArg, "%s", err)
+	}
+	return nil
+}
+
+func cmdRandom(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	on, err := parseBool(args)
+	if err != nil {
+		return err
+	}
+	_, err = s.lib.SetSessionMode(ctx, c.user.ID, &on, nil)
+	return err
+}
+
+// repeat and single map to the session's repeat modes: repeat is all, repeat and single is one
+func cmdRepeat(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	on, err := parseBool(args)
+	if err != nil {
+		return err
+	}
+	repeat := library.RepeatOff
+	if on {
+		repeat = library.RepeatAll
+	}
+	_, err = s.lib.SetSessionMode(ctx, c.user.ID, nil, &repeat)
+	return err
+}
+
+func cmdSingle(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if len(args) == 1 && args[0] == "oneshot" {
+		args = []string{"1"}
+	}
+	on, err := parseBool(args)
+	if err != nil {
+		return err
+	}
+	repeat := library.RepeatAll
+	if on {
+		repeat = library.RepeatOne
+	}
+	_, err = s.lib.SetSessionMode(ctx, c.user.ID, nil, &repeat)
+	return err
+}
+
+// lsinfo [URI]. the library is a tree of artist/album directories with the tracks in them, the root also
+// lists the stored playlists.
+func cmdLsInfo(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	uri := ""
+	if len(args) > 0 {
+		uri = strings.Trim(args[0], "/")
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := trackIDFromURI(uri); ok {
+		found := tracksAt(tracks, uri)
+		if len(found) == 0 {
+			return ack(ackErrorNoExist, "No such song")
+		}
+		writeSong(resp, found[0], -1)
+		return nil
+	}
+
+	depth := 0
+	if uri != "" {
+		depth = strings.Count(uri, "/") + 1
+	}
+	if depth >= 2 {
+		found := tracksAt(tracks, uri)
+		if len(found) == 0 {
+			return ack(ackErrorNoExist, "No such directory")
+		}
+		for _, t := range found {
+			writeSong(resp, t, -1)
+		}
+		return nil
+	}
+
+	var dirs []string
+	for _, t := range tracksAt(tracks, uri) {
+		dir := dirName(t.ArtistName)
+		if depth == 1 {
+			dir += "/" + dirName(t.AlbumName)
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if depth == 1 && len(dirs) == 0 {
+		return ack(ackErrorNoExist, "No such directory")
+	}
+	slices.SortFunc(dirs, func(a, b string) int { return cmp.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	for _, dir := range dirs {
+		resp.add("directory", dir)
+	}
+	if depth == 0 {
+		return writePlaylists(s, ctx, c, resp)
+	}
+	return nil
+}
+
+// list TYPE [FILTERS...] [group TYPE...] lists the unique values of a tag (grouped by other tags).
+// "list album ARTIST" is the old way to list the albums of an artist.
+func cmdList(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if len(args) == 0 {
+		return ack(ackErrorArg, "too few arguments for \"list\"")
+	}
+	tag := strings.ToLower(args[0])
+	if _, ok := tagNames[tag]; !ok {
+		return ack(ackErrorArg, "Unknown tag type: %s", args[0])
+	}
+	args = args[1:]
+	if tag == "album" && len(args) == 1 {
+		args = []string{"artist", args[0]}
+	}
+	f, err := parseFilters(args, true)
+	if err != nil {
+		return err
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string // the group values followed by the value
+	for _, t := range f.apply(tracks) {
+		row := make([]string, 0, len(f.groups)+1)
+		for _, g := range f.groups {
+			row = append(row, tagValue(t, g))
+		}
+		row = append(row, tagValue(t, tag))
+		if !slices.ContainsFunc(rows, func(r []string) bool { return slices.Equal(r, row) }) {
+			rows = append(rows, row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b []string) int {
+		for i := range a {
+			if n := cmp.Compare(strings.ToLower(a[i]), strings.ToLower(b[i])); n != 0 {
+				return n
+			}
+		}
+		return 0
+	})
+
+	last := make([]string, len(f.groups))
+	for _, row := range rows {
+		for i, g := range f.groups {
+			if row[i] != last[i] {
+				resp.add(tagNames[g], row[i])
+				last[i] = row[i]
+			}
+		}
+		resp.add(tagNames[tag], row[len(row)-1])
+	}
+	return nil
+}
+
+// find TYPE WHAT [...] [window START:END] finds songs that exactly match every filter
+func cmdFind(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return s.findSongs(ctx, c, args, true, resp)
+}
+
+// search is like find but case insensitive and matches parts of the tags
+func cmdSearch(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return s.findSongs(ctx, c, args, false, resp)
+}
+
+func (s *Server) findSongs(ctx context.Context, c *client, args []string, exact bool, resp *response) error {
+	f, err := parseFilters(args, exact)
+	if err != nil {
+		return err
+	}
+	if len(f.filters) == 0 {
+		return ack(ackErrorArg, "incorrect arguments")
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	found := f.apply(tracks)
+	if f.window != nil {
+		start, end := min(f.window[0], len(found)), min(f.window[1], len(found))
+		found = found[start:end]
+	}
+	for _, t := range found {
+		writeSong(resp, t, -1)
+	}
+	return nil
+}
+
+func cmdListPlaylists(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	return writePlaylists(s, ctx, c, resp)
+}
+
+func writePlaylists(s *Server, ctx context.Context, c *client, resp *response) error {
+	playlists, err := s.lib.ListPlaylists(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	for _, p := range playlists {
+		resp.add("playlist", p.Name)
+		resp.add("Last-Modified", p.CreatedAt.Time.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func cmdListPlaylist(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	_, tracks, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		resp.add("file", trackURI(t))
+	}
+	return nil
+}
+
+func cmdListPlaylistInfo(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	_, tracks, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		writeSong(resp, t, -1)
+	}
+	return nil
+}
+
+// load NAME [START:END] adds the playlist's tracks to the queue
+func cmdLoad(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 2); err != nil {
+		return err
+	}
+	_, tracks, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		start, end, err := parseRange(args[1], len(tracks))
+		if err != nil {
+			return err
+		}
+		tracks = tracks[start:end]
+	}
+	return s.queue(ctx, c, tracks)
+}
+
+// save NAME saves the queue as a new playlist
+func cmdSave(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	if _, _, err := s.playlistByName(ctx, c, args[0]); err == nil {
+		return ack(ackErrorExist, "Playlist already exists")
+	}
+	ps, err := s.lib.PlaybackSession(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	playlistID, err := s.lib.CreatePlaylist(ctx, c.user.ID, args[0], "", "")
+	if err != nil {
+		return err
+	}
+	return s.addToPlaylist(ctx, c, playlistID, queueOf(ps))
+}
+
+// playlistadd NAME URI adds a song or directory to the playlist, which is created if it doesn't exist
+func cmdPlaylistAdd(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 2, 2); err != nil {
+		return err
+	}
+	all, err := s.lib.LibraryTracks(ctx, c.user.ID)
+	if err != nil {
+		return err
+	}
+	tracks := tracksAt(all, args[1])
+	if len(tracks) == 0 {
+		return ack(ackErrorNoExist, "No such song")
+	}
+
+	var playlistID string
+	playlist, _, err := s.playlistByName(ctx, c, args[0])
+	if err == nil {
+		playlistID = uuid.UUID(playlist.ID.Bytes).String()
+	} else if playlistID, err = s.lib.CreatePlaylist(ctx, c.user.ID, args[0], "", ""); err != nil {
+		return err
+	}
+	return s.addToPlaylist(ctx, c, playlistID, tracks)
+}
+
+func (s *Server) addToPlaylist(ctx context.Context, c *client, playlistID string, tracks []library.Track) error {
+	for _, t := range tracks {
+		if err := s.lib.AddTrackToPlaylist(ctx, c.user.ID, playlistID, t.TrackID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playlistdelete NAME POS removes the song at POS from the playlist (and any other time it's in it)
+func cmdPlaylistDelete(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 2, 2); err != nil {
+		return err
+	}
+	playlist, tracks, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	start, end, err := parseRange(args[1], len(tracks))
+	if err != nil {
+		return err
+	}
+	playlistID := uuid.UUID(playlist.ID.Bytes).String()
+	for _, t := range tracks[start:end] {
+		if err := s.lib.RemoveTrackFromPlaylist(ctx, c.user.ID, playlistID, t.TrackID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdPlaylistClear(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	playlist, tracks, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	playlistID := uuid.UUID(playlist.ID.Bytes).String()
+	for _, t := range tracks {
+		if err := s.lib.RemoveTrackFromPlaylist(ctx, c.user.ID, playlistID, t.TrackID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdRename(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 2, 2); err != nil {
+		return err
+	}
+	playlist, _, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = s.lib.UpdatePlaylist(ctx, c.user.ID, uuid.UUID(playlist.ID.Bytes).String(), args[1], playlist.Description, playlist.ImageUrl)
+	return err
+}
+
+func cmdRm(s *Server, ctx context.Context, c *client, args []string, resp *response) error {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return err
+	}
+	playlist, _, err := s.playlistByName(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	return s.lib.DeletePlaylist(ctx, c.user.ID, uuid.UUID(playlist.ID.Bytes).String())
+}
+
+// playlistByName returns the user's playlist with the name and its tracks. mpd refers to stored playlists
+// by name, which are unique per user.
+func (s *Server) playlistByName(ctx context.Context, c *client, name string) (queries.Playlist, []library.Track, error) {
+	playlists, err := s.lib.ListPlaylists(ctx, c.user.ID)
+	if err != nil {
+		return queries.Playlist{}, nil, err
+	}
+	i := slices.IndexFunc(playlists, func(p queries.Playlist) bool { return p.Name == name })
+	if i == -1 {
+		return queries.Playlist{}, nil, ack(ackErrorNoExist, "No such playlist")
+	}
+	return s.lib.PlaylistTracks(ctx, c.user.ID, uuid.UUID(playlists[i].ID.Bytes).String())
+}
+
+// queueOf returns the session as an mpd queue: the current track followed by the queued tracks.
+func queueOf(ps library.PlaybackSession) []library.Track {
+	var queue []library.Track
+	if ps.CurrentTrack != nil {
+		queue = append(queue, *ps.CurrentTrack)
+	}
+	return append(queue, ps.Queue...)
+}
+
+// queueVersion changes whenever the session changes, which clients use to notice the queue has changed.
+func queueVersion(ps library.PlaybackSession) uint32 {
+	return uint32(ps.UpdatedAt.Unix()) + 1
+}
+
+// writeSong writes the song's tags. pos is its position in the queue, or -1 if it isn't in the queue.
+func writeSong(resp *response, t library.Track, pos int) {
+	resp.add("file", trackURI(t))
+	resp.add("Artist", strings.Join(t.Artists, ", "))
+	resp.add("AlbumArtist", t.ArtistName)
+	resp.add("Album", t.AlbumName)
+	resp.add("Title", t.TrackName)
+	if date := tagValue(t, "date"); date != "" {
+		resp.add("Date", date)
+	}
+	resp.add("Time", t.Duration)
+	resp.add("duration", fmt.Sprintf("%.3f", float64(t.Duration)))
+	if pos >= 0 {
+		resp.add("Pos", pos)
+		resp.add("Id", pos+1)
+	}
+}
+
+// trackURI is the path of the track in the artist/album directory tree.
+func trackURI(t library.Track) string {
+	return dirName(t.ArtistName) + "/" + dirName(t.AlbumName) + "/" + t.TrackID + ".m4a"
+}
+
+func trackIDFromURI(uri string) (string, bool) {
+	id, ok := strings.CutSuffix(path.Base(uri), ".m4a")
+	return id, ok && id != ""
+}
+
+// dirName makes a name usable as a directory name.
+func dirName(name string) string {
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// tracksAt returns the track with the uri or the tracks in the directory ("" is everything).
+func tracksAt(tracks []library.Track, uri string) []library.Track {
+	uri = strings.Trim(uri, "/")
+	if id, ok := trackIDFromURI(uri); ok {
+		i := slices.IndexFunc(tracks, func(t library.Track) bool { return t.TrackID == id })
+		if i == -1 {
+			return nil
+		}
+		return tracks[i : i+1]
+	}
+	if uri == "" {
+		return tracks
+	}
+	var found []library.Track
+	for _, t := range tracks {
+		if strings.HasPrefix(trackURI(t), uri+"/") {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// tagValue returns the value of the (lowercase) tag of the track.
+func tagValue(t library.Track, tag string) string {
+	switch tag {
+	case "artist", "albumartist":
+		return t.ArtistName
+	case "album":
+		return t.AlbumName
+	case "title":
+		return t.TrackName
+	case "date":
+		if t.TrackReleaseDate.Valid {
+			return strconv.Itoa(t.TrackReleaseDate.Time.Year())
+		}
+	case "file":
+		return trackURI(t)
+	}
+	return ""
+}
+
+// songFilters are the tag filters, window and groups of find, search and list.
+type songFilters struct {
+	filters [][2]string // tag and value
+	exact   bool
+	window  *[2]int
+	groups  []string
+}
+
+// parseFilters parses TYPE WHAT pairs (with "any" as a type that matches any tag), "window START:END",
+// "group TYPE" and "sort TYPE" (which is ignored). filter expressions like (artist == 'x') aren't supported.
+func parseFilters(args []string, exact bool) (songFilters, error) {
+	f := songFilters{exact: exact}
+	if len(args) == 1 && strings.HasPrefix(args[0], "(") {
+		return f, ack(ackErrorArg, "filter expressions aren't supported, use TYPE VALUE pairs")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return f, ack(ackErrorArg, "incorrect arguments")
+		}
+		key, value := strings.ToLower(args[i]), args[i+1]
+		switch key {
+		case "window":
+			start, end, err := parseRange(value, -1)
+			if err != nil {
+				return f, err
+			}
+			f.window = &[2]int{start, end}
+		case "sort":
+		case "group":
+			if _, ok := tagNames[strings.ToLower(value)]; !ok {
+				return f, ack(ackErrorArg, "Unknown tag type: %s", value)
+			}
+			f.groups = append(f.groups, strings.ToLower(value))
+		default:
+			if _, ok := tagNames[key]; !ok && key != "any" {
+				return f, ack(ackErrorArg, "Unknown tag type: %s", args[i])
+			}
+			f.filters = append(f.filters, [2]string{key, value})
+		}
+	}
+	return f, nil
+}
+
+// apply returns the tracks that match every filter.
+func (f songFilters) apply(tracks []library.Track) []library.Track {
+	var matched []library.Track
+	for _, t := range tracks {
+		if f.matches(t) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+func (f songFilters) matches(t library.Track) bool {
+	match := func(tag, value string) bool {
+		v := tagValue(t, tag)
+		if f.exact {
+			return v == value
+		}
+		return strings.Contains(strings.ToLower(v), strings.ToLower(value))
+	}
+	for _, filter := range f.filters {
+		tags := []string{filter[0]}
+		if filter[0] == "any" {
+			tags = []string{"artist", "album", "title", "date", "file"}
+		}
+		if !slices.ContainsFunc(tags, func(tag string) bool { return match(tag, filter[1]) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRange parses POS or START:END (END may be left out) into a [start, end) range within n items.
+// n is -1 if the range isn't checked.
+func parseRange(arg string, n int) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(arg, ":")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, ack(ackErrorArg, "invalid position %q", arg)
+	}
+	end := start + 1
+	if isRange {
+		end = n
+		if endStr != "" {
+			if end, err = strconv.Atoi(endStr); err != nil {
+				return 0, 0, ack(ackErrorArg, "invalid range %q", arg)
+			}
+		} else if n == -1 {
+			end = int(^uint(0) >> 1)
+		}
+	}
+	if end < start || (n != -1 && end > n) {
+		return 0, 0, ack(ackErrorArg, "Bad song index")
+	}
+	return start, end, nil
+}
+
+func parseBool(args []string) (bool, error) {
+	if err := wantArgs(args, 1, 1); err != nil {
+		return false, err
+	}
+	switch args[0] {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, ack(ackErrorArg, "boolean (0/1) expected: %s", args[0])
+}
+
+func boolArg(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func wantArgs(args []string, least, most int) error {
+	if len(args) < least {
+		return ack(ackErrorArg, "too few arguments")
+	}
+	if len(args) > most {
+		return ack(ackErrorArg, "too many arguments")
+	}
+	return nil
+}
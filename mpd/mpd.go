@@ -0,0 +1,310 @@
+// Package mpd is a front-end that speaks enough of the mpd protocol (https://mpd.readthedocs.io/en/latest/protocol.html)
+// for clients like ncmpcpp to browse the library, manage stored playlists and drive the playback session's
+// queue. there's no player behind it, playing is still done by the ui.
+package mpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiredkangaroo/music/library"
+)
+
+// protocolVersion is the version of the mpd protocol sent in the greeting.
+const protocolVersion = "0.23.5"
+
+const (
+	// readTimeout is how long a client can take to send its next command before it's disconnected
+	// (mpd's connection_timeout). clients that are idling aren't timed out.
+	readTimeout = time.Minute
+	// maxLineLength is the longest command line read, longer ones end the connection.
+	maxLineLength = 64 << 10
+	// maxListLength is the most commands a command list can have, more end the connection.
+	maxListLength = 4096
+)
+
+// ack error codes
+const (
+	ackErrorArg        = 2
+	ackErrorPassword   = 3
+	ackErrorPermission = 4
+	ackErrorUnknown    = 5
+	ackErrorNoExist    = 50
+	ackErrorSystem     = 52
+	ackErrorExist      = 56
+)
+
+// Server accepts mpd clients. clients log in with the password command and an api token as the password,
+// and can only use the commands the token's scopes allow.
+type Server struct {
+	lib     *library.Library
+	started time.Time
+
+	mx     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func NewServer(lib *library.Library) *Server {
+	return &Server{lib: lib, started: time.Now(), conns: make(map[net.Conn]struct{})}
+}
+
+// ListenAndServe listens on the tcp address and serves mpd clients until listening fails or Close is called,
+// when it returns nil.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.ln = ln
+	s.mx.Unlock()
+	defer ln.Close()
+
+	slog.Info("mpd listener started", "address", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		if !s.addConn(conn) {
+			conn.Close()
+			return nil
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops listening and disconnects the clients (mpd clients reconnect by themselves).
+func (s *Server) Close() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.closed = true
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// addConn adds a client connection to the ones Close disconnects. it returns false if the server is closed.
+func (s *Server) addConn(conn net.Conn) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) removeConn(conn net.Conn) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.conns, conn)
+}
+
+// client is a connection and who it's logged in as.
+type client struct {
+	user   library.User
+	scopes []string
+}
+
+// ackError is an error the client is told about as an ACK line.
+type ackError struct {
+	code    int
+	message string
+}
+
+func (e *ackError) Error() string { return e.message }
+
+func ack(code int, format string, a ...any) error {
+	return &ackError{code: code, message: fmt.Sprintf(format, a...)}
+}
+
+// response is the key: value lines a command responds with.
+type response struct {
+	bytes.Buffer
+}
+
+func (r *response) add(key string, value any) {
+	fmt.Fprintf(&r.Buffer, "%s: %v\n", key, value)
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.removeConn(conn)
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := bufio.NewScanner(conn)
+	r.Buffer(make([]byte, 4096), maxLineLength)
+	w := bufio.NewWriter(conn)
+	c := &client{}
+	fmt.Fprintf(w, "OK MPD %s\n", protocolVersion)
+
+	var list []string // the commands of a command list that's being received
+	inList, listOK := false, false
+	for {
+		if err := w.Flush(); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if !r.Scan() {
+			if err := r.Err(); err != nil {
+				slog.Debug("mpd read", "error", err)
+			}
+			return
+		}
+		line := r.Text()
+
+		switch {
+		case line == "command_list_begin" || line == "command_list_ok_begin":
+			inList, listOK, list = true, line == "command_list_ok_begin", nil
+			continue
+		case inList && line != "command_list_end":
+			if len(list) == maxListLength {
+				slog.Debug("mpd command list too long")
+				return
+			}
+			list = append(list, line)
+			continue
+		case inList:
+			inList = false
+			for i, l := range list {
+				if !s.execute(ctx, c, w, l, i) {
+					break
+				}
+				if listOK {
+					w.WriteString("list_OK\n")
+				}
+				if i == len(list)-1 {
+					w.WriteString("OK\n")
+				}
+			}
+			if len(list) == 0 {
+				w.WriteString("OK\n")
+			}
+			continue
+		}
+
+		args, err := splitArgs(line)
+		if err == nil && len(args) > 0 {
+			switch args[0] {
+			case "close":
+				return
+			case "idle":
+				// nothing is ever announced, the client waits until it sends noidle (anything else ends
+				// the connection like mpd does)
+				w.Flush()
+				conn.SetReadDeadline(time.Time{})
+				if !r.Scan() || r.Text() != "noidle" {
+					return
+				}
+				w.WriteString("OK\n")
+				continue
+			case "noidle":
+				continue // not idling, mpd ignores it
+			}
+		}
+		if s.execute(ctx, c, w, line, 0) {
+			w.WriteString("OK\n")
+		}
+	}
+}
+
+// execute runs one command line and writes its response (but not the OK). it returns false and writes an
+// ACK if the command failed. listNum is the position of the command in a command list.
+func (s *Server) execute(ctx context.Context, c *client, w *bufio.Writer, line string, listNum int) bool {
+	args, err := splitArgs(line)
+	name := ""
+	if err == nil && len(args) == 0 {
+		err = ack(ackErrorUnknown, "No command given")
+	}
+	if err == nil {
+		name = args[0]
+		cmd, ok := commands[name]
+		switch {
+		case !ok:
+			err = ack(ackErrorUnknown, "unknown command %q", name)
+		case cmd.scope != "" && !slices.Contains(c.scopes, cmd.scope):
+			err = ack(ackErrorPermission, "you don't have permission for %q", name)
+		default:
+			var resp response
+			cmdCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			err = cmd.run(s, cmdCtx, c, args[1:], &resp)
+			cancel()
+			if err == nil {
+				w.Write(resp.Bytes())
+				return true
+			}
+		}
+	}
+
+	var ae *ackError
+	if !errors.As(err, &ae) {
+		slog.Error("mpd command", "command", name, "error", err)
+		ae = &ackError{code: ackErrorSystem, message: err.Error()}
+	}
+	fmt.Fprintf(w, "ACK [%d@%d] {%s} %s\n", ae.code, listNum, name, ae.message)
+	return false
+}
+
+// splitArgs splits a command line into the command and its arguments. arguments can be quoted with
+// double quotes, in which \" and \\ are escapes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		if line[i] != '"' {
+			end := strings.IndexAny(line[i:], " \t")
+			if end == -1 {
+				end = len(line) - i
+			}
+			args = append(args, line[i:i+end])
+			i += end
+			continue
+		}
+
+		var arg strings.Builder
+		i++
+		for {
+			if i >= len(line) {
+				return nil, ack(ackErrorArg, "missing closing '\"'")
+			}
+			if line[i] == '"' {
+				i++
+				break
+			}
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			arg.WriteByte(line[i])
+			i++
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
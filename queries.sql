@@ -1,3 +1,5 @@
+-- a change to a query needs the same change to its sqlite version (db/sqlite/queries.sql).
+
 -- name: InsertTrack :exec
 WITH upsert_artist AS (
     INSERT INTO artists (artist_id, artist_name)
@@ -61,34 +63,53 @@ SELECT youtube_url FROM tracks WHERE track_id = $1;
 SELECT lyrics FROM tracks WHERE track_id = $1;
 
 -- name: SearchTrackByName :many
-SELECT * FROM tracks
+SELECT tracks.*, albums.*, artists.*, track_ratings.liked_at, track_ratings.rating FROM tracks
 JOIN albums ON tracks.album_id = albums.album_id
 JOIN artists ON tracks.artist_id = artists.artist_id
+LEFT JOIN track_ratings ON track_ratings.track_id = tracks.track_id AND track_ratings.user_id = $4
 WHERE track_name ILIKE '%' || $1 || '%'
 LIMIT $2 OFFSET $3;
 
 -- name: ListPlaylists :many
-SELECT * FROM playlists ORDER BY created_at DESC;
+SELECT * FROM playlists WHERE user_id = $1 ORDER BY created_at DESC, id;
 
 -- name: CreatePlaylist :one
-INSERT INTO playlists (name, description, image_url)
-VALUES ($1, $2, $3)
+INSERT INTO playlists (name, description, image_url, user_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id;
+
+-- name: CreateSmartPlaylist :one
+INSERT INTO playlists (name, description, image_url, rules, user_id)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id;
 
+-- name: GetPlaylistByID :one
+SELECT * FROM playlists WHERE id = $1;
+
+-- name: UpdatePlaylistRules :exec
+UPDATE playlists
+SET rules = $1
+WHERE id = $2;
+
 -- name: DeletePlaylist :exec
 DELETE FROM playlists WHERE id = $1;
 
 -- name: AddTrackToPlaylist :exec
-INSERT INTO playlist_tracks (playlist_id, track_id)
-VALUES ($1, $2);
+INSERT INTO playlist_tracks (playlist_id, track_id, position)
+VALUES ($1, $2, $3);
 
 -- name: GetPlaylist :one
+-- the tracks are in playlist order, or sorted by sort (title, artist, album, added_at, duration or
+-- play_count) if it isn't empty.
 SELECT
     p.id,
     p.name,
     p.description,
     p.image_url,
     p.created_at,
+    p.rules,
+    p.folder_id,
+    p.user_id,
     COALESCE(
         json_agg(
             json_build_object(
@@ -97,13 +118,34 @@ SELECT
                 'duration', t.duration,
                 'popularity', t.popularity,
                 'album_id', t.album_id,
+                'album_name', a.album_name,
                 'artist_id', t.artist_id,
+                'artist_name', ar.artist_name,
                 'artists', t.artists,
                 'cover_url', a.cover_url,
                 'downloaded', t.downloaded,
                 'track_release_date', t.track_release_date,
-                'lyrics', t.lyrics
+                'lyrics', t.lyrics,
+                'play_count', pc.play_count,
+                'position', pt.position,
+                'added_at', pt.added_at,
+                'liked_at', r.liked_at,
+                'rating', r.rating
             )
+            ORDER BY
+                CASE WHEN NOT sqlc.arg(descending)::boolean THEN
+                    CASE sqlc.arg(sort)::text WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+                END ASC,
+                CASE WHEN sqlc.arg(descending)::boolean THEN
+                    CASE sqlc.arg(sort)::text WHEN 'title' THEN lower(t.track_name) WHEN 'artist' THEN lower(ar.artist_name) WHEN 'album' THEN lower(a.album_name) END
+                END DESC,
+                CASE WHEN NOT sqlc.arg(descending)::boolean THEN
+                    CASE sqlc.arg(sort)::text WHEN 'added_at' THEN extract(epoch FROM pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN pc.play_count END
+                END ASC,
+                CASE WHEN sqlc.arg(descending)::boolean THEN
+                    CASE sqlc.arg(sort)::text WHEN 'added_at' THEN extract(epoch FROM pt.added_at) WHEN 'duration' THEN t.duration WHEN 'play_count' THEN pc.play_count END
+                END DESC,
+                pt.position
         ) FILTER (WHERE t.track_id IS NOT NULL),
         '[]'::json
     ) AS tracks
@@ -111,7 +153,12 @@ FROM playlists p
 LEFT JOIN playlist_tracks pt ON pt.playlist_id = p.id
 LEFT JOIN tracks t ON t.track_id = pt.track_id
 LEFT JOIN albums a ON t.album_id = a.album_id
-WHERE p.id = $1
+LEFT JOIN artists ar ON t.artist_id = ar.artist_id
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = p.user_id
+LEFT JOIN LATERAL (
+    SELECT count(*) AS play_count FROM plays WHERE plays.track_id = t.track_id AND plays.user_id = p.user_id
+) pc ON TRUE
+WHERE p.id = sqlc.arg(id)
 GROUP BY p.id;
 
 -- name: GetPlaylistTracksNotDownloaded :many
@@ -124,15 +171,15 @@ DELETE FROM playlist_tracks
 WHERE playlist_id = $1 AND track_id = $2;
 
 -- name: RecordPlay :one
-INSERT INTO plays (track_id, played_at, skipped_at)
-VALUES ($1, $2, $3)
-ON CONFLICT (track_id, played_at) DO NOTHING
+INSERT INTO plays (track_id, played_at, skipped_at, user_id)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, track_id, played_at) DO NOTHING
 RETURNING play_id;
 
 -- name: RecordSkip :exec
 UPDATE plays
 SET skipped_at = $1
-WHERE play_id = $2;
+WHERE play_id = $2 AND user_id = $3;
 
 -- name: MarkTrackAsDownloaded :exec
 UPDATE tracks
@@ -144,7 +191,773 @@ UPDATE tracks
 SET downloaded = FALSE
 WHERE track_id = $1;
 
+-- name: UpdatePlaylist :exec
+UPDATE playlists
+SET name = $2, description = $3, image_url = $4
+WHERE id = $1;
+
+-- name: PlaylistImageInUse :one
+SELECT EXISTS (
+    SELECT 1 FROM playlists WHERE image_url = $1
+);
+
 -- name: PlaylistWithNameExists :one
 SELECT EXISTS (
-    SELECT 1 FROM playlists WHERE name = $1
-);
\ No newline at end of file
+    SELECT 1 FROM playlists WHERE name = $1 AND user_id = $2
+);
+
+-- name: TopTracks :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.album_name,
+    a.cover_url,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY t.track_id, a.album_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: TopArtists :many
+SELECT
+    ar.artist_id,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: TopAlbums :many
+SELECT
+    a.album_id,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(*) AS play_count,
+    count(DISTINCT t.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = a.artist_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY a.album_id, ar.artist_id
+ORDER BY play_count DESC, seconds_listened DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListeningTotals :one
+SELECT
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    count(DISTINCT p.track_id) AS track_count,
+    COALESCE(sum(COALESCE(p.skipped_at, t.duration)), 0)::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time);
+
+-- name: SkipRates :many
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    (count(p.skipped_at)::float8 / count(*)::float8)::float8 AS skip_rate
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY t.track_id
+HAVING count(*) >= sqlc.arg(min_plays)::bigint
+ORDER BY skip_rate DESC, play_count DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListeningByHour :many
+SELECT
+    extract(hour FROM (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(time_zone)::text)::integer AS hour,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY hour
+ORDER BY hour;
+
+-- name: ListeningByWeekday :many
+SELECT
+    extract(dow FROM (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(time_zone)::text)::integer AS weekday,
+    count(*) AS play_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY weekday
+ORDER BY weekday;
+
+-- name: ListeningDays :many
+SELECT DISTINCT
+    ((p.played_at AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(time_zone)::text)::date AS day
+FROM plays p
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+ORDER BY day;
+
+-- name: FirstPlay :one
+SELECT
+    p.play_id,
+    p.played_at,
+    t.track_id,
+    t.track_name,
+    t.artists,
+    a.cover_url
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+JOIN albums a ON a.album_id = t.album_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+ORDER BY p.played_at
+LIMIT 1;
+
+-- name: MostSkippedTrack :one
+SELECT
+    t.track_id,
+    t.track_name,
+    t.artists,
+    count(*) AS play_count,
+    count(p.skipped_at) AS skip_count
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY t.track_id
+HAVING count(p.skipped_at) > 0
+ORDER BY skip_count DESC, play_count ASC
+LIMIT 1;
+
+-- name: NewArtists :many
+WITH first_plays AS (
+    SELECT t.artist_id, min(p.played_at)::timestamp AS first_played_at
+    FROM plays p
+    JOIN tracks t ON t.track_id = p.track_id
+    WHERE p.user_id = sqlc.arg(user_id)
+    GROUP BY t.artist_id
+)
+SELECT ar.artist_id, ar.artist_name, fp.first_played_at
+FROM first_plays fp
+JOIN artists ar ON ar.artist_id = fp.artist_id
+WHERE fp.first_played_at >= sqlc.arg(start_time) AND fp.first_played_at < sqlc.arg(end_time)
+ORDER BY fp.first_played_at;
+
+-- name: ListeningByMonth :many
+SELECT
+    date_trunc('month', (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(time_zone)::text)::date AS month,
+    count(*) AS play_count,
+    count(DISTINCT p.track_id) AS track_count,
+    sum(COALESCE(p.skipped_at, t.duration))::bigint AS seconds_listened
+FROM plays p
+JOIN tracks t ON t.track_id = p.track_id
+WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+GROUP BY month
+ORDER BY month;
+
+-- name: MonthlyTopTracks :many
+WITH monthly AS (
+    SELECT
+        date_trunc('month', (p.played_at AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(time_zone)::text)::date AS month,
+        p.track_id,
+        count(*) AS play_count
+    FROM plays p
+    WHERE p.user_id = sqlc.arg(user_id) AND p.played_at >= sqlc.arg(start_time) AND p.played_at < sqlc.arg(end_time)
+    GROUP BY month, p.track_id
+)
+SELECT DISTINCT ON (m.month)
+    m.month,
+    t.track_id,
+    t.track_name,
+    t.artists,
+    m.play_count
+FROM monthly m
+JOIN tracks t ON t.track_id = m.track_id
+ORDER BY m.month, m.play_count DESC, t.track_name;
+
+-- name: ListLibraryTracks :many
+-- tracks that are in the user's library (downloaded or in one of their regular playlists) along with their
+-- play stats, like and rating. tracks that were only cached from search results are left out.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = sqlc.arg(user_id)
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = sqlc.arg(user_id)
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = sqlc.arg(user_id)
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id;
+
+-- name: ListPlaylistTrackIDs :many
+SELECT track_id FROM playlist_tracks WHERE playlist_id = $1 ORDER BY position;
+
+-- name: RadioPlaylistCandidates :many
+-- tracks that share one of the user's playlists with any of the seed tracks, with the number of playlists
+-- they share.
+SELECT pt.track_id, count(DISTINCT pt.playlist_id) AS shared_playlists
+FROM playlist_tracks pt
+WHERE pt.playlist_id IN (
+    SELECT seed.playlist_id FROM playlist_tracks seed
+    JOIN playlists pl ON pl.id = seed.playlist_id
+    WHERE seed.track_id = ANY(sqlc.arg(seed_ids)::text[]) AND pl.user_id = sqlc.arg(user_id)
+)
+AND NOT (pt.track_id = ANY(sqlc.arg(seed_ids)::text[]))
+GROUP BY pt.track_id;
+
+-- name: RadioCoListenCandidates :many
+-- tracks that were played shortly after one of the seed tracks, with the number of times that happened.
+SELECT next_plays.track_id, count(*) AS co_plays
+FROM plays p
+JOIN LATERAL (
+    SELECT p2.track_id FROM plays p2
+    WHERE p2.user_id = p.user_id AND p2.played_at > p.played_at AND p2.played_at <= p.played_at + interval '30 minutes'
+    ORDER BY p2.played_at
+    LIMIT 3
+) next_plays ON TRUE
+WHERE p.track_id = ANY(sqlc.arg(seed_ids)::text[]) AND p.user_id = sqlc.arg(user_id)
+AND NOT (next_plays.track_id = ANY(sqlc.arg(seed_ids)::text[]))
+GROUP BY next_plays.track_id;
+
+-- name: RecentlyPlayedTrackIDs :many
+SELECT DISTINCT track_id FROM plays WHERE played_at >= $1 AND user_id = $2;
+
+-- name: GetTracksByIDs :many
+-- the tracks with the given IDs (in no particular order) with the same columns as ListLibraryTracks.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = sqlc.arg(user_id)
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = sqlc.arg(user_id)
+WHERE t.track_id = ANY(sqlc.arg(track_ids)::text[])
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id;
+
+-- name: GetPlaybackSession :one
+SELECT * FROM playback_sessions WHERE session_id = $1;
+
+-- name: UpsertPlaybackSession :one
+INSERT INTO playback_sessions (session_id, current_track_id, position, queue, history, shuffle, repeat, source_playlist_id, shuffle_seed, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+ON CONFLICT (session_id) DO UPDATE SET
+    current_track_id = EXCLUDED.current_track_id,
+    position = EXCLUDED.position,
+    queue = EXCLUDED.queue,
+    history = EXCLUDED.history,
+    shuffle = EXCLUDED.shuffle,
+    repeat = EXCLUDED.repeat,
+    source_playlist_id = EXCLUDED.source_playlist_id,
+    shuffle_seed = EXCLUDED.shuffle_seed,
+    updated_at = EXCLUDED.updated_at
+RETURNING updated_at;
+
+-- name: LastPlayedBefore :many
+-- when each of the tracks was last played before a point in time. tracks without plays are left out.
+SELECT track_id, max(played_at)::timestamp AS last_played_at
+FROM plays
+WHERE track_id = ANY(sqlc.arg(track_ids)::text[]) AND played_at < sqlc.arg(before) AND user_id = sqlc.arg(user_id)
+GROUP BY track_id;
+
+-- name: LockPlaylist :one
+-- locks the playlist until the end of the transaction so changes to the positions of its tracks don't race.
+SELECT id FROM playlists WHERE id = $1 FOR UPDATE;
+
+-- name: CountPlaylistTracks :one
+SELECT count(*) FROM playlist_tracks WHERE playlist_id = $1;
+
+-- name: ShiftPlaylistTracks :exec
+-- moves every track at or after position down by one to make room for a track at position.
+UPDATE playlist_tracks SET position = position + 1
+WHERE playlist_id = $1 AND position >= $2;
+
+-- name: MovePlaylistTracks :exec
+-- moves count tracks starting at from_position so they start at to_position (an index in the reordered
+-- playlist), shifting the tracks in between.
+UPDATE playlist_tracks SET position = CASE
+    WHEN position >= sqlc.arg(from_position)::integer AND position < sqlc.arg(from_position)::integer + sqlc.arg(count)::integer
+        THEN position - sqlc.arg(from_position)::integer + sqlc.arg(to_position)::integer
+    WHEN sqlc.arg(to_position)::integer > sqlc.arg(from_position)::integer
+        THEN position - sqlc.arg(count)::integer
+    ELSE position + sqlc.arg(count)::integer
+END
+WHERE playlist_id = sqlc.arg(playlist_id)
+AND position >= LEAST(sqlc.arg(from_position)::integer, sqlc.arg(to_position)::integer)
+AND position < GREATEST(sqlc.arg(from_position)::integer, sqlc.arg(to_position)::integer) + sqlc.arg(count)::integer;
+
+-- name: RenumberPlaylistTracks :exec
+-- closes the gaps in the positions of the playlist (e.g. after a track is removed).
+UPDATE playlist_tracks pt SET position = numbered.new_position
+FROM (
+    SELECT track_id, (row_number() OVER (ORDER BY position, added_at, track_id) - 1)::integer AS new_position
+    FROM playlist_tracks
+    WHERE playlist_id = $1
+) numbered
+WHERE pt.playlist_id = $1 AND pt.track_id = numbered.track_id AND pt.position <> numbered.new_position;
+
+-- name: CreateFolder :one
+INSERT INTO playlist_folders (name, parent_id, user_id)
+VALUES ($1, $2, $3)
+RETURNING id;
+
+-- name: GetFolder :one
+SELECT * FROM playlist_folders WHERE id = $1;
+
+-- name: ListFolders :many
+SELECT * FROM playlist_folders WHERE user_id = $1 ORDER BY name;
+
+-- name: RenameFolder :exec
+UPDATE playlist_folders SET name = $2 WHERE id = $1;
+
+-- name: MoveFolder :exec
+UPDATE playlist_folders SET parent_id = $2 WHERE id = $1;
+
+-- name: FolderIsWithin :one
+-- whether folder_id is ancestor_id or inside of it (at any depth).
+WITH RECURSIVE chain AS (
+    SELECT f.id, f.parent_id FROM playlist_folders f WHERE f.id = sqlc.arg(folder_id)
+    UNION ALL
+    SELECT f.id, f.parent_id FROM playlist_folders f JOIN chain c ON f.id = c.parent_id
+)
+SELECT EXISTS (
+    SELECT 1 FROM chain WHERE chain.id = sqlc.arg(ancestor_id)
+);
+
+-- name: MoveFolderPlaylistsToRoot :exec
+-- moves the playlists in the folder and all of its subfolders to the root.
+WITH RECURSIVE subtree AS (
+    SELECT f.id FROM playlist_folders f WHERE f.id = $1
+    UNION ALL
+    SELECT f.id FROM playlist_folders f JOIN subtree s ON f.parent_id = s.id
+)
+UPDATE playlists SET folder_id = NULL
+WHERE folder_id IN (SELECT id FROM subtree);
+
+-- name: DeleteFolder :exec
+DELETE FROM playlist_folders WHERE id = $1;
+
+-- name: MovePlaylistToFolder :exec
+UPDATE playlists SET folder_id = $2 WHERE id = $1;
+
+-- name: ListLikedTracks :many
+-- the liked tracks, most recently liked first, with the same columns as ListLibraryTracks.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM track_ratings r
+JOIN tracks t ON t.track_id = r.track_id
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = r.user_id
+WHERE r.user_id = $1 AND r.liked_at IS NOT NULL
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY r.liked_at DESC;
+
+-- name: GetTrackRating :one
+SELECT * FROM track_ratings WHERE user_id = $1 AND track_id = $2;
+
+-- name: SetTrackLiked :exec
+-- likes (keeping the original like date if it's already liked) or unlikes the track.
+INSERT INTO track_ratings (user_id, track_id, liked_at)
+VALUES (sqlc.arg(user_id), sqlc.arg(track_id), CASE WHEN sqlc.arg(liked)::boolean THEN CURRENT_TIMESTAMP END)
+ON CONFLICT (user_id, track_id) DO UPDATE SET
+    liked_at = CASE WHEN sqlc.arg(liked)::boolean THEN COALESCE(track_ratings.liked_at, CURRENT_TIMESTAMP) END;
+
+-- name: SetTrackRating :exec
+INSERT INTO track_ratings (user_id, track_id, rating)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, track_id) DO UPDATE SET rating = EXCLUDED.rating;
+
+-- name: ArtistGenresFetched :one
+SELECT genres IS NOT NULL AS fetched FROM artists WHERE artist_id = $1;
+
+-- name: SetArtistGenres :exec
+UPDATE artists SET genres = $2 WHERE artist_id = $1;
+
+-- name: ListGenres :many
+-- the genres of the artists of library tracks and how many library tracks each has.
+SELECT g.genre::text AS genre, count(DISTINCT t.track_id) AS track_count
+FROM artists ar
+CROSS JOIN LATERAL unnest(ar.genres) AS g(genre)
+JOIN tracks t ON t.artist_id = ar.artist_id
+WHERE t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = $1
+)
+GROUP BY g.genre
+ORDER BY g.genre;
+
+-- name: ListTracksByGenre :many
+-- the library tracks whose artist has the genre (case insensitive), with the same columns as ListLibraryTracks.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = sqlc.arg(user_id)
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = sqlc.arg(user_id)
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = sqlc.arg(user_id)
+)) AND EXISTS (
+    SELECT 1 FROM unnest(ar.genres) AS g(genre) WHERE lower(g.genre) = lower(sqlc.arg(genre)::text)
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name);
+
+-- name: TagTargetExists :one
+SELECT CASE sqlc.arg(target_type)::text
+    WHEN 'track' THEN EXISTS (SELECT 1 FROM tracks WHERE track_id = sqlc.arg(target_id)::text)
+    WHEN 'album' THEN EXISTS (SELECT 1 FROM albums WHERE album_id = sqlc.arg(target_id)::text)
+    WHEN 'artist' THEN EXISTS (SELECT 1 FROM artists WHERE artist_id = sqlc.arg(target_id)::text)
+    ELSE FALSE
+END::boolean AS exists;
+
+-- name: AddTag :exec
+INSERT INTO tags (tag, target_type, target_id)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveTag :exec
+DELETE FROM tags WHERE tag = $1 AND target_type = $2 AND target_id = $3;
+
+-- name: ListTagsOf :many
+SELECT tag FROM tags WHERE target_type = $1 AND target_id = $2 ORDER BY tag;
+
+-- name: ListTags :many
+-- every tag and how many tracks, albums and artists have it.
+SELECT tag, count(*) AS count FROM tags GROUP BY tag ORDER BY tag;
+
+-- name: ListTracksByTag :many
+-- the library tracks that have the tag themselves or through their album or (main) artist, with the same
+-- columns as ListLibraryTracks.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = sqlc.arg(user_id)
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = sqlc.arg(user_id)
+WHERE (t.downloaded OR EXISTS (
+    SELECT 1 FROM playlist_tracks pt
+    JOIN playlists pl ON pl.id = pt.playlist_id
+    WHERE pt.track_id = t.track_id AND pl.user_id = sqlc.arg(user_id)
+)) AND EXISTS (
+    SELECT 1 FROM tags tg
+    WHERE tg.tag = sqlc.arg(tag) AND (
+        (tg.target_type = 'track' AND tg.target_id = t.track_id)
+        OR (tg.target_type = 'album' AND tg.target_id = t.album_id)
+        OR (tg.target_type = 'artist' AND tg.target_id = t.artist_id)
+    )
+)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY lower(ar.artist_name), lower(a.album_name), lower(t.track_name);
+
+-- name: LockUsers :exec
+-- keeps two first-run setups from both creating an admin.
+LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE;
+
+-- name: CountUsers :one
+SELECT count(*) FROM users;
+
+-- name: CreateUser :one
+INSERT INTO users (username, password_hash, is_admin)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetUserByUsername :one
+SELECT * FROM users WHERE username = $1;
+
+-- name: CreateUserSession :exec
+INSERT INTO user_sessions (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: GetUserBySession :one
+SELECT users.* FROM user_sessions
+JOIN users ON users.id = user_sessions.user_id
+WHERE user_sessions.token_hash = $1 AND user_sessions.expires_at > CURRENT_TIMESTAMP;
+
+-- name: DeleteUserSession :exec
+DELETE FROM user_sessions WHERE token_hash = $1;
+
+-- name: DeleteUserSessionsExcept :exec
+-- logs the user out everywhere but the session with the token hash (which can be empty to log out everywhere).
+DELETE FROM user_sessions WHERE user_id = $1 AND token_hash <> $2;
+
+-- name: DeleteExpiredUserSessions :exec
+DELETE FROM user_sessions WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at;
+
+-- name: ListAPITokens :many
+SELECT id, name, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_tokens
+WHERE user_id = $1 ORDER BY created_at DESC;
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: GetUserByAPIToken :one
+-- only tokens that are neither revoked nor expired.
+SELECT users.id, users.username, users.password_hash, users.is_admin, users.created_at,
+    api_tokens.id AS token_id, api_tokens.scopes
+FROM api_tokens
+JOIN users ON users.id = api_tokens.user_id
+WHERE api_tokens.token_hash = $1 AND api_tokens.revoked_at IS NULL
+    AND (api_tokens.expires_at IS NULL OR api_tokens.expires_at > CURRENT_TIMESTAMP);
+
+-- name: TouchAPIToken :exec
+-- records that the token was used. it's only written once a minute so every request doesn't write.
+UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - interval '1 minute');
+
+-- name: ClaimUnownedData :exec
+-- gives the playlists, folders, plays, likes & ratings and playback session from before there were users to
+-- the user.
+WITH claimed_playlists AS (
+    UPDATE playlists SET user_id = sqlc.arg(user_id) WHERE playlists.user_id IS NULL
+), claimed_folders AS (
+    UPDATE playlist_folders SET user_id = sqlc.arg(user_id) WHERE playlist_folders.user_id IS NULL
+), claimed_plays AS (
+    UPDATE plays SET user_id = sqlc.arg(user_id) WHERE plays.user_id IS NULL
+), claimed_session AS (
+    UPDATE playback_sessions SET session_id = sqlc.arg(user_id)::text WHERE session_id = 'default'
+)
+UPDATE track_ratings SET user_id = sqlc.arg(user_id) WHERE track_ratings.user_id IS NULL;
+
+-- name: ListUsers :many
+SELECT id, username, is_admin, created_at FROM users ORDER BY created_at;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: DeletePlaybackSession :exec
+DELETE FROM playback_sessions WHERE session_id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = $2 WHERE id = $1;
+
+-- name: SetSubsonicPassword :exec
+INSERT INTO subsonic_passwords (user_id, password)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET password = EXCLUDED.password, created_at = CURRENT_TIMESTAMP;
+
+-- name: GetSubsonicPassword :one
+SELECT password FROM subsonic_passwords WHERE user_id = $1;
+
+-- name: ListAlbumTracks :many
+-- the tracks of the album that are known (in the library or cached from search results) with the same
+-- columns as ListLibraryTracks.
+SELECT
+    t.track_id,
+    t.track_name,
+    t.duration,
+    t.popularity,
+    t.album_id,
+    t.artist_id,
+    t.artists,
+    t.track_release_date,
+    t.downloaded,
+    t.added_at,
+    a.album_name,
+    a.cover_url,
+    ar.artist_name,
+    count(p.play_id) AS play_count,
+    count(p.skipped_at) AS skip_count,
+    max(p.played_at)::timestamp AS last_played_at,
+    r.liked_at,
+    r.rating
+FROM tracks t
+JOIN albums a ON a.album_id = t.album_id
+JOIN artists ar ON ar.artist_id = t.artist_id
+LEFT JOIN plays p ON p.track_id = t.track_id AND p.user_id = sqlc.arg(user_id)
+LEFT JOIN track_ratings r ON r.track_id = t.track_id AND r.user_id = sqlc.arg(user_id)
+WHERE t.album_id = sqlc.arg(album_id)
+GROUP BY t.track_id, a.album_id, ar.artist_id, r.track_id
+ORDER BY t.track_name;
+
+-- name: RecordFailedDownload :exec
+INSERT INTO failed_downloads (thing, error)
+VALUES ($1, $2)
+ON CONFLICT (thing) DO UPDATE SET
+    error = EXCLUDED.error,
+    attempts = failed_downloads.attempts + 1,
+    failed_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteFailedDownload :exec
+DELETE FROM failed_downloads WHERE thing = $1;
+
+-- name: ListFailedDownloads :many
+SELECT * FROM failed_downloads ORDER BY failed_at DESC;
+
+-- name: ListTrackDownloadStates :many
+SELECT track_id, downloaded FROM tracks ORDER BY track_id;
+
+-- name: LibraryCounts :one
+SELECT
+    (SELECT count(*) FROM tracks) AS tracks,
+    (SELECT count(*) FROM tracks WHERE downloaded) AS downloaded_tracks,
+    (SELECT count(*) FROM albums) AS albums,
+    (SELECT count(*) FROM artists) AS artists,
+    (SELECT count(*) FROM playlists) AS playlists,
+    (SELECT count(*) FROM users) AS users,
+    (SELECT count(*) FROM plays) AS plays,
+    (SELECT count(*) FROM failed_downloads) AS failed_downloads;
+
+-- name: CreateWebhook :one
+INSERT INTO webhooks (user_id, url, secret, events)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ListWebhooks :many
+SELECT * FROM webhooks WHERE user_id = $1 ORDER BY created_at;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks WHERE id = $1 AND user_id = $2;
+
+-- name: UpdateWebhook :execrows
+UPDATE webhooks SET url = $3, events = $4 WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE id = $1 AND user_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- queues an event for the webhooks of the user it happened to that are subscribed to it.
+INSERT INTO webhook_deliveries (webhook_id, event, payload)
+SELECT id, sqlc.arg(event)::text, sqlc.arg(payload)::jsonb FROM webhooks
+WHERE sqlc.arg(event)::text = ANY(events)
+    AND user_id = sqlc.arg(user_id)::uuid;
+
+-- name: ListDueWebhookDeliveries :many
+SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE d.status = 'pending' AND d.next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY d.next_attempt_at
+LIMIT $1;
+
+-- name: RecordWebhookAttempt :exec
+-- records an attempt at a delivery. a delivery that's still pending is attempted again in retry_after seconds.
+UPDATE webhook_deliveries SET
+    attempts = attempts + 1,
+    status = sqlc.arg(status)::text,
+    last_status_code = sqlc.narg(last_status_code),
+    last_error = sqlc.narg(last_error),
+    next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => sqlc.arg(retry_after)::integer),
+    delivered_at = CASE WHEN sqlc.arg(status)::text = 'delivered' THEN CURRENT_TIMESTAMP END
+WHERE id = sqlc.arg(id);
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id LIMIT $2;
+
+-- name: RetryWebhookDelivery :execrows
+-- sends a failed delivery again, with a new set of attempts.
+UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND webhook_id = $2 AND status = 'failed';
+
+-- name: DeleteOldWebhookDeliveries :exec
+-- deliveries are kept for a week after they're created so they can be looked at, unless they're pending.
+DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < CURRENT_TIMESTAMP - interval '7 days';
@@ -1,3 +1,4 @@
+-- a change to the schema needs a migration for sqlite too (db/sqlite/migrations).
 CREATE TABLE IF NOT EXISTS artists (
     artist_id text PRIMARY KEY,
     artist_name text NOT NULL
@@ -41,4 +42,184 @@ CREATE TABLE IF NOT EXISTS plays (
     track_id text NOT NULL REFERENCES tracks(track_id) ON DELETE CASCADE,
     played_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     skipped_at integer -- can be NULL if not skipped, x second into the track when skipped
-);
\ No newline at end of file
+);
+
+-- playback_sessions is the player state (current track, queue, history...) so any client can resume where
+-- another one left off. tracks are referenced by ID and every user has one session (see the user_id
+-- columns below).
+CREATE TABLE IF NOT EXISTS playback_sessions (
+    session_id text PRIMARY KEY,
+    current_track_id text REFERENCES tracks(track_id) ON DELETE SET NULL,
+    position double precision NOT NULL DEFAULT 0, -- seconds into the current track
+    queue text[] NOT NULL DEFAULT '{}',
+    history text[] NOT NULL DEFAULT '{}', -- oldest first
+    shuffle boolean NOT NULL DEFAULT FALSE,
+    repeat text NOT NULL DEFAULT 'off', -- off, one or all
+    source_playlist_id uuid REFERENCES playlists(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- playlist_folders organize playlists into a tree. a folder with no parent is at the root.
+CREATE TABLE IF NOT EXISTS playlist_folders (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name text NOT NULL,
+    parent_id uuid REFERENCES playlist_folders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- track_ratings are the likes and 1-5 star ratings of tracks. liked_at is NULL if the track isn't liked
+-- and rating is NULL if it isn't rated.
+CREATE TABLE IF NOT EXISTS track_ratings (
+    track_id text PRIMARY KEY REFERENCES tracks(track_id) ON DELETE CASCADE,
+    liked_at TIMESTAMP,
+    rating integer CHECK (rating BETWEEN 1 AND 5)
+);
+CREATE INDEX IF NOT EXISTS track_ratings_liked_at_idx ON track_ratings (liked_at) WHERE liked_at IS NOT NULL;
+
+-- tags are free-form labels (moods, occasions, ...) put on tracks, albums and artists. target_id is the
+-- track, album or artist ID depending on target_type. tags are stored lowercase.
+CREATE TABLE IF NOT EXISTS tags (
+    tag text NOT NULL,
+    target_type text NOT NULL CHECK (target_type IN ('track', 'album', 'artist')),
+    target_id text NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tag, target_type, target_id)
+);
+CREATE INDEX IF NOT EXISTS tags_target_idx ON tags (target_type, target_id);
+
+-- users can log in to the api and ui. the first one is created by the first-run setup and is an admin.
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    username text UNIQUE NOT NULL,
+    password_hash text NOT NULL, -- bcrypt
+    is_admin boolean NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- user_sessions are logins from the ui. the session cookie holds the token and only its sha256 hash is stored.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    token_hash text PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- api_tokens are for scripts, which send them as "Authorization: Bearer <token>". only the sha256 hash of
+-- the token is stored.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    token_hash text UNIQUE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- subsonic_passwords are the passwords subsonic clients log in with. subsonic's token auth sends
+-- md5(password + salt), so unlike the login password this one has to be stored as it is. they are generated
+-- by the server so they're never a password that's used anywhere else.
+CREATE TABLE IF NOT EXISTS subsonic_passwords (
+    user_id uuid PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    password text NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- failed_downloads are the downloads that didn't work (by what was asked to be downloaded, usually a spotify
+-- track url) so they can be listed and retried. a download that works removes its entry.
+CREATE TABLE IF NOT EXISTS failed_downloads (
+    thing text PRIMARY KEY,
+    error text NOT NULL,
+    attempts integer NOT NULL DEFAULT 1,
+    failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- webhooks are urls the events of a user's library are sent to (see library.WebhookEvents). the secret signs
+-- the requests so the receiver can tell they're from this server, so it has to be stored as it is.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- webhook_deliveries are the events sent (or to be sent) to a webhook. status is pending until the delivery
+-- works (delivered) or has been attempted too many times (failed); a pending delivery that was attempted is
+-- attempted again at next_attempt_at.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    webhook_id uuid NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    last_status_code integer,
+    last_error text,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_idx ON webhook_deliveries (webhook_id, created_at);
+
+-- stats queries filter plays by time window and group by track, so both need indexes (the unique one is
+-- further down since it includes plays.user_id).
+CREATE INDEX IF NOT EXISTS plays_played_at_idx ON plays (played_at);
+
+-- columns added after the initial schema. they're ALTERs so this file can be re-run on an existing database.
+
+-- tracks.added_at is when the track first entered the library (smart playlists can filter on it).
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+-- playlists.rules is NULL for regular playlists. smart playlists store their rules here and have their
+-- tracks evaluated when read instead of being stored in playlist_tracks.
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS rules jsonb;
+-- playback_sessions.shuffle_seed is the seed of the server side shuffle the queue came from (NULL if the
+-- queue isn't shuffled) so the same order can be generated again.
+ALTER TABLE playback_sessions ADD COLUMN IF NOT EXISTS shuffle_seed bigint;
+-- playlist_tracks.position is the (0 based) order of the tracks in a playlist and added_at is when the
+-- track was added to it. existing entries are numbered by when their track was added to the library and
+-- get the time of the migration as added_at since there's nothing better.
+ALTER TABLE playlist_tracks ADD COLUMN IF NOT EXISTS position integer;
+ALTER TABLE playlist_tracks ADD COLUMN IF NOT EXISTS added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE playlist_tracks pt SET position = numbered.position
+FROM (
+    SELECT pt2.playlist_id, pt2.track_id, row_number() OVER (PARTITION BY pt2.playlist_id ORDER BY t.added_at, t.track_id) - 1 AS position
+    FROM playlist_tracks pt2
+    JOIN tracks t ON t.track_id = pt2.track_id
+) numbered
+WHERE pt.position IS NULL AND pt.playlist_id = numbered.playlist_id AND pt.track_id = numbered.track_id;
+ALTER TABLE playlist_tracks ALTER COLUMN position SET NOT NULL;
+CREATE INDEX IF NOT EXISTS playlist_tracks_position_idx ON playlist_tracks (playlist_id, position);
+-- playlists.folder_id is the folder the playlist is in, NULL for the root.
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS folder_id uuid REFERENCES playlist_folders(id) ON DELETE CASCADE;
+-- artists.genres are the artist's genres from spotify, NULL if they haven't been fetched yet.
+ALTER TABLE artists ADD COLUMN IF NOT EXISTS genres text[];
+-- what users have to themselves is owned by a user: playlists, folders, plays and likes & ratings. playback
+-- sessions use the ID of their user as the session_id. tracks, albums, artists, tags and the downloaded
+-- audio are shared. rows from before there were users go to the first admin, here if it already exists
+-- and otherwise when the first-run setup creates it.
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE playlist_folders ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE plays ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE track_ratings ADD COLUMN IF NOT EXISTS user_id uuid REFERENCES users(id) ON DELETE CASCADE;
+UPDATE playlists SET user_id = (SELECT id FROM users WHERE is_admin ORDER BY created_at LIMIT 1) WHERE user_id IS NULL;
+UPDATE playlist_folders SET user_id = (SELECT id FROM users WHERE is_admin ORDER BY created_at LIMIT 1) WHERE user_id IS NULL;
+UPDATE plays SET user_id = (SELECT id FROM users WHERE is_admin ORDER BY created_at LIMIT 1) WHERE user_id IS NULL;
+UPDATE track_ratings SET user_id = (SELECT id FROM users WHERE is_admin ORDER BY created_at LIMIT 1) WHERE user_id IS NULL;
+UPDATE playback_sessions SET session_id = (SELECT id FROM users WHERE is_admin ORDER BY created_at LIMIT 1)::text
+WHERE session_id = 'default' AND EXISTS (SELECT 1 FROM users);
+-- playlist names, likes & ratings and plays are unique per user instead of globally. the unique index on
+-- plays backs the ON CONFLICT clause of RecordPlay.
+ALTER TABLE playlists DROP CONSTRAINT IF EXISTS playlists_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS playlists_user_id_name_idx ON playlists (user_id, name);
+ALTER TABLE track_ratings DROP CONSTRAINT IF EXISTS track_ratings_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS track_ratings_user_id_track_id_idx ON track_ratings (user_id, track_id);
+DROP INDEX IF EXISTS plays_track_id_played_at_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS plays_user_id_track_id_played_at_idx ON plays (user_id, track_id, played_at);
+CREATE INDEX IF NOT EXISTS plays_user_id_played_at_idx ON plays (user_id, played_at);
+-- api tokens are limited to scopes (see library.Scopes), can expire and are revoked instead of deleted so
+-- they still show up in the list. tokens from before scopes existed keep full access.
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS scopes text[] NOT NULL DEFAULT '{read,playlists:write,downloads,admin}';
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
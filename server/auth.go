@@ -0,0 +1,261 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// sessionCookie is the name of the cookie that holds the session token of a logged in ui.
+const sessionCookie = "music_session"
+
+// publicRoutes are the routes under /api/v1 that can be used without logging in.
+var publicRoutes = map[string]bool{
+	"/api/v1/auth/status":  true,
+	"/api/v1/auth/login":   true,
+	"/api/v1/auth/setup":   true,
+	"/api/v1/openapi.json": true,
+}
+
+// requireAuth is the middleware of the /api/v1 group. requests need either an api token
+// ("Authorization: Bearer <token>") with the scope the route requires or the session cookie, except for
+// the routes in publicRoutes.
+func (s *Server) requireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publicRoutes[c.Path()] {
+			return next(c)
+		}
+		user, scopes, err := s.authenticate(c)
+		if errors.Is(err, library.ErrInvalidCredentials) {
+			return c.JSON(401, errormap("unauthorized"))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		if scope := requiredScope(c); !slices.Contains(scopes, scope) {
+			return c.JSON(403, errormap(fmt.Sprintf("this api token doesn't have the %s scope", scope)))
+		}
+		c.Set("user", user)
+		return next(c)
+	}
+}
+
+// authenticate returns the user the request is from and the scopes it may use. a logged in ui may use
+// every scope.
+func (s *Server) authenticate(c echo.Context) (library.User, []string, error) {
+	ctx := c.Request().Context()
+	if token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer "); ok {
+		return s.lib.UserByAPIToken(ctx, token)
+	}
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return library.User{}, nil, library.ErrInvalidCredentials
+	}
+	user, err := s.lib.UserBySession(ctx, cookie.Value)
+	return user, library.Scopes, err
+}
+
+// requiredScope returns the api token scope the route needs. managing the account (tokens, password,
+// users, webhooks) needs admin and downloading needs downloads. other than that reading needs read and any
+// change needs playlists:write.
+func requiredScope(c echo.Context) string {
+	path := c.Path()
+	switch {
+	case strings.HasPrefix(path, "/api/v1/auth/"), strings.HasPrefix(path, "/api/v1/users"),
+		strings.HasPrefix(path, "/api/v1/webhooks"):
+		return library.ScopeAdmin
+	case strings.HasPrefix(path, "/api/v1/download/"), strings.HasPrefix(path, "/api/v1/download-playlist/"):
+		return library.ScopeDownloads
+	case c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead:
+		return library.ScopeRead
+	default:
+		return library.ScopePlaylistsWrite
+	}
+}
+
+// currentUser returns the user set by requireAuth.
+func currentUser(c echo.Context) library.User {
+	user, _ := c.Get("user").(library.User)
+	return user
+}
+
+// registerAuthRoutes registers the login, first-run setup, password, api token and subsonic password routes.
+func (s *Server) registerAuthRoutes(api *echo.Group) {
+	type Credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	// whether the first-run setup is needed and who is logged in (user is null if nobody is)
+	api.GET("/auth/status", func(c echo.Context) error {
+		setupRequired, err := s.lib.NeedsSetup(c.Request().Context())
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		var user *library.User
+		if u, _, err := s.authenticate(c); err == nil {
+			user = &u
+		}
+		return c.JSON(200, map[string]any{
+			"setup_required": setupRequired,
+			"user":           user,
+		})
+	})
+
+	// create the admin account on a fresh instance and log in as it
+	api.POST("/auth/setup", bindreq(func(c echo.Context, req Credentials) error {
+		if msg := validateCredentials(req.Username, req.Password); msg != "" {
+			return c.JSON(400, errormap(msg))
+		}
+		ctx := c.Request().Context()
+		if _, err := s.lib.Setup(ctx, req.Username, req.Password); errors.Is(err, library.ErrSetupDone) {
+			return c.JSON(403, errormap(err.Error()))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return s.login(c, req.Username, req.Password)
+	}))
+
+	api.POST("/auth/login", bindreq(func(c echo.Context, req Credentials) error {
+		return s.login(c, req.Username, req.Password)
+	}))
+
+	api.POST("/auth/logout", func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			if err := s.lib.Logout(c.Request().Context(), cookie.Value); err != nil {
+				return c.JSON(500, errormap(err.Error()))
+			}
+		}
+		c.SetCookie(s.sessionCookie(c, "", time.Unix(0, 0)))
+		return c.JSON(200, nil)
+	})
+
+	api.PUT("/auth/password", bindreq(func(c echo.Context, req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}) error {
+		user := currentUser(c)
+		if msg := validateCredentials(user.Username, req.NewPassword); msg != "" {
+			return c.JSON(400, errormap(msg))
+		}
+		// the other sessions are logged out, the one changing the password (if it's not an api token) stays
+		var session string
+		if cookie, err := c.Cookie(sessionCookie); err == nil && !strings.HasPrefix(c.Request().Header.Get("Authorization"), "Bearer ") {
+			session = cookie.Value
+		}
+		err := s.lib.ChangePassword(c.Request().Context(), user.Username, req.CurrentPassword, req.NewPassword, session)
+		if errors.Is(err, library.ErrInvalidCredentials) {
+			return c.JSON(403, errormap("the current password is wrong"))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	api.GET("/auth/tokens", func(c echo.Context) error {
+		tokens, err := s.lib.ListAPITokens(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tokens))
+	})
+
+	// create an api token with the scopes (see library.Scopes) that expires at expires_at (or never if it's
+	// null). the token is only ever shown in this response.
+	api.POST("/auth/tokens", bindreq(func(c echo.Context, req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}) error {
+		if req.Name == "" {
+			return c.JSON(400, errormap("name is required"))
+		}
+		if len(req.Name) > 50 {
+			return c.JSON(400, errormap("name may be at most 50 characters"))
+		}
+		var expiresAt time.Time
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
+		}
+		token, info, err := s.lib.CreateAPIToken(c.Request().Context(), currentUser(c).ID, req.Name, req.Scopes, expiresAt)
+		if errors.Is(err, library.ErrNoScopes) || errors.Is(err, library.ErrUnknownScope) || errors.Is(err, library.ErrExpiryInPast) {
+			return c.JSON(400, errormap(err.Error()))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]any{
+			"token":      token,
+			"id":         info.ID,
+			"name":       info.Name,
+			"scopes":     info.Scopes,
+			"created_at": info.CreatedAt,
+			"expires_at": info.ExpiresAt,
+		})
+	}))
+
+	// revoke an api token
+	api.DELETE("/auth/tokens/:tokenID", func(c echo.Context) error {
+		err := s.lib.RevokeAPIToken(c.Request().Context(), currentUser(c).ID, c.Param("tokenID"))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.JSON(404, errormap("api token not found"))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	})
+
+	// generate a new password for subsonic clients (replacing the old one). it's only shown in this response.
+	api.POST("/auth/subsonic-password", func(c echo.Context) error {
+		password, err := s.lib.SetSubsonicPassword(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]string{"password": password})
+	})
+}
+
+// login checks the credentials, sets the session cookie and responds with the user.
+func (s *Server) login(c echo.Context, username, password string) error {
+	token, user, err := s.lib.Login(c.Request().Context(), username, password)
+	if errors.Is(err, library.ErrInvalidCredentials) {
+		return c.JSON(401, errormap(err.Error()))
+	} else if err != nil {
+		return c.JSON(500, errormap(err.Error()))
+	}
+	c.SetCookie(s.sessionCookie(c, token, time.Now().Add(library.SessionDuration)))
+	return c.JSON(200, user)
+}
+
+func (s *Server) sessionCookie(c echo.Context, token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func validateCredentials(username, password string) string {
+	if username == "" {
+		return "username is required"
+	}
+	if len(username) > 32 {
+		return "username may be at most 32 characters"
+	}
+	if len(password) < 8 {
+		return "password must be at least 8 characters"
+	}
+	if len(password) > 72 { // the most bcrypt uses
+		return "password may be at most 72 bytes"
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// device commands that can be sent to another client
+const (
+	CommandPlay     = "play"     // resume, or play TrackIDs if there are any
+	CommandPause    = "pause"    // pause
+	CommandSeek     = "seek"     // seek to Position
+	CommandNext     = "next"     // skip to the next track
+	CommandPrevious = "previous" // go back to the previous track
+	CommandQueue    = "queue"    // add TrackIDs to the queue
+)
+
+const (
+	// deviceKeepAlive is how often a comment is sent on idle device streams so proxies don't close them.
+	deviceKeepAlive = 20 * time.Second
+	// deviceEventBuffer is the number of events that can wait to be sent to a device. if a device falls
+	// further behind than this, events to it are dropped.
+	deviceEventBuffer = 32
+)
+
+// DeviceCommand is a command relayed from one device to another.
+type DeviceCommand struct {
+	Command  string   `json:"command"`
+	Position float64  `json:"position,omitempty"`
+	TrackIDs []string `json:"track_ids,omitempty"`
+	From     string   `json:"from,omitempty"` // ID of the device that sent the command
+}
+
+// DeviceState is what a device reports about its player.
+type DeviceState struct {
+	TrackID   string    `json:"track_id"`
+	IsPlaying bool      `json:"is_playing"`
+	Position  float64   `json:"position"`
+	Volume    float64   `json:"volume"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// device is a connected client. it exists for as long as its event stream is open.
+type device struct {
+	ID          string       `json:"device_id"`
+	Name        string       `json:"name"`
+	ConnectedAt time.Time    `json:"connected_at"`
+	State       *DeviceState `json:"state"` // nil until the device reports its state
+
+	userID pgtype.UUID // devices only see and control the other devices of the same user
+	events chan Event
+	done   chan struct{} // closed when the device is replaced by a new connection with the same ID
+}
+
+// deviceHub keeps track of the connected devices of every user and relays events between them.
+type deviceHub struct {
+	devices map[pgtype.UUID]map[string]*device // by user, then by device ID
+	mx      sync.Mutex
+}
+
+func newDeviceHub() *deviceHub {
+	return &deviceHub{devices: make(map[pgtype.UUID]map[string]*device)}
+}
+
+// connect registers a device. a device that reconnects with the same ID replaces its old connection.
+func (h *deviceHub) connect(d *device) {
+	h.mx.Lock()
+	devices, ok := h.devices[d.userID]
+	if !ok {
+		devices = make(map[string]*device)
+		h.devices[d.userID] = devices
+	}
+	if old, ok := devices[d.ID]; ok {
+		close(old.done)
+		d.State = old.State
+	}
+	devices[d.ID] = d
+	h.mx.Unlock()
+	h.broadcastDevices(d.userID)
+}
+
+// disconnect removes the device, unless it has already been replaced by a newer connection.
+func (h *deviceHub) disconnect(d *device) {
+	h.mx.Lock()
+	devices := h.devices[d.userID]
+	if devices[d.ID] != d {
+		h.mx.Unlock()
+		return
+	}
+	delete(devices, d.ID)
+	if len(devices) == 0 {
+		delete(h.devices, d.userID)
+	}
+	h.mx.Unlock()
+	h.broadcastDevices(d.userID)
+}
+
+// list returns a copy of the user's connected devices sorted by name.
+func (h *deviceHub) list(userID pgtype.UUID) []device {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	devices := make([]device, 0, len(h.devices[userID]))
+	for _, d := range h.devices[userID] {
+		devices = append(devices, device{ID: d.ID, Name: d.Name, ConnectedAt: d.ConnectedAt, State: d.State})
+	}
+	slices.SortFunc(devices, func(a, b device) int { return strings.Compare(a.Name, b.Name) })
+	return devices
+}
+
+// send sends an event to one of the user's devices. it returns false if the device isn't connected.
+func (h *deviceHub) send(userID pgtype.UUID, deviceID string, event string, v any) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	d, ok := h.devices[userID][deviceID]
+	if !ok {
+		return false
+	}
+	d.push(event, v)
+	return true
+}
+
+// setState stores the state of one of the user's devices and sends it to their other devices.
+func (h *deviceHub) setState(userID pgtype.UUID, deviceID string, state DeviceState) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	d, ok := h.devices[userID][deviceID]
+	if !ok {
+		return false
+	}
+	state.UpdatedAt = time.Now()
+	d.State = &state
+	for _, other := range h.devices[userID] {
+		if other != d {
+			other.push("state", map[string]any{"device_id": d.ID, "state": state})
+		}
+	}
+	return true
+}
+
+// broadcastDevices sends the list of the user's devices to each of them.
+func (h *deviceHub) broadcastDevices(userID pgtype.UUID) {
+	devices := h.list(userID)
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for _, d := range h.devices[userID] {
+		d.push("devices", devices)
+	}
+}
+
+// push queues an event for the device without blocking. the hub must be locked.
+func (d *device) push(event string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("marshal device event", "error", err, "event", event)
+		return
+	}
+	select {
+	case d.events <- Event{Event: []byte(event), Data: b}:
+	default:
+		slog.Warn("device is not keeping up, dropping event", "device_id", d.ID, "event", event)
+	}
+}
+
+// registerDeviceRoutes registers the remote control routes. every open client connects to
+// /devices/connect and keeps the event stream open; the server relays commands and player states
+// between the connected devices of the same user.
+func (s *Server) registerDeviceRoutes(api *echo.Group) {
+	devices := api.Group("/devices")
+
+	devices.GET("", func(c echo.Context) error {
+		return c.JSON(200, s.devices.list(currentUser(c).ID))
+	})
+
+	// the event stream of a device. the first event is "hello" with the device's ID (clients should keep it
+	// and send it back as ?device_id= when reconnecting), then "devices" whenever a device connects or
+	// disconnects, "state" when another device reports its state and "command" for commands to this device.
+	// when the server shuts down the stream ends with "shutdown".
+	devices.GET("/connect", func(c echo.Context) error {
+		id := c.QueryParam("device_id")
+		if id == "" {
+			id = uuid.New().String()
+		}
+		name := c.QueryParam("name")
+		if name == "" {
+			name = "unnamed device"
+		}
+		if len(name) > 60 {
+			return c.JSON(400, errormap("name must be 60 characters or less"))
+		}
+
+		w := c.Response()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		d := &device{
+			ID:          id,
+			Name:        name,
+			ConnectedAt: time.Now(),
+			userID:      currentUser(c).ID,
+			events:      make(chan Event, deviceEventBuffer),
+			done:        make(chan struct{}),
+		}
+		d.push("hello", map[string]string{"device_id": id})
+		s.devices.connect(d)
+		defer s.devices.disconnect(d)
+
+		rc := http.NewResponseController(w)
+		ticker := time.NewTicker(deviceKeepAlive)
+		defer ticker.Stop()
+		for {
+			var e Event
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-d.done:
+				return nil
+			case <-s.shuttingDown:
+				return writeShutdownEvent(w)
+			case <-ticker.C:
+				e = Event{Comment: []byte("keep-alive")}
+			case e = <-d.events:
+			}
+			if err := e.MarshalTo(w); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+		}
+	})
+
+	// report the player state of a device, which is forwarded to the other devices
+	devices.PUT("/:deviceID/state", bindreq(func(c echo.Context, req DeviceState) error {
+		if !s.devices.setState(currentUser(c).ID, c.Param("deviceID"), req) {
+			return c.JSON(404, errormap("device not connected"))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	// send a command to a device
+	devices.POST("/:deviceID/commands", bindreq(func(c echo.Context, req DeviceCommand) error {
+		switch req.Command {
+		case CommandPlay, CommandPause, CommandNext, CommandPrevious:
+		case CommandSeek:
+			if req.Position < 0 {
+				return c.JSON(400, errormap("position may not be negative"))
+			}
+		case CommandQueue:
+			if len(req.TrackIDs) == 0 {
+				return c.JSON(400, errormap("track_ids is required"))
+			}
+		default:
+			return c.JSON(400, errormap("unknown command"))
+		}
+		if !s.devices.send(currentUser(c).ID, c.Param("deviceID"), "command", req) {
+			return c.JSON(404, errormap("device not connected"))
+		}
+		return c.JSON(200, nil)
+	}))
+}
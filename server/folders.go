@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerFolderRoutes registers the playlist folder routes. folders can contain playlists and other
+// folders; a parent_id or folder_id of "" is the root.
+func (s *Server) registerFolderRoutes(api *echo.Group) {
+	// every folder and playlist as a tree
+	api.GET("/playlists/tree", func(c echo.Context) error {
+		tree, err := s.lib.PlaylistTree(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, tree)
+	})
+
+	// move a playlist into a folder
+	api.PUT("/playlists/:playlistID/folder", bindreq(func(c echo.Context, req struct {
+		FolderID string `json:"folder_id"`
+	}) error {
+		err := s.lib.MovePlaylistToFolder(c.Request().Context(), currentUser(c).ID, c.Param("playlistID"), req.FolderID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	api.POST("/folders", bindreq(func(c echo.Context, req struct {
+		Name     string `json:"name"`
+		ParentID string `json:"parent_id"`
+	}) error {
+		if msg := validateFolderName(req.Name); msg != "" {
+			return c.JSON(400, errormap(msg))
+		}
+		folderID, err := s.lib.CreateFolder(c.Request().Context(), currentUser(c).ID, req.Name, req.ParentID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]string{"folder_id": folderID})
+	}))
+
+	// rename and/or move a folder. fields that are left out stay the same.
+	api.PATCH("/folders/:folderID", bindreq(func(c echo.Context, req struct {
+		Name     *string `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}) error {
+		ctx := c.Request().Context()
+		folderID := c.Param("folderID")
+		if req.Name != nil {
+			if msg := validateFolderName(*req.Name); msg != "" {
+				return c.JSON(400, errormap(msg))
+			}
+			if err := s.lib.RenameFolder(ctx, currentUser(c).ID, folderID, *req.Name); err != nil {
+				return c.JSON(500, errormap(err.Error()))
+			}
+		}
+		if req.ParentID != nil {
+			if err := s.lib.MoveFolder(ctx, currentUser(c).ID, folderID, *req.ParentID); err != nil {
+				return c.JSON(400, errormap(err.Error()))
+			}
+		}
+		return c.JSON(200, nil)
+	}))
+
+	// delete a folder and its subfolders. the playlists in them are deleted too, unless
+	// ?keep_playlists=true which moves them to the root instead.
+	api.DELETE("/folders/:folderID", func(c echo.Context) error {
+		keepPlaylists := false
+		if v := c.QueryParam("keep_playlists"); v != "" {
+			var err error
+			keepPlaylists, err = strconv.ParseBool(v)
+			if err != nil {
+				return c.JSON(400, errormap("keep_playlists must be true or false"))
+			}
+		}
+		if err := s.lib.DeleteFolder(c.Request().Context(), currentUser(c).ID, c.Param("folderID"), keepPlaylists); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	})
+}
+
+func validateFolderName(name string) string {
+	if name == "" {
+		return "name is required"
+	}
+	if len(name) > 30 {
+		return "name may be at most 30 characters"
+	}
+	return ""
+}
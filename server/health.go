@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// healthCheckTimeout is how long checking the database and the storage can take.
+const healthCheckTimeout = 5 * time.Second
+
+// Health is what /healthz and /readyz respond with. Status is ok if every check passed, failing if one didn't
+// and shutting_down (only from /readyz) once the server is shutting down.
+type Health struct {
+	Status   string         `json:"status"`
+	Database string         `json:"database"` // "ok" or the error
+	Storage  string         `json:"storage"`  // "ok" or the error
+	Tools    []library.Tool `json:"tools"`
+}
+
+// registerHealthRoutes registers /healthz, /readyz and /metrics. they're outside of /api/v1 and don't need
+// authentication so probes and scrapers can use them.
+//
+// both health routes check that the instance can download: the database and storage work and spotdl,
+// yt-dlp and ffmpeg run. they respond with 503 if a check fails, and /readyz also does while the server is
+// shutting down so load balancers stop sending requests to it.
+func (s *Server) registerHealthRoutes(e *echo.Echo) {
+	e.GET("/healthz", func(c echo.Context) error {
+		h := s.checkHealth(c.Request().Context())
+		return c.JSON(healthStatusCode(h), h)
+	})
+	e.GET("/readyz", func(c echo.Context) error {
+		h := s.checkHealth(c.Request().Context())
+		select {
+		case <-s.shuttingDown:
+			h.Status = "shutting_down"
+		default:
+		}
+		return c.JSON(healthStatusCode(h), h)
+	})
+	e.GET("/metrics", metricsHandler)
+}
+
+func (s *Server) checkHealth(ctx context.Context) Health {
+	h := Health{Status: "ok", Database: "ok", Storage: "ok"}
+	fail := func(field *string, err error) {
+		if err != nil {
+			*field = err.Error()
+			h.Status = "failing"
+		}
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	fail(&h.Database, s.lib.Ping(checkCtx))
+	fail(&h.Storage, s.storage.Check(checkCtx))
+	h.Tools = s.lib.Tools(ctx)
+	for _, t := range h.Tools {
+		if t.Error != "" {
+			h.Status = "failing"
+		}
+	}
+	return h
+}
+
+func healthStatusCode(h Health) int {
+	if h.Status != "ok" {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the metrics of the http requests, served at /metrics with the library's (and go's) metrics. requests are
+// labeled with their route ("/api/v1/playlists/:playlistID") rather than their path so there's a fixed number
+// of series.
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "music_http_requests_total",
+		Help: "http requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "music_http_request_duration_seconds",
+		Help:    "how long http requests took by method and route (event streams last as long as they're connected).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "music_http_requests_in_flight",
+		Help: "http requests being handled.",
+	})
+)
+
+// measureRequests is the middleware that counts and times the requests.
+func measureRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+		start := time.Now()
+
+		err := next(c)
+
+		// an error is turned into a response by echo after the middleware, so its status is worked out here
+		status := c.Response().Status
+		if err != nil {
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else if !c.Response().Committed {
+				status = http.StatusInternalServerError
+			}
+		}
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(c.Request().Method, route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// metricsHandler serves the metrics in the prometheus text format.
+var metricsHandler = echo.WrapHandler(promhttp.Handler())
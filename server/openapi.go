@@ -0,0 +1,70 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openAPISpec is the OpenAPI 3 document of every /api/v1 route. it's written by hand, so when a route is
+// added or changed the document has to be updated too (TestOpenAPIDocument fails on routes that are missing).
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// pathParam matches echo's :param path parameters.
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+func (s *Server) registerOpenAPIRoute(api *echo.Group) {
+	api.GET("/openapi.json", func(c echo.Context) error {
+		return c.Blob(200, echo.MIMEApplicationJSON, openAPISpec)
+	})
+}
+
+// checkOpenAPI compares the /api/v1 routes registered on e with the operations in the OpenAPI document and
+// returns the routes that aren't documented and the documented operations that have no route.
+func checkOpenAPI(e *echo.Echo, spec []byte) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("decode openapi document: %w", err)
+	}
+	documented := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" /api/v1"+path] = true
+		}
+	}
+
+	var problems []string
+	registered := make(map[string]bool)
+	for _, r := range e.Routes() {
+		switch r.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			continue // echo's not found routes of the group
+		}
+		if !strings.HasPrefix(r.Path, "/api/v1/") {
+			continue
+		}
+		route := r.Method + " " + pathParam.ReplaceAllString(r.Path, "{$1}")
+		registered[route] = true
+		if !documented[route] {
+			problems = append(problems, "not documented: "+route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			problems = append(problems, "documented but not registered: "+route)
+		}
+	}
+	slices.Sort(problems)
+	return problems, nil
+}
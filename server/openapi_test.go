@@ -0,0 +1,21 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestOpenAPIDocument fails when a route is added, removed or renamed without updating openapi.json.
+func TestOpenAPIDocument(t *testing.T) {
+	e := echo.New()
+	NewServer(nil, nil).registerRoutes(e)
+
+	problems, err := checkOpenAPI(e, openAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
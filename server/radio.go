@@ -0,0 +1,55 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerRadioRoutes registers the local radio routes. a radio is an endless queue generated from a
+// seed track, artist or playlist using only the library and the listening history.
+func (s *Server) registerRadioRoutes(api *echo.Group) {
+	type StartRadioRequest struct {
+		SeedType string `json:"seed_type"`
+		SeedID   string `json:"seed_id"`
+		N        int    `json:"n"`
+	}
+	// start a radio and get its first tracks
+	api.POST("/radio", bindreq(func(c echo.Context, req StartRadioRequest) error {
+		if req.SeedID == "" {
+			return c.JSON(400, errormap("seed_id is required"))
+		}
+		if req.N == 0 {
+			req.N = 10
+		}
+		radioID, err := s.lib.StartRadio(c.Request().Context(), currentUser(c).ID, req.SeedType, req.SeedID)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		tracks, err := s.lib.NextRadioTracks(c.Request().Context(), currentUser(c).ID, radioID, req.N)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]any{
+			"radio_id": radioID,
+			"tracks":   orEmpty(tracks),
+		})
+	}))
+
+	// get the next tracks of a radio (?n=10 by default)
+	api.GET("/radio/:radioID/next", func(c echo.Context) error {
+		n := 10
+		if v := c.QueryParam("n"); v != "" {
+			var err error
+			n, err = strconv.Atoi(v)
+			if err != nil {
+				return c.JSON(400, errormap("n must be an integer"))
+			}
+		}
+		tracks, err := s.lib.NextRadioTracks(c.Request().Context(), currentUser(c).ID, c.Param("radioID"), n)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tracks))
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// registerRatingRoutes registers the routes for liking and rating tracks.
+func (s *Server) registerRatingRoutes(api *echo.Group) {
+	// the liked tracks, most recently liked first
+	api.GET("/liked", func(c echo.Context) error {
+		tracks, err := s.lib.LikedTracks(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tracks))
+	})
+
+	// like or unlike a track
+	api.PUT("/tracks/:trackID/like", bindreq(func(c echo.Context, req struct {
+		Liked bool `json:"liked"`
+	}) error {
+		if err := s.lib.LikeTrack(c.Request().Context(), currentUser(c).ID, c.Param("trackID"), req.Liked); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	// rate a track 1 to 5 stars, a rating of 0 (or null) clears it
+	api.PUT("/tracks/:trackID/rating", bindreq(func(c echo.Context, req struct {
+		Rating int `json:"rating"`
+	}) error {
+		if req.Rating < 0 || req.Rating > 5 {
+			return c.JSON(400, errormap("rating must be between 1 and 5 (or 0 to clear it)"))
+		}
+		if err := s.lib.RateTrack(c.Request().Context(), currentUser(c).ID, c.Param("trackID"), req.Rating); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -23,24 +26,61 @@ import (
 type Server struct {
 	lib     *library.Library
 	storage storage.Storage
+	devices *deviceHub
+
+	echo         *echo.Echo
+	shuttingDown chan struct{} // closed when Shutdown is called
 }
 
+// Serve serves the api and the ui until it fails or Shutdown is called, when it returns
+// http.ErrServerClosed.
 func (s *Server) Serve() error {
-	e := echo.New()
+	e := s.echo
 
 	if env.DefaultEnv.Debug {
 		slog.Info("debug mode enabled")
-		e.Use(middleware.CORS())
+		// the ui dev server is on another origin and sends the session cookie along
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOriginFunc:  func(origin string) (bool, error) { return true, nil },
+			AllowCredentials: true,
+		}))
 	}
 
-	api := e.Group("/api/v1")
+	s.registerRoutes(e)
+
+	if env.DefaultEnv.Debug {
+		// point out api routes that were added or removed without updating the openapi document
+		problems, err := checkOpenAPI(e, openAPISpec)
+		if err != nil {
+			slog.Error("check openapi document", "error", err)
+		}
+		for _, problem := range problems {
+			slog.Warn("openapi document is out of date", "problem", problem)
+		}
+	}
+
+	if env.DefaultEnv.CertPath != "" && env.DefaultEnv.KeyPath != "" {
+		slog.Info("starting server with TLS", "address", env.DefaultEnv.ServerAddress)
+		return e.StartTLS(env.DefaultEnv.ServerAddress, env.DefaultEnv.CertPath, env.DefaultEnv.KeyPath)
+	} else {
+		slog.Info("starting server without TLS", "address", env.DefaultEnv.ServerAddress)
+		return e.Start(env.DefaultEnv.ServerAddress)
+	}
+}
+
+// registerRoutes registers the middleware and every route on the echo instance.
+func (s *Server) registerRoutes(e *echo.Echo) {
+	e.Use(measureRequests)
+
+	api := e.Group("/api/v1", s.requireAuth)
+	s.registerAuthRoutes(api)
 
 	api.GET("/search", func(c echo.Context) error {
 		query := c.QueryParam("q")
 		if query == "" {
 			return c.JSON(400, errormap("query parameter 'q' is required"))
 		}
-		res, err := s.lib.Search(c.Request().Context(), query)
+		res, err := s.lib.Search(c.Request().Context(), currentUser(c).ID, query)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -52,18 +92,9 @@ func (s *Server) Serve() error {
 		if trackID == "" {
 			return c.JSON(400, errormap("trackID parameter is required"))
 		}
-		rd, err := s.lib.Play(c.Request().Context(), trackID)
-		if err != nil {
+		if err := s.serveTrack(c, trackID); err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
-		data, err := io.ReadAll(rd) // drain the reader to a buffer
-		if err != nil {
-			return c.JSON(500, errormap(err.Error()))
-		}
-		defer rd.Close()
-
-		// serve the content
-		http.ServeContent(c.Response().Writer, c.Request(), s.lib.PathToTrackFile(trackID), time.Time{}, bytes.NewReader(data))
 		return nil
 	})
 
@@ -80,37 +111,45 @@ func (s *Server) Serve() error {
 	})
 
 	api.POST("/record/play/:trackID", func(c echo.Context) error {
-		return c.JSON(200, map[string]string{
-			"play_id": "not-implemented",
-		})
-		// trackID := c.Param("trackID")
-		// if trackID == "" {
-		// 	return c.JSON(400, errormap("trackID parameter is required"))
-		// }
-		// id, err := s.lib.RecordPlay(c.Request().Context(), trackID)
-		// if err != nil {
-		// 	return c.JSON(500, errormap(err.Error()))
-		// }
-		// return c.JSON(200, map[string]string{"play_id": id})
+		trackID := c.Param("trackID")
+		if trackID == "" {
+			return c.JSON(400, errormap("trackID parameter is required"))
+		}
+		id, err := s.lib.RecordPlay(c.Request().Context(), currentUser(c).ID, trackID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]string{"play_id": id})
 	})
-	api.POST("/record/skip/:playID", func(c echo.Context) error {
-		// playID := c.Param("playID")
-		// if playID == "" {
-		// 	return c.JSON(400, errormap("playID parameter is required"))
-		// }
-		// atStr := c.QueryParam("at")
-		// at, err := strconv.Atoi(atStr)
-		// if err != nil {
-		// 	return c.JSON(400, errormap("invalid 'at' parameter"))
-		// }
-		// if err := s.lib.RecordSkip(c.Request().Context(), playID, int32(at)); err != nil {
-		// 	return c.JSON(500, errormap(err.Error()))
-		// }
+	api.POST("/record/skip/:playID", bindreq(func(c echo.Context, req struct {
+		SkippedAt float64 `json:"skipped_at"` // seconds into the track (the ui sends the player's current time)
+	}) error {
+		playID := c.Param("playID")
+		if playID == "" {
+			return c.JSON(400, errormap("playID parameter is required"))
+		}
+		if req.SkippedAt < 0 {
+			return c.JSON(400, errormap("skipped_at may not be negative"))
+		}
+		if err := s.lib.RecordSkip(c.Request().Context(), currentUser(c).ID, playID, int32(req.SkippedAt)); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
 		return c.JSON(200, nil)
-	})
+	}))
+
+	s.registerStatsRoutes(api)
+	s.registerRadioRoutes(api)
+	s.registerSessionRoutes(api)
+	s.registerDeviceRoutes(api)
+	s.registerFolderRoutes(api)
+	s.registerRatingRoutes(api)
+	s.registerTagRoutes(api)
+	s.registerUserRoutes(api)
+	s.registerWebhookRoutes(api)
+	s.registerOpenAPIRoute(api)
 
 	api.GET("/playlists", func(c echo.Context) error {
-		playlists, err := s.lib.ListPlaylists(c.Request().Context())
+		playlists, err := s.lib.ListPlaylists(c.Request().Context(), currentUser(c).ID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -126,29 +165,107 @@ func (s *Server) Serve() error {
 		Description string `json:"description"`
 		ImageURL    string `json:"image_url"`
 	}) error {
-		// validations (this was made so i can return errors to test error handling in the frontend lol)
-		if req.Name == "" {
-			return c.JSON(400, errormap("name is required"))
+		if msg := validatePlaylist(req.Name, req.Description, req.ImageURL); msg != "" {
+			return c.JSON(400, errormap(msg))
 		}
-		if req.Description == "" {
-			return c.JSON(400, errormap("description is required"))
+		playlistID, err := s.lib.CreatePlaylist(c.Request().Context(), currentUser(c).ID, req.Name, req.Description, req.ImageURL)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
 		}
-		if req.ImageURL == "" {
-			return c.JSON(400, errormap("image is required"))
+		return c.JSON(200, map[string]string{"playlist_id": playlistID})
+	}))
+
+	// update the name, description and/or image of a playlist. fields that are left out stay the same.
+	api.PATCH("/playlists/:playlistID", bindreq(func(c echo.Context, req struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		ImageURL    *string `json:"image_url"`
+	}) error {
+		ctx := c.Request().Context()
+		playlist, err := s.lib.GetPlaylistByID(ctx, currentUser(c).ID, c.Param("playlistID"))
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
 		}
-		if len(req.Name) > 30 {
-			return c.JSON(400, errormap("name may be at most 30 characters"))
+		name, description, imageURL := playlist.Name, playlist.Description, playlist.ImageUrl
+		if req.Name != nil {
+			name = *req.Name
+		}
+		if req.Description != nil {
+			description = *req.Description
+		}
+		if req.ImageURL != nil {
+			imageURL = *req.ImageURL
 		}
-		if len(req.Description) > 60 {
-			return c.JSON(400, errormap("description may be at most 60 characters"))
+		if msg := validatePlaylist(name, description, imageURL); msg != "" {
+			return c.JSON(400, errormap(msg))
 		}
-		playlistID, err := s.lib.CreatePlaylist(c.Request().Context(), req.Name, req.Description, req.ImageURL)
+		oldImageURL, err := s.lib.UpdatePlaylist(ctx, currentUser(c).ID, c.Param("playlistID"), name, description, imageURL)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		if oldImageURL != "" {
+			if err := s.storage.Delete(ctx, oldImageURL); err != nil {
+				slog.Warn("delete old playlist image", "error", err, "image_url", oldImageURL)
+			}
+		}
+		return c.JSON(200, map[string]string{
+			"id":          c.Param("playlistID"),
+			"name":        name,
+			"description": description,
+			"image_url":   imageURL,
+		})
+	}))
+
+	// create smart playlist
+	api.POST("/playlists/smart", bindreq(func(c echo.Context, req struct {
+		Name        string             `json:"name"`
+		Description string             `json:"description"`
+		ImageURL    string             `json:"image_url"`
+		Rules       library.SmartRules `json:"rules"`
+	}) error {
+		if msg := validatePlaylist(req.Name, req.Description, req.ImageURL); msg != "" {
+			return c.JSON(400, errormap(msg))
+		}
+		if err := req.Rules.Validate(); err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		playlistID, err := s.lib.CreateSmartPlaylist(c.Request().Context(), currentUser(c).ID, req.Name, req.Description, req.ImageURL, req.Rules)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
 		return c.JSON(200, map[string]string{"playlist_id": playlistID})
 	}))
 
+	// replace the rules of a smart playlist
+	api.PUT("/playlists/:playlistID/rules", bindreq(func(c echo.Context, req library.SmartRules) error {
+		playlistID := c.Param("playlistID")
+		if err := req.Validate(); err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		if err := s.lib.UpdateSmartPlaylistRules(c.Request().Context(), currentUser(c).ID, playlistID, req); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	// save the current tracks of a smart playlist as a new regular playlist
+	api.POST("/playlists/:playlistID/snapshot", bindreq(func(c echo.Context, req struct {
+		Name string `json:"name"`
+	}) error {
+		playlistID := c.Param("playlistID")
+		if req.Name == "" {
+			return c.JSON(400, errormap("name is required"))
+		}
+		if len(req.Name) > 30 {
+			return c.JSON(400, errormap("name may be at most 30 characters"))
+		}
+		snapshotID, err := s.lib.SnapshotSmartPlaylist(c.Request().Context(), currentUser(c).ID, playlistID, req.Name)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]string{"playlist_id": snapshotID})
+	}))
+
 	api.POST("/playlists/import", bindreq(func(c echo.Context, req struct {
 		SpotifyPlaylistURL string `json:"spotify_playlist_url"`
 	}) error {
@@ -164,7 +281,7 @@ func (s *Server) Serve() error {
 		}
 		spotifyPlaylistID := u.Path[10:]
 
-		playlist, err := s.lib.Import(c.Request().Context(), spotifyPlaylistID)
+		playlist, err := s.lib.Import(c.Request().Context(), currentUser(c).ID, spotifyPlaylistID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -174,7 +291,7 @@ func (s *Server) Serve() error {
 	// delete playlist
 	api.DELETE("/playlists/:playlistID", func(c echo.Context) error {
 		playlistID := c.Param("playlistID")
-		err := s.lib.DeletePlaylist(c.Request().Context(), playlistID)
+		err := s.lib.DeletePlaylist(c.Request().Context(), currentUser(c).ID, playlistID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -184,30 +301,87 @@ func (s *Server) Serve() error {
 	// get playlist details
 	api.GET("/playlists/:playlistID", func(c echo.Context) error {
 		playlistID := c.Param("playlistID")
-		data, err := s.lib.GetPlaylist(c.Request().Context(), playlistID)
+		// ?sort= (title, artist, album, added_at, duration or play_count) and ?order=desc sort the tracks
+		// instead of returning them in playlist order
+		order := c.QueryParam("order")
+		if order != "" && order != "asc" && order != "desc" {
+			return c.JSON(400, errormap("order must be asc or desc"))
+		}
+		data, err := s.lib.GetPlaylist(c.Request().Context(), currentUser(c).ID, playlistID, c.QueryParam("sort"), order == "desc")
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
 		return c.JSON(200, data)
 	})
 
-	// add track to playlist
+	// shuffle a playlist so artists and albums are spread apart. ?seed= makes the order reproducible,
+	// ?weight_recent=true plays tracks that haven't been played in a while earlier (with ?as_of= as the
+	// RFC 3339 time the weighting was done at to reproduce it).
+	api.GET("/playlists/:playlistID/shuffle", func(c echo.Context) error {
+		var opts library.ShuffleOptions
+		if v := c.QueryParam("seed"); v != "" {
+			seed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || seed < 0 {
+				return c.JSON(400, errormap("seed must be a non-negative integer"))
+			}
+			opts.Seed = &seed
+		}
+		if v := c.QueryParam("weight_recent"); v != "" {
+			weight, err := strconv.ParseBool(v)
+			if err != nil {
+				return c.JSON(400, errormap("weight_recent must be true or false"))
+			}
+			opts.WeightRecent = weight
+		}
+		if v := c.QueryParam("as_of"); v != "" {
+			asOf, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.JSON(400, errormap("invalid as_of parameter"))
+			}
+			opts.AsOf = asOf
+		}
+		shuffle, err := s.lib.ShufflePlaylist(c.Request().Context(), currentUser(c).ID, c.Param("playlistID"), opts)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		shuffle.Tracks = orEmpty(shuffle.Tracks)
+		return c.JSON(200, shuffle)
+	})
+
+	// add track to playlist (at position, or at the end if there's no position)
 	api.POST("/playlists/:playlistID/tracks", bindreq(func(c echo.Context, req struct {
-		TrackID string `json:"track_id"`
+		TrackID  string `json:"track_id"`
+		Position *int   `json:"position"`
 	}) error {
 		playlistID := c.Param("playlistID")
-		err := s.lib.AddTrackToPlaylist(c.Request().Context(), playlistID, req.TrackID)
+		err := s.lib.AddTrackToPlaylist(c.Request().Context(), currentUser(c).ID, playlistID, req.TrackID, req.Position)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
 		return c.JSON(200, nil)
 	}))
 
+	// move count tracks starting at position from so they start at position to (drag & drop)
+	api.POST("/playlists/:playlistID/tracks/move", bindreq(func(c echo.Context, req struct {
+		From  int `json:"from"`
+		Count int `json:"count"`
+		To    int `json:"to"`
+	}) error {
+		if req.Count == 0 {
+			req.Count = 1
+		}
+		err := s.lib.MovePlaylistTracks(c.Request().Context(), currentUser(c).ID, c.Param("playlistID"), req.From, req.Count, req.To)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
 	// remove track from playlist
 	api.DELETE("/playlists/:playlistID/tracks/:trackID", func(c echo.Context) error {
 		playlistID := c.Param("playlistID")
 		trackID := c.Param("trackID")
-		err := s.lib.RemoveTrackFromPlaylist(c.Request().Context(), playlistID, trackID)
+		err := s.lib.RemoveTrackFromPlaylist(c.Request().Context(), currentUser(c).ID, playlistID, trackID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -220,9 +394,9 @@ func (s *Server) Serve() error {
 		if trackID == "" {
 			return c.JSON(400, errormap("trackID parameter is required"))
 		}
-		ctx, cancel := context.WithTimeout(c.Request().Context(), 1*time.Minute)
+		ctx, cancel := context.WithTimeout(c.Request().Context(), env.DefaultEnv.DownloadTimeout)
 		defer cancel()
-		err := s.lib.DownloadIfNotExists(ctx, trackID)
+		err := s.lib.DownloadIfNotExists(ctx, currentUser(c).ID, trackID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -235,7 +409,7 @@ func (s *Server) Serve() error {
 			return c.JSON(400, errormap("playlistID parameter is required"))
 		}
 
-		ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Minute)
+		ctx, cancel := context.WithTimeout(c.Request().Context(), env.DefaultEnv.PlaylistDownloadTimeout)
 		defer cancel()
 
 		w := c.Response()
@@ -243,7 +417,7 @@ func (s *Server) Serve() error {
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
-		numTracks, errChan, err := s.lib.DownloadPlaylist(ctx, playlistID)
+		numTracks, errChan, err := s.lib.DownloadPlaylist(ctx, currentUser(c).ID, playlistID)
 		if err != nil {
 			return c.JSON(500, errormap(err.Error()))
 		}
@@ -283,6 +457,11 @@ func (s *Server) Serve() error {
 			}
 			i++
 		}
+		select {
+		case <-s.shuttingDown: // the downloads that were left were interrupted
+			return writeShutdownEvent(w)
+		default:
+		}
 		return nil
 	})
 
@@ -339,6 +518,9 @@ func (s *Server) Serve() error {
 		return nil
 	})
 
+	s.registerSubsonicRoutes(e)
+	s.registerHealthRoutes(e)
+
 	// catch all (GET) route to serve frontend
 	e.GET("/*", func(c echo.Context) error {
 		p := c.Request().URL.Path
@@ -347,18 +529,121 @@ func (s *Server) Serve() error {
 		}
 		return c.File(filepath.Join("ui/dist", p))
 	})
+}
 
-	if env.DefaultEnv.CertPath != "" && env.DefaultEnv.KeyPath != "" {
-		slog.Info("starting server with TLS", "address", env.DefaultEnv.ServerAddress)
-		return e.StartTLS(env.DefaultEnv.ServerAddress, env.DefaultEnv.CertPath, env.DefaultEnv.KeyPath)
-	} else {
-		slog.Info("starting server without TLS", "address", env.DefaultEnv.ServerAddress)
-		return e.Start(env.DefaultEnv.ServerAddress)
+// serveTrack streams the audio file of the track, downloading it first if it isn't in storage. ranges
+// (seeking) and conditional requests (If-None-Match, If-Modified-Since, If-Range) are handled by
+// http.ServeContent, which only reads the part of the file it sends. an error is only returned if nothing
+// was written yet.
+func (s *Server) serveTrack(c echo.Context, trackID string) error {
+	f, err := s.lib.Play(c.Request().Context(), currentUser(c).ID, trackID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat track file: %w", err)
+	}
+	contentType, err := audioContentType(f)
+	if err != nil {
+		return fmt.Errorf("read track file: %w", err)
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, contentType)
+	// a download replaces the file, which changes its size or modification time
+	h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	h.Set("Cache-Control", "private, no-cache") // only for the user, and checked with the etag before it's reused
+	http.ServeContent(c.Response(), c.Request(), "", info.ModTime(), f)
+	return nil
+}
+
+// audioContentType returns the content type of an audio file from its first bytes. tracks are downloaded as
+// m4a but the yt-dlp arguments are a setting, so the extension can't be trusted.
+func audioContentType(f io.ReaderAt) (string, error) {
+	buf := make([]byte, 512) // what http.DetectContentType looks at
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+	switch {
+	case len(buf) >= 8 && string(buf[4:8]) == "ftyp":
+		return "audio/mp4", nil // http.DetectContentType says video/mp4
+	case bytes.HasPrefix(buf, []byte("fLaC")):
+		return "audio/flac", nil
+	}
+	switch ct := http.DetectContentType(buf); ct {
+	case "video/webm":
+		return "audio/webm", nil
+	case "application/ogg":
+		return "audio/ogg", nil
+	default:
+		return ct, nil
 	}
 }
 
+// Shutdown stops accepting connections and waits for the requests that are being handled to finish until ctx
+// is done, then closes the connections that are left. device event streams, which don't end by themselves,
+// are ended with a shutdown event telling the clients when to reconnect.
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.shuttingDown)
+	if err := s.echo.Shutdown(ctx); err != nil {
+		s.echo.Close()
+		return err
+	}
+	return nil
+}
+
 func NewServer(lib *library.Library, storage storage.Storage) *Server {
-	return &Server{lib: lib, storage: storage}
+	return &Server{lib: lib, storage: storage, devices: newDeviceHub(), echo: echo.New(), shuttingDown: make(chan struct{})}
+}
+
+// validatePlaylist validates the fields of a playlist and returns an error message, or "" if they are valid.
+// (this was made so i can return errors to test error handling in the frontend lol)
+func validatePlaylist(name, description, imageURL string) string {
+	if name == "" {
+		return "name is required"
+	}
+	if description == "" {
+		return "description is required"
+	}
+	if imageURL == "" {
+		return "image is required"
+	}
+	if msg := validateImageURL(imageURL); msg != "" {
+		return msg
+	}
+	return validatePlaylistText(name, description)
+}
+
+// validateImageURL checks that a playlist's image is an http(s) url. images in local storage are read from
+// disk by their key (see subsonicGetCoverArt), so a link to one has to name a file in the data directory.
+func validateImageURL(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "image_url must be an http or https url"
+	}
+	if key, ok := strings.CutPrefix(imageURL, env.DefaultEnv.ServerURL+"/api/v1/data/"); ok && !storage.ValidKey(key) {
+		return "image_url is not a file in storage"
+	}
+	return ""
+}
+
+// validatePlaylistText validates the name and description of a playlist, which is all clients that can't set a
+// description or image (subsonic's) are held to.
+func validatePlaylistText(name, description string) string {
+	if name == "" {
+		return "name is required"
+	}
+	if len(name) > 30 {
+		return "name may be at most 30 characters"
+	}
+	if len(description) > 60 {
+		return "description may be at most 60 characters"
+	}
+	return ""
 }
 
 func errormap(err string) map[string]string {
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tiredkangaroo/music/env"
+)
+
+func TestValidateImageURL(t *testing.T) {
+	serverURL := env.DefaultEnv.ServerURL
+	env.DefaultEnv.ServerURL = "https://music.example.com"
+	t.Cleanup(func() { env.DefaultEnv.ServerURL = serverURL })
+
+	for _, tt := range []struct {
+		imageURL string
+		ok       bool
+	}{
+		{"https://i.scdn.co/image/ab67616d0000b273", true},
+		{"https://music.example.com/api/v1/data/0123456789abcdef", true},
+		{"https://music.example.com/api/v1/data/../../etc/passwd", false},
+		{"https://music.example.com/api/v1/data/..", false},
+		{"https://music.example.com/api/v1/data/", false},
+		{"file:///etc/passwd", false},
+		{"/api/v1/data/0123456789abcdef", false},
+	} {
+		if msg := validateImageURL(tt.imageURL); (msg == "") != tt.ok {
+			t.Errorf("validateImageURL(%q) = %q, want valid %v", tt.imageURL, msg, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// registerSessionRoutes registers the playback session routes. the session is the player state (current
+// track, position, queue, history, shuffle & repeat) kept on the server so any client can pick up where
+// another one left off. every route responds with the whole session.
+func (s *Server) registerSessionRoutes(api *echo.Group) {
+	session := api.Group("/session")
+
+	session.GET("", func(c echo.Context) error {
+		ps, err := s.lib.PlaybackSession(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	})
+
+	// replace the whole session (e.g. when a playlist starts playing)
+	session.PUT("", bindreq(func(c echo.Context, req library.PlaybackState) error {
+		ps, err := s.lib.SetPlaybackSession(c.Request().Context(), currentUser(c).ID, req)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	}))
+
+	session.POST("/next", func(c echo.Context) error {
+		ps, err := s.lib.SessionNext(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	})
+
+	session.POST("/previous", func(c echo.Context) error {
+		ps, err := s.lib.SessionPrevious(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	})
+
+	type PositionRequest struct {
+		TrackID  string  `json:"track_id"`
+		Position float64 `json:"position"`
+	}
+	// clients report the position every few seconds while playing
+	session.PUT("/position", bindreq(func(c echo.Context, req PositionRequest) error {
+		ps, err := s.lib.SetSessionPosition(c.Request().Context(), currentUser(c).ID, req.TrackID, req.Position)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	}))
+
+	type ModeRequest struct {
+		Shuffle *bool   `json:"shuffle"`
+		Repeat  *string `json:"repeat"`
+	}
+	session.PUT("/mode", bindreq(func(c echo.Context, req ModeRequest) error {
+		ps, err := s.lib.SetSessionMode(c.Request().Context(), currentUser(c).ID, req.Shuffle, req.Repeat)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	}))
+
+	type QueueRequest struct {
+		TrackIDs []string `json:"track_ids"`
+		Next     bool     `json:"next"` // play next instead of adding to the end of the queue
+	}
+	session.POST("/queue", bindreq(func(c echo.Context, req QueueRequest) error {
+		ps, err := s.lib.QueueTracks(c.Request().Context(), currentUser(c).ID, req.TrackIDs, req.Next)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	}))
+
+	session.DELETE("/queue", func(c echo.Context) error {
+		ps, err := s.lib.ClearQueue(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	})
+
+	session.DELETE("/queue/:index", func(c echo.Context) error {
+		index, err := strconv.Atoi(c.Param("index"))
+		if err != nil {
+			return c.JSON(400, errormap("index must be an integer"))
+		}
+		ps, err := s.lib.RemoveFromQueue(c.Request().Context(), currentUser(c).ID, index)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		return c.JSON(200, ps)
+	})
+}
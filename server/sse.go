@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 // taken from the echo sse guide 💖
@@ -31,6 +34,23 @@ type Event struct {
 	Comment []byte
 }
 
+// shutdownRetry is the reconnection time sent with the shutdown event, about how long a restart takes.
+const shutdownRetry = 5 * time.Second
+
+// writeShutdownEvent ends an event stream because the server is shutting down: it sends a "shutdown" event
+// whose retry field makes the browser wait before reconnecting.
+func writeShutdownEvent(w http.ResponseWriter) error {
+	e := Event{
+		Event: []byte("shutdown"),
+		Data:  []byte("{}"),
+		Retry: []byte(strconv.FormatInt(shutdownRetry.Milliseconds(), 10)),
+	}
+	if err := e.MarshalTo(w); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
 // MarshalTo marshals Event to given Writer
 func (ev *Event) MarshalTo(w io.Writer) error {
 	// Marshalling part is taken from: https://github.com/r3labs/sse/blob/c6d5381ee3ca63828b321c16baa008fd6c0b4564/http.go#L16
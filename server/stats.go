@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// registerStatsRoutes registers the listening statistics routes. every route accepts the optional
+// query parameters from and to (RFC 3339 timestamps or YYYY-MM-DD dates) and tz (an IANA time zone
+// name, used for hour of day, weekday and streaks).
+func (s *Server) registerStatsRoutes(api *echo.Group) {
+	stats := api.Group("/stats")
+
+	stats.GET("/top/tracks", func(c echo.Context) error {
+		w, limit, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		tracks, err := s.lib.TopTracks(c.Request().Context(), currentUser(c).ID, w, limit)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tracks))
+	})
+
+	stats.GET("/top/artists", func(c echo.Context) error {
+		w, limit, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		artists, err := s.lib.TopArtists(c.Request().Context(), currentUser(c).ID, w, limit)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(artists))
+	})
+
+	stats.GET("/top/albums", func(c echo.Context) error {
+		w, limit, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		albums, err := s.lib.TopAlbums(c.Request().Context(), currentUser(c).ID, w, limit)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(albums))
+	})
+
+	stats.GET("/totals", func(c echo.Context) error {
+		w, _, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		totals, err := s.lib.ListeningTotals(c.Request().Context(), currentUser(c).ID, w)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]any{
+			"play_count":       totals.PlayCount,
+			"skip_count":       totals.SkipCount,
+			"track_count":      totals.TrackCount,
+			"seconds_listened": totals.SecondsListened,
+			"minutes_listened": totals.SecondsListened / 60,
+		})
+	})
+
+	stats.GET("/skips", func(c echo.Context) error {
+		w, limit, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		minPlays := int64(3)
+		if v := c.QueryParam("min_plays"); v != "" {
+			minPlays, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || minPlays < 1 {
+				return c.JSON(400, errormap("min_plays must be a positive integer"))
+			}
+		}
+		rates, err := s.lib.SkipRates(c.Request().Context(), currentUser(c).ID, w, minPlays, limit)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(rates))
+	})
+
+	stats.GET("/hours", func(c echo.Context) error {
+		w, _, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		hours, err := s.lib.ListeningByHour(c.Request().Context(), currentUser(c).ID, w)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, hours)
+	})
+
+	stats.GET("/weekdays", func(c echo.Context) error {
+		w, _, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		weekdays, err := s.lib.ListeningByWeekday(c.Request().Context(), currentUser(c).ID, w)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, weekdays)
+	})
+
+	stats.GET("/streaks", func(c echo.Context) error {
+		w, _, err := statsParams(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		streaks, err := s.lib.Streaks(c.Request().Context(), currentUser(c).ID, w)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, streaks)
+	})
+
+	// year in review (or any other window). ?year=2026 is a shortcut for the whole calendar year.
+	stats.GET("/recap", func(c echo.Context) error {
+		w, err := recapWindow(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		recap, err := s.lib.Recap(c.Request().Context(), currentUser(c).ID, w)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, recap)
+	})
+
+	// create a playlist from the top tracks of the recap window
+	stats.POST("/recap/playlist", func(c echo.Context) error {
+		w, err := recapWindow(c)
+		if err != nil {
+			return c.JSON(400, errormap(err.Error()))
+		}
+		var name, description string
+		if year := c.QueryParam("year"); year != "" {
+			name = "Top Tracks " + year
+			description = "my most played tracks of " + year
+		} else {
+			to := w.To
+			if to.IsZero() {
+				to = time.Now().In(w.Location)
+			}
+			name = "Top Tracks " + w.From.Format("Jan 2006") + " - " + to.Format("Jan 2006")
+			description = "my most played tracks from " + w.From.Format(time.DateOnly) + " to " + to.Format(time.DateOnly)
+		}
+		playlistID, err := s.lib.CreateRecapPlaylist(c.Request().Context(), currentUser(c).ID, w, name, description)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]string{"playlist_id": playlistID})
+	})
+}
+
+// recapWindow reads the stats window for a recap. the year parameter takes precedence over from and to.
+func recapWindow(c echo.Context) (library.StatsWindow, error) {
+	w, _, err := statsParams(c)
+	if err != nil {
+		return w, err
+	}
+	if v := c.QueryParam("year"); v != "" {
+		year, err := strconv.Atoi(v)
+		if err != nil || year < 1970 || year > 9999 {
+			return w, errors.New("invalid year parameter")
+		}
+		w.From = time.Date(year, time.January, 1, 0, 0, 0, 0, w.Location)
+		w.To = w.From.AddDate(1, 0, 0)
+	}
+	return w, nil
+}
+
+// statsParams reads the stats window and the limit (default 10, at most 100) from the query parameters.
+func statsParams(c echo.Context) (library.StatsWindow, int32, error) {
+	var w library.StatsWindow
+	var err error
+
+	loc := time.UTC
+	if tz := c.QueryParam("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return w, 0, errors.New("invalid tz parameter")
+		}
+	}
+	w.Location = loc
+
+	if v := c.QueryParam("from"); v != "" {
+		w.From, err = parseStatsTime(v, loc)
+		if err != nil {
+			return w, 0, errors.New("invalid from parameter")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		w.To, err = parseStatsTime(v, loc)
+		if err != nil {
+			return w, 0, errors.New("invalid to parameter")
+		}
+	}
+	if !w.To.IsZero() && !w.From.Before(w.To) {
+		return w, 0, errors.New("from must be before to")
+	}
+
+	limit := int32(10)
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return w, 0, errors.New("limit must be between 1 and 100")
+		}
+		limit = int32(n)
+	}
+	return w, limit, nil
+}
+
+// parseStatsTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (midnight in loc).
+func parseStatsTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, v, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,799 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/db"
+	"github.com/tiredkangaroo/music/env"
+	"github.com/tiredkangaroo/music/library"
+	"github.com/tiredkangaroo/music/storage"
+)
+
+// the subsonic api version that's implemented (the subset of it in registerSubsonicRoutes)
+const subsonicAPIVersion = "1.16.1"
+
+// subsonic error codes
+const (
+	subsonicErrGeneric          = 0
+	subsonicErrMissingParameter = 10
+	subsonicErrWrongCredentials = 40
+	subsonicErrNotFound         = 70
+)
+
+// subsonic ids are prefixed with what they are, since clients use one id space for everything (the cover art
+// of an album is the album's id, for example). the rest is the spotify id or, for playlists, the uuid.
+const (
+	subsonicTrackPrefix    = "tr-"
+	subsonicAlbumPrefix    = "al-"
+	subsonicArtistPrefix   = "ar-"
+	subsonicPlaylistPrefix = "pl-"
+)
+
+// spotifyImageHosts are the hosts spotify serves covers and playlist images from.
+var spotifyImageHosts = []string{"i.scdn.co", "mosaic.scdn.co", "image-cdn-ak.spotifycdn.com", "image-cdn-fa.spotifycdn.com"}
+
+// coverArtClient fetches the cover art that's proxied. it doesn't follow redirects, which could lead off the
+// allowed hosts.
+var coverArtClient = &http.Client{
+	Timeout: 15 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// lrcTimestamps matches the [mm:ss.xx] timestamps (and [ar:...] style tags) at the start of lrc lines.
+var lrcTimestamps = regexp.MustCompile(`(?m)^(?:\[[^\]]*\]\s*)+`)
+
+// registerSubsonicRoutes registers the subsonic api at /rest/<method> (and /rest/<method>.view) so subsonic
+// and opensubsonic clients can use the library. clients log in with the username and the subsonic password
+// from /api/v1/auth/subsonic-password.
+func (s *Server) registerSubsonicRoutes(e *echo.Echo) {
+	methods := map[string]echo.HandlerFunc{
+		"ping":            s.subsonicPing,
+		"getLicense":      s.subsonicGetLicense,
+		"getMusicFolders": s.subsonicGetMusicFolders,
+		"getArtists":      s.subsonicGetArtists,
+		"getArtist":       s.subsonicGetArtist,
+		"getAlbum":        s.subsonicGetAlbum,
+		"search3":         s.subsonicSearch3,
+		"getPlaylists":    s.subsonicGetPlaylists,
+		"getPlaylist":     s.subsonicGetPlaylist,
+		"createPlaylist":  s.subsonicCreatePlaylist,
+		"updatePlaylist":  s.subsonicUpdatePlaylist,
+		"stream":          s.subsonicStream,
+		"getCoverArt":     s.subsonicGetCoverArt,
+		"getLyrics":       s.subsonicGetLyrics,
+		"scrobble":        s.subsonicScrobble,
+	}
+
+	e.Match([]string{http.MethodGet, http.MethodPost}, "/rest/:method", func(c echo.Context) error {
+		handler, ok := methods[strings.TrimSuffix(c.Param("method"), ".view")]
+		if !ok {
+			return subsonicFail(c, subsonicErrNotFound, "unknown method")
+		}
+		username := c.FormValue("u")
+		if username == "" {
+			return subsonicFail(c, subsonicErrMissingParameter, "required parameter u is missing")
+		}
+		user, err := s.lib.SubsonicLogin(c.Request().Context(), username, c.FormValue("t"), c.FormValue("s"), c.FormValue("p"))
+		if errors.Is(err, library.ErrInvalidCredentials) {
+			return subsonicFail(c, subsonicErrWrongCredentials, "wrong username or password")
+		} else if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		c.Set("user", user)
+		return handler(c)
+	})
+}
+
+func (s *Server) subsonicPing(c echo.Context) error {
+	return subsonicOK(c, subsonicResponse{})
+}
+
+func (s *Server) subsonicGetLicense(c echo.Context) error {
+	return subsonicOK(c, subsonicResponse{License: &subsonicLicense{Valid: true}})
+}
+
+// there is only one music folder, the library
+func (s *Server) subsonicGetMusicFolders(c echo.Context) error {
+	return subsonicOK(c, subsonicResponse{MusicFolders: &subsonicMusicFolders{
+		MusicFolder: []subsonicMusicFolder{{ID: 1, Name: "Library"}},
+	}})
+}
+
+// the artists of the tracks in the library, indexed by their first letter
+func (s *Server) subsonicGetArtists(c echo.Context) error {
+	tracks, err := s.lib.LibraryTracks(c.Request().Context(), currentUser(c).ID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	artists := subsonicArtistsOf(tracks)
+	slices.SortFunc(artists, func(a, b subsonicArtist) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	index := &subsonicArtistIndexes{IgnoredArticles: ""}
+	for _, artist := range artists {
+		name := "#"
+		if r := []rune(strings.ToUpper(artist.Name)); len(r) > 0 && unicode.IsLetter(r[0]) {
+			name = string(r[0])
+		}
+		if len(index.Index) == 0 || index.Index[len(index.Index)-1].Name != name {
+			index.Index = append(index.Index, subsonicIndex{Name: name})
+		}
+		last := &index.Index[len(index.Index)-1]
+		last.Artist = append(last.Artist, artist)
+	}
+	return subsonicOK(c, subsonicResponse{Artists: index})
+}
+
+// an artist with their albums in the library
+func (s *Server) subsonicGetArtist(c echo.Context) error {
+	artistID, ok := subsonicParamID(c, "id", subsonicArtistPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter id is missing or invalid")
+	}
+	tracks, err := s.lib.LibraryTracks(c.Request().Context(), currentUser(c).ID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	tracks = slices.DeleteFunc(tracks, func(t library.Track) bool { return t.ArtistID != artistID })
+	if len(tracks) == 0 {
+		return subsonicFail(c, subsonicErrNotFound, "artist not found")
+	}
+	artist := subsonicArtistsOf(tracks)[0]
+	artist.Album = subsonicAlbumsOf(tracks)
+	return subsonicOK(c, subsonicResponse{Artist: &artist})
+}
+
+func (s *Server) subsonicGetAlbum(c echo.Context) error {
+	albumID, ok := subsonicParamID(c, "id", subsonicAlbumPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter id is missing or invalid")
+	}
+	tracks, err := s.lib.AlbumTracks(c.Request().Context(), currentUser(c).ID, albumID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	if len(tracks) == 0 {
+		return subsonicFail(c, subsonicErrNotFound, "album not found")
+	}
+	album := subsonicAlbumsOf(tracks)[0]
+	album.Song = subsonicSongs(tracks)
+	return subsonicOK(c, subsonicResponse{Album: &album})
+}
+
+// search the tracks (and the artists and albums of the results). an empty query lists the whole library,
+// which is how some clients sync.
+func (s *Server) subsonicSearch3(c echo.Context) error {
+	ctx := c.Request().Context()
+	query := strings.Trim(c.FormValue("query"), `"`)
+
+	var tracks []library.Track
+	if query == "" {
+		var err error
+		tracks, err = s.lib.LibraryTracks(ctx, currentUser(c).ID)
+		if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+	} else {
+		results, err := s.lib.Search(ctx, currentUser(c).ID, query)
+		if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		for _, r := range results {
+			tracks = append(tracks, library.Track{
+				TrackID:          r.TrackID,
+				TrackName:        r.TrackName,
+				Duration:         r.Duration,
+				Popularity:       r.Popularity,
+				AlbumID:          r.AlbumID,
+				AlbumName:        r.AlbumName,
+				ArtistID:         r.ArtistID,
+				ArtistName:       r.ArtistName,
+				Artists:          r.Artists,
+				CoverURL:         r.CoverUrl,
+				Downloaded:       r.Downloaded,
+				TrackReleaseDate: r.TrackReleaseDate,
+				LikedAt:          r.LikedAt,
+				Rating:           r.Rating,
+			})
+		}
+	}
+
+	result := &subsonicSearchResult3{
+		Artist: subsonicPage(c, "artist", subsonicArtistsOf(tracks)),
+		Album:  subsonicPage(c, "album", subsonicAlbumsOf(tracks)),
+		Song:   subsonicPage(c, "song", subsonicSongs(tracks)),
+	}
+	return subsonicOK(c, subsonicResponse{SearchResult3: result})
+}
+
+func (s *Server) subsonicGetPlaylists(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := currentUser(c)
+	playlists, err := s.lib.ListPlaylists(ctx, user.ID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	list := &subsonicPlaylists{Playlist: []subsonicPlaylist{}}
+	for _, p := range playlists {
+		_, tracks, err := s.lib.PlaylistTracks(ctx, user.ID, uuid.UUID(p.ID.Bytes).String())
+		if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		list.Playlist = append(list.Playlist, subsonicPlaylistOf(p, user, tracks))
+	}
+	return subsonicOK(c, subsonicResponse{Playlists: list})
+}
+
+func (s *Server) subsonicGetPlaylist(c echo.Context) error {
+	playlistID, ok := subsonicParamID(c, "id", subsonicPlaylistPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter id is missing or invalid")
+	}
+	return s.subsonicRespondPlaylist(c, playlistID)
+}
+
+// create a playlist with the songs, or replace the songs of playlistId
+func (s *Server) subsonicCreatePlaylist(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := currentUser(c)
+	songIDs, ok := subsonicParamIDs(c, "songId", subsonicTrackPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrNotFound, "invalid song id")
+	}
+
+	playlistID, ok := subsonicParamID(c, "playlistId", subsonicPlaylistPrefix)
+	if !ok {
+		name := c.FormValue("name")
+		if name == "" {
+			return subsonicFail(c, subsonicErrMissingParameter, "required parameter name or playlistId is missing")
+		}
+		if msg := validatePlaylistText(name, ""); msg != "" {
+			return subsonicFail(c, subsonicErrGeneric, msg)
+		}
+		id, err := s.lib.CreatePlaylist(ctx, user.ID, name, "", "")
+		if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		playlistID = id
+	}
+
+	err := s.lib.SetPlaylistTracks(ctx, user.ID, playlistID, songIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return subsonicFail(c, subsonicErrNotFound, "playlist not found")
+	} else if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	return s.subsonicRespondPlaylist(c, playlistID)
+}
+
+// rename a playlist, change its comment (the description), and add or remove songs
+func (s *Server) subsonicUpdatePlaylist(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := currentUser(c)
+	playlistID, ok := subsonicParamID(c, "playlistId", subsonicPlaylistPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter playlistId is missing or invalid")
+	}
+	add, ok := subsonicParamIDs(c, "songIdToAdd", subsonicTrackPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrNotFound, "invalid song id")
+	}
+	params, _ := c.FormParams()
+	var remove []int
+	for _, v := range params["songIndexToRemove"] {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return subsonicFail(c, subsonicErrGeneric, "songIndexToRemove must be a non-negative integer")
+		}
+		remove = append(remove, i)
+	}
+
+	playlist, tracks, err := s.lib.PlaylistTracks(ctx, user.ID, playlistID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return subsonicFail(c, subsonicErrNotFound, "playlist not found")
+	} else if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+
+	name, description := playlist.Name, playlist.Description
+	if v, ok := params["name"]; ok && len(v) > 0 {
+		name = v[0]
+	}
+	if v, ok := params["comment"]; ok && len(v) > 0 {
+		description = v[0]
+	}
+	if msg := validatePlaylistText(name, description); msg != "" {
+		return subsonicFail(c, subsonicErrGeneric, msg)
+	}
+	for _, i := range remove {
+		if i >= len(tracks) {
+			return subsonicFail(c, subsonicErrNotFound, "songIndexToRemove is out of range")
+		}
+	}
+
+	if name != playlist.Name || description != playlist.Description {
+		if _, err := s.lib.UpdatePlaylist(ctx, user.ID, playlistID, name, description, playlist.ImageUrl); err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+	}
+
+	if len(remove) > 0 || len(add) > 0 {
+		// the indexes are of the tracks as they were read, so the new list is made from them and replaces the
+		// tracks at once
+		var trackIDs []string
+		for i, t := range tracks {
+			if !slices.Contains(remove, i) {
+				trackIDs = append(trackIDs, t.TrackID)
+			}
+		}
+		if err := s.lib.SetPlaylistTracks(ctx, user.ID, playlistID, append(trackIDs, add...)); err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+	}
+	return subsonicOK(c, subsonicResponse{})
+}
+
+// the audio of a track, like /api/v1/play. it's never transcoded so maxBitRate and format are ignored.
+func (s *Server) subsonicStream(c echo.Context) error {
+	trackID, ok := subsonicParamID(c, "id", subsonicTrackPrefix)
+	if !ok {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter id is missing or invalid")
+	}
+	if !isSpotifyTrackID(trackID) {
+		return subsonicFail(c, subsonicErrNotFound, "song not found")
+	}
+	if err := s.serveTrack(c, trackID); err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	return nil
+}
+
+// the cover of an album or the image of a playlist. the size parameter is ignored.
+func (s *Server) subsonicGetCoverArt(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.FormValue("id")
+	var link string
+	if albumID, ok := strings.CutPrefix(id, subsonicAlbumPrefix); ok {
+		tracks, err := s.lib.AlbumTracks(ctx, currentUser(c).ID, albumID)
+		if err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		if len(tracks) > 0 {
+			link = tracks[0].CoverURL
+		}
+	} else if playlistID, ok := strings.CutPrefix(id, subsonicPlaylistPrefix); ok {
+		playlist, err := s.lib.GetPlaylistByID(ctx, currentUser(c).ID, playlistID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+		link = playlist.ImageUrl
+	}
+	if link == "" {
+		return subsonicFail(c, subsonicErrNotFound, "cover art not found")
+	}
+
+	// images uploaded to local storage are read from disk, spotify's covers and images in remote storage are
+	// proxied, and clients are redirected to any other image url (a playlist's image can be any url, which the
+	// server shouldn't be made to request)
+	if key, ok := strings.CutPrefix(link, env.DefaultEnv.ServerURL+"/api/v1/data/"); ok {
+		localStorage, ok := s.storage.(*storage.LocalStorage)
+		if !ok || !storage.ValidKey(key) {
+			return subsonicFail(c, subsonicErrNotFound, "cover art not found")
+		}
+		rd, err := localStorage.Load(key)
+		if err != nil {
+			return subsonicFail(c, subsonicErrNotFound, "cover art not found")
+		}
+		defer rd.Close()
+		return c.Stream(200, "application/octet-stream", rd)
+	}
+	if !proxiedCoverArt(link) {
+		return c.Redirect(http.StatusFound, link)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	resp, err := coverArtClient.Do(req)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return subsonicFail(c, subsonicErrNotFound, "cover art not found")
+	}
+	return c.Stream(200, resp.Header.Get("Content-Type"), resp.Body)
+}
+
+// proxiedCoverArt reports whether the cover art at link is fetched by the server: https links to spotify's
+// image hosts and links to the remote storage server.
+func proxiedCoverArt(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "https" && u.User == nil && slices.Contains(spotifyImageHosts, u.Host) {
+		return true
+	}
+	return env.DefaultEnv.StorageURL != "" && strings.HasPrefix(link, env.DefaultEnv.StorageURL+"/pull/")
+}
+
+// the lyrics of the library track with the title (and artist), without the lrc timestamps
+func (s *Server) subsonicGetLyrics(c echo.Context) error {
+	ctx := c.Request().Context()
+	artist, title := c.FormValue("artist"), c.FormValue("title")
+	lyrics := &subsonicLyrics{Artist: artist, Title: title}
+	if title == "" {
+		return subsonicOK(c, subsonicResponse{Lyrics: lyrics})
+	}
+	tracks, err := s.lib.LibraryTracks(ctx, currentUser(c).ID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	i := slices.IndexFunc(tracks, func(t library.Track) bool {
+		return strings.EqualFold(t.TrackName, title) && (artist == "" || strings.EqualFold(t.ArtistName, artist))
+	})
+	if i == -1 {
+		return subsonicOK(c, subsonicResponse{Lyrics: lyrics})
+	}
+	text, err := s.lib.Lyrics(ctx, tracks[i].TrackID)
+	if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	lyrics.Artist, lyrics.Title = tracks[i].ArtistName, tracks[i].TrackName
+	lyrics.Value = strings.TrimSpace(lrcTimestamps.ReplaceAllString(text, ""))
+	return subsonicOK(c, subsonicResponse{Lyrics: lyrics})
+}
+
+// record plays. submission=false means the client only says what's playing now, which isn't recorded.
+func (s *Server) subsonicScrobble(c echo.Context) error {
+	ids, ok := subsonicParamIDs(c, "id", subsonicTrackPrefix)
+	if !ok || len(ids) == 0 {
+		return subsonicFail(c, subsonicErrMissingParameter, "required parameter id is missing or invalid")
+	}
+	for _, id := range ids {
+		if !isSpotifyTrackID(id) {
+			return subsonicFail(c, subsonicErrNotFound, "song not found")
+		}
+	}
+	if c.FormValue("submission") == "false" {
+		return subsonicOK(c, subsonicResponse{})
+	}
+	for _, id := range ids {
+		if _, err := s.lib.RecordPlay(c.Request().Context(), currentUser(c).ID, id); err != nil {
+			return subsonicFail(c, subsonicErrGeneric, err.Error())
+		}
+	}
+	return subsonicOK(c, subsonicResponse{})
+}
+
+// subsonicRespondPlaylist responds with the playlist and its songs.
+func (s *Server) subsonicRespondPlaylist(c echo.Context, playlistID string) error {
+	user := currentUser(c)
+	playlist, tracks, err := s.lib.PlaylistTracks(c.Request().Context(), user.ID, playlistID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return subsonicFail(c, subsonicErrNotFound, "playlist not found")
+	} else if err != nil {
+		return subsonicFail(c, subsonicErrGeneric, err.Error())
+	}
+	p := subsonicPlaylistOf(playlist, user, tracks)
+	p.Entry = subsonicSongs(tracks)
+	return subsonicOK(c, subsonicResponse{Playlist: &p})
+}
+
+// subsonicOK responds with resp in the format the client asked for (f=json, or xml by default).
+func subsonicOK(c echo.Context, resp subsonicResponse) error {
+	resp.Xmlns = "http://subsonic.org/restapi"
+	if resp.Status == "" {
+		resp.Status = "ok"
+	}
+	resp.Version = subsonicAPIVersion
+	resp.Type = "music"
+	resp.ServerVersion = "1.0.0"
+	resp.OpenSubsonic = true
+	if c.FormValue("f") == "json" {
+		return c.JSON(200, map[string]subsonicResponse{"subsonic-response": resp})
+	}
+	return c.XML(200, resp)
+}
+
+// subsonicFail responds with a subsonic error. subsonic errors are still 200s.
+func subsonicFail(c echo.Context, code int, message string) error {
+	return subsonicOK(c, subsonicResponse{
+		Status: "failed",
+		Error:  &subsonicError{Code: code, Message: message},
+	})
+}
+
+// subsonicParamID returns the parameter without its prefix, or false if it's missing or has another prefix.
+func subsonicParamID(c echo.Context, name, prefix string) (string, bool) {
+	id, ok := strings.CutPrefix(c.FormValue(name), prefix)
+	return id, ok && id != ""
+}
+
+// isSpotifyTrackID reports whether id looks like a spotify track ID (22 base62 characters). ids that reach
+// the track's file path are checked with it, so they can't be a path.
+func isSpotifyTrackID(id string) bool {
+	if len(id) != 22 {
+		return false
+	}
+	for _, r := range id {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// subsonicParamIDs is subsonicParamID for parameters that can be repeated.
+func subsonicParamIDs(c echo.Context, name, prefix string) ([]string, bool) {
+	params, err := c.FormParams()
+	if err != nil {
+		return nil, false
+	}
+	ids := make([]string, 0, len(params[name]))
+	for _, v := range params[name] {
+		id, ok := strings.CutPrefix(v, prefix)
+		if !ok || id == "" {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// subsonicPage returns the page of items that <kind>Count and <kind>Offset ask for (20 from the start by
+// default).
+func subsonicPage[T any](c echo.Context, kind string, items []T) []T {
+	count, err := strconv.Atoi(c.FormValue(kind + "Count"))
+	if err != nil || count < 0 {
+		count = 20
+	}
+	offset, err := strconv.Atoi(c.FormValue(kind + "Offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	offset = min(offset, len(items))
+	return items[offset:min(offset+count, len(items))]
+}
+
+func subsonicSongs(tracks []library.Track) []subsonicSong {
+	songs := make([]subsonicSong, len(tracks))
+	for i, t := range tracks {
+		songs[i] = subsonicSong{
+			ID:          subsonicTrackPrefix + t.TrackID,
+			Parent:      subsonicAlbumPrefix + t.AlbumID,
+			Title:       t.TrackName,
+			Album:       t.AlbumName,
+			Artist:      strings.Join(t.Artists, ", "),
+			Year:        subsonicYear(t.TrackReleaseDate),
+			CoverArt:    subsonicAlbumPrefix + t.AlbumID,
+			ContentType: "audio/mp4",
+			Suffix:      "m4a",
+			Duration:    int(t.Duration),
+			PlayCount:   t.PlayCount,
+			AlbumID:     subsonicAlbumPrefix + t.AlbumID,
+			ArtistID:    subsonicArtistPrefix + t.ArtistID,
+			Type:        "music",
+		}
+		if songs[i].Artist == "" {
+			songs[i].Artist = t.ArtistName
+		}
+		if t.LikedAt.Valid {
+			songs[i].Starred = t.LikedAt.Time.Format("2006-01-02T15:04:05Z")
+		}
+		if t.Rating.Valid {
+			songs[i].UserRating = int(t.Rating.Int32)
+		}
+	}
+	return songs
+}
+
+// subsonicAlbumsOf returns the albums of the tracks in the order they first appear.
+func subsonicAlbumsOf(tracks []library.Track) []subsonicAlbum {
+	var albums []subsonicAlbum
+	index := make(map[string]int)
+	for _, t := range tracks {
+		i, ok := index[t.AlbumID]
+		if !ok {
+			i = len(albums)
+			index[t.AlbumID] = i
+			albums = append(albums, subsonicAlbum{
+				ID:       subsonicAlbumPrefix + t.AlbumID,
+				Name:     t.AlbumName,
+				Artist:   t.ArtistName,
+				ArtistID: subsonicArtistPrefix + t.ArtistID,
+				CoverArt: subsonicAlbumPrefix + t.AlbumID,
+				Year:     subsonicYear(t.TrackReleaseDate),
+			})
+		}
+		albums[i].SongCount++
+		albums[i].Duration += int(t.Duration)
+	}
+	return albums
+}
+
+// subsonicArtistsOf returns the (main) artists of the tracks in the order they first appear.
+func subsonicArtistsOf(tracks []library.Track) []subsonicArtist {
+	var artists []subsonicArtist
+	index := make(map[string]int)
+	albums := make(map[string]bool)
+	for _, t := range tracks {
+		i, ok := index[t.ArtistID]
+		if !ok {
+			i = len(artists)
+			index[t.ArtistID] = i
+			artists = append(artists, subsonicArtist{
+				ID:   subsonicArtistPrefix + t.ArtistID,
+				Name: t.ArtistName,
+			})
+		}
+		if !albums[t.AlbumID] {
+			albums[t.AlbumID] = true
+			artists[i].AlbumCount++
+		}
+	}
+	return artists
+}
+
+func subsonicPlaylistOf(p db.Playlist, owner library.User, tracks []library.Track) subsonicPlaylist {
+	playlist := subsonicPlaylist{
+		ID:        subsonicPlaylistPrefix + uuid.UUID(p.ID.Bytes).String(),
+		Name:      p.Name,
+		Comment:   p.Description,
+		Owner:     owner.Username,
+		SongCount: len(tracks),
+		Created:   p.CreatedAt.Time.Format("2006-01-02T15:04:05Z"),
+	}
+	if p.ImageUrl != "" {
+		playlist.CoverArt = playlist.ID
+	}
+	for _, t := range tracks {
+		playlist.Duration += int(t.Duration)
+	}
+	return playlist
+}
+
+func subsonicYear(d pgtype.Date) int {
+	if !d.Valid {
+		return 0
+	}
+	return d.Time.Year()
+}
+
+// subsonicResponse is the <subsonic-response> element every method responds with. the json format has the
+// same fields (attributes and elements alike) inside {"subsonic-response": ...}.
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error         *subsonicError         `xml:"error,omitempty" json:"error,omitempty"`
+	License       *subsonicLicense       `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *subsonicMusicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Artists       *subsonicArtistIndexes `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *subsonicArtist        `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *subsonicAlbum         `xml:"album,omitempty" json:"album,omitempty"`
+	SearchResult3 *subsonicSearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists     *subsonicPlaylists     `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist      *subsonicPlaylist      `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	Lyrics        *subsonicLyrics        `xml:"lyrics,omitempty" json:"lyrics,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicMusicFolders struct {
+	MusicFolder []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicArtistIndexes struct {
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	AlbumCount int             `xml:"albumCount,attr" json:"albumCount"`
+	Album      []subsonicAlbum `xml:"album,omitempty" json:"album,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID        string         `xml:"id,attr" json:"id"`
+	Name      string         `xml:"name,attr" json:"name"`
+	Artist    string         `xml:"artist,attr" json:"artist"`
+	ArtistID  string         `xml:"artistId,attr" json:"artistId"`
+	CoverArt  string         `xml:"coverArt,attr" json:"coverArt"`
+	SongCount int            `xml:"songCount,attr" json:"songCount"`
+	Duration  int            `xml:"duration,attr" json:"duration"`
+	Year      int            `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Song      []subsonicSong `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+type subsonicSong struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr" json:"parent"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr" json:"album"`
+	Artist      string `xml:"artist,attr" json:"artist"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	CoverArt    string `xml:"coverArt,attr" json:"coverArt"`
+	ContentType string `xml:"contentType,attr" json:"contentType"`
+	Suffix      string `xml:"suffix,attr" json:"suffix"`
+	Duration    int    `xml:"duration,attr" json:"duration"` // seconds
+	PlayCount   int64  `xml:"playCount,attr" json:"playCount"`
+	AlbumID     string `xml:"albumId,attr" json:"albumId"`
+	ArtistID    string `xml:"artistId,attr" json:"artistId"`
+	Type        string `xml:"type,attr" json:"type"`
+	Starred     string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	UserRating  int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+}
+
+type subsonicSearchResult3 struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+	Album  []subsonicAlbum  `xml:"album" json:"album"`
+	Song   []subsonicSong   `xml:"song" json:"song"`
+}
+
+type subsonicPlaylists struct {
+	Playlist []subsonicPlaylist `xml:"playlist" json:"playlist"`
+}
+
+type subsonicPlaylist struct {
+	ID        string         `xml:"id,attr" json:"id"`
+	Name      string         `xml:"name,attr" json:"name"`
+	Comment   string         `xml:"comment,attr" json:"comment"`
+	Owner     string         `xml:"owner,attr" json:"owner"`
+	Public    bool           `xml:"public,attr" json:"public"`
+	SongCount int            `xml:"songCount,attr" json:"songCount"`
+	Duration  int            `xml:"duration,attr" json:"duration"`
+	Created   string         `xml:"created,attr" json:"created"`
+	CoverArt  string         `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Entry     []subsonicSong `xml:"entry,omitempty" json:"entry,omitempty"`
+}
+
+type subsonicLyrics struct {
+	Artist string `xml:"artist,attr" json:"artist"`
+	Title  string `xml:"title,attr" json:"title"`
+	Value  string `xml:",chardata" json:"value"`
+}
@@ -0,0 +1,19 @@
+package server
+
+import "testing"
+
+func TestIsSpotifyTrackID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"4uLU6hMCjMI75M1A2tKUQC": true,
+		"0VjIjW4GlUZAMYd2vXMi3b": true,
+		"4uLU6hMCjMI75M1A2tKUQ":  false,
+		"../../../../etc/passwd": false,
+		"4uLU6hMCjMI75M1A2/..QC": false,
+		"4uLU6hMCjMI75M1A2tKU.C": false,
+		"":                       false,
+	} {
+		if got := isSpotifyTrackID(id); got != want {
+			t.Errorf("isSpotifyTrackID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// registerTagRoutes registers the routes for tagging tracks, albums and artists and browsing by tag or genre.
+func (s *Server) registerTagRoutes(api *echo.Group) {
+	// every tag and how many things have it
+	api.GET("/tags", func(c echo.Context) error {
+		tags, err := s.lib.ListTags(c.Request().Context())
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tags))
+	})
+
+	// every genre in the library and how many tracks it has
+	api.GET("/genres", func(c echo.Context) error {
+		genres, err := s.lib.ListGenres(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(genres))
+	})
+
+	// the library tracks with a tag (?tag=) or genre (?genre=)
+	api.GET("/browse", func(c echo.Context) error {
+		tag, genre := c.QueryParam("tag"), c.QueryParam("genre")
+		if (tag == "") == (genre == "") {
+			return c.JSON(400, errormap("either tag or genre is required"))
+		}
+		tracks, err := s.lib.BrowseTracks(c.Request().Context(), currentUser(c).ID, tag, genre)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(tracks))
+	})
+
+	// /tracks/:trackID/tags, /albums/:albumID/tags and /artists/:artistID/tags
+	for _, target := range library.TagTargets {
+		param := target + "ID"
+		base := "/" + target + "s/:" + param + "/tags"
+
+		api.GET(base, func(c echo.Context) error {
+			tags, err := s.lib.TagsOf(c.Request().Context(), target, c.Param(param))
+			if err != nil {
+				return c.JSON(500, errormap(err.Error()))
+			}
+			return c.JSON(200, orEmpty(tags))
+		})
+
+		api.POST(base, bindreq(func(c echo.Context, req struct {
+			Tag string `json:"tag"`
+		}) error {
+			if req.Tag == "" {
+				return c.JSON(400, errormap("tag is required"))
+			}
+			if err := s.lib.AddTag(c.Request().Context(), target, c.Param(param), req.Tag); err != nil {
+				return c.JSON(500, errormap(err.Error()))
+			}
+			return c.JSON(200, nil)
+		}))
+
+		api.DELETE(base+"/:tag", func(c echo.Context) error {
+			if err := s.lib.RemoveTag(c.Request().Context(), target, c.Param(param), c.Param("tag")); err != nil {
+				return c.JSON(500, errormap(err.Error()))
+			}
+			return c.JSON(200, nil)
+		})
+	}
+}
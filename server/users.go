@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/tiredkangaroo/music/library"
+)
+
+// registerUserRoutes registers the routes admins use to manage the other users.
+func (s *Server) registerUserRoutes(api *echo.Group) {
+	users := api.Group("/users", requireAdmin)
+
+	users.GET("", func(c echo.Context) error {
+		list, err := s.lib.ListUsers(c.Request().Context())
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(list))
+	})
+
+	// create a user with an empty library. the admin tells them their password.
+	users.POST("", bindreq(func(c echo.Context, req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}) error {
+		if msg := validateCredentials(req.Username, req.Password); msg != "" {
+			return c.JSON(400, errormap(msg))
+		}
+		user, err := s.lib.CreateUser(c.Request().Context(), req.Username, req.Password)
+		if errors.Is(err, library.ErrUsernameTaken) {
+			return c.JSON(400, errormap(err.Error()))
+		} else if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, user)
+	}))
+
+	// delete a user and everything that's theirs (but not the tracks they downloaded)
+	users.DELETE("/:userID", func(c echo.Context) error {
+		userID := c.Param("userID")
+		if userID == uuid.UUID(currentUser(c).ID.Bytes).String() {
+			return c.JSON(400, errormap("you can't delete your own account"))
+		}
+		if err := s.lib.DeleteUser(c.Request().Context(), userID); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	})
+}
+
+// requireAdmin only lets admins through. it runs after requireAuth.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !currentUser(c).IsAdmin {
+			return c.JSON(403, errormap("only admins can do this"))
+		}
+		return next(c)
+	}
+}
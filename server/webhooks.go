@@ -0,0 +1,83 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerWebhookRoutes registers the routes for managing the user's webhooks and looking at (and retrying)
+// their deliveries.
+func (s *Server) registerWebhookRoutes(api *echo.Group) {
+	type WebhookRequest struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	// the user's webhooks (without their secrets)
+	api.GET("/webhooks", func(c echo.Context) error {
+		webhooks, err := s.lib.ListWebhooks(c.Request().Context(), currentUser(c).ID)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(webhooks))
+	})
+
+	// create a webhook that's sent the events (see library.WebhookEvents). the secret that signs its
+	// requests is only ever shown in this response.
+	api.POST("/webhooks", bindreq(func(c echo.Context, req WebhookRequest) error {
+		secret, webhook, err := s.lib.CreateWebhook(c.Request().Context(), currentUser(c).ID, req.URL, req.Events)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, map[string]any{
+			"secret":     secret,
+			"id":         webhook.ID,
+			"url":        webhook.URL,
+			"events":     webhook.Events,
+			"created_at": webhook.CreatedAt,
+		})
+	}))
+
+	// change the url and events of a webhook
+	api.PUT("/webhooks/:webhookID", bindreq(func(c echo.Context, req WebhookRequest) error {
+		if err := s.lib.UpdateWebhook(c.Request().Context(), currentUser(c).ID, c.Param("webhookID"), req.URL, req.Events); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	}))
+
+	// delete a webhook and its deliveries
+	api.DELETE("/webhooks/:webhookID", func(c echo.Context) error {
+		if err := s.lib.DeleteWebhook(c.Request().Context(), currentUser(c).ID, c.Param("webhookID")); err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	})
+
+	// the latest deliveries of a webhook (?limit=, default 50, at most 500), newest first
+	api.GET("/webhooks/:webhookID/deliveries", func(c echo.Context) error {
+		limit := int32(50)
+		if v := c.QueryParam("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 500 {
+				return c.JSON(400, errormap("limit must be between 1 and 500"))
+			}
+			limit = int32(n)
+		}
+		deliveries, err := s.lib.WebhookDeliveries(c.Request().Context(), currentUser(c).ID, c.Param("webhookID"), limit)
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, orEmpty(deliveries))
+	})
+
+	// send a failed delivery again
+	api.POST("/webhooks/:webhookID/deliveries/:deliveryID/retry", func(c echo.Context) error {
+		err := s.lib.RetryWebhookDelivery(c.Request().Context(), currentUser(c).ID, c.Param("webhookID"), c.Param("deliveryID"))
+		if err != nil {
+			return c.JSON(500, errormap(err.Error()))
+		}
+		return c.JSON(200, nil)
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +22,11 @@ import (
 type Storage interface {
 	// Store uses the provided reader to store the data and returns a link to access the data.
 	Store(ctx context.Context, rd io.Reader, contentType string) (string, error)
+	// Delete deletes the data behind a link returned by Store. links that weren't returned by this
+	// storage (e.g. spotify cover URLs) are ignored.
+	Delete(ctx context.Context, link string) error
+	// Check returns an error if data can't be stored right now.
+	Check(ctx context.Context) error
 }
 
 // LocalStorage is a storage implementation that stores data locally on disk. It expects a /data/:key
@@ -48,7 +54,31 @@ func (ls *LocalStorage) Store(ctx context.Context, rd io.Reader, contentType str
 	return env.DefaultEnv.ServerURL + "/api/v1/data/" + key, nil
 }
 
+func (ls *LocalStorage) Delete(ctx context.Context, link string) error {
+	key, ok := strings.CutPrefix(link, env.DefaultEnv.ServerURL+"/api/v1/data/")
+	if !ok || !ValidKey(key) {
+		return nil // not stored here
+	}
+	if err := os.Remove(filepath.Join(ls.DataPath, key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove file: %w", err)
+	}
+	return nil
+}
+
+// Check writes and removes a file in the data directory.
+func (ls *LocalStorage) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(ls.DataPath, ".check-*")
+	if err != nil {
+		return fmt.Errorf("data directory isn't writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 func (ls *LocalStorage) Load(key string) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, fmt.Errorf("file not found")
+	}
 	p := filepath.Join(ls.DataPath, key)
 	f, err := os.Open(p)
 	if err != nil {
@@ -60,6 +90,12 @@ func (ls *LocalStorage) Load(key string) (io.ReadCloser, error) {
 	return f, nil
 }
 
+// ValidKey reports whether key names a file directly in the data directory, so a key taken from a link
+// can't be a path out of it.
+func ValidKey(key string) bool {
+	return key != "" && key != "." && key != ".." && key == filepath.Base(key)
+}
+
 func NewLocalStorage(dataPath string) *LocalStorage {
 	os.MkdirAll(dataPath, 0755)
 	return &LocalStorage{
@@ -113,6 +149,30 @@ func (rs *RemoteStorage) Store(ctx context.Context, rd io.Reader, contentType st
 	return env.DefaultEnv.StorageURL + "/pull/" + string(data), nil
 }
 
+// Delete does nothing: the storage server has no endpoint for deleting data, so replaced images are
+// left on it.
+func (rs *RemoteStorage) Delete(ctx context.Context, link string) error {
+	return nil
+}
+
+// Check checks that the storage server answers. it has no health endpoint, so any response that isn't a
+// server error will do.
+func (rs *RemoteStorage) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rs.StorageURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("storage server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // NewRemoteStorage creates a new RemoteStorage instance.
 func NewRemoteStorage(storageURL, storageAPISecret string) *RemoteStorage {
 	return &RemoteStorage{
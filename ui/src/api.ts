@@ -1,7 +1,52 @@
-import type { PlaylistHead, Playlist, Track, WithError } from "./types.ts";
+import type {
+  AuthStatus,
+  PlaylistHead,
+  Playlist,
+  Track,
+  User,
+  WithError,
+} from "./types.ts";
 
 const API_BASE = import.meta.env.VITE_API_BASE || "/api/v1";
 
+// send the session cookie along even when the api is on another origin (VITE_API_BASE in development)
+const fetch: typeof window.fetch = (input, init) =>
+  window.fetch(input, { credentials: "include", ...init });
+
+export async function getAuthStatus(): Promise<WithError<AuthStatus>> {
+  const res = await fetch(`${API_BASE}/auth/status`);
+  return res.json();
+}
+
+export async function login(
+  username: string,
+  password: string,
+): Promise<WithError<User>> {
+  const res = await fetch(`${API_BASE}/auth/login`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
+  });
+  return res.json();
+}
+
+// setup creates the admin account on a fresh instance and logs in as it.
+export async function setup(
+  username: string,
+  password: string,
+): Promise<WithError<User>> {
+  const res = await fetch(`${API_BASE}/auth/setup`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
+  });
+  return res.json();
+}
+
+export async function logout() {
+  await fetch(`${API_BASE}/auth/logout`, { method: "POST" });
+}
+
 export async function searchTracks(q: string): Promise<WithError<Track[]>> {
   const res = await fetch(`${API_BASE}/search?q=${encodeURIComponent(q)}`);
   if (!res.ok) throw new Error("Search failed");
@@ -117,7 +162,7 @@ export function requestDownloadPlaylist(
 ): Promise<number> {
   return new Promise((resolve, reject) => {
     const url = `${API_BASE}/download-playlist/${playlistID}`;
-    const es = new EventSource(url);
+    const es = new EventSource(url, { withCredentials: true });
 
     let totalTracks: number | null = null;
     let resolved = false;
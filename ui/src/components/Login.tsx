@@ -0,0 +1,90 @@
+import { useEffect, useState, type ReactNode } from "react";
+import type { User } from "../types";
+import { getAuthStatus, login, setup } from "../api";
+
+// AuthGate shows the login form (or the first-run setup form on a fresh instance) until the user is
+// logged in, then its children.
+export function AuthGate(props: { children: ReactNode }) {
+  const [user, setUser] = useState<User | null>(null);
+  const [setupRequired, setSetupRequired] = useState(false);
+  const [loading, setLoading] = useState(true);
+  const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
+  const [errorMessage, setErrorMessage] = useState("");
+
+  useEffect(() => {
+    getAuthStatus()
+      .then((data) => {
+        if (data.error) {
+          setErrorMessage(data.error);
+          return;
+        }
+        setSetupRequired(data.setup_required);
+        setUser(data.user);
+      })
+      .catch(() => setErrorMessage("could not reach the server"))
+      .finally(() => setLoading(false));
+  }, []);
+
+  async function handleSubmit(event: React.FormEvent) {
+    event.preventDefault();
+    setErrorMessage("");
+    const data = await (setupRequired ? setup : login)(username, password);
+    if (data.error) {
+      setErrorMessage(data.error);
+      return;
+    }
+    setUser(data);
+  }
+
+  if (loading) {
+    return null;
+  }
+  if (user !== null) {
+    return <>{props.children}</>;
+  }
+  return (
+    <div className="min-h-screen flex justify-center items-center font-mono">
+      <form
+        className="w-96 p-6 flex flex-col gap-4 border-r-8 border-b-8 border-l-2 border-t-2 border-black"
+        onSubmit={handleSubmit}
+      >
+        <h1 className="text-3xl font-bold">
+          {setupRequired ? "Create Admin Account" : "Log In"}
+        </h1>
+        {setupRequired && (
+          <p className="text-gray-500">
+            this is a fresh instance. the account you create here is the
+            admin.
+          </p>
+        )}
+        {errorMessage && (
+          <div className="w-full p-2 bg-red-200 border-2 border-red-600 text-red-800">
+            <p>error: {errorMessage}</p>
+          </div>
+        )}
+        <input
+          className="p-2 border border-black w-full"
+          placeholder="Username"
+          autoComplete="username"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+        ></input>
+        <input
+          className="p-2 border border-black w-full"
+          placeholder="Password"
+          type="password"
+          autoComplete={setupRequired ? "new-password" : "current-password"}
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+        ></input>
+        <button
+          className="bg-blue-500 text-white px-4 py-2 rounded"
+          type="submit"
+        >
+          {setupRequired ? "Create Account" : "Log In"}
+        </button>
+      </form>
+    </div>
+  );
+}
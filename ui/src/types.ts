@@ -13,6 +13,12 @@ export interface Track {
   artist_name?: string;
   downloaded?: boolean;
   lyrics?: string;
+  play_count?: number;
+  position?: number; // position in the playlist (regular playlists only)
+  added_at?: string; // when the track was added to the playlist (regular playlists only)
+  liked_at?: string | null; // when the track was liked, null if it isn't
+  rating?: number | null; // 1-5 stars, null if it isn't rated
+  genres?: string[] | null; // genres of the artist (search results only), null if not fetched yet
 }
 
 export interface PlaylistHead {
@@ -21,6 +27,33 @@ export interface PlaylistHead {
   description: string;
   image_url: string;
   created_at: string;
+  rules?: SmartRules | null; // set for smart playlists
+  folder_id?: string | null; // null at the root
+}
+
+export interface SmartRule {
+  type:
+    | "artist_is"
+    | "release_year_between"
+    | "added_within_days"
+    | "play_count_above"
+    | "never_played"
+    | "skipped_more_than"
+    | "downloaded";
+  artist?: string;
+  from?: number;
+  to?: number;
+  days?: number;
+  count?: number;
+  percent?: number;
+}
+
+export interface SmartRules {
+  match: "all" | "any";
+  rules: SmartRule[];
+  sort?: string;
+  order?: "asc" | "desc";
+  limit?: number;
 }
 
 export interface Playlist extends PlaylistHead {
@@ -40,6 +73,18 @@ export interface PlayerState {
   playID: string | null;
 }
 
+export interface User {
+  id: string;
+  username: string;
+  is_admin: boolean;
+  created_at: string;
+}
+
+export interface AuthStatus {
+  setup_required: boolean;
+  user: User | null; // null if not logged in
+}
+
 export type WithError<T> = T & {
   error?: string;
 };